@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"philadelphia/internal/baseline"
+	"philadelphia/internal/runner"
+)
+
+// defaultBaselineDir is BASELINE_DIR, or baselines/ under workspace.
+func defaultBaselineDir(workspace string) string {
+	if dir := strings.TrimSpace(os.Getenv("BASELINE_DIR")); dir != "" {
+		return dir
+	}
+	return filepath.Join(workspace, "baselines")
+}
+
+func baselineCmd(args []string) {
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("baseline list", flag.ExitOnError)
+		dir := fs.String("dir", defaultBaselineDir("."), "Baseline directory")
+		asJSON := fs.Bool("json", false, "Print JSON")
+		fs.Parse(args[1:])
+		store := baseline.Open(*dir)
+		sets, err := store.List()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if store.Legacy() {
+			log.Printf("warning: %s holds a flat screenshot.png from an older version; it is not used, approve a new run to re-create it", *dir)
+		}
+		if *asJSON {
+			b, _ := json.MarshalIndent(sets, "", "  ")
+			fmt.Println(string(b))
+			return
+		}
+		for _, set := range sets {
+			fmt.Printf("%s  %s  %s  %s  %s\n", set.ID, set.Key.Script, set.Key.URL, set.Key.Viewport, set.Key.Engine)
+			names := make([]string, 0, len(set.Checkpoints))
+			for name := range set.Checkpoints {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				cp := set.Checkpoints[name]
+				fmt.Printf("    %-24s %-9s run %s\n", name, cp.Status, cp.Run)
+				kinds := make([]string, 0, len(cp.Snapshots))
+				for kind := range cp.Snapshots {
+					kinds = append(kinds, kind)
+				}
+				sort.Strings(kinds)
+				for _, kind := range kinds {
+					fmt.Printf("      %-22s %-9s run %s\n", kind+" snapshot", cp.Snapshots[kind].Status, cp.Snapshots[kind].Run)
+				}
+			}
+		}
+	case "approve":
+		if len(args) < 2 || len(args) > 3 {
+			log.Fatal("usage: lab baseline approve <run-id> [checkpoint]")
+		}
+		checkpoint := ""
+		if len(args) == 3 {
+			checkpoint = args[2]
+		}
+		approved, err := runner.ApproveRun(filepath.Join("runs", filepath.Base(args[1])), checkpoint)
+		if err != nil {
+			log.Fatalf("approve: %v", err)
+		}
+		for _, a := range approved {
+			fmt.Printf("approved %s -> %s\n", a.Checkpoint, a.Path)
+		}
+	case "reset":
+		fs := flag.NewFlagSet("baseline reset", flag.ExitOnError)
+		dir := fs.String("dir", defaultBaselineDir("."), "Baseline directory")
+		set := fs.String("set", "", "Baseline set id (see lab baseline list)")
+		checkpoint := fs.String("checkpoint", "", "Only reset this checkpoint of --set")
+		all := fs.Bool("all", false, "Reset every baseline set")
+		fs.Parse(args[1:])
+		if *set == "" && !*all {
+			log.Fatal("pass --set <id> or --all")
+		}
+		n, err := baseline.Open(*dir).Reset(*set, *checkpoint)
+		if err != nil {
+			log.Fatalf("reset: %v", err)
+		}
+		fmt.Printf("removed %d checkpoint(s)\n", n)
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+type baselineRequest struct {
+	Dir        string `json:"dir"`
+	RunID      string `json:"run_id"`
+	Set        string `json:"set"`
+	Checkpoint string `json:"checkpoint"`
+	All        bool   `json:"all"`
+}
+
+// handleBaselines serves GET /v1/baselines, POST /v1/baselines/approve and
+// POST /v1/baselines/reset.
+//
+// The baseline directory is the "dir" query parameter or request field, the
+// same path a run was given as "baseline"; it defaults to
+// defaultBaselineDir.
+func (s *server) handleBaselines(w http.ResponseWriter, r *http.Request) {
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/baselines"), "/")
+	if action == "" {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "GET only"})
+			return
+		}
+		dir := s.baselineDir(r.URL.Query().Get("dir"))
+		store := baseline.Open(dir)
+		sets, err := store.List()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if sets == nil {
+			sets = []baseline.Set{}
+		}
+		resp := map[string]any{"dir": dir, "sets": sets}
+		if store.Legacy() {
+			resp["warning"] = "flat screenshot.png from an older version is not used; approve a new run to re-create it"
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "POST only"})
+		return
+	}
+	var req baselineRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	switch action {
+	case "approve":
+		if req.RunID == "" || req.RunID != filepath.Base(req.RunID) || strings.HasPrefix(req.RunID, ".") {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid run_id"})
+			return
+		}
+		approved, err := runner.ApproveRun(filepath.Join(s.workspace, "runs", req.RunID), req.Checkpoint)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"approved": approved})
+	case "reset":
+		if req.Set == "" && !req.All {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "set or all is required"})
+			return
+		}
+		n, err := baseline.Open(s.baselineDir(req.Dir)).Reset(req.Set, req.Checkpoint)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"removed": n})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown path"})
+	}
+}
+
+// baselineDir returns dir, or the default baseline directory when it is
+// empty.
+func (s *server) baselineDir(dir string) string {
+	if dir = strings.TrimSpace(dir); dir != "" {
+		return dir
+	}
+	return defaultBaselineDir(s.workspace)
+}
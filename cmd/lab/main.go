@@ -12,7 +12,10 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"philadelphia/internal/flows"
+	"philadelphia/internal/har"
 	"philadelphia/internal/runner"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -25,6 +28,16 @@ func main() {
 	switch os.Args[1] {
 	case "run":
 		runCmd(os.Args[2:])
+	case "record":
+		recordCmd(os.Args[2:])
+	case "import-steps":
+		importStepsCmd(os.Args[2:])
+	case "export-steps":
+		exportStepsCmd(os.Args[2:])
+	case "har":
+		harCmd(os.Args[2:])
+	case "baseline":
+		baselineCmd(os.Args[2:])
 	case "serve":
 		serveCmd(os.Args[2:])
 	case "list":
@@ -37,6 +50,19 @@ func main() {
 func usage() {
 	fmt.Println("lab usage:")
 	fmt.Println("  lab run   --url <url> --script <path> [--engine <name>] [--ext <dir>] [--headless=false]")
+	fmt.Println("            [--debug] [--break-at 2,5] [--replay-har <file.har> [--har-strict] [--har-match url|url+body]]")
+	fmt.Println("            [--proxy-record <cassette.json> | --proxy-replay <cassette.json>] [--emulate slow-3g,4x-cpu]")
+	fmt.Println("            [--deterministic [--clock <rfc3339>] [--seed N] [--timezone <tz>] [--dpr N]]")
+	fmt.Println("            [--viewport 390x844] [--device \"iPhone 13\"] [--color-scheme dark] [--locale de-DE]")
+	fmt.Println("            [--matrix-viewports a,b] [--matrix-devices a,b] [--matrix-color-schemes light,dark] [--matrix-locales a,b]")
+	fmt.Println("            [--impact] [--mutations] [--snapshot dom|a11y [--snapshot-rules rules.json]]")
+	fmt.Println("  lab record --url <url> --script <path> [--out steps.json]")
+	fmt.Println("  lab import-steps --chrome <recording.json> [--out steps.json]")
+	fmt.Println("  lab export-steps --steps <steps.json> --url <url> --script <path> [--out flow.spec.ts]")
+	fmt.Println("  lab har edit --har <file.har> --url <glob|/regex/> (--find <text> --replace <text> [--regex] | --body-file <path>) [--out <file.har>]")
+	fmt.Println("  lab baseline list [--dir baselines] [--json]")
+	fmt.Println("  lab baseline approve <run-id> [checkpoint]")
+	fmt.Println("  lab baseline reset [--dir baselines] (--set <id> [--checkpoint <name>] | --all)")
 	fmt.Println("  lab serve [--port 8787]")
 	fmt.Println("  lab list  # list run ids")
 }
@@ -48,13 +74,96 @@ func runCmd(args []string) {
 	engine := fs.String("engine", "Tampermonkey (init-script)", "Engine label")
 	ext := fs.String("ext", runner.DiscoverExtensionDir(), "Extension directory (MV3)")
 	headless := fs.Bool("headless", true, "Headless mode")
-	trace := fs.Bool("trace", false, "Capture trace (stub)")
-	har := fs.Bool("har", false, "Capture HAR (stub)")
+	trace := fs.Bool("trace", false, "Capture a Playwright trace, one chunk per step")
+	har := fs.Bool("har", false, "Record network.har")
+	harContent := fs.String("har-content", "embed", "HAR body storage: embed, attach or omit")
+	harMode := fs.String("har-mode", "full", "HAR detail: full or minimal")
+	harFilter := fs.String("har-url-filter", "", "Only record requests matching this URL glob or /regex/")
 	replayHar := fs.String("replay-har", "", "Replay from HAR file")
+	harStrict := fs.Bool("har-strict", false, "Fail requests missing from --replay-har instead of going to the network")
+	harMatch := fs.String("har-match", "url", "HAR replay matching: url or url+body")
+	proxyRecord := fs.String("proxy-record", "", "Record all browser traffic (workers and extensions included) through the MITM proxy into this cassette")
+	proxyReplay := fs.String("proxy-replay", "", "Serve all browser traffic from this proxy cassette")
+	visualThreshold := fs.Float64("visual-threshold", 0, "Visual diff colour tolerance 0-255 (YIQ distance)")
+	visualMaxRatio := fs.Float64("visual-max-ratio", 0, "Fail the run when more than this fraction of pixels differ from the baseline (0 only reports)")
+	ssim := fs.Bool("ssim", false, "Also compute an SSIM score for visual diffs")
+	ignoreJSON := fs.String("ignore-regions", "", "JSON array of regions left out of visual diffs [{\"selector\":\".ad\"},{\"x\":0,\"y\":0,\"width\":300,\"height\":40}]")
+	snapshot := fs.String("snapshot", "", "Also snapshot each checkpoint's DOM (dom) or accessibility tree (a11y) and diff it against its baseline")
+	snapshotRules := fs.String("snapshot-rules", "", "JSON file of snapshot normalisation rules (strip_attrs, mask_values, mask_text, ignore)")
+	mutations := fs.Bool("mutations", false, "Journal DOM mutations to artifacts/mutations.ndjson (on whenever a step uses assert-mutations)")
+	noStabilize := fs.Bool("no-stabilize", false, "Take screenshots after a fixed wait instead of freezing animations and waiting for two identical frames")
+	hideScrollbars := fs.Bool("hide-scrollbars", false, "Hide scrollbars in screenshots")
+	stableBudget := fs.Duration("stable-budget", 0, "How long a screenshot may take to settle (default 5s)")
+	deterministic := fs.Bool("deterministic", false, "Freeze the clock, seed randomness and pin timezone, locale, reduced motion and DPR")
+	clock := fs.String("clock", "", "Fixed time for --deterministic, RFC 3339 (default 2025-01-01T12:00:00Z)")
+	var seed *uint32
+	fs.Func("seed", "Math.random/crypto seed for --deterministic, 0-4294967295 (default 1)", func(v string) error {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return fmt.Errorf("want an integer from 0 to 4294967295")
+		}
+		u := uint32(n)
+		seed = &u
+		return nil
+	})
+	timezone := fs.String("timezone", "", "Timezone for --deterministic (default UTC)")
+	locale := fs.String("locale", "", "Browser locale, e.g. de-DE (en-US under --deterministic)")
+	viewport := fs.String("viewport", "", "Viewport WIDTHxHEIGHT (default 1280x720, or the device's)")
+	device := fs.String("device", "", "Playwright device to emulate, e.g. \"iPhone 13\" (user agent, viewport, DPR, touch)")
+	colorScheme := fs.String("color-scheme", "", "prefers-color-scheme: light, dark or no-preference")
+	matrixViewports := fs.String("matrix-viewports", "", "Matrix run: comma-separated viewports")
+	matrixDevices := fs.String("matrix-devices", "", "Matrix run: comma-separated device names")
+	matrixSchemes := fs.String("matrix-color-schemes", "", "Matrix run: comma-separated color schemes")
+	matrixLocales := fs.String("matrix-locales", "", "Matrix run: comma-separated locales")
+	impact := fs.Bool("impact", false, "Run without and with the script and report what the script changed")
+	dpr := fs.Float64("dpr", 0, "Device scale factor for --deterministic (default 1)")
+	emulate := fs.String("emulate", "", "Comma-separated emulation profiles: "+strings.Join(runner.EmulationProfileNames(), ", "))
 	baseline := fs.String("baseline", os.Getenv("BASELINE_DIR"), "Baseline dir for visual diff")
 	stepsJSON := fs.String("steps", "", "JSON array of steps [{\"action\":\"click\",\"target\":\"text=...\"}]")
+	dialog := fs.String("dialog", "accept", "Dialog policy: accept, dismiss, or respond:<text>")
+	debug := fs.Bool("debug", false, "Headed slow-mo run that pauses with a REPL on the first failing step")
+	redact := fs.String("redact", "", "JSON file with network redaction rules (headers, cookies, query_params, body_patterns)")
+	netAssertPath := fs.String("network-assertions", "", "JSON file with an array of network assertions checked after the run")
+	networkBodies := fs.Bool("network-bodies", false, "Capture text/JSON bodies in logs/network.ndjson")
+	sandbox := fs.Bool("sandbox", false, "Abort requests to hosts outside the target, the script's @connect hosts and --allow-hosts")
+	allowHosts := fs.String("allow-hosts", "", "Comma-separated extra hosts the sandbox lets through")
+	breakAt := fs.String("break-at", "", "Comma-separated step indexes (1-based) to pause before; implies --debug")
 	fs.Parse(args)
 
+	var proxyMode, proxyCassette string
+	switch {
+	case *proxyRecord != "" && *proxyReplay != "":
+		log.Fatal("use either --proxy-record or --proxy-replay, not both")
+	case *proxyRecord != "":
+		proxyMode, proxyCassette = "record", *proxyRecord
+	case *proxyReplay != "":
+		proxyMode, proxyCassette = "replay", *proxyReplay
+	}
+
+	var determinism *runner.Determinism
+	if *deterministic || *clock != "" || seed != nil || *timezone != "" || *dpr != 0 {
+		determinism = &runner.Determinism{Seed: seed, Timezone: *timezone, DeviceScaleFactor: *dpr}
+		if *clock != "" {
+			t, err := time.Parse(time.RFC3339, *clock)
+			if err != nil {
+				log.Fatalf("invalid --clock: %v", err)
+			}
+			determinism.Time = t
+		}
+	}
+
+	var breakpoints []int
+	for _, v := range strings.Split(*breakAt, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("invalid --break-at index %q", v)
+		}
+		breakpoints = append(breakpoints, n)
+	}
+
 	var blocked []string
 	if env := os.Getenv("BLOCKED_HOSTS"); env != "" {
 		for _, h := range strings.Split(env, ",") {
@@ -70,19 +179,122 @@ func runCmd(args []string) {
 		}
 	}
 
+	var ignoreRegions []runner.IgnoreRegion
+	if strings.TrimSpace(*ignoreJSON) != "" {
+		if err := json.Unmarshal([]byte(*ignoreJSON), &ignoreRegions); err != nil {
+			log.Fatalf("invalid ignore regions JSON: %v", err)
+		}
+	}
+	var sandboxHosts []string
+	for _, h := range strings.Split(*allowHosts, ",") {
+		if trimmed := strings.TrimSpace(h); trimmed != "" {
+			sandboxHosts = append(sandboxHosts, trimmed)
+		}
+	}
+	var netAsserts []runner.NetworkAssertion
+	if *netAssertPath != "" {
+		data, err := os.ReadFile(*netAssertPath)
+		if err != nil {
+			log.Fatalf("read network assertions: %v", err)
+		}
+		if err := json.Unmarshal(data, &netAsserts); err != nil {
+			log.Fatalf("invalid network assertions: %v", err)
+		}
+	}
+	var snapRules *runner.SnapshotRules
+	if *snapshotRules != "" {
+		data, err := os.ReadFile(*snapshotRules)
+		if err != nil {
+			log.Fatalf("read snapshot rules: %v", err)
+		}
+		snapRules = &runner.SnapshotRules{}
+		if err := json.Unmarshal(data, snapRules); err != nil {
+			log.Fatalf("invalid snapshot rules: %v", err)
+		}
+	}
+	var redaction *runner.RedactionRules
+	if *redact != "" {
+		data, err := os.ReadFile(*redact)
+		if err != nil {
+			log.Fatalf("read redaction rules: %v", err)
+		}
+		redaction = &runner.RedactionRules{}
+		if err := json.Unmarshal(data, redaction); err != nil {
+			log.Fatalf("invalid redaction rules: %v", err)
+		}
+	}
+
 	opts := runner.Options{
-		TargetURL:    *url,
-		ScriptPath:   *script,
-		Engine:       *engine,
-		ExtensionDir: strings.TrimSpace(*ext),
-		Headless:     *headless,
-		CaptureTrace: *trace,
-		CaptureHAR:   *har,
-		ReplayHAR:    strings.TrimSpace(*replayHar),
-		BaselineDir:  strings.TrimSpace(*baseline),
-		BlockedHosts: blocked,
-		Steps:        steps,
-		Workspace:    ".",
+		TargetURL:           *url,
+		ScriptPath:          *script,
+		Engine:              *engine,
+		ExtensionDir:        strings.TrimSpace(*ext),
+		Headless:            *headless,
+		CaptureTrace:        *trace,
+		CaptureHAR:          *har,
+		HARContent:          *harContent,
+		HARMode:             *harMode,
+		HARURLFilter:        *harFilter,
+		ReplayHAR:           strings.TrimSpace(*replayHar),
+		ReplayHARStrict:     *harStrict,
+		ReplayHARMatch:      *harMatch,
+		BaselineDir:         strings.TrimSpace(*baseline),
+		VisualDiffThreshold: *visualThreshold,
+		VisualMaxDiffRatio:  *visualMaxRatio,
+		VisualSSIM:          *ssim,
+		IgnoreRegions:       ignoreRegions,
+		NoStabilize:         *noStabilize,
+		Mutations:           *mutations,
+		Snapshot:            *snapshot,
+		SnapshotRules:       snapRules,
+		HideScrollbars:      *hideScrollbars,
+		StableBudget:        *stableBudget,
+		BlockedHosts:        blocked,
+		Steps:               steps,
+		DialogPolicy:        *dialog,
+		Debug:               *debug,
+		BreakAt:             breakpoints,
+		Redaction:           redaction,
+		NetworkBodies:       *networkBodies,
+		NetworkAssertions:   netAsserts,
+		Sandbox:             *sandbox,
+		SandboxAllowHosts:   sandboxHosts,
+		ProxyMode:           proxyMode,
+		ProxyCassette:       proxyCassette,
+		Emulation:           *emulate,
+		Deterministic:       determinism,
+		Viewport:            *viewport,
+		Device:              *device,
+		ColorScheme:         *colorScheme,
+		Locale:              *locale,
+		Workspace:           ".",
+	}
+	matrix := runner.Matrix{
+		Viewports:    splitList(*matrixViewports),
+		Devices:      splitList(*matrixDevices),
+		ColorSchemes: splitList(*matrixSchemes),
+		Locales:      splitList(*matrixLocales),
+	}
+	if *impact && !matrix.Empty() {
+		log.Fatal("--impact cannot be combined with --matrix-* flags")
+	}
+	if *impact {
+		rep, err := runner.RunImpact(opts)
+		if err != nil {
+			log.Fatalf("impact run failed: %v", err)
+		}
+		b, _ := json.MarshalIndent(rep, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+	if !matrix.Empty() {
+		mm, err := runner.RunMatrix(opts, matrix)
+		if err != nil {
+			log.Fatalf("matrix run failed: %v", err)
+		}
+		b, _ := json.MarshalIndent(mm, "", "  ")
+		fmt.Println(string(b))
+		return
 	}
 	res, err := runner.Run(opts)
 	if err != nil {
@@ -92,6 +304,139 @@ func runCmd(args []string) {
 	fmt.Println(string(b))
 }
 
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func recordCmd(args []string) {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	url := fs.String("url", "", "Target URL")
+	script := fs.String("script", "", "Userscript path")
+	out := fs.String("out", "recorded-steps.json", "Where to write the recorded steps")
+	fs.Parse(args)
+
+	log.Printf("recording %s; close the browser window to finish", *url)
+	steps, err := runner.Record(runner.RecordOptions{TargetURL: *url, ScriptPath: *script})
+	if err != nil {
+		log.Fatalf("record failed: %v", err)
+	}
+	b, _ := json.MarshalIndent(steps, "", "  ")
+	if err := os.WriteFile(*out, append(b, '\n'), 0o644); err != nil {
+		log.Fatalf("write steps: %v", err)
+	}
+	log.Printf("wrote %d steps to %s (use with: lab run --steps \"$(cat %s)\")", len(steps), *out, *out)
+}
+
+func importStepsCmd(args []string) {
+	fs := flag.NewFlagSet("import-steps", flag.ExitOnError)
+	chrome := fs.String("chrome", "", "Chrome DevTools Recorder JSON export")
+	out := fs.String("out", "steps.json", "Where to write the converted steps")
+	fs.Parse(args)
+
+	data, err := os.ReadFile(*chrome)
+	if err != nil {
+		log.Fatalf("read recording: %v", err)
+	}
+	flow, err := flows.FromChromeRecorder(data)
+	if err != nil {
+		log.Fatalf("import failed: %v", err)
+	}
+	for _, s := range flow.Skipped {
+		log.Printf("skipped step %d (%s): %s", s.Index, s.Type, s.Reason)
+	}
+	b, _ := json.MarshalIndent(flow.Steps, "", "  ")
+	if err := os.WriteFile(*out, append(b, '\n'), 0o644); err != nil {
+		log.Fatalf("write steps: %v", err)
+	}
+	log.Printf("wrote %d steps to %s", len(flow.Steps), *out)
+	if flow.URL != "" {
+		// Steps files have no place for the start page; it becomes the run's --url.
+		log.Printf("warning: the recording starts at %s, which is not in the steps; run them with --url %s", flow.URL, flow.URL)
+	}
+}
+
+func exportStepsCmd(args []string) {
+	fs := flag.NewFlagSet("export-steps", flag.ExitOnError)
+	stepsPath := fs.String("steps", "", "Steps JSON file")
+	url := fs.String("url", "", "Target URL")
+	script := fs.String("script", "", "Userscript path")
+	title := fs.String("title", "", "Test title")
+	out := fs.String("out", "flow.spec.ts", "Where to write the Playwright test")
+	fs.Parse(args)
+
+	data, err := os.ReadFile(*stepsPath)
+	if err != nil {
+		log.Fatalf("read steps: %v", err)
+	}
+	var steps []runner.Step
+	if err := json.Unmarshal(data, &steps); err != nil {
+		log.Fatalf("invalid steps JSON: %v", err)
+	}
+	src, warnings := flows.PlaywrightTest(*title, *url, *script, steps)
+	for _, w := range warnings {
+		log.Printf("warning: %s", w)
+	}
+	if err := os.WriteFile(*out, []byte(src), 0o644); err != nil {
+		log.Fatalf("write test: %v", err)
+	}
+	log.Printf("wrote %s", *out)
+}
+
+func harCmd(args []string) {
+	if len(args) == 0 || args[0] != "edit" {
+		usage()
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("har edit", flag.ExitOnError)
+	harPath := fs.String("har", "", "HAR file to edit")
+	urlPattern := fs.String("url", "", "Request URL glob or /regex/ selecting entries (default: all)")
+	method := fs.String("method", "", "Only edit entries with this request method")
+	find := fs.String("find", "", "Text to replace in matching response bodies")
+	replace := fs.String("replace", "", "Replacement text")
+	regex := fs.Bool("regex", false, "Treat --find as a regular expression ($1 expands groups)")
+	bodyFile := fs.String("body-file", "", "Replace matching response bodies with this file")
+	out := fs.String("out", "", "Where to write the edited HAR (default: overwrite --har)")
+	fs.Parse(args[1:])
+
+	if *harPath == "" {
+		log.Fatal("--har is required")
+	}
+	data, err := os.ReadFile(*harPath)
+	if err != nil {
+		log.Fatalf("read HAR: %v", err)
+	}
+	rule := har.EditRule{Method: *method, Find: *find, Replace: *replace, Regex: *regex}
+	if *urlPattern != "" {
+		if rule.Match, err = runner.URLFilter(*urlPattern); err != nil {
+			log.Fatalf("invalid --url: %v", err)
+		}
+	}
+	if *bodyFile != "" {
+		if rule.Body, err = os.ReadFile(*bodyFile); err != nil {
+			log.Fatalf("read body file: %v", err)
+		}
+	}
+	dest := *out
+	if dest == "" {
+		dest = *harPath
+	}
+	edited, n, err := har.Edit(data, filepath.Dir(*harPath), filepath.Dir(dest), rule)
+	if err != nil {
+		log.Fatalf("edit HAR: %v", err)
+	}
+	if err := os.WriteFile(dest, edited, 0o644); err != nil {
+		log.Fatalf("write HAR: %v", err)
+	}
+	log.Printf("rewrote %d response bodies into %s", n, dest)
+}
+
 func listCmd() {
 	runs, err := runner.FindRuns(".")
 	if err != nil {
@@ -179,6 +524,8 @@ func (s *server) routes() http.Handler {
 	mux.HandleFunc("/v1/runs", auth.authenticate(s.handleRuns))
 	mux.HandleFunc("/v1/runs/", s.handleRunByID) // Read-only, no auth required
 	mux.HandleFunc("/v1/extensions", auth.authenticate(s.handleExtensions))
+	mux.HandleFunc("/v1/baselines", auth.authenticate(s.handleBaselines))
+	mux.HandleFunc("/v1/baselines/", auth.authenticate(s.handleBaselines))
 	// static files for artifacts
 	runsDir := filepath.Join(s.workspace, "runs")
 	mux.Handle("/runs/", http.StripPrefix("/runs/", http.FileServer(http.Dir(runsDir))))
@@ -198,20 +545,51 @@ func (s *server) health(w http.ResponseWriter, r *http.Request) {
 }
 
 type runRequest struct {
-	URL             string        `json:"url"`
-	Script          string        `json:"script"`
-	ScriptURL       string        `json:"script_url"`
-	ScriptGitRepo   string        `json:"script_git_repo"`
-	ScriptGitPath   string        `json:"script_git_path"`
-	Engine          string        `json:"engine"`
-	ExtensionDir    string        `json:"extension_dir"`
-	Headless        *bool         `json:"headless"`
-	HAR             bool          `json:"har"`
-	ReplayHAR       string        `json:"replay_har"`
-	Baseline        string        `json:"baseline"`
-	BlockedHosts    []string      `json:"blocked_hosts"`
-	VisualThreshold float64       `json:"visual_threshold"`
-	Steps           []runner.Step `json:"steps"`
+	URL               string                    `json:"url"`
+	Script            string                    `json:"script"`
+	ScriptURL         string                    `json:"script_url"`
+	ScriptGitRepo     string                    `json:"script_git_repo"`
+	ScriptGitPath     string                    `json:"script_git_path"`
+	Engine            string                    `json:"engine"`
+	ExtensionDir      string                    `json:"extension_dir"`
+	Headless          *bool                     `json:"headless"`
+	Trace             bool                      `json:"trace"`
+	HAR               bool                      `json:"har"`
+	HARContent        string                    `json:"har_content"`
+	HARMode           string                    `json:"har_mode"`
+	HARURLFilter      string                    `json:"har_url_filter"`
+	ReplayHAR         string                    `json:"replay_har"`
+	ReplayHARStrict   bool                      `json:"replay_har_strict"`
+	ReplayHARMatch    string                    `json:"replay_har_match"`
+	Baseline          string                    `json:"baseline"`
+	BlockedHosts      []string                  `json:"blocked_hosts"`
+	VisualThreshold   float64                   `json:"visual_threshold"`
+	VisualMaxRatio    float64                   `json:"visual_max_ratio"`
+	VisualSSIM        bool                      `json:"visual_ssim"`
+	IgnoreRegions     []runner.IgnoreRegion     `json:"ignore_regions"`
+	NoStabilize       bool                      `json:"no_stabilize"`
+	Mutations         bool                      `json:"mutations"`
+	Snapshot          string                    `json:"snapshot"`
+	SnapshotRules     *runner.SnapshotRules     `json:"snapshot_rules"`
+	HideScrollbars    bool                      `json:"hide_scrollbars"`
+	StableBudgetMS    int                       `json:"stable_budget_ms"`
+	Steps             []runner.Step             `json:"steps"`
+	DialogPolicy      string                    `json:"dialog_policy"`
+	Redaction         *runner.RedactionRules    `json:"redaction"`
+	NetworkBodies     bool                      `json:"network_bodies"`
+	NetworkAssertions []runner.NetworkAssertion `json:"network_assertions"`
+	Sandbox           bool                      `json:"sandbox"`
+	SandboxAllowHosts []string                  `json:"sandbox_allow_hosts"`
+	ProxyMode         string                    `json:"proxy_mode"`
+	ProxyCassette     string                    `json:"proxy_cassette"`
+	Emulation         string                    `json:"emulation"`
+	Deterministic     *runner.Determinism       `json:"deterministic"`
+	Viewport          string                    `json:"viewport"`
+	Device            string                    `json:"device"`
+	ColorScheme       string                    `json:"color_scheme"`
+	Locale            string                    `json:"locale"`
+	Matrix            *runner.Matrix            `json:"matrix"`
+	Impact            bool                      `json:"impact"`
 }
 
 func (s *server) handleRuns(w http.ResponseWriter, r *http.Request) {
@@ -241,17 +619,76 @@ func (s *server) handleRuns(w http.ResponseWriter, r *http.Request) {
 		Engine:              req.Engine,
 		ExtensionDir:        strings.TrimSpace(req.ExtensionDir),
 		Headless:            true,
+		CaptureTrace:        req.Trace,
 		CaptureHAR:          req.HAR,
+		HARContent:          req.HARContent,
+		HARMode:             req.HARMode,
+		HARURLFilter:        req.HARURLFilter,
 		ReplayHAR:           strings.TrimSpace(req.ReplayHAR),
+		ReplayHARStrict:     req.ReplayHARStrict,
+		ReplayHARMatch:      req.ReplayHARMatch,
 		BaselineDir:         strings.TrimSpace(req.Baseline),
 		VisualDiffThreshold: req.VisualThreshold,
+		VisualMaxDiffRatio:  req.VisualMaxRatio,
+		VisualSSIM:          req.VisualSSIM,
+		IgnoreRegions:       req.IgnoreRegions,
+		NoStabilize:         req.NoStabilize,
+		Mutations:           req.Mutations,
+		Snapshot:            req.Snapshot,
+		SnapshotRules:       req.SnapshotRules,
+		HideScrollbars:      req.HideScrollbars,
+		StableBudget:        time.Duration(req.StableBudgetMS) * time.Millisecond,
 		BlockedHosts:        blocked,
 		Steps:               req.Steps,
+		DialogPolicy:        req.DialogPolicy,
+		Redaction:           req.Redaction,
+		NetworkBodies:       req.NetworkBodies,
+		NetworkAssertions:   req.NetworkAssertions,
+		Sandbox:             req.Sandbox,
+		SandboxAllowHosts:   req.SandboxAllowHosts,
+		ProxyMode:           req.ProxyMode,
+		ProxyCassette:       strings.TrimSpace(req.ProxyCassette),
+		Emulation:           req.Emulation,
+		Deterministic:       req.Deterministic,
+		Viewport:            req.Viewport,
+		Device:              req.Device,
+		ColorScheme:         req.ColorScheme,
+		Locale:              req.Locale,
 		Workspace:           s.workspace,
 	}
 	if req.Headless != nil {
 		opts.Headless = *req.Headless
 	}
+	if opts.ProxyCassette != "" {
+		p, err := cassettePath(s.workspace, opts.ProxyCassette)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		opts.ProxyCassette = p
+	}
+	if req.Impact && req.Matrix != nil && !req.Matrix.Empty() {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "impact cannot be combined with matrix"})
+		return
+	}
+	if req.Impact {
+		rep, err := runner.RunImpact(opts)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, normalizeImpactPaths(rep))
+		return
+	}
+	if req.Matrix != nil && !req.Matrix.Empty() {
+		mm, err := runner.RunMatrix(opts, *req.Matrix)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, mm)
+		return
+	}
 
 	res, err := runner.Run(opts)
 	if err != nil {
@@ -271,6 +708,26 @@ func (s *server) handleRunByID(w http.ResponseWriter, r *http.Request) {
 	runID := parts[0]
 	if len(parts) == 1 {
 		manifestPath := filepath.Join(s.workspace, "runs", runID, "run.json")
+		matrixPath := filepath.Join(s.workspace, "runs", runID, "matrix.json")
+		if _, err := os.Stat(matrixPath); err == nil {
+			mm, err := runner.LoadMatrixManifest(matrixPath)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, mm)
+			return
+		}
+		impactPath := filepath.Join(s.workspace, "runs", runID, "impact.json")
+		if _, err := os.Stat(impactPath); err == nil {
+			rep, err := runner.LoadImpactReport(impactPath)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, normalizeImpactPaths(rep))
+			return
+		}
 		if _, err := os.Stat(manifestPath); err != nil {
 			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
 			return
@@ -290,9 +747,79 @@ func (s *server) handleRunByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(parts) >= 2 && parts[1] == "network" {
+		logPath := filepath.Join(s.workspace, "runs", runID, "logs", "network.ndjson")
+		http.ServeFile(w, r, logPath)
+		return
+	}
+
 	writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown path"})
 }
 
+// normalizeImpactPaths turns the impact report's artifact paths into URLs.
+func normalizeImpactPaths(rep runner.ImpactReport) runner.ImpactReport {
+	if rep.Visual == nil {
+		return rep
+	}
+	prefix := "/runs/" + rep.RunID + "/artifacts/"
+	v := *rep.Visual
+	if v.DiffImg != "" {
+		v.DiffImg = prefix + v.DiffImg
+	}
+	if v.CompareImg != "" {
+		v.CompareImg = prefix + v.CompareImg
+	}
+	if v.Baseline != "" {
+		v.Baseline = "/" + filepath.ToSlash(v.Baseline)
+	}
+	rep.Visual = &v
+	return rep
+}
+
+// cassettePath resolves an API-supplied proxy cassette against
+// <workspace>/cassettes and rejects anything outside it. Recording writes the
+// cassette and its bodies/ directory, so a client-chosen path could otherwise
+// overwrite arbitrary files; replay gets the same check so cassettes cannot be
+// read from elsewhere either.
+func cassettePath(workspace, p string) (string, error) {
+	root, err := filepath.Abs(filepath.Join(workspace, "cassettes"))
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(root, p)
+	}
+	p = filepath.Clean(p)
+	if !within(root, p) || p == root {
+		return "", fmt.Errorf("invalid proxy_cassette %q: must be a file under %s", p, root)
+	}
+	// Symlinks inside the directory must not lead out of it.
+	if realRoot, err := filepath.EvalSymlinks(root); err == nil {
+		for dir := filepath.Dir(p); within(root, dir); dir = filepath.Dir(dir) {
+			real, err := filepath.EvalSymlinks(dir)
+			if err != nil {
+				continue // not created yet
+			}
+			if !within(realRoot, real) {
+				return "", fmt.Errorf("invalid proxy_cassette %q: leaves %s", p, root)
+			}
+			break
+		}
+		for _, f := range []string{p, filepath.Join(filepath.Dir(p), "bodies")} {
+			if real, err := filepath.EvalSymlinks(f); err == nil && !within(realRoot, real) {
+				return "", fmt.Errorf("invalid proxy_cassette %q: leaves %s", p, root)
+			}
+		}
+	}
+	return p, nil
+}
+
+// within reports whether p is dir or inside it.
+func within(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func sanitizeFilename(filename string) (string, error) {
 	// Strip directory components
 	base := filepath.Base(filename)
@@ -385,12 +912,82 @@ func normalizeManifestPaths(res runner.Result) runner.Manifest {
 	if m.HAR != "" && !strings.HasPrefix(m.HAR, "/runs/") {
 		m.HAR = prefix + m.HAR
 	}
-	if m.TraceZip != "" && !strings.HasPrefix(m.TraceZip, "/runs/") {
-		m.TraceZip = prefix + m.TraceZip
-	}
 	if m.VisualDiffImg != "" && !strings.HasPrefix(m.VisualDiffImg, "/runs/") {
 		m.VisualDiffImg = prefix + m.VisualDiffImg
 	}
+	if m.VisualCompareImg != "" && !strings.HasPrefix(m.VisualCompareImg, "/runs/") {
+		m.VisualCompareImg = prefix + m.VisualCompareImg
+	}
+	if m.Visual != nil {
+		v := *m.Visual
+		if v.DiffImg != "" && !strings.HasPrefix(v.DiffImg, "/runs/") {
+			v.DiffImg = prefix + v.DiffImg
+		}
+		if v.CompareImg != "" && !strings.HasPrefix(v.CompareImg, "/runs/") {
+			v.CompareImg = prefix + v.CompareImg
+		}
+		m.Visual = &v
+	}
+	if m.ProposedSteps != "" && !strings.HasPrefix(m.ProposedSteps, "/runs/") {
+		m.ProposedSteps = prefix + m.ProposedSteps
+	}
+	if m.Mutations != nil && !strings.HasPrefix(m.Mutations.Journal, "/runs/") {
+		mu := *m.Mutations
+		mu.Journal = prefix + mu.Journal
+		m.Mutations = &mu
+	}
+	downloads := make([]runner.DownloadRecord, len(m.Downloads))
+	for i, d := range m.Downloads {
+		if d.Path != "" && !strings.HasPrefix(d.Path, "/runs/") {
+			d.Path = prefix + d.Path
+		}
+		downloads[i] = d
+	}
+	m.Downloads = downloads
+	chunks := make([]runner.TraceChunk, len(m.TraceChunks))
+	for i, c := range m.TraceChunks {
+		if c.Path != "" && !strings.HasPrefix(c.Path, "/runs/") {
+			c.Path = prefix + c.Path
+		}
+		chunks[i] = c
+	}
+	m.TraceChunks = chunks
+	shots := make([]runner.ScreenshotResult, len(m.Screenshots))
+	for i, sh := range m.Screenshots {
+		if sh.Path != "" && !strings.HasPrefix(sh.Path, "/runs/") {
+			sh.Path = prefix + sh.Path
+		}
+		if sh.Visual != nil {
+			v := *sh.Visual
+			if v.DiffImg != "" && !strings.HasPrefix(v.DiffImg, "/runs/") {
+				v.DiffImg = prefix + v.DiffImg
+			}
+			if v.CompareImg != "" && !strings.HasPrefix(v.CompareImg, "/runs/") {
+				v.CompareImg = prefix + v.CompareImg
+			}
+			sh.Visual = &v
+		}
+		if sh.Snapshot != nil {
+			sn := *sh.Snapshot
+			if sn.Path != "" && !strings.HasPrefix(sn.Path, "/runs/") {
+				sn.Path = prefix + sn.Path
+			}
+			if sn.DiffPath != "" && !strings.HasPrefix(sn.DiffPath, "/runs/") {
+				sn.DiffPath = prefix + sn.DiffPath
+			}
+			sh.Snapshot = &sn
+		}
+		shots[i] = sh
+	}
+	m.Screenshots = shots
+	steps := make([]runner.StepResult, len(m.Steps))
+	for i, s := range m.Steps {
+		if s.Trace != "" && !strings.HasPrefix(s.Trace, "/runs/") {
+			s.Trace = prefix + s.Trace
+		}
+		steps[i] = s
+	}
+	m.Steps = steps
 	return m
 }
 
@@ -0,0 +1,318 @@
+// Package baseline stores approved screenshots for visual comparison. Each
+// set of baselines is keyed by script, URL, viewport and engine and lives in
+// its own directory:
+//
+//	<dir>/<set-id>/baseline.json          key and checkpoint status
+//	<dir>/<set-id>/<checkpoint>.png       approved baseline
+//	<dir>/<set-id>/<checkpoint>.pending.png  candidate awaiting approval
+//	<dir>/<set-id>/<checkpoint>.<kind>.json          approved DOM or accessibility snapshot
+//	<dir>/<set-id>/<checkpoint>.pending.<kind>.json  snapshot candidate
+//
+// New checkpoints start out pending. Only an explicit approval turns a
+// screenshot or snapshot into the truth later runs are compared against.
+package baseline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checkpoint statuses.
+const (
+	StatusApproved = "approved"
+	StatusPending  = "pending"
+)
+
+const indexFile = "baseline.json"
+
+// Key identifies one set of baselines.
+type Key struct {
+	Script   string `json:"script"`   // namespace/name from the userscript header, or the file name
+	URL      string `json:"url"`      // target URL without the fragment
+	Viewport string `json:"viewport"` // WxH, e.g. 1280x720
+	Engine   string `json:"engine"`
+	Variant  string `json:"variant,omitempty"` // device, colour scheme and locale, when set
+}
+
+var unsafeChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Slug makes a checkpoint or script name safe for file names.
+func Slug(s string) string {
+	return strings.Trim(unsafeChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// ID is the directory name of the set: a readable script slug plus a hash of
+// the whole key. Keys without a variant hash as they did before variants.
+func (k Key) ID() string {
+	fields := []string{k.Script, k.URL, k.Viewport, k.Engine}
+	if k.Variant != "" {
+		fields = append(fields, k.Variant)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x00")))
+	slug := Slug(k.Script)
+	if len(slug) > 40 {
+		slug = strings.Trim(slug[:40], "-")
+	}
+	if slug == "" {
+		slug = "script"
+	}
+	return slug + "-" + hex.EncodeToString(sum[:6])
+}
+
+// Checkpoint is the state of one named screenshot in a set.
+type Checkpoint struct {
+	Status    string                 `json:"status"`
+	Hash      string                 `json:"hash"` // sha256 of the PNG for Status
+	Run       string                 `json:"run"`  // run the image came from
+	UpdatedAt time.Time              `json:"updated_at"`
+	Snapshots map[string]*Checkpoint `json:"snapshots,omitempty"` // DOM or accessibility snapshots by kind
+}
+
+// Set is one keyed group of baselines.
+type Set struct {
+	ID          string                 `json:"id"`
+	Key         Key                    `json:"key"`
+	Checkpoints map[string]*Checkpoint `json:"checkpoints"`
+}
+
+// Store is a baseline directory.
+type Store struct {
+	Dir string
+}
+
+// mu serialises index updates; runs started by the server share stores.
+var mu sync.Mutex
+
+// Open returns the store rooted at dir.
+func Open(dir string) *Store {
+	return &Store{Dir: dir}
+}
+
+// ApprovedPath returns the approved baseline for a checkpoint, if any.
+func (s *Store) ApprovedPath(k Key, checkpoint string) (string, bool) {
+	return s.approved(k, checkpoint, "")
+}
+
+// ApprovedSnapshotPath returns the approved snapshot of the given kind for a
+// checkpoint, if any.
+func (s *Store) ApprovedSnapshotPath(k Key, checkpoint, kind string) (string, bool) {
+	return s.approved(k, checkpoint, kind)
+}
+
+func (s *Store) approved(k Key, checkpoint, kind string) (string, bool) {
+	p := filepath.Join(s.Dir, k.ID(), fileName(Slug(checkpoint), kind, false))
+	if _, err := os.Stat(p); err != nil {
+		return p, false
+	}
+	return p, true
+}
+
+// Propose records png as the pending baseline for a checkpoint with no
+// approved one and returns where it was written.
+func (s *Store) Propose(k Key, checkpoint, run string, png []byte) (string, error) {
+	return s.put(k, checkpoint, "", run, png, StatusPending)
+}
+
+// Approve makes png the approved baseline for a checkpoint, replacing any
+// earlier approval and dropping the pending candidate.
+func (s *Store) Approve(k Key, checkpoint, run string, png []byte) (string, error) {
+	return s.put(k, checkpoint, "", run, png, StatusApproved)
+}
+
+// ProposeSnapshot is Propose for a snapshot of the given kind, e.g. dom.
+func (s *Store) ProposeSnapshot(k Key, checkpoint, kind, run string, data []byte) (string, error) {
+	return s.put(k, checkpoint, kind, run, data, StatusPending)
+}
+
+// ApproveSnapshot is Approve for a snapshot of the given kind.
+func (s *Store) ApproveSnapshot(k Key, checkpoint, kind, run string, data []byte) (string, error) {
+	return s.put(k, checkpoint, kind, run, data, StatusApproved)
+}
+
+// fileName is a checkpoint's screenshot file, or its snapshot file when kind
+// is set.
+func fileName(name, kind string, pending bool) string {
+	ext := ".png"
+	if kind != "" {
+		ext = "." + kind + ".json"
+	}
+	if pending {
+		return name + ".pending" + ext
+	}
+	return name + ext
+}
+
+func (s *Store) put(k Key, checkpoint, kind, run string, data []byte, status string) (string, error) {
+	name := Slug(checkpoint)
+	if name == "" {
+		return "", errors.New("empty checkpoint name")
+	}
+	if kind != Slug(kind) {
+		return "", fmt.Errorf("invalid snapshot kind %q", kind)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	set, err := s.load(k.ID())
+	if err != nil {
+		return "", err
+	}
+	if set.ID == "" {
+		set = &Set{ID: k.ID(), Key: k, Checkpoints: map[string]*Checkpoint{}}
+	}
+	dir := filepath.Join(s.Dir, set.ID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	approved, pending := filepath.Join(dir, fileName(name, kind, false)), filepath.Join(dir, fileName(name, kind, true))
+	path := pending
+	if status == StatusApproved {
+		path = approved
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	if status == StatusApproved {
+		_ = os.Remove(pending)
+	}
+	sum := sha256.Sum256(data)
+	state := &Checkpoint{Status: status, Hash: hex.EncodeToString(sum[:]), Run: run, UpdatedAt: time.Now().UTC()}
+	cp := set.Checkpoints[name]
+	if cp == nil {
+		cp = &Checkpoint{}
+		set.Checkpoints[name] = cp
+	}
+	if kind == "" {
+		state.Snapshots = cp.Snapshots
+		set.Checkpoints[name] = state
+	} else {
+		if cp.Snapshots == nil {
+			cp.Snapshots = map[string]*Checkpoint{}
+		}
+		cp.Snapshots[kind] = state
+	}
+	return path, s.save(set)
+}
+
+// Legacy reports whether dir still holds a flat screenshot.png from before
+// baselines were keyed. Such files are never read; the run has to be
+// approved again to get a keyed set.
+func (s *Store) Legacy() bool {
+	info, err := os.Stat(filepath.Join(s.Dir, "screenshot.png"))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// List returns every set, sorted by ID.
+func (s *Store) List() ([]Set, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sets []Set
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		set, err := s.load(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if set.ID != "" {
+			sets = append(sets, *set)
+		}
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].ID < sets[j].ID })
+	return sets, nil
+}
+
+// Reset deletes baselines. An empty id removes every set; a checkpoint
+// narrows the reset to one checkpoint of the set. It returns the number of
+// checkpoints removed.
+func (s *Store) Reset(id, checkpoint string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if id == "" {
+		if checkpoint != "" {
+			return 0, errors.New("a checkpoint reset needs a set id")
+		}
+		sets, err := s.List()
+		if err != nil {
+			return 0, err
+		}
+		n := 0
+		for _, set := range sets {
+			if err := os.RemoveAll(filepath.Join(s.Dir, set.ID)); err != nil {
+				return n, err
+			}
+			n += len(set.Checkpoints)
+		}
+		return n, nil
+	}
+	if id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return 0, fmt.Errorf("invalid set id %q", id)
+	}
+	set, err := s.load(id)
+	if err != nil {
+		return 0, err
+	}
+	if set.ID == "" {
+		return 0, fmt.Errorf("no baseline set %q", id)
+	}
+	if checkpoint == "" {
+		return len(set.Checkpoints), os.RemoveAll(filepath.Join(s.Dir, id))
+	}
+	name := Slug(checkpoint)
+	if set.Checkpoints[name] == nil {
+		return 0, fmt.Errorf("set %s has no checkpoint %q", id, checkpoint)
+	}
+	files := []string{fileName(name, "", false), fileName(name, "", true)}
+	for kind := range set.Checkpoints[name].Snapshots {
+		files = append(files, fileName(name, kind, false), fileName(name, kind, true))
+	}
+	delete(set.Checkpoints, name)
+	for _, f := range files {
+		if err := os.Remove(filepath.Join(s.Dir, id, f)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	}
+	return 1, s.save(set)
+}
+
+// load reads a set's index; a missing index yields an empty Set.
+func (s *Store) load(id string) (*Set, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir, id, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Set{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var set Set
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("baseline index %s: %w", id, err)
+	}
+	if set.Checkpoints == nil {
+		set.Checkpoints = map[string]*Checkpoint{}
+	}
+	return &set, nil
+}
+
+func (s *Store) save(set *Set) error {
+	data, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.Dir, set.ID, indexFile), append(data, '\n'), 0o644)
+}
@@ -0,0 +1,152 @@
+package baseline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyID(t *testing.T) {
+	k := Key{Script: "me/Dark Mode", URL: "https://example.com/", Viewport: "1280x720", Engine: "chromium"}
+	if k.ID() != k.ID() {
+		t.Fatal("ID is not stable")
+	}
+	if got := k.ID(); got[:len("me-dark-mode-")] != "me-dark-mode-" {
+		t.Fatalf("ID = %q", got)
+	}
+	for _, other := range []Key{
+		{Script: k.Script, URL: "https://example.com/other", Viewport: k.Viewport, Engine: k.Engine},
+		{Script: k.Script, URL: k.URL, Viewport: "390x844", Engine: k.Engine},
+		{Script: k.Script, URL: k.URL, Viewport: k.Viewport, Engine: "firefox"},
+		{Script: k.Script, URL: k.URL, Viewport: k.Viewport, Engine: k.Engine, Variant: "dark"},
+	} {
+		if other.ID() == k.ID() {
+			t.Errorf("%+v shares ID with %+v", other, k)
+		}
+	}
+}
+
+func TestProposeApproveReset(t *testing.T) {
+	s := Open(t.TempDir())
+	k := Key{Script: "demo", URL: "https://example.com/", Viewport: "800x600", Engine: "chromium"}
+
+	if _, ok := s.ApprovedPath(k, "final"); ok {
+		t.Fatal("empty store has an approved baseline")
+	}
+	pending, err := s.Propose(k, "final", "run1", []byte("one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(pending) != "final.pending.png" {
+		t.Fatalf("pending path = %s", pending)
+	}
+	if _, ok := s.ApprovedPath(k, "final"); ok {
+		t.Fatal("a pending baseline counts as approved")
+	}
+
+	approved, err := s.Approve(k, "final", "run2", []byte("two"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := s.ApprovedPath(k, "final"); !ok || p != approved {
+		t.Fatalf("ApprovedPath = %s, %v; want %s", p, ok, approved)
+	}
+	if _, err := os.Stat(pending); !os.IsNotExist(err) {
+		t.Fatalf("pending candidate left behind: %v", err)
+	}
+	if _, err := s.Propose(k, "Open Menu", "run2", []byte("three")); err != nil {
+		t.Fatal(err)
+	}
+
+	sets, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 1 || sets[0].Key != k || len(sets[0].Checkpoints) != 2 {
+		t.Fatalf("List = %+v", sets)
+	}
+	if cp := sets[0].Checkpoints["final"]; cp.Status != StatusApproved || cp.Run != "run2" {
+		t.Fatalf("final = %+v", cp)
+	}
+	if cp := sets[0].Checkpoints["open-menu"]; cp.Status != StatusPending {
+		t.Fatalf("open-menu = %+v", cp)
+	}
+
+	if _, err := s.Reset("../etc", ""); err == nil {
+		t.Fatal("Reset accepted a path outside the store")
+	}
+	if n, err := s.Reset(k.ID(), "open-menu"); err != nil || n != 1 {
+		t.Fatalf("Reset checkpoint = %d, %v", n, err)
+	}
+	if n, err := s.Reset("", ""); err != nil || n != 1 {
+		t.Fatalf("Reset all = %d, %v", n, err)
+	}
+	if sets, _ := s.List(); len(sets) != 0 {
+		t.Fatalf("sets after reset: %+v", sets)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	s := Open(t.TempDir())
+	k := Key{Script: "demo", URL: "https://example.com/", Viewport: "800x600", Engine: "chromium"}
+
+	if _, err := s.Approve(k, "final", "run1", []byte("png")); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := s.ProposeSnapshot(k, "final", "dom", "run1", []byte(`{"t":"html"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(pending) != "final.pending.dom.json" {
+		t.Fatalf("pending snapshot = %s", pending)
+	}
+	if _, ok := s.ApprovedSnapshotPath(k, "final", "dom"); ok {
+		t.Fatal("pending snapshot reported as approved")
+	}
+	path, err := s.ApproveSnapshot(k, "final", "dom", "run2", []byte(`{"t":"html"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := s.ApprovedSnapshotPath(k, "final", "dom"); !ok || got != path {
+		t.Fatalf("approved snapshot = %s, %v", got, ok)
+	}
+	if _, err := os.Stat(pending); !os.IsNotExist(err) {
+		t.Fatal("pending snapshot left behind after approval")
+	}
+	// Re-approving the screenshot keeps the snapshot's state.
+	if _, err := s.Approve(k, "final", "run3", []byte("png2")); err != nil {
+		t.Fatal(err)
+	}
+	sets, _ := s.List()
+	cp := sets[0].Checkpoints["final"]
+	if cp.Run != "run3" || cp.Snapshots["dom"] == nil || cp.Snapshots["dom"].Status != StatusApproved || cp.Snapshots["dom"].Run != "run2" {
+		t.Fatalf("checkpoint = %+v", cp)
+	}
+	if _, err := s.ProposeSnapshot(k, "final", "../x", "run1", nil); err == nil {
+		t.Fatal("accepted an unsafe snapshot kind")
+	}
+
+	if n, err := s.Reset(k.ID(), "final"); err != nil || n != 1 {
+		t.Fatalf("Reset = %d, %v", n, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("snapshot left behind after reset")
+	}
+}
+
+func TestLegacy(t *testing.T) {
+	s := Open(t.TempDir())
+	if s.Legacy() {
+		t.Fatal("empty store reported as legacy")
+	}
+	if err := os.WriteFile(filepath.Join(s.Dir, "screenshot.png"), []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Legacy() {
+		t.Fatal("flat screenshot.png not reported")
+	}
+	sets, err := s.List()
+	if err != nil || len(sets) != 0 {
+		t.Fatalf("List = %v, %v; want no sets", sets, err)
+	}
+}
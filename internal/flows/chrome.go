@@ -0,0 +1,236 @@
+// Package flows converts step flows between the runner's DSL and external
+// recorder/test formats.
+package flows
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"philadelphia/internal/runner"
+)
+
+// Flow is a step list plus the page it starts on.
+type Flow struct {
+	Title   string        `json:"title,omitempty"`
+	URL     string        `json:"url,omitempty"`
+	Steps   []runner.Step `json:"steps"`
+	Skipped []Skipped     `json:"skipped,omitempty"`
+}
+
+// Skipped reports a source step that could not be translated.
+type Skipped struct {
+	Index  int    `json:"index"` // 0-based index in the source recording
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type chromeRecording struct {
+	Title string       `json:"title"`
+	Steps []chromeStep `json:"steps"`
+}
+
+type chromeStep struct {
+	Type       string          `json:"type"`
+	URL        string          `json:"url"`
+	Value      string          `json:"value"`
+	Key        string          `json:"key"`
+	Expression string          `json:"expression"`
+	Selectors  [][]string      `json:"-"`
+	RawSel     json.RawMessage `json:"selectors"`
+	Frame      []int           `json:"frame"`
+	Count      *int            `json:"count"`
+	Operator   string          `json:"operator"`
+	Visible    *bool           `json:"visible"`
+}
+
+var ariaRole = regexp.MustCompile(`^(.*?)\[role="([^"]+)"\]$`)
+
+// FromChromeRecorder converts a Chrome DevTools Recorder JSON export. The first
+// navigate step becomes Flow.URL; steps with no runner equivalent are listed in
+// Flow.Skipped rather than failing the import.
+func FromChromeRecorder(data []byte) (Flow, error) {
+	var rec chromeRecording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return Flow{}, fmt.Errorf("parse recording: %w", err)
+	}
+	flow := Flow{Title: rec.Title}
+	var held []string // modifiers currently pressed
+	skip := func(i int, typ, reason string) {
+		flow.Skipped = append(flow.Skipped, Skipped{Index: i, Type: typ, Reason: reason})
+	}
+	for i, st := range rec.Steps {
+		if err := st.parseSelectors(); err != nil {
+			skip(i, st.Type, err.Error())
+			continue
+		}
+		if len(st.Frame) > 0 {
+			skip(i, st.Type, "steps inside iframes are not supported")
+			continue
+		}
+		switch st.Type {
+		case "navigate":
+			if flow.URL == "" && len(flow.Steps) == 0 {
+				flow.URL = st.URL
+				continue
+			}
+			flow.Steps = append(flow.Steps, runner.Step{Action: "goto", Value: st.URL})
+		case "click", "change", "waitForElement":
+			sel, ok := pickSelector(st.Selectors)
+			if !ok {
+				skip(i, st.Type, "no translatable selector")
+				continue
+			}
+			switch st.Type {
+			case "click":
+				flow.Steps = append(flow.Steps, runner.Step{Action: "click", Target: sel})
+			case "change":
+				action := "fill"
+				if isSelectElement(st.Selectors) {
+					action = "select"
+				}
+				flow.Steps = append(flow.Steps, runner.Step{Action: action, Target: sel, Value: st.Value})
+			case "waitForElement":
+				switch {
+				case st.Visible != nil && !*st.Visible:
+					skip(i, st.Type, "waiting for a hidden element is not supported")
+				case st.Count != nil && !(*st.Count == 1 && (st.Operator == "" || st.Operator == ">=")):
+					skip(i, st.Type, fmt.Sprintf("element count %s %d is not supported", st.Operator, *st.Count))
+				default:
+					flow.Steps = append(flow.Steps, runner.Step{Action: "waitforselector", Target: sel})
+				}
+			}
+		case "keyDown":
+			if isModifier(st.Key) {
+				held = append(held, st.Key)
+				continue
+			}
+			key := strings.Join(append(append([]string(nil), held...), st.Key), "+")
+			flow.Steps = append(flow.Steps, runner.Step{Action: "press", Value: key})
+		case "keyUp":
+			for j, k := range held {
+				if k == st.Key {
+					held = append(held[:j], held[j+1:]...)
+					break
+				}
+			}
+		case "waitForExpression":
+			skip(i, st.Type, "polling expressions are not supported; use an eval step")
+		case "setViewport":
+			skip(i, st.Type, "viewport is a run option, not a step")
+		default:
+			skip(i, st.Type, "no equivalent runner action")
+		}
+	}
+	return flow, nil
+}
+
+// parseSelectors accepts both the nested ([][]string) and flat ([]string) forms.
+func (st *chromeStep) parseSelectors() error {
+	if len(st.RawSel) == 0 {
+		return nil
+	}
+	var nested [][]string
+	if err := json.Unmarshal(st.RawSel, &nested); err == nil {
+		st.Selectors = nested
+		return nil
+	}
+	var flat []string
+	if err := json.Unmarshal(st.RawSel, &flat); err != nil {
+		return fmt.Errorf("unrecognised selectors: %w", err)
+	}
+	for _, s := range flat {
+		st.Selectors = append(st.Selectors, []string{s})
+	}
+	return nil
+}
+
+// pickSelector chooses the most resilient translatable selector: ARIA role +
+// name, then text, then CSS, then XPath, then an ARIA name without a role.
+func pickSelector(groups [][]string) (string, bool) {
+	var aria, text, css, xpath, ariaName string
+	for _, chain := range groups {
+		if len(chain) == 0 {
+			continue
+		}
+		// Chains walk into shadow roots; Playwright CSS pierces open shadow DOM,
+		// so the innermost segment is enough when it is plain CSS.
+		last := chain[len(chain)-1]
+		switch {
+		case strings.HasPrefix(last, "aria/"):
+			name := strings.TrimPrefix(last, "aria/")
+			if m := ariaRole.FindStringSubmatch(name); m != nil {
+				if aria == "" {
+					aria = fmt.Sprintf("role=%s[name=%s]", m[2], quote(m[1]))
+				}
+			} else if ariaName == "" && len(chain) == 1 {
+				ariaName = "text=" + quote(name)
+			}
+		case strings.HasPrefix(last, "text/"):
+			if text == "" && len(chain) == 1 {
+				text = "text=" + quote(strings.TrimPrefix(last, "text/"))
+			}
+		case strings.HasPrefix(last, "xpath/"):
+			if xpath == "" && len(chain) == 1 {
+				xpath = "xpath=" + strings.TrimPrefix(last, "xpath/")
+			}
+		case strings.HasPrefix(last, "pierce/"):
+			if css == "" {
+				css = strings.TrimPrefix(last, "pierce/")
+			}
+		default:
+			if css == "" {
+				css = last
+			}
+		}
+	}
+	for _, s := range []string{aria, text, css, xpath, ariaName} {
+		if s != "" {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// selectTag matches a compound CSS selector for a <select> element.
+var selectTag = regexp.MustCompile(`(?i)^select($|[#.\[:])`)
+
+// isSelectElement reports whether a change step targets a <select>, judged
+// from its CSS selectors or an ARIA combobox/listbox role. Recordings do not
+// carry the element's tag, so this is a best guess.
+func isSelectElement(groups [][]string) bool {
+	for _, chain := range groups {
+		if len(chain) == 0 {
+			continue
+		}
+		last := strings.TrimPrefix(chain[len(chain)-1], "pierce/")
+		switch {
+		case strings.HasPrefix(last, "aria/"):
+			if m := ariaRole.FindStringSubmatch(strings.TrimPrefix(last, "aria/")); m != nil && (m[2] == "combobox" || m[2] == "listbox") {
+				return true
+			}
+		case strings.HasPrefix(last, "text/"), strings.HasPrefix(last, "xpath/"):
+		default:
+			// The last compound selector decides the element type.
+			parts := strings.FieldsFunc(last, func(r rune) bool { return r == ' ' || r == '>' || r == '+' || r == '~' })
+			if len(parts) > 0 && selectTag.MatchString(parts[len(parts)-1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isModifier(key string) bool {
+	switch key {
+	case "Control", "Shift", "Alt", "Meta":
+		return true
+	}
+	return false
+}
+
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
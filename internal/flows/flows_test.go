@@ -0,0 +1,146 @@
+package flows
+
+import (
+	"strings"
+	"testing"
+
+	"philadelphia/internal/runner"
+)
+
+const sampleRecording = `{
+  "title": "search wiki",
+  "steps": [
+    {"type": "setViewport", "width": 1280, "height": 720},
+    {"type": "navigate", "url": "https://en.wikipedia.org/"},
+    {"type": "click", "selectors": [["aria/Search Wikipedia[role=\"searchbox\"]"], ["#searchInput"], ["xpath///*[@id=\"searchInput\"]"]]},
+    {"type": "change", "value": "Tampermonkey", "selectors": [["#searchInput"]]},
+    {"type": "change", "value": "de", "selectors": [["form > select#lang"]]},
+    {"type": "keyDown", "key": "Control"},
+    {"type": "keyDown", "key": "k"},
+    {"type": "keyUp", "key": "k"},
+    {"type": "keyUp", "key": "Control"},
+    {"type": "keyDown", "key": "Enter"},
+    {"type": "waitForElement", "selectors": [["text/Toggle Dark Mode"]]},
+    {"type": "hover", "selectors": [["#p-search"]]},
+    {"type": "click", "frame": [0], "selectors": [["button"]]}
+  ]
+}`
+
+func TestFromChromeRecorder(t *testing.T) {
+	flow, err := FromChromeRecorder([]byte(sampleRecording))
+	if err != nil {
+		t.Fatalf("FromChromeRecorder() error = %v", err)
+	}
+	if flow.URL != "https://en.wikipedia.org/" {
+		t.Fatalf("unexpected url: %q", flow.URL)
+	}
+	want := []runner.Step{
+		{Action: "click", Target: `role=searchbox[name="Search Wikipedia"]`},
+		{Action: "fill", Target: "#searchInput", Value: "Tampermonkey"},
+		{Action: "select", Target: "form > select#lang", Value: "de"},
+		{Action: "press", Value: "Control+k"},
+		{Action: "press", Value: "Enter"},
+		{Action: "waitforselector", Target: `text="Toggle Dark Mode"`},
+	}
+	if len(flow.Steps) != len(want) {
+		t.Fatalf("got %d steps, want %d: %+v", len(flow.Steps), len(want), flow.Steps)
+	}
+	for i := range want {
+		if flow.Steps[i] != want[i] {
+			t.Fatalf("step %d = %+v, want %+v", i, flow.Steps[i], want[i])
+		}
+	}
+	var skippedTypes []string
+	for _, s := range flow.Skipped {
+		skippedTypes = append(skippedTypes, s.Type)
+	}
+	if got := strings.Join(skippedTypes, ","); got != "setViewport,hover,click" {
+		t.Fatalf("unexpected skipped steps: %s", got)
+	}
+}
+
+func TestIsSelectElement(t *testing.T) {
+	cases := []struct {
+		sel  string
+		want bool
+	}{
+		{"select", true},
+		{"SELECT#lang", true},
+		{"form > select[name=lang]", true},
+		{"pierce/select.country", true},
+		{`aria/Language[role="combobox"]`, true},
+		{`aria/Sort[role="listbox"]`, true},
+		{"select#lang > option", false},
+		{"#selection", false},
+		{".select-box input", false},
+		{`aria/Search[role="searchbox"]`, false},
+		{"text/select", false},
+	}
+	for _, c := range cases {
+		if got := isSelectElement([][]string{{c.sel}}); got != c.want {
+			t.Errorf("isSelectElement(%q) = %v, want %v", c.sel, got, c.want)
+		}
+	}
+}
+
+func TestPlaywrightTestWaitAndRespond(t *testing.T) {
+	src, _ := PlaywrightTest("t", "", "", []runner.Step{
+		{Action: "wait", Value: "250"},
+		{Action: "wait", Value: "soon"},
+		{Action: "wait", Value: "-5"},
+		{Action: "click", Target: "#name", Dialog: " Respond:Ada "},
+	})
+	for _, want := range []string{
+		"await page.waitForTimeout(250);",
+		"await page.waitForTimeout(500);",
+		`page.once('dialog', d => d.accept("Ada"));`,
+	} {
+		if !strings.Contains(src, want) {
+			t.Fatalf("missing %q in:\n%s", want, src)
+		}
+	}
+	if strings.Contains(src, "soon") || strings.Contains(src, "-5") {
+		t.Fatalf("raw wait value leaked into:\n%s", src)
+	}
+}
+
+func TestPlaywrightTestMarksLabOnlySteps(t *testing.T) {
+	src, warnings := PlaywrightTest("t", "https://example.com", "s.user.js", []runner.Step{
+		{Action: "click", Target: "#delete", Dialog: "dismiss"},
+		{Action: "assert-text", Target: "h1", Value: "Hi \"there\""},
+		{Action: "menu-command", Value: "Toggle"},
+	})
+	for _, want := range []string{
+		`await page.addInitScript({ path: "s.user.js" });`,
+		`page.once('dialog', d => d.dismiss());`,
+		`await expect(page.locator("h1").first()).toHaveText("Hi \"there\"");`,
+		`// TODO: "menu-command"`,
+	} {
+		if !strings.Contains(src, want) {
+			t.Fatalf("missing %q in:\n%s", want, src)
+		}
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %v", warnings)
+	}
+}
+
+func TestPlaywrightTestScreenshots(t *testing.T) {
+	src, warnings := PlaywrightTest("t", "", "", []runner.Step{
+		{Action: "screenshot", Value: "before", Shot: &runner.ScreenshotSpec{Ignore: []runner.IgnoreRegion{{Selector: ".ad"}}}},
+		{Action: "screenshot", Value: "header", Target: "#top"},
+		{Action: "screenshot", Value: "fold", Shot: &runner.ScreenshotSpec{Mode: "viewport"}},
+	})
+	for _, want := range []string{
+		`await expect(page).toHaveScreenshot("before.png", { fullPage: true, mask: [page.locator(".ad")] });`,
+		`await expect(page.locator("#top")).toHaveScreenshot("header.png");`,
+		`await expect(page).toHaveScreenshot("fold.png");`,
+	} {
+		if !strings.Contains(src, want) {
+			t.Fatalf("missing %q in:\n%s", want, src)
+		}
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("unexpected warnings %v", warnings)
+	}
+}
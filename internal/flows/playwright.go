@@ -0,0 +1,140 @@
+package flows
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"philadelphia/internal/runner"
+)
+
+// PlaywrightTest renders steps as a standalone @playwright/test spec that
+// injects the userscript and replays the flow. Steps that only make sense
+// inside the lab (GM menu, dialog/download bookkeeping) become TODO comments
+// and are returned as warnings.
+func PlaywrightTest(title, url, scriptPath string, steps []runner.Step) (string, []string) {
+	if title == "" {
+		title = "userscript flow"
+	}
+	var (
+		b        strings.Builder
+		warnings []string
+	)
+	b.WriteString("// Generated by `lab export-steps`. GM_* APIs are not shimmed; scripts that\n")
+	b.WriteString("// depend on them need a userscript engine or their own stubs.\n")
+	b.WriteString("import { test, expect } from '@playwright/test';\n\n")
+	fmt.Fprintf(&b, "test(%s, async ({ page }) => {\n", quote(title))
+	if scriptPath != "" {
+		fmt.Fprintf(&b, "  await page.addInitScript({ path: %s });\n", quote(scriptPath))
+	}
+	if url != "" {
+		fmt.Fprintf(&b, "  await page.goto(%s);\n", quote(url))
+	}
+	for i, st := range steps {
+		lines, warn := playwrightLines(st)
+		if warn != "" {
+			warnings = append(warnings, fmt.Sprintf("step %d (%s): %s", i+1, st.Action, warn))
+		}
+		fmt.Fprintf(&b, "\n  // step %d: %s\n", i+1, st.Action)
+		for _, l := range lines {
+			b.WriteString("  " + l + "\n")
+		}
+	}
+	b.WriteString("});\n")
+	return b.String(), warnings
+}
+
+func playwrightLines(st runner.Step) ([]string, string) {
+	var pre []string
+	policy := strings.TrimSpace(st.Dialog)
+	switch lower := strings.ToLower(policy); {
+	case lower == "":
+	case lower == "accept":
+		pre = append(pre, "page.once('dialog', d => d.accept());")
+	case lower == "dismiss":
+		pre = append(pre, "page.once('dialog', d => d.dismiss());")
+	case strings.HasPrefix(lower, "respond:"):
+		pre = append(pre, fmt.Sprintf("page.once('dialog', d => d.accept(%s));", quote(policy[len("respond:"):])))
+	}
+	loc := fmt.Sprintf("page.locator(%s)", quote(st.Target))
+	var lines []string
+	switch strings.ToLower(st.Action) {
+	case "click":
+		lines = []string{fmt.Sprintf("await %s.click();", loc)}
+	case "fill":
+		lines = []string{fmt.Sprintf("await %s.fill(%s);", loc, quote(st.Value))}
+	case "select":
+		lines = []string{fmt.Sprintf("await %s.selectOption(%s);", loc, quote(st.Value))}
+	case "press":
+		if st.Target == "" {
+			lines = []string{fmt.Sprintf("await page.keyboard.press(%s);", quote(st.Value))}
+		} else {
+			lines = []string{fmt.Sprintf("await %s.press(%s);", loc, quote(st.Value))}
+		}
+	case "goto":
+		lines = []string{fmt.Sprintf("await page.goto(%s);", quote(st.Value))}
+	case "waitforselector":
+		lines = []string{fmt.Sprintf("await page.waitForSelector(%s);", quote(st.Target))}
+	case "wait":
+		// Same fallback as the runner: anything but a positive number waits 500ms.
+		ms := 500.0
+		if v, err := strconv.ParseFloat(strings.TrimSpace(st.Value), 64); err == nil && v > 0 {
+			ms = v
+		}
+		lines = []string{fmt.Sprintf("await page.waitForTimeout(%s);", strconv.FormatFloat(ms, 'f', -1, 64))}
+	case "choose-files":
+		var files []string
+		for _, f := range strings.Split(st.Value, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				files = append(files, quote(f))
+			}
+		}
+		lines = []string{
+			"{",
+			"  const chooser = page.waitForEvent('filechooser');",
+			fmt.Sprintf("  await %s.click();", loc),
+			fmt.Sprintf("  await (await chooser).setFiles([%s]);", strings.Join(files, ", ")),
+			"}",
+		}
+	case "eval":
+		lines = []string{fmt.Sprintf("await page.evaluate(%s);", quote(st.Value))}
+	case "screenshot":
+		var opts []string
+		if st.Target == "" && (st.Shot == nil || !strings.EqualFold(st.Shot.Mode, "viewport")) {
+			opts = append(opts, "fullPage: true")
+		}
+		if st.Shot != nil {
+			var masks []string
+			for _, r := range st.Shot.Ignore {
+				if r.Selector != "" {
+					masks = append(masks, fmt.Sprintf("page.locator(%s)", quote(r.Selector)))
+				}
+			}
+			if len(masks) > 0 {
+				opts = append(opts, "mask: ["+strings.Join(masks, ", ")+"]")
+			}
+		}
+		subject := "page"
+		if st.Target != "" {
+			subject = loc
+		}
+		arg := quote(st.Value + ".png")
+		if len(opts) > 0 {
+			arg += ", { " + strings.Join(opts, ", ") + " }"
+		}
+		lines = []string{fmt.Sprintf("await expect(%s).toHaveScreenshot(%s);", subject, arg)}
+	case "assert-text", "assert-equals":
+		lines = []string{fmt.Sprintf("await expect(%s.first()).toHaveText(%s);", loc, quote(st.Value))}
+	case "assert-contains":
+		lines = []string{fmt.Sprintf("await expect(%s.first()).toContainText(%s);", loc, quote(st.Value))}
+	case "assert-exists":
+		lines = []string{fmt.Sprintf("await expect(%s.first()).toBeAttached();", loc)}
+	case "assert-not-exists":
+		lines = []string{fmt.Sprintf("await expect(%s).toHaveCount(0);", loc)}
+	case "assert-attr":
+		lines = []string{fmt.Sprintf("await expect(%s.first()).toHaveAttribute(%s, %s);", loc, quote(st.Attr), quote(st.Value))}
+	default:
+		return append(pre, fmt.Sprintf("// TODO: %q has no Playwright equivalent (target=%q value=%q)", st.Action, st.Target, st.Value)), "not exported; left as a TODO comment"
+	}
+	return append(pre, lines...), ""
+}
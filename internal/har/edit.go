@@ -0,0 +1,165 @@
+package har
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// EditRule rewrites the response bodies of matching entries. Exactly one of
+// Body or Find must be set.
+type EditRule struct {
+	Match   func(url string) bool // selects entries by request URL; nil matches all
+	Method  string                // optional request method filter
+	Find    string                // text (or regular expression with Regex) to replace
+	Replace string
+	Regex   bool
+	Body    []byte // replaces the whole body when non-nil
+}
+
+// Edit applies rule to the HAR document in data and returns the rewritten
+// document and the number of bodies changed. The document is edited
+// generically so fields this package does not model survive. Sidecar bodies
+// are resolved against dir, the HAR's directory. Rewritten ones are inlined;
+// unchanged entries keep their sidecar, referenced relative to destDir, the
+// directory the edited HAR is written to. A missing sidecar only matters when
+// a whole-body replacement would not need it anyway, so such entries are
+// rewritten under Body and left alone under Find.
+func Edit(data []byte, dir, destDir string, rule EditRule) ([]byte, int, error) {
+	if (rule.Body == nil) == (rule.Find == "") {
+		return nil, 0, errors.New("edit needs either a replacement body or a find string")
+	}
+	var re *regexp.Regexp
+	if rule.Regex {
+		var err error
+		if re, err = regexp.Compile(rule.Find); err != nil {
+			return nil, 0, fmt.Errorf("find regex: %w", err)
+		}
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, 0, fmt.Errorf("parse HAR: %w", err)
+	}
+	logObj, _ := doc["log"].(map[string]any)
+	if logObj == nil {
+		return nil, 0, errors.New("HAR has no log")
+	}
+	entries, _ := logObj["entries"].([]any)
+
+	changed := 0
+	for _, raw := range entries {
+		entry, _ := raw.(map[string]any)
+		req, _ := entry["request"].(map[string]any)
+		resp, _ := entry["response"].(map[string]any)
+		if req == nil || resp == nil {
+			continue
+		}
+		url, _ := req["url"].(string)
+		method, _ := req["method"].(string)
+		if rule.Match != nil && !rule.Match(url) {
+			continue
+		}
+		if rule.Method != "" && !strings.EqualFold(rule.Method, method) {
+			continue
+		}
+		content, _ := resp["content"].(map[string]any)
+		if content == nil {
+			content = map[string]any{}
+			resp["content"] = content
+		}
+		old, err := contentBody(content, dir)
+		missing := errors.Is(err, fs.ErrNotExist) && content["_file"] != nil
+		switch {
+		case missing && rule.Body == nil:
+			// Nothing to search in; the entry is left as recorded.
+			continue
+		case err != nil && !missing:
+			return nil, 0, fmt.Errorf("%s: %w", url, err)
+		}
+		var body []byte
+		switch {
+		case rule.Body != nil:
+			body = rule.Body
+		case re != nil:
+			body = re.ReplaceAll(old, []byte(rule.Replace))
+		default:
+			body = []byte(strings.ReplaceAll(string(old), rule.Find, rule.Replace))
+		}
+		if !missing && bytes.Equal(body, old) {
+			continue
+		}
+		delete(content, "_file")
+		if enc, _ := content["encoding"].(string); enc == "base64" {
+			content["text"] = base64.StdEncoding.EncodeToString(body)
+		} else {
+			content["text"] = string(body)
+		}
+		content["size"] = len(body)
+		if headers, ok := resp["headers"].([]any); ok {
+			for _, h := range headers {
+				hm, _ := h.(map[string]any)
+				if name, _ := hm["name"].(string); strings.EqualFold(name, "content-length") {
+					hm["value"] = strconv.Itoa(len(body))
+				}
+			}
+		}
+		changed++
+	}
+	if err := rebaseSidecars(entries, dir, destDir); err != nil {
+		return nil, 0, err
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, 0, err
+	}
+	return append(out, '\n'), changed, nil
+}
+
+// rebaseSidecars makes the remaining _file references relative to destDir.
+func rebaseSidecars(entries []any, dir, destDir string) error {
+	from, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	to, err := filepath.Abs(destDir)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return nil
+	}
+	for _, raw := range entries {
+		entry, _ := raw.(map[string]any)
+		resp, _ := entry["response"].(map[string]any)
+		content, _ := resp["content"].(map[string]any)
+		file, _ := content["_file"].(string)
+		if file == "" {
+			continue
+		}
+		rel, err := filepath.Rel(to, filepath.Join(from, file))
+		if err != nil {
+			return fmt.Errorf("sidecar %s: %w", file, err)
+		}
+		content["_file"] = filepath.ToSlash(rel)
+	}
+	return nil
+}
+
+func contentBody(content map[string]any, dir string) ([]byte, error) {
+	if file, _ := content["_file"].(string); file != "" {
+		return os.ReadFile(filepath.Join(dir, file))
+	}
+	text, _ := content["text"].(string)
+	if enc, _ := content["encoding"].(string); enc == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
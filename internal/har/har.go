@@ -0,0 +1,180 @@
+// Package har reads, indexes and edits HTTP Archive files recorded by the
+// runner, for strict offline replay and for simulating site drift.
+package har
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// File is the subset of a HAR document needed for replay.
+type File struct {
+	Log Log `json:"log"`
+}
+
+// Log holds the recorded exchanges.
+type Log struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Entry is one request/response pair.
+type Entry struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is the recorded request line and body.
+type Request struct {
+	Method   string    `json:"method"`
+	URL      string    `json:"url"`
+	PostData *PostData `json:"postData,omitempty"`
+}
+
+// PostData is a recorded request body.
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// Response is the recorded response.
+type Response struct {
+	Status      int      `json:"status"`
+	StatusText  string   `json:"statusText"`
+	Headers     []Header `json:"headers"`
+	Content     Content  `json:"content"`
+	RedirectURL string   `json:"redirectURL,omitempty"`
+}
+
+// Header is a name/value pair; names may repeat.
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Content is a response body, inline or in a sidecar file (Playwright's
+// "attach" content mode).
+type Content struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	File     string `json:"_file,omitempty"`
+}
+
+// Load parses a HAR file.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// Body returns the decoded response body. Sidecar files resolve against dir,
+// the directory holding the HAR.
+func (c Content) Body(dir string) ([]byte, error) {
+	if c.File != "" {
+		return os.ReadFile(filepath.Join(dir, c.File))
+	}
+	if c.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(c.Text)
+	}
+	return []byte(c.Text), nil
+}
+
+// MatchMode selects which parts of a request must equal the recording.
+type MatchMode string
+
+const (
+	MatchURL     MatchMode = "url"      // URL only; method and body are ignored
+	MatchURLBody MatchMode = "url+body" // method, URL and request body
+)
+
+// ParseMatchMode validates a match mode; empty means MatchURL.
+func ParseMatchMode(s string) (MatchMode, error) {
+	switch m := MatchMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case "":
+		return MatchURL, nil
+	case MatchURL, MatchURLBody:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown HAR match mode %q (want url or url+body)", s)
+	}
+}
+
+// Index finds recorded responses for live requests. Repeated requests for one
+// key are served the recorded responses in order; the last one repeats.
+type Index struct {
+	mode   MatchMode
+	mu     sync.Mutex
+	byKey  map[string][]*Entry
+	served map[string]int
+}
+
+// NewIndex indexes f for lookups in the given mode.
+func NewIndex(f *File, mode MatchMode) *Index {
+	idx := &Index{mode: mode, byKey: map[string][]*Entry{}, served: map[string]int{}}
+	for i := range f.Log.Entries {
+		e := &f.Log.Entries[i]
+		var body []byte
+		if e.Request.PostData != nil {
+			body = []byte(e.Request.PostData.Text)
+		}
+		key := idx.key(e.Request.Method, e.Request.URL, body)
+		idx.byKey[key] = append(idx.byKey[key], e)
+	}
+	return idx
+}
+
+func (idx *Index) key(method, url string, body []byte) string {
+	if i := strings.IndexByte(url, '#'); i >= 0 {
+		url = url[:i]
+	}
+	if idx.mode != MatchURLBody {
+		return url
+	}
+	return strings.ToUpper(method) + " " + url + "\n" + canonicalBody(body)
+}
+
+// Lookup returns the next recorded entry for the request, if any.
+func (idx *Index) Lookup(method, url string, body []byte) (*Entry, bool) {
+	key := idx.key(method, url, body)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	list := idx.byKey[key]
+	if len(list) == 0 {
+		return nil, false
+	}
+	n := idx.served[key]
+	idx.served[key] = n + 1
+	if n >= len(list) {
+		n = len(list) - 1
+	}
+	return list[n], true
+}
+
+// canonicalBody re-encodes JSON bodies so key order and whitespace do not
+// affect matching; other bodies compare byte for byte.
+func canonicalBody(body []byte) string {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return ""
+	}
+	var v any
+	if json.Unmarshal(trimmed, &v) == nil {
+		if b, err := json.Marshal(v); err == nil {
+			return string(b)
+		}
+	}
+	return string(body)
+}
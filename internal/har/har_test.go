@@ -0,0 +1,196 @@
+package har
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sample = `{"log":{"version":"1.2","creator":{"name":"test"},"entries":[
+ {"request":{"method":"GET","url":"https://example.com/","headers":[]},
+  "response":{"status":200,"statusText":"OK","headers":[{"name":"Content-Length","value":"24"}],
+   "content":{"size":24,"mimeType":"text/html","text":"<h1 class=title>Hi</h1>"}},"timings":{"wait":5}},
+ {"request":{"method":"POST","url":"https://example.com/api","postData":{"mimeType":"application/json","text":"{\"a\":1,\"b\":2}"}},
+  "response":{"status":200,"headers":[],"content":{"size":2,"mimeType":"application/json","text":"e30=","encoding":"base64"}}},
+ {"request":{"method":"POST","url":"https://example.com/api","postData":{"mimeType":"application/json","text":"{\"a\":9}"}},
+  "response":{"status":500,"headers":[],"content":{"size":0,"mimeType":"text/plain","text":""}}},
+ {"request":{"method":"GET","url":"https://example.com/img.png"},
+  "response":{"status":200,"headers":[],"content":{"size":3,"mimeType":"image/png","_file":"img.bin"}}}
+]}}`
+
+func load(t *testing.T) (*File, string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "run.har")
+	if err := os.WriteFile(path, []byte(sample), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "img.bin"), []byte("PNG"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, dir
+}
+
+func TestIndexMatchModes(t *testing.T) {
+	f, dir := load(t)
+
+	byURL := NewIndex(f, MatchURL)
+	first, ok := byURL.Lookup("POST", "https://example.com/api", []byte(`{"a":9}`))
+	if !ok || first.Response.Status != 200 {
+		t.Fatalf("url mode should ignore the body and serve recordings in order; got %+v", first)
+	}
+	second, _ := byURL.Lookup("GET", "https://example.com/api", nil)
+	third, _ := byURL.Lookup("GET", "https://example.com/api", nil)
+	if second.Response.Status != 500 || third.Response.Status != 500 {
+		t.Fatalf("expected the last recording to repeat, got %d then %d", second.Response.Status, third.Response.Status)
+	}
+	if _, ok := byURL.Lookup("GET", "https://example.com/#top", nil); !ok {
+		t.Fatal("fragment should not affect matching")
+	}
+
+	byBody := NewIndex(f, MatchURLBody)
+	e, ok := byBody.Lookup("POST", "https://example.com/api", []byte(`{ "b": 2, "a": 1 }`))
+	if !ok || e.Response.Status != 200 {
+		t.Fatalf("JSON bodies should match regardless of key order, got %+v %v", e, ok)
+	}
+	if e, ok = byBody.Lookup("POST", "https://example.com/api", []byte(`{"a":9}`)); !ok || e.Response.Status != 500 {
+		t.Fatalf("body {a:9} should select the 500 recording")
+	}
+	if _, ok := byBody.Lookup("POST", "https://example.com/api", []byte(`{"a":3}`)); ok {
+		t.Fatal("unrecorded body matched")
+	}
+	if _, ok := byBody.Lookup("GET", "https://example.com/api", nil); ok {
+		t.Fatal("url+body mode should compare methods")
+	}
+
+	body, err := f.Log.Entries[1].Response.Content.Body(dir)
+	if err != nil || string(body) != "{}" {
+		t.Fatalf("base64 body = %q %v", body, err)
+	}
+	if body, _ = f.Log.Entries[3].Response.Content.Body(dir); string(body) != "PNG" {
+		t.Fatalf("sidecar body = %q", body)
+	}
+}
+
+func TestParseMatchMode(t *testing.T) {
+	if m, err := ParseMatchMode(""); err != nil || m != MatchURL {
+		t.Fatalf("default = %q %v", m, err)
+	}
+	if m, err := ParseMatchMode("URL+Body"); err != nil || m != MatchURLBody {
+		t.Fatalf("url+body = %q %v", m, err)
+	}
+	if _, err := ParseMatchMode("headers"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestEdit(t *testing.T) {
+	_, dir := load(t)
+	htmlOnly := func(u string) bool { return u == "https://example.com/" }
+
+	out, n, err := Edit([]byte(sample), dir, dir, EditRule{Match: htmlOnly, Find: `class=title`, Replace: `class="headline"`})
+	if err != nil || n != 1 {
+		t.Fatalf("edit = %d %v", n, err)
+	}
+	var f File
+	if err := json.Unmarshal(out, &f); err != nil {
+		t.Fatal(err)
+	}
+	c := f.Log.Entries[0].Response.Content
+	if c.Text != `<h1 class="headline">Hi</h1>` || c.Size != int64(len(c.Text)) {
+		t.Fatalf("content = %+v", c)
+	}
+	if !strings.Contains(string(out), `"timings"`) {
+		t.Fatal("unmodelled fields were dropped")
+	}
+	if h := f.Log.Entries[0].Response.Headers[0]; h.Value != "28" {
+		t.Fatalf("content-length = %s", h.Value)
+	}
+
+	out, n, err = Edit([]byte(sample), dir, dir, EditRule{Method: "POST", Find: `^\{\}$`, Replace: `{"drift":true}`, Regex: true})
+	if err != nil || n != 1 {
+		t.Fatalf("regex edit = %d %v", n, err)
+	}
+	f = File{}
+	json.Unmarshal(out, &f)
+	if got, _ := base64.StdEncoding.DecodeString(f.Log.Entries[1].Response.Content.Text); string(got) != `{"drift":true}` {
+		t.Fatalf("base64 body = %q", got)
+	}
+
+	out, n, err = Edit([]byte(sample), dir, dir, EditRule{Match: func(u string) bool { return strings.HasSuffix(u, ".png") }, Body: []byte("GIF")})
+	if err != nil || n != 1 {
+		t.Fatalf("body edit = %d %v", n, err)
+	}
+	f = File{}
+	json.Unmarshal(out, &f)
+	if c := f.Log.Entries[3].Response.Content; c.File != "" || c.Text != "GIF" {
+		t.Fatalf("sidecar not inlined: %+v", c)
+	}
+
+	if _, _, err := Edit([]byte(sample), dir, dir, EditRule{}); err == nil {
+		t.Fatal("expected error without find or body")
+	}
+}
+
+func TestEditSidecars(t *testing.T) {
+	_, dir := load(t)
+	// The unchanged sidecar body is neither inlined nor counted.
+	out, n, err := Edit([]byte(sample), dir, dir, EditRule{Find: "Hi", Replace: "Bye"})
+	if err != nil || n != 1 {
+		t.Fatalf("edit = %d %v", n, err)
+	}
+	var f File
+	json.Unmarshal(out, &f)
+	if c := f.Log.Entries[3].Response.Content; c.File != "img.bin" || c.Text != "" {
+		t.Fatalf("unchanged sidecar rewritten: %+v", c)
+	}
+
+	// A missing sidecar only blocks entries that would be rewritten from it.
+	empty := t.TempDir()
+	if _, n, err := Edit([]byte(sample), empty, empty, EditRule{Find: "Hi", Replace: "Bye"}); err != nil || n != 1 {
+		t.Fatalf("find with missing sidecar = %d %v", n, err)
+	}
+	out, n, err = Edit([]byte(sample), empty, empty, EditRule{Match: func(u string) bool { return strings.HasSuffix(u, ".png") }, Body: []byte("GIF")})
+	if err != nil || n != 1 {
+		t.Fatalf("body with missing sidecar = %d %v", n, err)
+	}
+	f = File{}
+	json.Unmarshal(out, &f)
+	if c := f.Log.Entries[3].Response.Content; c.File != "" || c.Text != "GIF" {
+		t.Fatalf("missing sidecar not replaced: %+v", c)
+	}
+}
+
+func TestEditToOtherDir(t *testing.T) {
+	_, dir := load(t)
+	destDir := filepath.Join(dir, "edited")
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	out, n, err := Edit([]byte(sample), dir, destDir, EditRule{Find: "Hi", Replace: "Bye"})
+	if err != nil || n != 1 {
+		t.Fatalf("edit = %d %v", n, err)
+	}
+	dest := filepath.Join(destDir, "run.har")
+	if err := os.WriteFile(dest, out, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := f.Log.Entries[3].Response.Content
+	if c.File != "../img.bin" {
+		t.Fatalf("sidecar = %q, want ../img.bin", c.File)
+	}
+	if body, err := c.Body(destDir); err != nil || string(body) != "PNG" {
+		t.Fatalf("body from %s = %q, %v", destDir, body, err)
+	}
+}
@@ -0,0 +1,177 @@
+// Package proxy is a recording/replaying HTTP(S) proxy. It terminates TLS
+// with certificates issued by a locally generated CA, so it sees every request
+// the browser makes, including service-worker and extension traffic that
+// Playwright routing and HAR replay miss.
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CA issues leaf certificates for intercepted hosts. All leaves share one key
+// so a single SPKI pin makes the browser trust them.
+type CA struct {
+	Cert     *x509.Certificate
+	CertPath string
+
+	key     *ecdsa.PrivateKey
+	leafKey *ecdsa.PrivateKey
+	mu      sync.Mutex
+	leaves  map[string]*tls.Certificate
+}
+
+// LoadOrCreateCA reads ca.pem and ca-key.pem from dir, generating them on
+// first use. The key file is written with 0600 permissions.
+func LoadOrCreateCA(dir string) (*CA, error) {
+	certPath := filepath.Join(dir, "ca.pem")
+	keyPath := filepath.Join(dir, "ca-key.pem")
+	ca := &CA{CertPath: certPath, leaves: map[string]*tls.Certificate{}}
+
+	certPEM, certErr := os.ReadFile(certPath)
+	keyPEM, keyErr := os.ReadFile(keyPath)
+	switch {
+	case certErr == nil && keyErr == nil:
+		if err := ca.parse(certPEM, keyPEM); err != nil {
+			return nil, fmt.Errorf("load CA from %s: %w", dir, err)
+		}
+	case errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist):
+		if err := ca.generate(certPath, keyPath); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("incomplete CA in %s: %v / %v", dir, certErr, keyErr)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	ca.leafKey = leafKey
+	return ca, nil
+}
+
+func (ca *CA) generate(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "philadelphia lab local CA", Organization: []string{"philadelphia lab"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(certPath), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certPath, certPEM, 0o644); err != nil {
+		return err
+	}
+	return ca.parse(certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func (ca *CA) parse(certPEM, keyPEM []byte) error {
+	cb, _ := pem.Decode(certPEM)
+	kb, _ := pem.Decode(keyPEM)
+	if cb == nil || kb == nil {
+		return errors.New("invalid PEM")
+	}
+	cert, err := x509.ParseCertificate(cb.Bytes)
+	if err != nil {
+		return err
+	}
+	key, err := x509.ParseECPrivateKey(kb.Bytes)
+	if err != nil {
+		return err
+	}
+	ca.Cert, ca.key = cert, key
+	return nil
+}
+
+// SPKIPins returns base64 SHA-256 hashes of the CA and leaf public keys, the
+// form Chromium's --ignore-certificate-errors-spki-list expects.
+func (ca *CA) SPKIPins() ([]string, error) {
+	var pins []string
+	for _, pub := range []any{&ca.key.PublicKey, &ca.leafKey.PublicKey} {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(der)
+		pins = append(pins, base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	return pins, nil
+}
+
+// Pool returns a cert pool trusting the CA, for Go clients.
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// certFor returns a cached leaf certificate for host.
+func (ca *CA) certFor(host string) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if c, ok := ca.leaves[host]; ok {
+		return c, nil
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(0, 0, 30),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, &ca.leafKey.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Certificate{Certificate: [][]byte{der, ca.Cert.Raw}, PrivateKey: ca.leafKey}
+	ca.leaves[host] = c
+	return c, nil
+}
+
+func randomSerial() *big.Int {
+	n, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	return n
+}
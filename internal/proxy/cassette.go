@@ -0,0 +1,405 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// cassetteVersion is bumped when the file layout changes incompatibly.
+const cassetteVersion = 1
+
+// maxInlineBody is the largest body kept inside the cassette; larger ones go
+// to bodies/<hash> beside it so the cassette stays small and diffable.
+const maxInlineBody = 16 << 10
+
+// Cassette is a recorded set of exchanges. It is stored as indented JSON with
+// one interaction per block, no timestamps, sorted header maps and
+// interactions sorted by request, so re-recording an unchanged site produces
+// an empty diff even though concurrent requests arrive in any order.
+type Cassette struct {
+	Version      int            `json:"version"`
+	DefaultMatch MatchRule      `json:"default_match"`
+	Redacted     string         `json:"redacted,omitempty"` // placeholder for masked values; matches anything on replay
+	Interactions []*Interaction `json:"interactions"`
+
+	path     string
+	mu       sync.Mutex
+	used     map[int]int
+	redactor Redactor
+}
+
+// Redactor masks credentials in exchanges before they are recorded.
+type Redactor interface {
+	URL(raw string) string
+	Headers(h map[string]string) map[string]string
+	Body(text string) string
+}
+
+// Interaction is one request/response pair. Match overrides DefaultMatch
+// field by field for this entry.
+type Interaction struct {
+	ID       int        `json:"id"`
+	Match    *MatchRule `json:"match,omitempty"`
+	Request  Message    `json:"request"`
+	Response Message    `json:"response"`
+}
+
+// Message is a request or response. Exactly one body field is set, if any.
+type Message struct {
+	Method     string            `json:"method,omitempty"`
+	URL        string            `json:"url,omitempty"`
+	Status     int               `json:"status,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"` // repeated headers joined by "\n"
+	Body       string            `json:"body,omitempty"`
+	BodyBase64 string            `json:"body_base64,omitempty"`
+	BodyFile   string            `json:"body_file,omitempty"` // relative to the cassette
+}
+
+// MatchRule controls how a live request is compared with a recorded one.
+type MatchRule struct {
+	Method       string   `json:"method,omitempty"`        // exact (default) or ignore
+	Query        string   `json:"query,omitempty"`         // exact (default), unordered or ignore
+	IgnoreParams []string `json:"ignore_params,omitempty"` // query parameters left out of the comparison
+	Body         string   `json:"body,omitempty"`          // exact (default), json or ignore
+	Headers      []string `json:"headers,omitempty"`       // request headers that must be equal
+}
+
+// merge returns r with the fields set in o taking precedence.
+func (r MatchRule) merge(o *MatchRule) MatchRule {
+	if o == nil {
+		return r
+	}
+	if o.Method != "" {
+		r.Method = o.Method
+	}
+	if o.Query != "" {
+		r.Query = o.Query
+	}
+	if o.IgnoreParams != nil {
+		r.IgnoreParams = o.IgnoreParams
+	}
+	if o.Body != "" {
+		r.Body = o.Body
+	}
+	if o.Headers != nil {
+		r.Headers = o.Headers
+	}
+	return r
+}
+
+// NewCassette returns an empty cassette that saves to path.
+func NewCassette(path string) *Cassette {
+	return &Cassette{Version: cassetteVersion, path: path, used: map[int]int{}}
+}
+
+// SetRedactor masks every exchange recorded from now on, including bodies
+// spilled beside the cassette. placeholder is what masked values are replaced
+// with; it is stored in the cassette so replay can match around it.
+func (c *Cassette) SetRedactor(r Redactor, placeholder string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.redactor, c.Redacted = r, placeholder
+}
+
+// LoadCassette reads a cassette for replay.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cassette{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("parse cassette %s: %w", path, err)
+	}
+	if c.Version != cassetteVersion {
+		return nil, fmt.Errorf("cassette %s: unsupported version %d", path, c.Version)
+	}
+	c.path, c.used = path, map[int]int{}
+	return c, nil
+}
+
+// Save writes the cassette. Large bodies were already written beside it when
+// they were recorded.
+func (c *Cassette) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sortInteractions()
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, append(data, '\n'), 0o644)
+}
+
+// sortInteractions orders interactions by method, URL and request body hash
+// and renumbers them. The sort is stable, so repeats of one request keep the
+// order they were answered in, which replay serves them in.
+func (c *Cassette) sortInteractions() {
+	keys := make(map[*Interaction]string, len(c.Interactions))
+	for _, it := range c.Interactions {
+		body, _ := c.body(it.Request)
+		sum := sha256.Sum256(body)
+		keys[it] = it.Request.Method + " " + it.Request.URL + " " + hex.EncodeToString(sum[:])
+	}
+	sort.SliceStable(c.Interactions, func(i, j int) bool {
+		return keys[c.Interactions[i]] < keys[c.Interactions[j]]
+	})
+	for i, it := range c.Interactions {
+		it.ID = i + 1
+	}
+}
+
+// Len reports the number of interactions.
+func (c *Cassette) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.Interactions)
+}
+
+// add records an exchange, spilling large bodies to files.
+func (c *Cassette) add(req, resp Message, reqBody, respBody []byte) error {
+	c.mu.Lock()
+	red := c.redactor
+	c.mu.Unlock()
+	if red != nil {
+		req.URL = red.URL(req.URL)
+		req.Headers = red.Headers(req.Headers)
+		resp.Headers = red.Headers(resp.Headers)
+		reqBody, respBody = redactBody(red, reqBody), redactBody(red, respBody)
+	}
+	if err := c.setBody(&req, reqBody); err != nil {
+		return err
+	}
+	if err := c.setBody(&resp, respBody); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interactions = append(c.Interactions, &Interaction{ID: len(c.Interactions) + 1, Request: req, Response: resp})
+	return nil
+}
+
+// redactBody masks text bodies; binary ones are kept as they are.
+func redactBody(r Redactor, body []byte) []byte {
+	if len(body) == 0 || !utf8.Valid(body) {
+		return body
+	}
+	return []byte(r.Body(string(body)))
+}
+
+func (c *Cassette) setBody(m *Message, body []byte) error {
+	switch {
+	case len(body) == 0:
+	case len(body) > maxInlineBody:
+		sum := sha256.Sum256(body)
+		rel := filepath.ToSlash(filepath.Join("bodies", hex.EncodeToString(sum[:8])))
+		abs := filepath.Join(filepath.Dir(c.path), rel)
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(abs, body, 0o644); err != nil {
+			return err
+		}
+		m.BodyFile = rel
+	case utf8.Valid(body):
+		m.Body = string(body)
+	default:
+		m.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+	return nil
+}
+
+// body returns a message's decoded body.
+func (c *Cassette) body(m Message) ([]byte, error) {
+	switch {
+	case m.BodyFile != "":
+		return os.ReadFile(filepath.Join(filepath.Dir(c.path), filepath.FromSlash(m.BodyFile)))
+	case m.BodyBase64 != "":
+		return base64.StdEncoding.DecodeString(m.BodyBase64)
+	default:
+		return []byte(m.Body), nil
+	}
+}
+
+// match finds the recorded interaction for a live request. Each recording is
+// served once in order; when all candidates are used the last one repeats.
+func (c *Cassette) match(method, rawURL string, headers http.Header, body []byte) (*Interaction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var last *Interaction
+	for _, it := range c.Interactions {
+		rule := c.DefaultMatch.merge(it.Match)
+		if !c.matches(it, rule, method, rawURL, headers, body) {
+			continue
+		}
+		if c.used[it.ID] == 0 {
+			c.used[it.ID]++
+			return it, true
+		}
+		last = it
+	}
+	if last != nil {
+		c.used[last.ID]++
+		return last, true
+	}
+	return nil, false
+}
+
+func (c *Cassette) matches(it *Interaction, rule MatchRule, method, rawURL string, headers http.Header, body []byte) bool {
+	if rule.Method != "ignore" && !strings.EqualFold(it.Request.Method, method) {
+		return false
+	}
+	if masked := maskedParams(it.Request.URL, c.Redacted); len(masked) > 0 {
+		// Redaction re-encoded the recorded query, so compare it unordered
+		// and leave the masked parameters out.
+		rule.IgnoreParams = append(append([]string(nil), rule.IgnoreParams...), masked...)
+		if rule.Query != "ignore" {
+			rule.Query = "unordered"
+		}
+	}
+	if !urlsMatch(it.Request.URL, rawURL, rule) {
+		return false
+	}
+	for _, h := range rule.Headers {
+		recorded := it.Request.Headers[strings.ToLower(h)]
+		if c.Redacted != "" && strings.Contains(recorded, c.Redacted) {
+			continue
+		}
+		if recorded != strings.Join(headers.Values(h), "\n") {
+			return false
+		}
+	}
+	if rule.Body == "ignore" {
+		return true
+	}
+	recorded, err := c.body(it.Request)
+	if err != nil {
+		return false
+	}
+	if rule.Body == "json" && json.Valid(recorded) {
+		return redactedEqual(canonicalJSON(recorded), canonicalJSON(body), c.Redacted)
+	}
+	return redactedEqual(string(recorded), string(body), c.Redacted)
+}
+
+// maskedParams lists the query parameters of a recorded URL whose value is
+// the redaction placeholder.
+func maskedParams(recorded, placeholder string) []string {
+	if placeholder == "" {
+		return nil
+	}
+	u, err := url.Parse(recorded)
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for k, vs := range u.Query() {
+		for _, v := range vs {
+			if v == placeholder {
+				out = append(out, k)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// redactedEqual compares a recorded value with a live one, letting each
+// placeholder in the recorded value stand for any text.
+func redactedEqual(recorded, live, placeholder string) bool {
+	if placeholder == "" || !strings.Contains(recorded, placeholder) {
+		return recorded == live
+	}
+	parts := strings.Split(recorded, placeholder)
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	re, err := regexp.Compile(`^` + strings.Join(parts, `(?s:.*?)`) + `$`)
+	return err == nil && re.MatchString(live)
+}
+
+func urlsMatch(recorded, live string, rule MatchRule) bool {
+	a, err1 := url.Parse(recorded)
+	b, err2 := url.Parse(live)
+	if err1 != nil || err2 != nil {
+		return recorded == live
+	}
+	if a.Scheme != b.Scheme || !strings.EqualFold(a.Host, b.Host) || a.EscapedPath() != b.EscapedPath() {
+		return false
+	}
+	switch rule.Query {
+	case "ignore":
+		return true
+	case "unordered":
+		return canonicalQuery(a.Query(), rule.IgnoreParams) == canonicalQuery(b.Query(), rule.IgnoreParams)
+	default:
+		if len(rule.IgnoreParams) == 0 {
+			return a.RawQuery == b.RawQuery
+		}
+		return orderedQuery(a.RawQuery, rule.IgnoreParams) == orderedQuery(b.RawQuery, rule.IgnoreParams)
+	}
+}
+
+func canonicalQuery(q url.Values, ignore []string) string {
+	for _, p := range ignore {
+		q.Del(p)
+	}
+	return q.Encode() // sorted by key
+}
+
+func orderedQuery(raw string, ignore []string) string {
+	skip := map[string]bool{}
+	for _, p := range ignore {
+		skip[p] = true
+	}
+	var kept []string
+	for _, part := range strings.Split(raw, "&") {
+		key, _, _ := strings.Cut(part, "=")
+		if k, err := url.QueryUnescape(key); err == nil && skip[k] {
+			continue
+		}
+		kept = append(kept, part)
+	}
+	return strings.Join(kept, "&")
+}
+
+func canonicalJSON(b []byte) string {
+	var v any
+	if json.Unmarshal(b, &v) != nil {
+		return string(b)
+	}
+	out, _ := json.Marshal(v)
+	return string(out)
+}
+
+// headerMap flattens headers with lower-case names; keep, when non-nil, is
+// an allowlist and skip a denylist.
+func headerMap(h http.Header, keep, skip map[string]bool) map[string]string {
+	out := map[string]string{}
+	for k, v := range h {
+		name := strings.ToLower(k)
+		if skip[name] || (keep != nil && !keep[name]) {
+			continue
+		}
+		out[name] = strings.Join(v, "\n")
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,403 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Mode selects whether the proxy records or replays.
+type Mode string
+
+const (
+	ModeRecord Mode = "record" // forward upstream and append exchanges to the cassette
+	ModeReplay Mode = "replay" // answer from the cassette; never contact upstream
+)
+
+// ParseMode validates a mode name.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(strings.ToLower(strings.TrimSpace(s))); m {
+	case ModeRecord, ModeReplay:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown proxy mode %q (want record or replay)", s)
+	}
+}
+
+// maxMisses caps the unmatched requests kept in Stats.
+const maxMisses = 200
+
+// hopHeaders are connection-level headers never forwarded or recorded.
+var hopHeaders = map[string]bool{
+	"connection": true, "keep-alive": true, "proxy-connection": true, "proxy-authenticate": true,
+	"proxy-authorization": true, "te": true, "trailer": true, "transfer-encoding": true, "upgrade": true,
+}
+
+// volatileResponseHeaders change on every fetch and would make cassettes noisy.
+var volatileResponseHeaders = map[string]bool{
+	"date": true, "age": true, "content-length": true, "alt-svc": true, "nel": true, "report-to": true,
+	"server-timing": true, "x-request-id": true, "cf-ray": true, "x-amz-cf-id": true,
+}
+
+// recordedRequestHeaders are the request headers kept for header match rules.
+var recordedRequestHeaders = map[string]bool{"content-type": true, "accept": true, "x-requested-with": true}
+
+// Options configure a Server.
+type Options struct {
+	Mode        Mode
+	Cassette    *Cassette
+	CA          *CA
+	UpstreamTLS *tls.Config // optional; defaults to system roots
+	Logf        func(format string, args ...any)
+}
+
+// Stats summarise a proxy session.
+type Stats struct {
+	Recorded       int      `json:"recorded"`
+	Replayed       int      `json:"replayed"`
+	Tunneled       int      `json:"tunneled"` // WebSocket upgrades passed through unrecorded
+	Errors         int      `json:"errors"`
+	UnmatchedCount int      `json:"unmatched_count"`
+	Unmatched      []string `json:"unmatched,omitempty"` // "METHOD URL"
+}
+
+// Server is a MITM HTTP proxy bound to localhost.
+type Server struct {
+	opts      Options
+	transport *http.Transport
+	ln        net.Listener
+	srv       *http.Server
+
+	mu     sync.Mutex
+	stats  Stats
+	conns  sync.WaitGroup
+	active map[net.Conn]bool // hijacked CONNECT tunnels, closed by Close
+}
+
+// New validates opts and returns an unstarted server.
+func New(opts Options) (*Server, error) {
+	if opts.CA == nil || opts.Cassette == nil {
+		return nil, errors.New("proxy needs a CA and a cassette")
+	}
+	if _, err := ParseMode(string(opts.Mode)); err != nil {
+		return nil, err
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...any) {}
+	}
+	return &Server{
+		opts:   opts,
+		active: map[net.Conn]bool{},
+		transport: &http.Transport{
+			Proxy:              nil,
+			TLSClientConfig:    opts.UpstreamTLS,
+			DisableCompression: true,
+			ForceAttemptHTTP2:  true,
+		},
+	}, nil
+}
+
+// Start listens on a random localhost port and returns its address.
+func (s *Server) Start() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	s.ln = ln
+	s.srv = &http.Server{Handler: s}
+	go s.srv.Serve(ln)
+	return ln.Addr().String(), nil
+}
+
+// Close stops accepting connections and waits for intercepted ones to end.
+func (s *Server) Close() error {
+	if s.srv == nil {
+		return nil
+	}
+	err := s.srv.Close()
+	s.mu.Lock()
+	for c := range s.active {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.conns.Wait()
+	s.transport.CloseIdleConnections()
+	return err
+}
+
+// Stats returns a copy of the session counters.
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stats
+	st.Unmatched = append([]string(nil), s.stats.Unmatched...)
+	return st
+}
+
+// ServeHTTP handles CONNECT tunnels and plain proxied HTTP requests.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		s.handleConnect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "this is a proxy; send absolute-form requests", http.StatusBadRequest)
+		return
+	}
+	resp := s.exchange(r)
+	defer resp.Body.Close()
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// handleConnect terminates TLS for the tunnelled host and serves the
+// HTTP/1.1 requests inside it.
+func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking unsupported", http.StatusInternalServerError)
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.active[conn] = true
+	s.mu.Unlock()
+	s.conns.Add(1)
+	go func() {
+		defer s.conns.Done()
+		defer func() {
+			conn.Close()
+			s.mu.Lock()
+			delete(s.active, conn)
+			s.mu.Unlock()
+		}()
+		if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+			return
+		}
+		host := r.URL.Hostname()
+		tlsConn := tls.Server(conn, &tls.Config{
+			NextProtos: []string{"http/1.1"},
+			GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				name := hello.ServerName
+				if name == "" {
+					name = host
+				}
+				return s.opts.CA.certFor(name)
+			},
+		})
+		if err := tlsConn.Handshake(); err != nil {
+			s.opts.Logf("proxy: TLS handshake for %s failed: %v", host, err)
+			return
+		}
+		s.serveConn(tlsConn, r.Host)
+	}()
+}
+
+func (s *Server) serveConn(conn net.Conn, authority string) {
+	br := bufio.NewReader(conn)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		req.URL.Scheme = "https"
+		req.URL.Host = req.Host
+		if req.URL.Host == "" {
+			req.URL.Host = authority
+		}
+		if isUpgrade(req) {
+			s.tunnelUpgrade(conn, br, req)
+			return
+		}
+		resp := s.exchange(req)
+		resp.Request = req
+		err = resp.Write(conn)
+		resp.Body.Close()
+		if err != nil || req.Close || resp.Close {
+			return
+		}
+	}
+}
+
+// exchange records or replays one request and returns the response to send.
+func (s *Server) exchange(req *http.Request) *http.Response {
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return s.errorResponse(http.StatusBadGateway, "read request body: "+err.Error())
+	}
+	if s.opts.Mode == ModeReplay {
+		return s.replay(req, body)
+	}
+	return s.record(req, body)
+}
+
+func (s *Server) replay(req *http.Request, body []byte) *http.Response {
+	it, ok := s.opts.Cassette.match(req.Method, req.URL.String(), req.Header, body)
+	if !ok {
+		s.mu.Lock()
+		s.stats.UnmatchedCount++
+		if len(s.stats.Unmatched) < maxMisses {
+			s.stats.Unmatched = append(s.stats.Unmatched, req.Method+" "+req.URL.String())
+		}
+		s.mu.Unlock()
+		s.opts.Logf("proxy: no cassette entry for %s %s", req.Method, req.URL)
+		resp := textResponse(http.StatusBadGateway, "no cassette entry for "+req.Method+" "+req.URL.String())
+		resp.Header.Set("X-Lab-Proxy", "unmatched")
+		return resp
+	}
+	respBody, err := s.opts.Cassette.body(it.Response)
+	if err != nil {
+		return s.errorResponse(http.StatusBadGateway, "cassette body: "+err.Error())
+	}
+	h := http.Header{}
+	for name, v := range it.Response.Headers {
+		for _, part := range strings.Split(v, "\n") {
+			h.Add(name, part)
+		}
+	}
+	h.Set("Content-Length", strconv.Itoa(len(respBody)))
+	s.mu.Lock()
+	s.stats.Replayed++
+	s.mu.Unlock()
+	return newResponse(it.Response.Status, h, respBody)
+}
+
+func (s *Server) record(req *http.Request, body []byte) *http.Response {
+	out := req.Clone(req.Context())
+	out.RequestURI = ""
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	out.ContentLength = int64(len(body))
+	for name := range out.Header {
+		if hopHeaders[strings.ToLower(name)] {
+			out.Header.Del(name)
+		}
+	}
+	// Ask for identity encoding so recorded bodies stay readable in diffs.
+	out.Header.Set("Accept-Encoding", "identity")
+
+	resp, err := s.transport.RoundTrip(out)
+	if err != nil {
+		return s.errorResponse(http.StatusBadGateway, "upstream: "+err.Error())
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return s.errorResponse(http.StatusBadGateway, "read upstream body: "+err.Error())
+	}
+	skip := map[string]bool{}
+	for k := range hopHeaders {
+		skip[k] = true
+	}
+	for k := range volatileResponseHeaders {
+		skip[k] = true
+	}
+	reqMsg := Message{Method: req.Method, URL: req.URL.String(), Headers: headerMap(req.Header, recordedRequestHeaders, nil)}
+	respMsg := Message{Status: resp.StatusCode, Headers: headerMap(resp.Header, nil, skip)}
+	if err := s.opts.Cassette.add(reqMsg, respMsg, body, respBody); err != nil {
+		s.opts.Logf("proxy: record %s: %v", req.URL, err)
+	}
+	s.mu.Lock()
+	s.stats.Recorded++
+	s.mu.Unlock()
+
+	h := resp.Header.Clone()
+	for name := range h {
+		if hopHeaders[strings.ToLower(name)] {
+			h.Del(name)
+		}
+	}
+	h.Set("Content-Length", strconv.Itoa(len(respBody)))
+	return newResponse(resp.StatusCode, h, respBody)
+}
+
+// tunnelUpgrade passes a WebSocket upgrade through to the origin in record
+// mode. The frames are not recorded; replay refuses upgrades.
+func (s *Server) tunnelUpgrade(conn net.Conn, br *bufio.Reader, req *http.Request) {
+	if s.opts.Mode == ModeReplay {
+		s.mu.Lock()
+		s.stats.UnmatchedCount++
+		if len(s.stats.Unmatched) < maxMisses {
+			s.stats.Unmatched = append(s.stats.Unmatched, "UPGRADE "+req.URL.String())
+		}
+		s.mu.Unlock()
+		resp := textResponse(http.StatusBadGateway, "upgrades are not replayed")
+		resp.Write(conn)
+		return
+	}
+	addr := req.URL.Host
+	if req.URL.Port() == "" {
+		addr = net.JoinHostPort(req.URL.Hostname(), "443")
+	}
+	cfg := &tls.Config{ServerName: req.URL.Hostname()}
+	if s.opts.UpstreamTLS != nil {
+		cfg = s.opts.UpstreamTLS.Clone()
+		cfg.ServerName = req.URL.Hostname()
+	}
+	cfg.NextProtos = []string{"http/1.1"}
+	upstream, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		s.errorResponse(http.StatusBadGateway, "upstream: "+err.Error()).Write(conn)
+		return
+	}
+	defer upstream.Close()
+	if err := req.Write(upstream); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.stats.Tunneled++
+	s.mu.Unlock()
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(upstream, br); done <- struct{}{} }()
+	go func() { io.Copy(conn, upstream); done <- struct{}{} }()
+	<-done
+}
+
+// errorResponse answers a proxy failure and counts it in Stats.Errors.
+// Replay misses are counted as unmatched instead and use textResponse.
+func (s *Server) errorResponse(status int, msg string) *http.Response {
+	s.mu.Lock()
+	s.stats.Errors++
+	s.mu.Unlock()
+	return textResponse(status, msg)
+}
+
+func textResponse(status int, msg string) *http.Response {
+	h := http.Header{}
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(len(msg)))
+	return newResponse(status, h, []byte(msg))
+}
+
+func newResponse(status int, h http.Header, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
+
+func isUpgrade(req *http.Request) bool {
+	return strings.Contains(strings.ToLower(req.Header.Get("Connection")), "upgrade") && req.Header.Get("Upgrade") != ""
+}
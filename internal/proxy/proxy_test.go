@@ -0,0 +1,290 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func client(t *testing.T, ca *CA, addr string) *http.Client {
+	t.Helper()
+	proxyURL, _ := url.Parse("http://" + addr)
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: ca.Pool()},
+	}}
+}
+
+func get(t *testing.T, c *http.Client, method, u, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, u, strings.NewReader(body))
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func start(t *testing.T, opts Options) (*Server, string) {
+	t.Helper()
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := s.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, addr
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadOrCreateCA(filepath.Join(dir, "ca"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := strings.Repeat("x", maxInlineBody+1)
+	hits := 0
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Date", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Set("Content-Type", "text/plain")
+		switch r.URL.Path {
+		case "/big":
+			io.WriteString(w, big)
+		case "/echo":
+			b, _ := io.ReadAll(r.Body)
+			io.WriteString(w, "echo:"+string(b))
+		default:
+			io.WriteString(w, "hello "+r.URL.RawQuery)
+		}
+	}))
+	pool := upstream.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	path := filepath.Join(dir, "cassette.json")
+	cas := NewCassette(path)
+	rec, addr := start(t, Options{Mode: ModeRecord, Cassette: cas, CA: ca, UpstreamTLS: &tls.Config{RootCAs: pool}})
+	c := client(t, ca, addr)
+	if code, body := get(t, c, "GET", upstream.URL+"/a?x=1&y=2", ""); code != 200 || body != "hello x=1&y=2" {
+		t.Fatalf("record: %d %q", code, body)
+	}
+	get(t, c, "GET", upstream.URL+"/big", "")
+	get(t, c, "POST", upstream.URL+"/echo", `{"b":2,"a":1}`)
+	if st := rec.Stats(); st.Recorded != 3 {
+		t.Fatalf("stats = %+v", st)
+	}
+	if err := cas.Save(); err != nil {
+		t.Fatal(err)
+	}
+	upstream.Close()
+
+	raw, _ := os.ReadFile(path)
+	if strings.Contains(string(raw), "Mon, 01 Jan") {
+		t.Error("volatile date header recorded")
+	}
+	if !strings.Contains(string(raw), `"body_file": "bodies/`) {
+		t.Error("large body not spilled to a file")
+	}
+
+	loaded, err := LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.DefaultMatch = MatchRule{Query: "unordered", Body: "json"}
+	rep, addr := start(t, Options{Mode: ModeReplay, Cassette: loaded, CA: ca})
+	c = client(t, ca, addr)
+	if code, body := get(t, c, "GET", upstream.URL+"/a?y=2&x=1", ""); code != 200 || body != "hello x=1&y=2" {
+		t.Fatalf("replay unordered: %d %q", code, body)
+	}
+	if _, body := get(t, c, "GET", upstream.URL+"/big", ""); body != big {
+		t.Fatalf("replay big body: %d bytes", len(body))
+	}
+	if _, body := get(t, c, "POST", upstream.URL+"/echo", `{"a":1,"b":2}`); body != `echo:{"b":2,"a":1}` {
+		t.Fatalf("replay json body: %q", body)
+	}
+	if code, _ := get(t, c, "GET", upstream.URL+"/missing", ""); code != http.StatusBadGateway {
+		t.Fatalf("unmatched status = %d", code)
+	}
+	st := rep.Stats()
+	if st.Replayed != 3 || st.UnmatchedCount != 1 || st.Errors != 0 || hits != 3 {
+		t.Fatalf("replay stats = %+v, upstream hits = %d", st, hits)
+	}
+}
+
+func TestMatchRules(t *testing.T) {
+	c := &Cassette{Version: cassetteVersion, used: map[int]int{}, Interactions: []*Interaction{
+		{ID: 1, Request: Message{Method: "GET", URL: "https://a.test/p?q=1&ts=1"}, Response: Message{Status: 200, Body: "one"}},
+		{ID: 2, Request: Message{Method: "GET", URL: "https://a.test/p?q=1&ts=1"}, Response: Message{Status: 200, Body: "two"}},
+		{ID: 3, Match: &MatchRule{Method: "ignore", Query: "ignore"}, Request: Message{Method: "POST", URL: "https://a.test/any"}, Response: Message{Status: 204}},
+	}}
+	c.DefaultMatch = MatchRule{IgnoreParams: []string{"ts"}}
+
+	for _, want := range []int{1, 2, 2} {
+		it, ok := c.match("GET", "https://a.test/p?q=1&ts=99", nil, nil)
+		if !ok || it.ID != want {
+			t.Fatalf("sequence: got %v %v, want %d", it, ok, want)
+		}
+	}
+	if _, ok := c.match("GET", "https://a.test/p?q=2", nil, nil); ok {
+		t.Error("different query matched")
+	}
+	if it, ok := c.match("PUT", "https://a.test/any?z=1", nil, nil); !ok || it.ID != 3 {
+		t.Error("per-entry override not applied")
+	}
+	if _, ok := c.match("GET", "https://b.test/p?q=1", nil, nil); ok {
+		t.Error("different host matched")
+	}
+}
+
+func TestCassetteSaveIsOrderIndependent(t *testing.T) {
+	dir := t.TempDir()
+	type exchange struct{ method, url, body, resp string }
+	exchanges := []exchange{
+		{"GET", "https://a.test/poll", "", "first"},
+		{"POST", "https://a.test/api", `{"q":2}`, "two"},
+		{"GET", "https://a.test/poll", "", "second"},
+		{"POST", "https://a.test/api", `{"q":1}`, "one"},
+		{"GET", "https://a.test/", "", "root"},
+	}
+	save := func(name string, order []int) string {
+		c := NewCassette(filepath.Join(dir, name))
+		for _, i := range order {
+			e := exchanges[i]
+			if err := c.add(Message{Method: e.method, URL: e.url}, Message{Status: 200}, []byte(e.body), []byte(e.resp)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := c.Save(); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := os.ReadFile(c.path)
+		return string(b)
+	}
+	// Both polls keep their relative order; everything else arrives shuffled.
+	a := save("a.json", []int{0, 1, 2, 3, 4})
+	b := save("b.json", []int{4, 3, 0, 1, 2})
+	if a != b {
+		t.Fatalf("cassettes differ:\n%s\n---\n%s", a, b)
+	}
+	loaded, err := LoadCassette(filepath.Join(dir, "a.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, it := range loaded.Interactions {
+		if it.ID != i+1 {
+			t.Fatalf("interaction %d has id %d", i, it.ID)
+		}
+	}
+	for _, want := range []string{"first", "second"} {
+		if it, ok := loaded.match("GET", "https://a.test/poll", nil, nil); !ok || it.Response.Body != want {
+			t.Fatalf("poll replay = %+v, want %q", it, want)
+		}
+	}
+}
+
+// maskRedactor masks the token parameter, cookies and the word hunter2.
+type maskRedactor struct{}
+
+func (maskRedactor) URL(raw string) string {
+	u, _ := url.Parse(raw)
+	q := u.Query()
+	if q.Has("token") {
+		q.Set("token", "[REDACTED]")
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+func (maskRedactor) Headers(h map[string]string) map[string]string {
+	for k := range h {
+		if k == "set-cookie" || k == "x-api-key" {
+			h[k] = "[REDACTED]"
+		}
+	}
+	return h
+}
+
+func (maskRedactor) Body(text string) string {
+	return strings.ReplaceAll(text, "hunter2", "[REDACTED]")
+}
+
+func TestCassetteRedaction(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cassette.json")
+	c := NewCassette(path)
+	c.SetRedactor(maskRedactor{}, "[REDACTED]")
+	big := strings.Repeat("x", maxInlineBody) + "hunter2"
+	for _, ex := range []struct {
+		req      Message
+		body     string
+		respHead map[string]string
+		resp     string
+	}{
+		{Message{Method: "GET", URL: "https://a.test/p?z=1&token=abc"}, "", map[string]string{"set-cookie": "sid=xyz"}, "ok"},
+		{Message{Method: "POST", URL: "https://a.test/login", Headers: map[string]string{"x-api-key": "k1"}}, `{"user":"u","password":"hunter2"}`, nil, big},
+	} {
+		if err := c.add(ex.req, Message{Status: 200, Headers: ex.respHead}, []byte(ex.body), []byte(ex.resp)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "bodies", "*"))
+	for _, f := range append(files, path) {
+		raw, _ := os.ReadFile(f)
+		for _, secret := range []string{"abc", "xyz", "hunter2", "k1"} {
+			if strings.Contains(string(raw), secret) {
+				t.Errorf("%s still contains %q", filepath.Base(f), secret)
+			}
+		}
+	}
+
+	loaded, err := LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Redacted != "[REDACTED]" {
+		t.Fatalf("placeholder not recorded: %q", loaded.Redacted)
+	}
+	if _, ok := loaded.match("GET", "https://a.test/p?z=1&token=live", nil, nil); !ok {
+		t.Error("masked query parameter blocked the match")
+	}
+	if _, ok := loaded.match("GET", "https://a.test/p?z=2&token=live", nil, nil); ok {
+		t.Error("unmasked query parameter ignored")
+	}
+	h := http.Header{"X-Api-Key": {"k2"}}
+	loaded.DefaultMatch = MatchRule{Headers: []string{"x-api-key"}}
+	if _, ok := loaded.match("POST", "https://a.test/login", h, []byte(`{"user":"u","password":"s3cret"}`)); !ok {
+		t.Error("masked body or header blocked the match")
+	}
+	if _, ok := loaded.match("POST", "https://a.test/login", h, []byte(`{"user":"v","password":"s3cret"}`)); ok {
+		t.Error("unmasked body text ignored")
+	}
+	loaded.DefaultMatch = MatchRule{Body: "json"}
+	if _, ok := loaded.match("POST", "https://a.test/login", nil, []byte(`{"password": "x", "user": "u"}`)); !ok {
+		t.Error("json body with masked value did not match")
+	}
+	if _, ok := loaded.match("POST", "https://a.test/login", nil, []byte(`{"password": "x", "user": "v"}`)); ok {
+		t.Error("json match ignored the unmasked field")
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	if m, err := ParseMode("Replay"); err != nil || m != ModeReplay {
+		t.Fatalf("ParseMode = %q, %v", m, err)
+	}
+	if _, err := ParseMode("tap"); err == nil {
+		t.Fatal("want error")
+	}
+}
@@ -0,0 +1,102 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+
+	"philadelphia/internal/baseline"
+	"philadelphia/internal/userscript"
+)
+
+// BaselineRef tells which baseline set a run was compared against, so the
+// run can later be approved into it.
+type BaselineRef struct {
+	Dir     string       `json:"dir"` // absolute baseline directory
+	Set     string       `json:"set"`
+	Key     baseline.Key `json:"key"`
+	Pending []string     `json:"pending,omitempty"` // checkpoints with no approved baseline yet
+}
+
+// ApprovedCheckpoint reports one checkpoint promoted by ApproveRun.
+type ApprovedCheckpoint struct {
+	Checkpoint string `json:"checkpoint"`
+	Path       string `json:"path"`
+	Snapshot   string `json:"snapshot,omitempty"` // approved DOM or accessibility snapshot, if the run took one
+}
+
+// baselineKey identifies the baselines for this script, page, viewport,
+// engine and environment variant.
+func baselineKey(meta userscript.Meta, scriptPath, targetURL, engine string, env *PageEnvironment, page playwright.Page) baseline.Key {
+	script := strings.Trim(meta.Namespace+"/"+meta.Name, "/")
+	if meta.Name == "" {
+		script = filepath.Base(scriptPath)
+	}
+	if u, err := url.Parse(targetURL); err == nil {
+		u.Fragment = ""
+		targetURL = u.String()
+	}
+	viewport := "default"
+	if vs := page.ViewportSize(); vs != nil {
+		viewport = fmt.Sprintf("%dx%d", vs.Width, vs.Height)
+	}
+	return baseline.Key{Script: script, URL: targetURL, Viewport: viewport, Engine: engine, Variant: env.variant()}
+}
+
+// ApproveRun promotes a finished run's screenshots, and their snapshots, to
+// approved baselines in the set the run used. An empty checkpoint approves every checkpoint.
+func ApproveRun(runDir, checkpoint string) ([]ApprovedCheckpoint, error) {
+	m, err := LoadManifest(filepath.Join(runDir, "run.json"))
+	if err != nil {
+		return nil, err
+	}
+	if m.Baseline == nil {
+		return nil, fmt.Errorf("run %s was not compared against baselines (no --baseline)", m.RunID)
+	}
+	store := baseline.Open(m.Baseline.Dir)
+	want := baseline.Slug(checkpoint)
+	var approved []ApprovedCheckpoint
+	for _, sh := range m.Screenshots {
+		slug := baseline.Slug(sh.Name)
+		if want != "" && slug != want {
+			continue
+		}
+		if sh.Path == "" {
+			if want != "" {
+				return nil, fmt.Errorf("checkpoint %q has no screenshot: %s", sh.Name, sh.Error)
+			}
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(runDir, "artifacts", filepath.FromSlash(sh.Path)))
+		if err != nil {
+			return approved, err
+		}
+		path, err := store.Approve(m.Baseline.Key, slug, m.RunID, data)
+		if err != nil {
+			return approved, err
+		}
+		ac := ApprovedCheckpoint{Checkpoint: slug, Path: path}
+		if snap := sh.Snapshot; snap != nil && snap.Path != "" {
+			data, err := os.ReadFile(filepath.Join(runDir, "artifacts", filepath.FromSlash(snap.Path)))
+			if err != nil {
+				return approved, err
+			}
+			if ac.Snapshot, err = store.ApproveSnapshot(m.Baseline.Key, slug, snap.Kind, m.RunID, data); err != nil {
+				return approved, err
+			}
+		}
+		approved = append(approved, ac)
+	}
+	if len(approved) == 0 {
+		if want != "" {
+			return nil, fmt.Errorf("run %s has no checkpoint %q", m.RunID, checkpoint)
+		}
+		return nil, errors.New("run has no screenshots to approve")
+	}
+	return approved, nil
+}
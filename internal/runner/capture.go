@@ -0,0 +1,218 @@
+package runner
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+
+	"philadelphia/internal/har"
+)
+
+// TraceChunk is one Playwright trace file. The run is split into a load chunk
+// (launch through first navigation), one chunk per step and a finish chunk.
+type TraceChunk struct {
+	Name  string `json:"name"`
+	Step  int    `json:"step,omitempty"` // 1-based step index; 0 for load/finish
+	Path  string `json:"path,omitempty"` // relative to artifacts; empty when invalid
+	Bytes int64  `json:"bytes,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// HARCapture describes the recorded HAR for the manifest.
+type HARCapture struct {
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	Content   string `json:"content"`
+	Mode      string `json:"mode"`
+	URLFilter string `json:"url_filter,omitempty"`
+}
+
+// traceRecorder runs one tracing session for the whole context and cuts it
+// into chunks at step boundaries.
+type traceRecorder struct {
+	tracing playwright.Tracing
+	dir     string // artifacts dir
+	logger  *ndjsonLogger
+	chunks  []TraceChunk
+	open    bool
+	stopped bool
+}
+
+var unsafeChunkChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// startTrace begins tracing with the load chunk open.
+func startTrace(ctx playwright.BrowserContext, artifactsDir, title string, logger *ndjsonLogger) (*traceRecorder, error) {
+	if err := os.MkdirAll(filepath.Join(artifactsDir, "trace"), 0o755); err != nil {
+		return nil, err
+	}
+	t := &traceRecorder{tracing: ctx.Tracing(), dir: artifactsDir, logger: logger}
+	if err := t.tracing.Start(playwright.TracingStartOptions{
+		Title:       playwright.String(title),
+		Screenshots: playwright.Bool(true),
+		Snapshots:   playwright.Bool(true),
+		Sources:     playwright.Bool(true),
+	}); err != nil {
+		return nil, err
+	}
+	t.open = true
+	t.chunks = append(t.chunks, TraceChunk{Name: "load", Path: "trace/00-load.zip"})
+	return t, nil
+}
+
+// begin closes the open chunk and starts the next one. A nil recorder is a no-op.
+func (t *traceRecorder) begin(step int, name string) {
+	if t == nil || t.stopped {
+		return
+	}
+	t.stopChunk()
+	slug := strings.Trim(unsafeChunkChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	chunk := TraceChunk{Name: name, Step: step, Path: fmt.Sprintf("trace/%02d-%s.zip", len(t.chunks), slug)}
+	title := name
+	if step > 0 {
+		title = fmt.Sprintf("step %d: %s", step, name)
+	}
+	if err := t.tracing.StartChunk(playwright.TracingStartChunkOptions{Title: playwright.String(title)}); err != nil {
+		t.logger.warn("trace", "start chunk failed", map[string]any{"chunk": chunk.Path, "error": err.Error()})
+		return
+	}
+	t.open = true
+	t.chunks = append(t.chunks, chunk)
+}
+
+// current returns the path of the chunk being recorded.
+func (t *traceRecorder) current() string {
+	if t == nil || !t.open || len(t.chunks) == 0 {
+		return ""
+	}
+	return t.chunks[len(t.chunks)-1].Path
+}
+
+func (t *traceRecorder) stopChunk() {
+	if !t.open {
+		return
+	}
+	t.open = false
+	c := &t.chunks[len(t.chunks)-1]
+	if err := t.tracing.StopChunk(filepath.Join(t.dir, c.Path)); err != nil {
+		c.Error = err.Error()
+		t.logger.warn("trace", "stop chunk failed", map[string]any{"chunk": c.Path, "error": err.Error()})
+	}
+}
+
+// stop writes the last chunk, ends tracing and validates every chunk. It is
+// safe to call more than once and must run before the context closes.
+func (t *traceRecorder) stop() []TraceChunk {
+	if t == nil {
+		return nil
+	}
+	if !t.stopped {
+		t.stopped = true
+		// Stop writes the open chunk itself; a separate StopChunk would leave
+		// Stop discarding a chunk that no longer exists.
+		var path string
+		if t.open {
+			t.open = false
+			path = filepath.Join(t.dir, t.chunks[len(t.chunks)-1].Path)
+		}
+		if err := t.tracing.Stop(path); err != nil {
+			if path != "" {
+				t.chunks[len(t.chunks)-1].Error = err.Error()
+			}
+			t.logger.warn("trace", "stop failed", map[string]any{"error": err.Error()})
+		}
+		for i := range t.chunks {
+			c := &t.chunks[i]
+			if c.Error != "" {
+				c.Path = ""
+				continue
+			}
+			size, err := validateTrace(filepath.Join(t.dir, c.Path))
+			if err != nil {
+				t.logger.warn("trace", "invalid trace chunk", map[string]any{"chunk": c.Path, "error": err.Error()})
+				c.Error, c.Path = err.Error(), ""
+				continue
+			}
+			c.Bytes = size
+		}
+		t.logger.info("trace", "trace captured", map[string]any{"chunks": len(t.chunks)})
+	}
+	return t.chunks
+}
+
+// validateTrace checks that path is a zip holding Playwright trace events.
+func validateTrace(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return 0, fmt.Errorf("not a zip: %w", err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, ".trace") && f.UncompressedSize64 > 0 {
+			return info.Size(), nil
+		}
+	}
+	return 0, errors.New("zip has no trace events")
+}
+
+// harRecordOptions validates HAR capture settings and applies them to ctxOpts.
+func harRecordOptions(ctxOpts *playwright.BrowserTypeLaunchPersistentContextOptions, path, content, mode, urlFilter string) (HARCapture, error) {
+	capture := HARCapture{Content: strings.ToLower(content), Mode: strings.ToLower(mode), URLFilter: urlFilter}
+	if capture.Content == "" {
+		capture.Content = "embed"
+	}
+	if capture.Mode == "" {
+		capture.Mode = "full"
+	}
+	switch capture.Content {
+	case "embed":
+		ctxOpts.RecordHarContent = playwright.HarContentPolicyEmbed
+	case "attach":
+		ctxOpts.RecordHarContent = playwright.HarContentPolicyAttach
+	case "omit":
+		ctxOpts.RecordHarContent = playwright.HarContentPolicyOmit
+	default:
+		return capture, fmt.Errorf("unknown HAR content policy %q (want embed, attach or omit)", content)
+	}
+	switch capture.Mode {
+	case "full":
+		ctxOpts.RecordHarMode = playwright.HarModeFull
+	case "minimal":
+		ctxOpts.RecordHarMode = playwright.HarModeMinimal
+	default:
+		return capture, fmt.Errorf("unknown HAR mode %q (want full or minimal)", mode)
+	}
+	if urlFilter != "" {
+		m, err := newURLMatcher(urlFilter)
+		if err != nil {
+			return capture, fmt.Errorf("HAR url filter: %w", err)
+		}
+		ctxOpts.RecordHarURLFilter = m.re
+	}
+	ctxOpts.RecordHarPath = playwright.String(path)
+	return capture, nil
+}
+
+// validateHAR parses the HAR written when the context closed.
+func validateHAR(path string, capture *HARCapture) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	f, err := har.Load(path)
+	if err != nil {
+		return err
+	}
+	capture.Entries = len(f.Log.Entries)
+	capture.Bytes = info.Size()
+	return nil
+}
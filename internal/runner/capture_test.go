@@ -0,0 +1,72 @@
+package runner
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+func writeZip(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, body := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(body))
+	}
+	zw.Close()
+	f.Close()
+}
+
+func TestValidateTrace(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.zip")
+	writeZip(t, good, map[string]string{"trace.trace": `{"type":"context-options"}`, "trace.network": ""})
+	if n, err := validateTrace(good); err != nil || n == 0 {
+		t.Fatalf("valid trace rejected: %d %v", n, err)
+	}
+
+	empty := filepath.Join(dir, "empty.zip")
+	writeZip(t, empty, map[string]string{"resources/a.png": "x"})
+	if _, err := validateTrace(empty); err == nil {
+		t.Fatal("zip without trace events accepted")
+	}
+
+	junk := filepath.Join(dir, "junk.zip")
+	os.WriteFile(junk, []byte("not a zip"), 0o644)
+	if _, err := validateTrace(junk); err == nil {
+		t.Fatal("non-zip accepted")
+	}
+	if _, err := validateTrace(filepath.Join(dir, "missing.zip")); err == nil {
+		t.Fatal("missing file accepted")
+	}
+}
+
+func TestHARRecordOptions(t *testing.T) {
+	var opts playwright.BrowserTypeLaunchPersistentContextOptions
+	capture, err := harRecordOptions(&opts, "/tmp/x.har", "", "", "**/api/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capture.Content != "embed" || capture.Mode != "full" || opts.RecordHarContent != playwright.HarContentPolicyEmbed || opts.RecordHarURLFilter == nil {
+		t.Fatalf("defaults = %+v %+v", capture, opts)
+	}
+	if capture, err = harRecordOptions(&opts, "/tmp/x.har", "Attach", "minimal", ""); err != nil || opts.RecordHarMode != playwright.HarModeMinimal || capture.Content != "attach" {
+		t.Fatalf("attach/minimal = %+v %v", capture, err)
+	}
+	if _, err := harRecordOptions(&opts, "/tmp/x.har", "inline", "", ""); err == nil {
+		t.Fatal("bad content policy accepted")
+	}
+	if _, err := harRecordOptions(&opts, "/tmp/x.har", "", "verbose", ""); err == nil {
+		t.Fatal("bad mode accepted")
+	}
+}
@@ -0,0 +1,196 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// debugSlowMo is the per-operation delay applied to headed debug runs.
+const debugSlowMo = 250
+
+// pauseAction is what the REPL decided when control returns to the step loop.
+type pauseAction int
+
+const (
+	pauseResume pauseAction = iota // run the remaining steps
+	pauseNext                      // run one step, then pause again
+	pauseRetry                     // re-run the step that just failed
+	pauseSkip                      // drop the failed step from the proposed list and move on
+	pauseAbort                     // stop executing steps
+)
+
+// debugger pauses the step loop on breakpoints and the first failing step,
+// offering a REPL on the configured terminal. Every step that passes, whether
+// from the flow or typed at the prompt, is appended to the proposed step list
+// written at the end of the run.
+type debugger struct {
+	in           *bufio.Scanner
+	out          io.Writer
+	breakAt      map[int]bool
+	stepping     bool
+	pausedOnFail bool
+	proposed     []Step
+}
+
+func newDebugger(in io.Reader, out io.Writer, breakAt []int) *debugger {
+	if in == nil {
+		in = os.Stdin
+	}
+	if out == nil {
+		out = os.Stdout
+	}
+	d := &debugger{in: bufio.NewScanner(in), out: out, breakAt: map[int]bool{}}
+	for _, n := range breakAt {
+		d.breakAt[n] = true
+	}
+	return d
+}
+
+// shouldBreak reports whether to pause before the 1-based step index.
+func (d *debugger) shouldBreak(index int) bool {
+	if d.stepping {
+		d.stepping = false
+		return true
+	}
+	return d.breakAt[index]
+}
+
+// shouldPauseOnFailure is true only for the first failing step of the run.
+func (d *debugger) shouldPauseOnFailure() bool {
+	if d.pausedOnFail {
+		return false
+	}
+	d.pausedOnFail = true
+	return true
+}
+
+const debugHelp = `commands:
+  sel <selector>          count matches and show the first texts
+  eval <js>               evaluate JS in the page (recorded as an eval step)
+  step <json>             run an ad-hoc step, e.g. step {"action":"click","target":"#go"}
+  <action> <target> [val] shorthand for step, e.g. click text=Save
+  steps                   print the proposed step list so far
+  c | continue            resume the remaining steps
+  n | next                run the next step and pause again
+  r | retry               re-run the failed step
+  s | skip                drop the failed step and continue
+  q | abort               stop the run here and write artifacts
+`
+
+// pause runs the REPL until the user resumes or aborts. failed is the step
+// that just failed, or nil when pausing on a breakpoint before next.
+func (d *debugger) pause(sr *stepRunner, reason string, index int, next Step, failed error) pauseAction {
+	fmt.Fprintf(d.out, "\n-- paused (%s) at step %d: %s %s\n", reason, index, next.Action, next.Target)
+	if failed != nil {
+		fmt.Fprintf(d.out, "   error: %v\n", failed)
+	}
+	fmt.Fprint(d.out, "   type 'help' for commands\n")
+	for {
+		fmt.Fprint(d.out, "lab> ")
+		if !d.in.Scan() {
+			fmt.Fprintln(d.out)
+			return pauseAbort
+		}
+		line := strings.TrimSpace(d.in.Text())
+		if line == "" {
+			continue
+		}
+		cmd, rest, _ := strings.Cut(line, " ")
+		rest = strings.TrimSpace(rest)
+		switch strings.ToLower(cmd) {
+		case "help", "h", "?":
+			fmt.Fprint(d.out, debugHelp)
+		case "c", "continue":
+			return pauseResume
+		case "n", "next":
+			d.stepping = true
+			return pauseNext
+		case "r", "retry":
+			if failed == nil {
+				fmt.Fprintln(d.out, "nothing to retry")
+				continue
+			}
+			return pauseRetry
+		case "s", "skip":
+			if failed == nil {
+				fmt.Fprintln(d.out, "nothing to skip")
+				continue
+			}
+			return pauseSkip
+		case "q", "abort", "quit":
+			return pauseAbort
+		case "steps":
+			b, _ := json.MarshalIndent(d.proposed, "", "  ")
+			fmt.Fprintln(d.out, string(b))
+		case "sel":
+			d.trySelector(sr.page, rest)
+		case "eval":
+			d.runAdHoc(sr, Step{Action: "eval", Value: rest})
+		case "step":
+			var step Step
+			if err := json.Unmarshal([]byte(rest), &step); err != nil {
+				fmt.Fprintf(d.out, "invalid step JSON: %v\n", err)
+				continue
+			}
+			d.runAdHoc(sr, step)
+		default:
+			d.runAdHoc(sr, parseShorthandStep(cmd, rest))
+		}
+	}
+}
+
+// runAdHoc executes a typed step and records it in the proposed list if it passes.
+func (d *debugger) runAdHoc(sr *stepRunner, step Step) {
+	if err := sr.runStep("debug", step); err != nil {
+		fmt.Fprintf(d.out, "FAIL %s: %v\n", step.Action, err)
+		return
+	}
+	d.proposed = append(d.proposed, step)
+	fmt.Fprintf(d.out, "ok   %s\n", step.Action)
+}
+
+func (d *debugger) trySelector(page playwright.Page, selector string) {
+	if selector == "" {
+		fmt.Fprintln(d.out, "usage: sel <selector>")
+		return
+	}
+	loc := page.Locator(selector)
+	n, err := loc.Count()
+	if err != nil {
+		fmt.Fprintf(d.out, "error: %v\n", err)
+		return
+	}
+	fmt.Fprintf(d.out, "%d match(es)\n", n)
+	for i := 0; i < n && i < 5; i++ {
+		text, err := loc.Nth(i).InnerText(playwright.LocatorInnerTextOptions{Timeout: playwright.Float(1000)})
+		if err != nil {
+			continue
+		}
+		text = strings.Join(strings.Fields(text), " ")
+		if len(text) > 80 {
+			text = text[:77] + "..."
+		}
+		fmt.Fprintf(d.out, "  [%d] %s\n", i, text)
+	}
+	if n > 0 {
+		_ = loc.First().Highlight()
+	}
+}
+
+// parseShorthandStep turns "click text=Save" or "fill #q hello" into a Step.
+func parseShorthandStep(action, rest string) Step {
+	step := Step{Action: action}
+	target, value, _ := strings.Cut(rest, " ")
+	step.Target = target
+	step.Value = strings.TrimSpace(value)
+	if strings.EqualFold(action, "wait") && step.Value == "" {
+		step.Target, step.Value = "", target
+	}
+	return step
+}
@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// clickPage is a Page whose Click fails for targets with failures left.
+type clickPage struct {
+	playwright.Page
+	failures map[string]int
+	clicked  []string
+}
+
+func (p *clickPage) Click(selector string, _ ...playwright.PageClickOptions) error {
+	if p.failures[selector] > 0 {
+		p.failures[selector]--
+		return errors.New("element not found: " + selector)
+	}
+	p.clicked = append(p.clicked, selector)
+	return nil
+}
+
+func debugRun(input string, breakAt []int, failures map[string]int, steps []Step) (*clickPage, *stepRunner, []StepResult) {
+	page := &clickPage{failures: failures}
+	logger := &ndjsonLogger{w: bufio.NewWriter(io.Discard)}
+	sr := &stepRunner{
+		page:      page,
+		logger:    logger,
+		dialogs:   newDialogHandler("", logger),
+		downloads: newDownloadCollector("", logger),
+		debug:     newDebugger(strings.NewReader(input), &bytes.Buffer{}, breakAt),
+	}
+	return page, sr, executeSteps(sr, steps)
+}
+
+func click(target string) Step { return Step{Action: "click", Target: target} }
+
+func TestDebugRetry(t *testing.T) {
+	steps := []Step{click("#a"), click("#b")}
+	page, sr, results := debugRun("retry\n", nil, map[string]int{"#a": 1}, steps)
+	if len(results) != 2 || !results[0].Passed || !results[1].Passed {
+		t.Fatalf("results = %+v", results)
+	}
+	if !reflect.DeepEqual(sr.debug.proposed, steps) {
+		t.Fatalf("proposed = %+v", sr.debug.proposed)
+	}
+	if !reflect.DeepEqual(page.clicked, []string{"#a", "#b"}) {
+		t.Fatalf("clicked = %v", page.clicked)
+	}
+}
+
+func TestDebugSkip(t *testing.T) {
+	_, sr, results := debugRun("skip\n", nil, map[string]int{"#a": 1}, []Step{click("#a"), click("#b")})
+	if len(results) != 2 || results[0].Passed || !results[1].Passed {
+		t.Fatalf("results = %+v", results)
+	}
+	if !reflect.DeepEqual(sr.debug.proposed, []Step{click("#b")}) {
+		t.Fatalf("proposed = %+v", sr.debug.proposed)
+	}
+}
+
+func TestDebugContinueAfterFailure(t *testing.T) {
+	// The failed ad-hoc click is dropped; the passing one replaces the failed step.
+	input := "click #typo\nclick #fixed\ncontinue\n"
+	_, sr, results := debugRun(input, nil, map[string]int{"#a": 1, "#typo": 1}, []Step{click("#a"), click("#b")})
+	if len(results) != 2 || results[0].Passed || !results[1].Passed || sr.aborted {
+		t.Fatalf("results = %+v aborted=%v", results, sr.aborted)
+	}
+	if want := []Step{click("#fixed"), click("#b")}; !reflect.DeepEqual(sr.debug.proposed, want) {
+		t.Fatalf("proposed = %+v, want %+v", sr.debug.proposed, want)
+	}
+}
+
+func TestDebugAbortAndBreakpoint(t *testing.T) {
+	page, sr, results := debugRun("next\nabort\n", []int{2}, nil, []Step{click("#a"), click("#b"), click("#c")})
+	if !sr.aborted || len(results) != 2 || !reflect.DeepEqual(page.clicked, []string{"#a", "#b"}) {
+		t.Fatalf("aborted=%v results=%+v clicked=%v", sr.aborted, results, page.clicked)
+	}
+}
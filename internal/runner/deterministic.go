@@ -0,0 +1,124 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// Determinism pins the parts of the page environment that otherwise change
+// from run to run. Zero fields take the defaults below (a nil Seed, so that 0
+// stays a valid seed); the manifest records the values actually applied.
+type Determinism struct {
+	Time              time.Time `json:"time"`                // Date.now() and new Date() always return this
+	Seed              *uint32   `json:"seed"`                // seeds Math.random and crypto.getRandomValues
+	Timezone          string    `json:"timezone"`            // IANA zone, e.g. Europe/Berlin
+	Locale            string    `json:"locale"`              // BCP 47 tag, e.g. de-DE
+	ReducedMotion     string    `json:"reduced_motion"`      // reduce or no-preference
+	DeviceScaleFactor float64   `json:"device_scale_factor"` // window.devicePixelRatio
+}
+
+const defaultSeed uint32 = 1
+
+var defaultDeterminism = Determinism{
+	Time:              time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+	Timezone:          "UTC",
+	Locale:            "en-US",
+	ReducedMotion:     "reduce",
+	DeviceScaleFactor: 1,
+}
+
+// withDefaults fills unset fields and validates the rest.
+func (d Determinism) withDefaults() (Determinism, error) {
+	if d.Time.IsZero() {
+		d.Time = defaultDeterminism.Time
+	}
+	d.Time = d.Time.UTC()
+	if d.Seed == nil {
+		d.Seed = new(uint32)
+		*d.Seed = defaultSeed
+	}
+	if d.Timezone == "" {
+		d.Timezone = defaultDeterminism.Timezone
+	}
+	if _, err := time.LoadLocation(d.Timezone); err != nil {
+		return d, fmt.Errorf("deterministic: unknown timezone %q", d.Timezone)
+	}
+	if d.Locale == "" {
+		d.Locale = defaultDeterminism.Locale
+	}
+	switch d.ReducedMotion = strings.ToLower(d.ReducedMotion); d.ReducedMotion {
+	case "":
+		d.ReducedMotion = defaultDeterminism.ReducedMotion
+	case "reduce", "no-preference":
+	default:
+		return d, fmt.Errorf("deterministic: reduced_motion must be reduce or no-preference, got %q", d.ReducedMotion)
+	}
+	if d.DeviceScaleFactor < 0 {
+		return d, fmt.Errorf("deterministic: device_scale_factor must be positive, got %v", d.DeviceScaleFactor)
+	}
+	if d.DeviceScaleFactor == 0 {
+		d.DeviceScaleFactor = defaultDeterminism.DeviceScaleFactor
+	}
+	return d, nil
+}
+
+// variantLocale is the locale that goes into the baseline variant. Only a
+// locale someone chose counts; the en-US default alone must not move a
+// deterministic run into a different baseline set than a live one.
+func (d Determinism) variantLocale(opts Options) string {
+	if opts.Locale == "" && (opts.Deterministic == nil || opts.Deterministic.Locale == "") {
+		return ""
+	}
+	return d.Locale
+}
+
+// contextOptions pins timezone, locale, reduced motion and device scale
+// factor on the browser context.
+func (d Determinism) contextOptions(ctxOpts *playwright.BrowserTypeLaunchPersistentContextOptions) {
+	ctxOpts.TimezoneId = playwright.String(d.Timezone)
+	ctxOpts.Locale = playwright.String(d.Locale)
+	ctxOpts.DeviceScaleFactor = playwright.Float(d.DeviceScaleFactor)
+	if d.ReducedMotion == "reduce" {
+		ctxOpts.ReducedMotion = playwright.ReducedMotionReduce
+	} else {
+		ctxOpts.ReducedMotion = playwright.ReducedMotionNoPreference
+	}
+}
+
+// seededRandomJS replaces Math.random and crypto.getRandomValues with a
+// mulberry32 generator, so a seed yields the same sequence in every page and
+// frame. Workers keep the real ones.
+const seededRandomJS = `(seed => {
+  let s = seed >>> 0;
+  const next = () => {
+    s = (s + 0x6D2B79F5) >>> 0;
+    let t = s;
+    t = Math.imul(t ^ (t >>> 15), t | 1);
+    t ^= t + Math.imul(t ^ (t >>> 7), t | 61);
+    return (t ^ (t >>> 14)) >>> 0;
+  };
+  Math.random = () => next() / 4294967296;
+  if (globalThis.crypto && crypto.getRandomValues) {
+    const fill = function getRandomValues(arr) {
+      const bytes = new Uint8Array(arr.buffer, arr.byteOffset, arr.byteLength);
+      for (let i = 0; i < bytes.length; i++) bytes[i] = next() & 0xff;
+      return arr;
+    };
+    Object.defineProperty(Crypto.prototype, 'getRandomValues', {value: fill, configurable: true, writable: true});
+  }
+})(%d);`
+
+// install freezes the clock and seeds randomness for every page of ctx. It
+// must run before the first navigation and before the userscript is added.
+func (d Determinism) install(ctx playwright.BrowserContext) error {
+	if err := ctx.Clock().SetFixedTime(d.Time); err != nil {
+		return fmt.Errorf("fixed clock: %w", err)
+	}
+	if err := ctx.AddInitScript(playwright.Script{Content: playwright.String(fmt.Sprintf(seededRandomJS, *d.Seed))}); err != nil {
+		return fmt.Errorf("seeded random: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+func TestDeterminismDefaults(t *testing.T) {
+	seed := uint32(42)
+	d, err := Determinism{Locale: "de-DE", Seed: &seed}.withDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultDeterminism
+	want.Locale, want.Seed = "de-DE", d.Seed
+	if d != want || *d.Seed != 42 {
+		t.Fatalf("withDefaults = %+v, want %+v", d, want)
+	}
+
+	// A nil seed takes the default; an explicit 0 is kept.
+	if d, _ := (Determinism{}).withDefaults(); *d.Seed != defaultSeed {
+		t.Fatalf("default seed = %d, want %d", *d.Seed, defaultSeed)
+	}
+	zero := uint32(0)
+	if d, _ := (Determinism{Seed: &zero}).withDefaults(); *d.Seed != 0 {
+		t.Fatalf("seed 0 became %d", *d.Seed)
+	}
+	var fromJSON Determinism
+	if err := json.Unmarshal([]byte(`{"seed": 0}`), &fromJSON); err != nil || fromJSON.Seed == nil || *fromJSON.Seed != 0 {
+		t.Fatalf("json seed 0 = %v, %v", fromJSON.Seed, err)
+	}
+
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	d, err = Determinism{Time: time.Date(2024, 2, 29, 9, 0, 0, 0, berlin), ReducedMotion: "No-Preference"}.withDefaults()
+	if err != nil || d.Time.Location() != time.UTC || d.Time.Hour() != 8 || d.ReducedMotion != "no-preference" {
+		t.Fatalf("withDefaults = %+v, %v", d, err)
+	}
+
+	for _, bad := range []Determinism{
+		{Timezone: "Mars/Olympus"},
+		{ReducedMotion: "sometimes"},
+		{DeviceScaleFactor: -2},
+	} {
+		if _, err := bad.withDefaults(); err == nil || !strings.HasPrefix(err.Error(), "deterministic:") {
+			t.Errorf("%+v: err = %v", bad, err)
+		}
+	}
+}
+
+func TestDeterminismContextOptions(t *testing.T) {
+	d, _ := Determinism{Timezone: "Asia/Tokyo", DeviceScaleFactor: 2}.withDefaults()
+	var o playwright.BrowserTypeLaunchPersistentContextOptions
+	d.contextOptions(&o)
+	if *o.TimezoneId != "Asia/Tokyo" || *o.Locale != "en-US" || *o.DeviceScaleFactor != 2 || *o.ReducedMotion != *playwright.ReducedMotionReduce {
+		t.Fatalf("context options = tz %s locale %s dpr %v motion %s", *o.TimezoneId, *o.Locale, *o.DeviceScaleFactor, *o.ReducedMotion)
+	}
+}
+
+func TestDeterminismVariantLocale(t *testing.T) {
+	d, _ := Determinism{}.withDefaults()
+	for _, tc := range []struct {
+		opts Options
+		want string
+	}{
+		{Options{Deterministic: &Determinism{}}, ""},
+		{Options{Deterministic: &Determinism{}, Locale: "en-US"}, "en-US"},
+		{Options{Deterministic: &Determinism{Locale: "en-US"}}, "en-US"},
+	} {
+		if got := d.variantLocale(tc.opts); got != tc.want {
+			t.Errorf("variantLocale(%+v) = %q, want %q", tc.opts, got, tc.want)
+		}
+	}
+}
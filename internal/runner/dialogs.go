@@ -0,0 +1,290 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// DialogRecord captures one alert/confirm/prompt/beforeunload dialog and how it was answered.
+type DialogRecord struct {
+	Step         int       `json:"step,omitempty"` // 1-based step index active when the dialog opened; 0 = outside steps
+	Type         string    `json:"type"`
+	Message      string    `json:"message"`
+	DefaultValue string    `json:"default_value,omitempty"`
+	Action       string    `json:"action"` // accept or dismiss
+	Response     string    `json:"response,omitempty"`
+	At           time.Time `json:"at"`
+}
+
+// DownloadRecord describes a file the page downloaded during the run.
+type DownloadRecord struct {
+	Step   int    `json:"step,omitempty"`
+	Name   string `json:"name"` // browser-suggested filename
+	URL    string `json:"url"`
+	Path   string `json:"path,omitempty"` // relative to the artifacts dir
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// parseDialogPolicy splits a policy string into an action and optional prompt text.
+// Accepted forms: "" or "accept", "dismiss", "respond:<text>".
+func parseDialogPolicy(policy string) (action, text string, err error) {
+	p := strings.TrimSpace(policy)
+	switch {
+	case p == "" || strings.EqualFold(p, "accept"):
+		return "accept", "", nil
+	case strings.EqualFold(p, "dismiss"):
+		return "dismiss", "", nil
+	case strings.HasPrefix(strings.ToLower(p), "respond:"):
+		return "accept", p[len("respond:"):], nil
+	}
+	return "", "", fmt.Errorf("unknown dialog policy %q (use accept, dismiss, or respond:<text>)", policy)
+}
+
+// dialogHandler answers dialogs according to the run policy or the active step override.
+type dialogHandler struct {
+	mu       sync.Mutex
+	policy   string
+	override string
+	step     int
+	records  []DialogRecord
+	logger   *ndjsonLogger
+}
+
+func newDialogHandler(policy string, logger *ndjsonLogger) *dialogHandler {
+	return &dialogHandler{policy: policy, logger: logger}
+}
+
+// setStep records the active step and its optional per-step policy.
+func (h *dialogHandler) setStep(step int, override string) {
+	h.mu.Lock()
+	h.step = step
+	h.override = override
+	h.mu.Unlock()
+}
+
+func (h *dialogHandler) handle(d playwright.Dialog) {
+	h.mu.Lock()
+	policy := h.policy
+	if h.override != "" {
+		policy = h.override
+	}
+	step := h.step
+	h.mu.Unlock()
+
+	action, text, err := parseDialogPolicy(policy)
+	if err != nil {
+		h.logger.warn("dialog", "invalid policy; accepting", map[string]any{"error": err.Error()})
+		action, text = "accept", ""
+	}
+	rec := DialogRecord{
+		Step:         step,
+		Type:         d.Type(),
+		Message:      d.Message(),
+		DefaultValue: d.DefaultValue(),
+		Action:       action,
+		At:           time.Now(),
+	}
+	if action == "dismiss" {
+		err = d.Dismiss()
+	} else if d.Type() == "prompt" && text != "" {
+		rec.Response = text
+		err = d.Accept(text)
+	} else {
+		err = d.Accept()
+	}
+	meta := map[string]any{"type": rec.Type, "message": rec.Message, "action": rec.Action}
+	if err != nil {
+		meta["error"] = err.Error()
+		h.logger.warn("dialog", "answer failed", meta)
+	} else {
+		h.logger.info("dialog", "dialog answered", meta)
+	}
+
+	h.mu.Lock()
+	h.records = append(h.records, rec)
+	h.mu.Unlock()
+}
+
+func (h *dialogHandler) snapshot() []DialogRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]DialogRecord(nil), h.records...)
+}
+
+// await polls until a dialog of type typ (any type when empty) whose message
+// contains substr has been answered, or timeout elapses.
+func (h *dialogHandler) await(typ, substr string, timeout time.Duration) *DialogRecord {
+	var found *DialogRecord
+	pollUntil(timeout, func() bool {
+		for _, d := range h.snapshot() {
+			if (typ == "" || strings.EqualFold(d.Type, typ)) && strings.Contains(d.Message, substr) {
+				found = &d
+				return true
+			}
+		}
+		return false
+	})
+	return found
+}
+
+// downloadCollector saves every page download into artifacts/downloads.
+type downloadCollector struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	dir     string
+	step    int
+	records []DownloadRecord
+	claimed map[string]bool // destinations handed out by reserve
+	logger  *ndjsonLogger
+}
+
+func newDownloadCollector(artifactsDir string, logger *ndjsonLogger) *downloadCollector {
+	return &downloadCollector{dir: filepath.Join(artifactsDir, "downloads"), logger: logger}
+}
+
+func (c *downloadCollector) setStep(step int) {
+	c.mu.Lock()
+	c.step = step
+	c.mu.Unlock()
+}
+
+// handle is invoked on the Playwright dispatch goroutine, so the blocking save runs separately.
+func (c *downloadCollector) handle(d playwright.Download) {
+	c.mu.Lock()
+	rec := DownloadRecord{Step: c.step, Name: d.SuggestedFilename(), URL: d.URL()}
+	c.mu.Unlock()
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.save(d, rec)
+	}()
+}
+
+func (c *downloadCollector) save(d playwright.Download, rec DownloadRecord) {
+	defer func() {
+		c.mu.Lock()
+		c.records = append(c.records, rec)
+		c.mu.Unlock()
+	}()
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		rec.Error = err.Error()
+		return
+	}
+	name := filepath.Base(rec.Name)
+	if name == "" || name == "." || name == string(filepath.Separator) || strings.HasPrefix(name, ".") {
+		name = "download"
+	}
+	dest := c.reserve(filepath.Join(c.dir, name))
+	if err := d.SaveAs(dest); err != nil {
+		rec.Error = err.Error()
+		c.logger.warn("download", "save failed", map[string]any{"url": rec.URL, "error": rec.Error})
+		return
+	}
+	size, sum, err := hashFile(dest)
+	if err != nil {
+		rec.Error = err.Error()
+		return
+	}
+	rec.Path = filepath.Join("downloads", filepath.Base(dest))
+	rec.Size = size
+	rec.SHA256 = sum
+	c.logger.info("download", "download saved", map[string]any{"name": rec.Name, "path": rec.Path, "size": size})
+}
+
+// wait blocks until in-flight downloads finish and returns the collected records.
+func (c *downloadCollector) wait() []DownloadRecord {
+	c.wg.Wait()
+	return c.snapshot()
+}
+
+func (c *downloadCollector) snapshot() []DownloadRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]DownloadRecord(nil), c.records...)
+}
+
+// await polls until a download whose suggested name matches pattern has
+// finished saving, or timeout elapses.
+func (c *downloadCollector) await(pattern string, timeout time.Duration) *DownloadRecord {
+	var found *DownloadRecord
+	pollUntil(timeout, func() bool {
+		for _, d := range c.snapshot() {
+			if matchName(pattern, d.Name) {
+				found = &d
+				return true
+			}
+		}
+		return false
+	})
+	return found
+}
+
+// reserve claims a free destination for path. Downloads are saved
+// concurrently, so a name counts as taken from the moment it is handed out,
+// not only once the file exists.
+func (c *downloadCollector) reserve(path string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.claimed == nil {
+		c.claimed = map[string]bool{}
+	}
+	dest := uniquePath(path, c.claimed)
+	c.claimed[dest] = true
+	return dest
+}
+
+// uniquePath appends a numeric suffix when path already exists or is taken.
+func uniquePath(path string, taken map[string]bool) string {
+	free := func(p string) bool {
+		_, err := os.Stat(p)
+		return err != nil && !taken[p]
+	}
+	if free(path) {
+		return path
+	}
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		if candidate := fmt.Sprintf("%s-%d%s", stem, i, ext); free(candidate) {
+			return candidate
+		}
+	}
+}
+
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// pollUntil re-checks cond every 100ms until it holds or timeout elapses.
+func pollUntil(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,131 @@
+package runner
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseDialogPolicy(t *testing.T) {
+	cases := []struct {
+		in, action, text string
+		err              bool
+	}{
+		{"", "accept", "", false},
+		{" Accept ", "accept", "", false},
+		{"DISMISS", "dismiss", "", false},
+		{"respond:hello", "accept", "hello", false},
+		{"Respond:Mixed Case", "accept", "Mixed Case", false},
+		{"respond:", "accept", "", false},
+		{"ignore", "", "", true},
+	}
+	for _, c := range cases {
+		action, text, err := parseDialogPolicy(c.in)
+		if (err != nil) != c.err {
+			t.Errorf("parseDialogPolicy(%q) err = %v, want error %v", c.in, err, c.err)
+			continue
+		}
+		if action != c.action || text != c.text {
+			t.Errorf("parseDialogPolicy(%q) = %q, %q; want %q, %q", c.in, action, text, c.action, c.text)
+		}
+	}
+}
+
+func TestUniquePath(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "report.csv")
+	if got := uniquePath(p, nil); got != p {
+		t.Fatalf("free path = %q, want %q", got, p)
+	}
+	for _, name := range []string{"report.csv", "report-1.csv"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := uniquePath(p, nil), filepath.Join(dir, "report-2.csv"); got != want {
+		t.Fatalf("taken path = %q, want %q", got, want)
+	}
+}
+
+func TestDownloadReserve(t *testing.T) {
+	c := newDownloadCollector(t.TempDir(), &ndjsonLogger{w: bufio.NewWriter(io.Discard)})
+	p := filepath.Join(c.dir, "report.csv")
+	got := map[string]bool{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dest := c.reserve(p)
+			mu.Lock()
+			got[dest] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if len(got) != 4 || !got[p] || !got[filepath.Join(c.dir, "report-3.csv")] {
+		t.Fatalf("reserved %v, want four distinct paths", got)
+	}
+}
+
+func TestMatchName(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"report.csv", "report.csv", true},
+		{"*.csv", "report.csv", true},
+		{"*.csv", "report.json", false},
+		{"report-?.csv", "report-1.csv", true},
+		{"[", "[", true},
+		{"[", "x", false},
+	}
+	for _, c := range cases {
+		if got := matchName(c.pattern, c.name); got != c.want {
+			t.Errorf("matchName(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestDialogAwait(t *testing.T) {
+	h := newDialogHandler("", &ndjsonLogger{w: bufio.NewWriter(io.Discard)})
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		h.mu.Lock()
+		h.records = append(h.records,
+			DialogRecord{Type: "alert", Message: "Saved"},
+			DialogRecord{Type: "confirm", Message: "Are you sure?"})
+		h.mu.Unlock()
+	}()
+	d := h.await("CONFIRM", "you sure", 2*time.Second)
+	if d == nil || d.Type != "confirm" {
+		t.Fatalf("await confirm = %+v", d)
+	}
+	if d := h.await("alert", "you sure", 200*time.Millisecond); d != nil {
+		t.Fatalf("type filter ignored: %+v", d)
+	}
+	if d := h.await("", "Saved", 0); d == nil || d.Type != "alert" {
+		t.Fatalf("await any type = %+v", d)
+	}
+}
+
+func TestDownloadAwait(t *testing.T) {
+	c := newDownloadCollector(t.TempDir(), &ndjsonLogger{w: bufio.NewWriter(io.Discard)})
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		c.mu.Lock()
+		c.records = append(c.records, DownloadRecord{Name: "export.csv", Size: 3})
+		c.mu.Unlock()
+	}()
+	if d := c.await("*.csv", 2*time.Second); d == nil || d.Name != "export.csv" {
+		t.Fatalf("await *.csv = %+v", d)
+	}
+	if d := c.await("*.json", 200*time.Millisecond); d != nil {
+		t.Fatalf("await *.json = %+v", d)
+	}
+}
@@ -0,0 +1,363 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// maxDOMNodes bounds a DOM snapshot; larger documents are truncated.
+const maxDOMNodes = 20000
+
+// maxDOMChanges bounds the changes listed in a DOMDiff; counts stay exact.
+const maxDOMChanges = 500
+
+// DOMNode is one element of a DOM snapshot. Text is the element's own text
+// nodes, whitespace-collapsed and truncated.
+type DOMNode struct {
+	Tag       string            `json:"t"`
+	Attrs     map[string]string `json:"a,omitempty"`
+	Text      string            `json:"x,omitempty"`
+	Children  []*DOMNode        `json:"c,omitempty"`
+	Truncated bool              `json:"truncated,omitempty"` // children past maxDOMNodes were dropped
+}
+
+// Element tree of the document, or of the first element matching selector,
+// skipping scripts, templates and elements matching ignore.
+const domSnapshotJS = `({maxNodes, selector, ignore}) => {
+  let count = 0;
+  const skip = new Set(['SCRIPT', 'NOSCRIPT', 'TEMPLATE']);
+  const ignored = el => ignore.some(sel => { try { return el.matches(sel); } catch { return false; } });
+  const walk = el => {
+    count++;
+    const n = {t: el.tagName.toLowerCase()};
+    if (el.attributes.length) {
+      n.a = {};
+      for (const a of el.attributes) n.a[a.name] = a.value.slice(0, 500);
+    }
+    let text = '';
+    for (const c of el.childNodes) if (c.nodeType === 3) text += c.nodeValue;
+    text = text.replace(/\s+/g, ' ').trim();
+    if (text) n.x = text.slice(0, 200);
+    const kids = [];
+    for (const c of el.children) {
+      if (skip.has(c.tagName) || ignored(c)) continue;
+      if (count >= maxNodes) { n.truncated = true; break; }
+      kids.push(walk(c));
+    }
+    if (kids.length) n.c = kids;
+    return n;
+  };
+  const root = selector ? document.querySelector(selector) : document.documentElement;
+  return root ? walk(root) : null;
+}`
+
+// snapshotDOM captures the page's element tree, or the subtree of the first
+// element matching selector. Elements matching an ignore selector are left
+// out with their subtrees.
+func snapshotDOM(page playwright.Page, selector string, ignore []string) (*DOMNode, error) {
+	raw, err := page.Evaluate(domSnapshotJS, map[string]any{"maxNodes": maxDOMNodes, "selector": selector, "ignore": append([]string{}, ignore...)})
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("no element matches %q", selector)
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var root DOMNode
+	if err := json.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+	return &root, nil
+}
+
+// size counts the nodes in the subtree.
+func (n *DOMNode) size() int {
+	s := 1
+	for _, c := range n.Children {
+		s += c.size()
+	}
+	return s
+}
+
+// label is a CSS-like step for paths: tag#id, or tag:nth-child(i).
+func (n *DOMNode) label(index int) string {
+	if id := n.Attrs["id"]; id != "" {
+		return n.Tag + "#" + id
+	}
+	return fmt.Sprintf("%s:nth-child(%d)", n.Tag, index+1)
+}
+
+// signature is what children are first aligned on.
+func (n *DOMNode) signature() string {
+	return n.Tag + "#" + n.Attrs["id"] + "." + n.Attrs["class"]
+}
+
+// DOM change kinds.
+const (
+	domAdded   = "added"
+	domRemoved = "removed"
+	domMoved   = "moved"
+	domChanged = "changed"
+)
+
+// DOMChange is one difference between two snapshots.
+type DOMChange struct {
+	Kind       string       `json:"kind"`            // added, removed, moved or changed
+	Path       string       `json:"path"`            // in the newer tree, or the older one for removals
+	From       string       `json:"from,omitempty"`  // path in the older tree of a moved node
+	Nodes      int          `json:"nodes,omitempty"` // subtree size of an added, removed or moved node
+	Attrs      []AttrChange `json:"attrs,omitempty"`
+	TextBefore string       `json:"text_before,omitempty"`
+	TextAfter  string       `json:"text_after,omitempty"`
+}
+
+// AttrChange is an attribute added (empty Before), removed (empty After) or
+// modified.
+type AttrChange struct {
+	Name   string `json:"name"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// DOMDiff summarises the structural differences between two snapshots.
+// Added, Removed and Moved count nodes, subtrees included; Changed counts
+// matched nodes whose attributes or text differ. A subtree removed in one
+// place and added unchanged in another counts as moved.
+type DOMDiff struct {
+	Added     int         `json:"added"`
+	Removed   int         `json:"removed"`
+	Moved     int         `json:"moved,omitempty"`
+	Changed   int         `json:"changed"`
+	Changes   []DOMChange `json:"changes,omitempty"`
+	Truncated bool        `json:"truncated,omitempty"` // more than maxDOMChanges changes
+}
+
+// Identical reports whether the snapshots had no differences.
+func (d DOMDiff) Identical() bool {
+	return d.Added == 0 && d.Removed == 0 && d.Moved == 0 && d.Changed == 0
+}
+
+// diffDOM compares two snapshots. Children of matched nodes are aligned by
+// tag, id and class first; the rest are then paired by tag in order, so a
+// node whose class changed shows as changed rather than removed and added.
+func diffDOM(before, after *DOMNode) DOMDiff {
+	var w domWalker
+	if before == nil || after == nil {
+		return DOMDiff{}
+	}
+	if before.Tag != after.Tag {
+		w.add(DOMChange{Kind: domRemoved, Path: before.Tag, Nodes: before.size()}, before)
+		w.add(DOMChange{Kind: domAdded, Path: after.Tag, Nodes: after.size()}, after)
+	} else {
+		w.walk(before, after, before.Tag)
+	}
+	return w.result()
+}
+
+// domWalker collects changes in document order; moves are only known once
+// both trees have been walked.
+type domWalker struct {
+	changes []DOMChange
+	nodes   []*DOMNode // the added or removed subtree of changes[i]
+}
+
+func (w *domWalker) add(c DOMChange, n *DOMNode) {
+	w.changes = append(w.changes, c)
+	w.nodes = append(w.nodes, n)
+}
+
+// result pairs removed subtrees with identical added ones as moves, in order,
+// and tallies the rest.
+func (w *domWalker) result() DOMDiff {
+	removed := map[string][]int{}
+	for i, c := range w.changes {
+		if c.Kind == domRemoved {
+			fp := fingerprint(w.nodes[i])
+			removed[fp] = append(removed[fp], i)
+		}
+	}
+	drop := map[int]bool{}
+	if len(removed) > 0 {
+		for i, c := range w.changes {
+			if c.Kind != domAdded {
+				continue
+			}
+			fp := fingerprint(w.nodes[i])
+			if from := removed[fp]; len(from) > 0 {
+				removed[fp] = from[1:]
+				drop[from[0]] = true
+				w.changes[i] = DOMChange{Kind: domMoved, Path: c.Path, From: w.changes[from[0]].Path, Nodes: c.Nodes}
+			}
+		}
+	}
+	var d DOMDiff
+	for i, c := range w.changes {
+		if !drop[i] {
+			d.record(c)
+		}
+	}
+	return d
+}
+
+// fingerprint identifies a subtree by its content; encoding/json sorts the
+// attribute keys.
+func fingerprint(n *DOMNode) string {
+	b, _ := json.Marshal(n)
+	return string(b)
+}
+
+func (d *DOMDiff) record(c DOMChange) {
+	switch c.Kind {
+	case domAdded:
+		d.Added += c.Nodes
+	case domRemoved:
+		d.Removed += c.Nodes
+	case domMoved:
+		d.Moved += c.Nodes
+	default:
+		d.Changed++
+	}
+	if len(d.Changes) >= maxDOMChanges {
+		d.Truncated = true
+		return
+	}
+	d.Changes = append(d.Changes, c)
+}
+
+func (w *domWalker) walk(a, b *DOMNode, path string) {
+	attrs := diffAttrs(a.Attrs, b.Attrs)
+	if len(attrs) > 0 || a.Text != b.Text {
+		c := DOMChange{Kind: domChanged, Path: path, Attrs: attrs}
+		if a.Text != b.Text {
+			c.TextBefore, c.TextAfter = a.Text, b.Text
+		}
+		w.add(c, nil)
+	}
+	for _, p := range alignChildren(a.Children, b.Children) {
+		switch {
+		case p.a < 0:
+			n := b.Children[p.b]
+			w.add(DOMChange{Kind: domAdded, Path: path + " > " + n.label(p.b), Nodes: n.size()}, n)
+		case p.b < 0:
+			n := a.Children[p.a]
+			w.add(DOMChange{Kind: domRemoved, Path: path + " > " + n.label(p.a), Nodes: n.size()}, n)
+		default:
+			w.walk(a.Children[p.a], b.Children[p.b], path+" > "+b.Children[p.b].label(p.b))
+		}
+	}
+}
+
+func diffAttrs(a, b map[string]string) []AttrChange {
+	var out []AttrChange
+	for k, v := range a {
+		if w, ok := b[k]; !ok {
+			out = append(out, AttrChange{Name: k, Before: v})
+		} else if w != v {
+			out = append(out, AttrChange{Name: k, Before: v, After: w})
+		}
+	}
+	for k, w := range b {
+		if _, ok := a[k]; !ok {
+			out = append(out, AttrChange{Name: k, After: w})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// childPair is an aligned pair of child indexes; -1 marks a side with no
+// counterpart.
+type childPair struct{ a, b int }
+
+// maxLCSCells caps the alignment table; wider parents are paired by tag only.
+const maxLCSCells = 4_000_000
+
+// alignChildren aligns two child lists in order: an LCS over signatures
+// gives anchors, and the unmatched runs between anchors are paired by tag.
+func alignChildren(a, b []*DOMNode) []childPair {
+	var anchors []childPair
+	if len(a)*len(b) <= maxLCSCells {
+		anchors = lcsPairs(a, b)
+	}
+	var out []childPair
+	i, j := 0, 0
+	for _, anc := range append(anchors, childPair{len(a), len(b)}) {
+		out = append(out, pairByTag(a, b, i, anc.a, j, anc.b)...)
+		if anc.a < len(a) {
+			out = append(out, anc)
+		}
+		i, j = anc.a+1, anc.b+1
+	}
+	return out
+}
+
+func lcsPairs(a, b []*DOMNode) []childPair {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return nil
+	}
+	// table[i][j] is the LCS length of a[i:] and b[j:].
+	table := make([][]int32, n+1)
+	for i := range table {
+		table[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i].signature() == b[j].signature() {
+				table[i][j] = table[i+1][j+1] + 1
+			} else {
+				table[i][j] = max(table[i+1][j], table[i][j+1])
+			}
+		}
+	}
+	var out []childPair
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case a[i].signature() == b[j].signature():
+			out = append(out, childPair{i, j})
+			i, j = i+1, j+1
+		case table[i+1][j] >= table[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return out
+}
+
+// pairByTag aligns a[i0:i1] with b[j0:j1]: same-tag nodes are paired in
+// order, the rest are removals and additions.
+func pairByTag(a, b []*DOMNode, i0, i1, j0, j1 int) []childPair {
+	var out []childPair
+	j := j0
+	for i := i0; i < i1; i++ {
+		k := j
+		for k < j1 && b[k].Tag != a[i].Tag {
+			k++
+		}
+		if k == j1 {
+			out = append(out, childPair{i, -1})
+			continue
+		}
+		for ; j < k; j++ {
+			out = append(out, childPair{-1, j})
+		}
+		out = append(out, childPair{i, k})
+		j = k + 1
+	}
+	for ; j < j1; j++ {
+		out = append(out, childPair{-1, j})
+	}
+	return out
+}
+
+// summary is a one-line description for logs.
+func (d DOMDiff) summary() string {
+	return fmt.Sprintf("+%d -%d ~%d nodes, %d moved", d.Added, d.Removed, d.Changed, d.Moved)
+}
@@ -0,0 +1,70 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func el(tag string, attrs map[string]string, text string, children ...*DOMNode) *DOMNode {
+	return &DOMNode{Tag: tag, Attrs: attrs, Text: text, Children: children}
+}
+
+func TestDiffDOMInsertionDoesNotShiftSiblings(t *testing.T) {
+	item := func(text string) *DOMNode { return el("li", nil, text) }
+	before := el("html", nil, "", el("body", nil, "", el("ul", nil, "", item("a"), item("b"), item("c"))))
+	after := el("html", nil, "", el("body", map[string]string{"class": "dark"}, "",
+		el("ul", nil, "", item("a"), el("li", map[string]string{"class": "injected"}, "new", el("span", nil, "x")), item("b"), item("c")),
+		el("div", map[string]string{"id": "toggle"}, "Toggle"),
+	))
+	d := diffDOM(before, after)
+	if d.Added != 3 || d.Removed != 0 || d.Changed != 1 {
+		t.Fatalf("diff = %s: %+v", d.summary(), d.Changes)
+	}
+	want := []DOMChange{
+		{Kind: domChanged, Path: "html > body:nth-child(1)", Attrs: []AttrChange{{Name: "class", After: "dark"}}},
+		{Kind: domAdded, Path: "html > body:nth-child(1) > ul:nth-child(1) > li:nth-child(2)", Nodes: 2},
+		{Kind: domAdded, Path: "html > body:nth-child(1) > div#toggle", Nodes: 1},
+	}
+	if !reflect.DeepEqual(d.Changes, want) {
+		t.Fatalf("changes = %+v", d.Changes)
+	}
+}
+
+func TestDiffDOMChangedClassAndRemoval(t *testing.T) {
+	before := el("html", nil, "", el("div", map[string]string{"class": "card"}, "Hello"), el("aside", map[string]string{"id": "ad"}, ""))
+	after := el("html", nil, "", el("div", map[string]string{"class": "card dark"}, "Hello!"))
+	d := diffDOM(before, after)
+	if d.Added != 0 || d.Removed != 1 || d.Changed != 1 {
+		t.Fatalf("diff = %s: %+v", d.summary(), d.Changes)
+	}
+	c := d.Changes[0]
+	if c.Kind != domChanged || c.TextBefore != "Hello" || c.TextAfter != "Hello!" || len(c.Attrs) != 1 {
+		t.Fatalf("change = %+v", c)
+	}
+	if d.Changes[1].Path != "html > aside#ad" {
+		t.Fatalf("removal path = %s", d.Changes[1].Path)
+	}
+	if !diffDOM(before, before).Identical() {
+		t.Fatal("snapshot differs from itself")
+	}
+}
+
+func TestDiffDOMMoved(t *testing.T) {
+	nav := func() *DOMNode { return el("nav", map[string]string{"id": "menu"}, "", el("a", nil, "Home")) }
+	before := el("html", nil, "", el("header", nil, "", nav()), el("main", nil, "Body"))
+	after := el("html", nil, "", el("header", nil, ""), el("main", nil, "Body", nav()))
+	d := diffDOM(before, after)
+	if d.Added != 0 || d.Removed != 0 || d.Moved != 2 || len(d.Changes) != 1 {
+		t.Fatalf("diff = %s: %+v", d.summary(), d.Changes)
+	}
+	want := DOMChange{Kind: domMoved, Path: "html > main:nth-child(2) > nav#menu", From: "html > header:nth-child(1) > nav#menu", Nodes: 2}
+	if !reflect.DeepEqual(d.Changes[0], want) {
+		t.Fatalf("change = %+v", d.Changes[0])
+	}
+
+	// A node that moved and changed is a removal and an addition.
+	after.Children[1].Children[0].Children[0].Text = "Start"
+	if d := diffDOM(before, after); d.Moved != 0 || d.Added != 2 || d.Removed != 2 {
+		t.Fatalf("diff = %s", d.summary())
+	}
+}
@@ -0,0 +1,263 @@
+package runner
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// EgressHost aggregates the userscript's traffic to one host.
+type EgressHost struct {
+	Host      string   `json:"host"`
+	Requests  int      `json:"requests"`
+	BytesOut  int64    `json:"bytes_out"`
+	Declared  bool     `json:"declared"`   // covered by an @connect entry
+	OffOrigin bool     `json:"off_origin"` // not the target host or one of its subdomains
+	Types     []string `json:"types"`      // resource types, plus gm_xmlhttpRequest
+}
+
+// EgressFlag marks a userscript request that appears to carry page secrets off-origin.
+type EgressFlag struct {
+	EntryID int    `json:"entry_id"`
+	Method  string `json:"method"`
+	URL     string `json:"url"`
+	Host    string `json:"host"`
+	Kind    string `json:"kind"` // cookie or form
+	Reason  string `json:"reason"`
+}
+
+// ScriptEgressReport separates traffic the userscript caused from the page's own.
+type ScriptEgressReport struct {
+	Requests   int          `json:"requests"`
+	BytesOut   int64        `json:"bytes_out"`
+	GMRequests int          `json:"gm_requests"`
+	Hosts      []EgressHost `json:"hosts,omitempty"`
+	Undeclared []string     `json:"undeclared_hosts,omitempty"` // off-origin hosts missing from @connect
+	Flags      []EgressFlag `json:"flags,omitempty"`
+}
+
+// gmRequest is a GM_xmlhttpRequest call logged by the shim.
+type gmRequest struct {
+	Method string
+	URL    string
+	Bytes  int
+}
+
+// pageSecrets are values that should not leave the target origin. They are
+// held in memory only and never written to artifacts.
+type pageSecrets struct {
+	cookies []string
+	fields  []string
+}
+
+// minSecretLen keeps short values ("1", "on", "en") from matching everything.
+const minSecretLen = 6
+
+var (
+	// Key names are matched whole, with explicit suffixes, so sidebar=,
+	// author= or tokenizer= do not look like credentials.
+	cookieKeyRe = regexp.MustCompile(`(?i)(^|[?&;\s"'{,])(cookies?|(php|j)?sess(ion)?(_?(id|token))?|sid|auth(_?token|orization)?|((access|refresh|id|api|csrf|xsrf)_?)?token|jwt)["']?\s*[=:]`)
+	formKeyRe   = regexp.MustCompile(`(?i)(^|[?&"'{,\s])(pass(word|wd)?|e-?mail(_?address)?|user(name)?|login|card_?(number|num|no)|cc_?num(ber)?|cvv|cvc|ssn|phone(_?number)?|(street|billing|shipping|home|mailing)_?address|address_?line_?[12]?)["']?\s*[=:]`)
+	cookieJarRe = regexp.MustCompile(`[\w.-]+=[^;&\s]{4,};\s*[\w.-]+=[^;&\s]+`)
+)
+
+// secretsJS reads cookie values and editable field values from the page.
+const secretsJS = `() => {
+  const cookies = document.cookie.split(';').map(c => c.slice(c.indexOf('=') + 1).trim());
+  const fields = Array.from(document.querySelectorAll('input, textarea, select'))
+    .filter(el => !['button', 'submit', 'reset', 'checkbox', 'radio', 'file', 'image'].includes((el.type || '').toLowerCase()))
+    .map(el => el.value || '');
+  return { cookies, fields };
+}`
+
+// listGMRequests reads the shim's GM_xmlhttpRequest log from the page.
+func listGMRequests(page playwright.Page) ([]gmRequest, error) {
+	raw, err := page.Evaluate(`() => window.__labGM ? window.__labGM.requests : []`)
+	if err != nil {
+		return nil, err
+	}
+	items, _ := raw.([]any)
+	var out []gmRequest
+	for _, it := range items {
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		r := gmRequest{}
+		r.Method, _ = m["method"].(string)
+		r.URL, _ = m["url"].(string)
+		switch b := m["bytes"].(type) {
+		case int:
+			r.Bytes = b
+		case float64:
+			r.Bytes = int(b)
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// collectPageSecrets gathers cookie and form field values from the page and
+// the context's cookie jar for the target URL.
+func collectPageSecrets(ctx playwright.BrowserContext, page playwright.Page, targetURL string) pageSecrets {
+	var s pageSecrets
+	if raw, err := page.Evaluate(secretsJS); err == nil {
+		m, _ := raw.(map[string]any)
+		s.cookies = stringList(m["cookies"])
+		s.fields = stringList(m["fields"])
+	}
+	if cookies, err := ctx.Cookies(targetURL); err == nil {
+		for _, c := range cookies {
+			s.cookies = append(s.cookies, c.Value)
+		}
+	}
+	return s
+}
+
+func stringList(v any) []string {
+	items, _ := v.([]any)
+	var out []string
+	for _, it := range items {
+		if s, ok := it.(string); ok && len(s) >= minSecretLen {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// connectDeclares reports whether @connect covers host. "self" is the target
+// host and "*" allows everything, as in Tampermonkey.
+func connectDeclares(connect []string, host, targetHost string) bool {
+	for _, c := range connect {
+		switch c = strings.TrimSpace(c); c {
+		case "*":
+			return true
+		case "self":
+			if targetHost != "" && host == targetHost {
+				return true
+			}
+		default:
+			if hostMatches(host, c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// analyzeScriptEgress builds the egress report from entries attributed to the
+// userscript. gm URLs must already be redacted the same way as entry URLs.
+func analyzeScriptEgress(entries []NetworkEntry, gm []gmRequest, connect []string, targetHost string, secrets pageSecrets) *ScriptEgressReport {
+	rep := &ScriptEgressReport{GMRequests: len(gm)}
+	gmCalls := map[string]int{}
+	for _, r := range gm {
+		gmCalls[r.Method+" "+r.URL]++
+	}
+	hosts := map[string]*EgressHost{}
+	types := map[string]map[string]bool{}
+	for _, e := range entries {
+		key := e.Method + " " + e.URL
+		viaGM := gmCalls[key] > 0
+		if viaGM {
+			gmCalls[key]--
+		}
+		if e.Initiator != initiatorUserscript && !viaGM {
+			continue
+		}
+		host := entryHost(e.URL)
+		if host == "" {
+			continue
+		}
+		out := int64(e.Sizes.RequestBody)
+		if out == 0 {
+			out = int64(len(e.rawBody))
+		}
+		rep.Requests++
+		rep.BytesOut += out
+		h, ok := hosts[host]
+		if !ok {
+			h = &EgressHost{
+				Host:      host,
+				Declared:  connectDeclares(connect, host, targetHost),
+				OffOrigin: targetHost == "" || !hostMatches(host, targetHost),
+			}
+			hosts[host] = h
+			types[host] = map[string]bool{}
+		}
+		h.Requests++
+		h.BytesOut += out
+		types[host][e.ResourceType] = true
+		if viaGM {
+			types[host]["gm_xmlhttpRequest"] = true
+		}
+		if h.OffOrigin {
+			rep.Flags = append(rep.Flags, egressFlags(e, host, secrets)...)
+		}
+	}
+	for host, h := range hosts {
+		for t := range types[host] {
+			h.Types = append(h.Types, t)
+		}
+		sort.Strings(h.Types)
+		rep.Hosts = append(rep.Hosts, *h)
+		if h.OffOrigin && !h.Declared {
+			rep.Undeclared = append(rep.Undeclared, host)
+		}
+	}
+	sort.Slice(rep.Hosts, func(i, j int) bool {
+		if rep.Hosts[i].Requests != rep.Hosts[j].Requests {
+			return rep.Hosts[i].Requests > rep.Hosts[j].Requests
+		}
+		return rep.Hosts[i].Host < rep.Hosts[j].Host
+	})
+	sort.Strings(rep.Undeclared)
+	return rep
+}
+
+// egressFlags inspects the unredacted query string and body of an off-origin request.
+func egressFlags(e NetworkEntry, host string, secrets pageSecrets) []EgressFlag {
+	payload := e.rawBody
+	if u, err := url.Parse(e.rawURL); err == nil {
+		payload = u.RawQuery + "\n" + payload
+		if q, err := url.QueryUnescape(u.RawQuery); err == nil {
+			payload += "\n" + q
+		}
+	}
+	if dec, err := url.QueryUnescape(e.rawBody); err == nil {
+		payload += "\n" + dec
+	}
+	flag := func(kind, reason string) EgressFlag {
+		return EgressFlag{EntryID: e.ID, Method: e.Method, URL: e.URL, Host: host, Kind: kind, Reason: reason}
+	}
+	var flags []EgressFlag
+	switch {
+	case containsAny(payload, secrets.cookies):
+		flags = append(flags, flag("cookie", "payload contains a cookie value from the target"))
+	case cookieJarRe.MatchString(payload):
+		flags = append(flags, flag("cookie", "payload looks like a cookie header"))
+	case cookieKeyRe.MatchString(payload):
+		flags = append(flags, flag("cookie", "payload has session/token-like keys"))
+	}
+	ct := strings.ToLower(e.RequestHeaders["content-type"])
+	switch {
+	case containsAny(payload, secrets.fields):
+		flags = append(flags, flag("form", "payload contains a form field value from the page"))
+	case formKeyRe.MatchString(payload):
+		flags = append(flags, flag("form", "payload has credential/personal-data keys"))
+	case e.rawBody != "" && (strings.Contains(ct, "application/x-www-form-urlencoded") || strings.Contains(ct, "multipart/form-data")):
+		flags = append(flags, flag("form", "form-encoded body sent off-origin"))
+	}
+	return flags
+}
+
+func containsAny(s string, needles []string) bool {
+	for _, n := range needles {
+		if len(n) >= minSecretLen && strings.Contains(s, n) {
+			return true
+		}
+	}
+	return false
+}
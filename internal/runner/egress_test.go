@@ -0,0 +1,82 @@
+package runner
+
+import "testing"
+
+func TestAnalyzeScriptEgress(t *testing.T) {
+	entries := []NetworkEntry{
+		{ID: 1, Method: "GET", URL: "https://example.com/app.js", ResourceType: "script", Initiator: initiatorPage},
+		{ID: 2, Method: "GET", URL: "https://example.com/api/me", ResourceType: "fetch", Initiator: initiatorUserscript},
+		{ID: 3, Method: "POST", URL: "https://collect.evil.test/c", ResourceType: "fetch", Initiator: initiatorUnknown,
+			rawURL: "https://collect.evil.test/c", rawBody: "d=abc123session", Sizes: NetworkSizes{RequestBody: 15}},
+		{ID: 4, Method: "GET", URL: "https://px.evil.test/p.gif?x=1", ResourceType: "image", Initiator: initiatorUserscript,
+			rawURL: "https://px.evil.test/p.gif?x=1&u=jane%40example.com"},
+		{ID: 5, Method: "GET", URL: "https://api.allowed.test/v1", ResourceType: "fetch", Initiator: initiatorUserscript,
+			rawURL: "https://api.allowed.test/v1"},
+	}
+	gm := []gmRequest{{Method: "POST", URL: "https://collect.evil.test/c", Bytes: 15}}
+	secrets := pageSecrets{cookies: []string{"abc123session"}, fields: []string{"jane@example.com"}}
+
+	rep := analyzeScriptEgress(entries, gm, []string{"self", "allowed.test"}, "example.com", secrets)
+	if rep.Requests != 4 || rep.GMRequests != 1 || rep.BytesOut != 15 {
+		t.Fatalf("report totals = %+v", rep)
+	}
+	byHost := map[string]EgressHost{}
+	for _, h := range rep.Hosts {
+		byHost[h.Host] = h
+	}
+	if h := byHost["example.com"]; !h.Declared || h.OffOrigin {
+		t.Errorf("target host = %+v", h)
+	}
+	if h := byHost["collect.evil.test"]; h.Declared || !h.OffOrigin || len(h.Types) != 2 {
+		t.Errorf("gm host = %+v", h)
+	}
+	if !byHost["api.allowed.test"].Declared {
+		t.Error("@connect allowed.test should cover api.allowed.test")
+	}
+	if len(rep.Undeclared) != 2 || rep.Undeclared[0] != "collect.evil.test" || rep.Undeclared[1] != "px.evil.test" {
+		t.Errorf("undeclared = %v", rep.Undeclared)
+	}
+	kinds := map[int]string{}
+	for _, f := range rep.Flags {
+		kinds[f.EntryID] += f.Kind + " "
+	}
+	if kinds[3] != "cookie " || kinds[4] != "form " || kinds[5] != "" {
+		t.Errorf("flags = %+v", rep.Flags)
+	}
+}
+
+func TestEgressFlagHeuristics(t *testing.T) {
+	for _, tc := range []struct {
+		body, ct, want string
+	}{
+		{body: `{"password":"hunter2"}`, want: "form"},
+		{body: "a=b&c=d", ct: "application/x-www-form-urlencoded", want: "form"},
+		{body: "sid=0123abcd; theme=dark", want: "cookie"},
+		{body: `{"token":"x"}`, want: "cookie"},
+		{body: `{"width":1280}`, want: ""},
+		{body: "PHPSESSID=abc", want: "cookie"},
+		{body: "session_id=abc", want: "cookie"},
+		{body: `{"access_token":"x"}`, want: "cookie"},
+		{body: "Authorization: Bearer x", want: "cookie"},
+		{body: "card_number=4111", want: "form"},
+		{body: `{"billing_address":"1 Main St"}`, want: "form"},
+		{body: "sidebar=collapsed", want: ""},
+		{body: "author=ann", want: ""},
+		{body: `{"tokenizer":"bpe"}`, want: ""},
+		{body: "authenticated=1", want: ""},
+		{body: "address=0x52908400098527886E0F7030069857D2E4169EE7", want: ""},
+		{body: "ip_address=10.0.0.1", want: ""},
+		{body: "card=summary_large_image", want: ""},
+		{body: "passage=3", want: ""},
+	} {
+		e := NetworkEntry{ID: 1, Method: "POST", URL: "https://x.test/", rawURL: "https://x.test/", rawBody: tc.body,
+			RequestHeaders: map[string]string{"content-type": tc.ct}}
+		got := ""
+		for _, f := range egressFlags(e, "x.test", pageSecrets{}) {
+			got += f.Kind
+		}
+		if got != tc.want {
+			t.Errorf("body %q: flags %q, want %q", tc.body, got, tc.want)
+		}
+	}
+}
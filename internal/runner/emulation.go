@@ -0,0 +1,160 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// EmulationProfile describes throttled network and CPU conditions. Throughput
+// is in bytes per second; zero means unthrottled.
+type EmulationProfile struct {
+	LatencyMS        float64 `json:"latency_ms,omitempty"`
+	DownloadBps      float64 `json:"download_bps,omitempty"`
+	UploadBps        float64 `json:"upload_bps,omitempty"`
+	OfflineAfterLoad bool    `json:"offline_after_load,omitempty"` // offline once the first navigation settles
+	CPURate          float64 `json:"cpu_rate,omitempty"`           // slowdown factor; 1 is none
+}
+
+// emulationProfiles are the named profiles. The network presets match the
+// Chrome DevTools ones.
+var emulationProfiles = map[string]EmulationProfile{
+	"slow-3g":            {LatencyMS: 2000, DownloadBps: 500 * 1000 / 8 * 0.8, UploadBps: 500 * 1000 / 8 * 0.8},
+	"fast-3g":            {LatencyMS: 562.5, DownloadBps: 1.6 * 1000 * 1000 / 8 * 0.9, UploadBps: 750 * 1000 / 8 * 0.9},
+	"offline-after-load": {OfflineAfterLoad: true},
+	"2x-cpu":             {CPURate: 2},
+	"4x-cpu":             {CPURate: 4},
+	"6x-cpu":             {CPURate: 6},
+}
+
+// EmulationProfileNames lists the named profiles, sorted.
+func EmulationProfileNames() []string {
+	names := make([]string, 0, len(emulationProfiles))
+	for name := range emulationProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// EmulationReport records the conditions a run used.
+type EmulationReport struct {
+	Profiles  []string         `json:"profiles"`
+	Applied   EmulationProfile `json:"applied"`
+	OfflineAt *time.Time       `json:"offline_at,omitempty"` // when offline-after-load took effect
+	Errors    []string         `json:"errors,omitempty"`
+}
+
+// parseEmulation merges comma-separated profile names, e.g. "slow-3g,4x-cpu".
+// Later network profiles override earlier ones; the highest CPU rate wins.
+func parseEmulation(spec string) ([]string, EmulationProfile, error) {
+	var names []string
+	var merged EmulationProfile
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		p, ok := emulationProfiles[name]
+		if !ok {
+			return nil, merged, fmt.Errorf("unknown emulation profile %q (want %s)", name, strings.Join(EmulationProfileNames(), ", "))
+		}
+		names = append(names, name)
+		if p.LatencyMS > 0 || p.DownloadBps > 0 || p.UploadBps > 0 {
+			merged.LatencyMS, merged.DownloadBps, merged.UploadBps = p.LatencyMS, p.DownloadBps, p.UploadBps
+		}
+		merged.OfflineAfterLoad = merged.OfflineAfterLoad || p.OfflineAfterLoad
+		if p.CPURate > merged.CPURate {
+			merged.CPURate = p.CPURate
+		}
+	}
+	return names, merged, nil
+}
+
+// emulator applies a profile to one page through CDP. Service workers and
+// extension pages run in other targets and are not throttled.
+type emulator struct {
+	session playwright.CDPSession
+	report  *EmulationReport
+	logger  *ndjsonLogger
+}
+
+func newEmulator(ctx playwright.BrowserContext, page playwright.Page, names []string, p EmulationProfile, logger *ndjsonLogger) (*emulator, error) {
+	session, err := ctx.NewCDPSession(page)
+	if err != nil {
+		return nil, err
+	}
+	return &emulator{session: session, report: &EmulationReport{Profiles: names, Applied: p}, logger: logger}, nil
+}
+
+// apply sets the conditions that hold from the first navigation on.
+func (e *emulator) apply() {
+	p := e.report.Applied
+	if p.LatencyMS > 0 || p.DownloadBps > 0 || p.UploadBps > 0 {
+		e.network(false)
+	}
+	if p.CPURate > 1 {
+		if _, err := e.session.Send("Emulation.setCPUThrottlingRate", map[string]any{"rate": p.CPURate}); err != nil {
+			e.fail("cpu throttling", err)
+		}
+	}
+	e.logger.info("emulation", "profile applied", map[string]any{"profiles": e.report.Profiles, "applied": p})
+}
+
+// afterLoad takes the page offline for offline-after-load.
+func (e *emulator) afterLoad() {
+	if !e.report.Applied.OfflineAfterLoad {
+		return
+	}
+	if e.network(true) {
+		now := time.Now()
+		e.report.OfflineAt = &now
+		e.logger.info("emulation", "network offline after load", nil)
+	}
+}
+
+func (e *emulator) network(offline bool) bool {
+	p := e.report.Applied
+	if _, err := e.session.Send("Network.enable", map[string]any{}); err != nil {
+		e.fail("network enable", err)
+		return false
+	}
+	download, upload := p.DownloadBps, p.UploadBps
+	if download == 0 {
+		download = -1 // CDP: disabled
+	}
+	if upload == 0 {
+		upload = -1
+	}
+	if _, err := e.session.Send("Network.emulateNetworkConditions", map[string]any{
+		"offline":            offline,
+		"latency":            p.LatencyMS,
+		"downloadThroughput": download,
+		"uploadThroughput":   upload,
+	}); err != nil {
+		e.fail("network conditions", err)
+		return false
+	}
+	return true
+}
+
+func (e *emulator) fail(what string, err error) {
+	e.logger.warn("emulation", what+" failed", map[string]any{"error": err.Error()})
+	e.report.Errors = append(e.report.Errors, what+": "+err.Error())
+}
+
+// navigationTimeout scales the first navigation's timeout for slow profiles.
+func navigationTimeout(p EmulationProfile) float64 {
+	const base = 40_000
+	scale := 1.0
+	if p.LatencyMS > 0 || p.DownloadBps > 0 {
+		scale = 3
+	}
+	if p.CPURate > 1 {
+		scale *= 1 + p.CPURate/4
+	}
+	return base * scale
+}
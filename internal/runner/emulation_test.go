@@ -0,0 +1,34 @@
+package runner
+
+import "testing"
+
+func TestParseEmulation(t *testing.T) {
+	names, p, err := parseEmulation(" Slow-3G, 4x-cpu,offline-after-load,2x-cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 4 || names[0] != "slow-3g" {
+		t.Fatalf("names = %v", names)
+	}
+	if p.LatencyMS != 2000 || p.DownloadBps != 50_000 || !p.OfflineAfterLoad || p.CPURate != 4 {
+		t.Fatalf("merged = %+v", p)
+	}
+	if _, p, _ := parseEmulation("slow-3g,fast-3g"); p.LatencyMS != 562.5 {
+		t.Fatalf("later network profile should win: %+v", p)
+	}
+	if names, _, err := parseEmulation(""); err != nil || names != nil {
+		t.Fatalf("empty spec = %v, %v", names, err)
+	}
+	if _, _, err := parseEmulation("dial-up"); err == nil {
+		t.Fatal("want error for unknown profile")
+	}
+}
+
+func TestNavigationTimeout(t *testing.T) {
+	if got := navigationTimeout(EmulationProfile{}); got != 40_000 {
+		t.Fatalf("unthrottled = %v", got)
+	}
+	if got := navigationTimeout(emulationProfiles["slow-3g"]); got <= 40_000 {
+		t.Fatalf("slow-3g = %v", got)
+	}
+}
@@ -0,0 +1,143 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// Default viewport, also used for the video size.
+const defaultViewportWidth, defaultViewportHeight = 1280, 720
+
+// PageEnvironment is the viewport, device and preferences a run was given.
+type PageEnvironment struct {
+	Viewport    string `json:"viewport"`
+	Device      string `json:"device,omitempty"`
+	UserAgent   string `json:"user_agent,omitempty"`
+	Mobile      bool   `json:"mobile,omitempty"`
+	Touch       bool   `json:"touch,omitempty"`
+	ColorScheme string `json:"color_scheme,omitempty"`
+	Locale      string `json:"locale,omitempty"`
+}
+
+// parseViewport reads "WxH".
+func parseViewport(s string) (*playwright.Size, error) {
+	w, h, ok := strings.Cut(strings.ToLower(strings.TrimSpace(s)), "x")
+	width, err1 := strconv.Atoi(w)
+	height, err2 := strconv.Atoi(h)
+	if !ok || err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid viewport %q (want WIDTHxHEIGHT, e.g. 390x844)", s)
+	}
+	return &playwright.Size{Width: width, Height: height}, nil
+}
+
+// parseColorScheme validates a prefers-color-scheme value; empty leaves the
+// browser default.
+func parseColorScheme(s string) (*playwright.ColorScheme, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return nil, nil
+	case "light":
+		return playwright.ColorSchemeLight, nil
+	case "dark":
+		return playwright.ColorSchemeDark, nil
+	case "no-preference":
+		return playwright.ColorSchemeNoPreference, nil
+	}
+	return nil, fmt.Errorf("invalid color scheme %q (want light, dark or no-preference)", s)
+}
+
+// lookupDevice finds a Playwright device descriptor by name, ignoring case.
+func lookupDevice(devices map[string]*playwright.DeviceDescriptor, name string) (string, *playwright.DeviceDescriptor, error) {
+	if d, ok := devices[name]; ok {
+		return name, d, nil
+	}
+	for n, d := range devices {
+		if strings.EqualFold(n, name) {
+			return n, d, nil
+		}
+	}
+	var near []string
+	for n := range devices {
+		if strings.Contains(strings.ToLower(n), strings.ToLower(name)) && !strings.HasSuffix(n, "landscape") {
+			near = append(near, n)
+		}
+	}
+	sort.Strings(near)
+	if len(near) > 5 {
+		near = near[:5]
+	}
+	if len(near) > 0 {
+		return "", nil, fmt.Errorf("unknown device %q (did you mean %s?)", name, strings.Join(near, ", "))
+	}
+	return "", nil, fmt.Errorf("unknown device %q (use a Playwright device name such as \"iPhone 13\" or \"Pixel 7\")", name)
+}
+
+// applyEnvironment sets viewport, device, colour scheme and locale on the
+// context options. A viewport overrides the device's own. Video is recorded
+// at the viewport size.
+func applyEnvironment(ctxOpts *playwright.BrowserTypeLaunchPersistentContextOptions, opts Options, devices map[string]*playwright.DeviceDescriptor) (*PageEnvironment, *playwright.DeviceDescriptor, error) {
+	env := &PageEnvironment{Locale: opts.Locale}
+	viewport := &playwright.Size{Width: defaultViewportWidth, Height: defaultViewportHeight}
+	var device *playwright.DeviceDescriptor
+	if opts.Device != "" {
+		name, d, err := lookupDevice(devices, opts.Device)
+		if err != nil {
+			return nil, nil, err
+		}
+		device = d
+		env.Device, env.UserAgent, env.Mobile, env.Touch = name, d.UserAgent, d.IsMobile, d.HasTouch
+		ctxOpts.UserAgent = playwright.String(d.UserAgent)
+		ctxOpts.IsMobile = playwright.Bool(d.IsMobile)
+		ctxOpts.HasTouch = playwright.Bool(d.HasTouch)
+		ctxOpts.DeviceScaleFactor = playwright.Float(d.DeviceScaleFactor)
+		if d.Screen != nil {
+			ctxOpts.Screen = d.Screen
+		}
+		if d.Viewport != nil {
+			viewport = d.Viewport
+		}
+	}
+	if opts.Viewport != "" {
+		v, err := parseViewport(opts.Viewport)
+		if err != nil {
+			return nil, nil, err
+		}
+		viewport = v
+	}
+	ctxOpts.Viewport = viewport
+	if ctxOpts.RecordVideo != nil {
+		ctxOpts.RecordVideo.Size = &playwright.Size{Width: viewport.Width, Height: viewport.Height}
+	}
+	env.Viewport = fmt.Sprintf("%dx%d", viewport.Width, viewport.Height)
+	scheme, err := parseColorScheme(opts.ColorScheme)
+	if err != nil {
+		return nil, nil, err
+	}
+	if scheme != nil {
+		ctxOpts.ColorScheme = scheme
+		env.ColorScheme = string(*scheme)
+	}
+	if opts.Locale != "" {
+		ctxOpts.Locale = playwright.String(opts.Locale)
+	}
+	return env, device, nil
+}
+
+// variant distinguishes baselines whose page differs for reasons other than
+// the viewport: device, colour scheme and locale.
+func (e *PageEnvironment) variant() string {
+	if e == nil {
+		return ""
+	}
+	var parts []string
+	for _, p := range []string{e.Device, e.ColorScheme, e.Locale} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,54 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+func TestApplyEnvironment(t *testing.T) {
+	devices := map[string]*playwright.DeviceDescriptor{
+		"iPhone 13":           {UserAgent: "iPhone UA", Viewport: &playwright.Size{Width: 390, Height: 664}, DeviceScaleFactor: 3, IsMobile: true, HasTouch: true},
+		"iPhone 13 landscape": {UserAgent: "iPhone UA", Viewport: &playwright.Size{Width: 750, Height: 342}, DeviceScaleFactor: 3, IsMobile: true, HasTouch: true},
+	}
+	ctxOpts := playwright.BrowserTypeLaunchPersistentContextOptions{RecordVideo: &playwright.RecordVideo{Dir: "video"}}
+	env, device, err := applyEnvironment(&ctxOpts, Options{Device: "iphone 13", ColorScheme: "dark", Locale: "de-DE"}, devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device == nil || env.Device != "iPhone 13" || !env.Touch || env.Viewport != "390x664" || env.ColorScheme != "dark" {
+		t.Fatalf("env = %+v", env)
+	}
+	if *ctxOpts.UserAgent != "iPhone UA" || !*ctxOpts.HasTouch || *ctxOpts.DeviceScaleFactor != 3 || *ctxOpts.Locale != "de-DE" {
+		t.Fatal("device settings not applied to the context")
+	}
+	if ctxOpts.RecordVideo.Size.Width != 390 || *ctxOpts.ColorScheme != *playwright.ColorSchemeDark {
+		t.Fatalf("video %v, scheme %v", ctxOpts.RecordVideo.Size, *ctxOpts.ColorScheme)
+	}
+	if got := env.variant(); got != "iPhone 13/dark/de-DE" {
+		t.Fatalf("variant = %q", got)
+	}
+
+	ctxOpts = playwright.BrowserTypeLaunchPersistentContextOptions{}
+	env, _, err = applyEnvironment(&ctxOpts, Options{Device: "iPhone 13", Viewport: "400x900"}, devices)
+	if err != nil || env.Viewport != "400x900" || ctxOpts.Viewport.Height != 900 {
+		t.Fatalf("viewport override: %+v, %v", env, err)
+	}
+	if env, _, _ := applyEnvironment(&ctxOpts, Options{}, devices); env.Viewport != "1280x720" || env.variant() != "" {
+		t.Fatalf("defaults: %+v", env)
+	}
+
+	_, _, err = applyEnvironment(&ctxOpts, Options{Device: "iphone"}, devices)
+	if err == nil || !strings.Contains(err.Error(), `did you mean iPhone 13?`) {
+		t.Fatalf("unknown device error = %v", err)
+	}
+	if _, err := parseViewport("390X844"); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"390", "0x10", "axb"} {
+		if _, err := parseViewport(bad); err == nil {
+			t.Errorf("viewport %q accepted", bad)
+		}
+	}
+}
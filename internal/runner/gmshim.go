@@ -0,0 +1,229 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// gmShim is prepended to the userscript in init-script mode. It provides the
+// GM_* APIs scripts commonly grant and keeps a registry the runner can read
+// back through window.__labGM. GM_xmlhttpRequest is backed by fetch, so unlike
+// a real engine it is subject to CORS; every call is logged for the egress report.
+const gmShim = `(() => {
+  if (window.__labGM) return;
+  const menu = new Map();
+  let nextId = 1;
+  const requests = [];
+  window.__labGM = {
+    menu,
+    requests,
+    listMenu() {
+      return Array.from(menu.entries()).map(([id, c]) => ({ id, caption: c.caption, access_key: c.accessKey || '' }));
+    },
+    invokeMenu(caption) {
+      // Exact caption first, then case-insensitive, matching assert-menu-command.
+      const all = Array.from(menu.values());
+      const want = String(caption).toLowerCase();
+      const c = all.find(c => c.caption === caption) || all.find(c => c.caption.toLowerCase() === want);
+      if (!c) return false;
+      c.fn(new MouseEvent('click'));
+      return true;
+    },
+  };
+  const store = 'labgm:';
+  window.GM_registerMenuCommand = (caption, fn, opts) => {
+    const accessKey = typeof opts === 'string' ? opts : (opts && opts.accessKey) || '';
+    const id = (opts && opts.id) || nextId++;
+    menu.set(id, { caption: String(caption), fn, accessKey });
+    return id;
+  };
+  window.GM_unregisterMenuCommand = (id) => { menu.delete(id); };
+  window.GM_addStyle = (css) => {
+    const s = document.createElement('style');
+    s.textContent = css;
+    (document.head || document.documentElement).appendChild(s);
+    return s;
+  };
+  window.GM_getValue = (k, d) => {
+    const v = localStorage.getItem(store + k);
+    return v === null ? d : JSON.parse(v);
+  };
+  window.GM_setValue = (k, v) => { localStorage.setItem(store + k, JSON.stringify(v)); };
+  window.GM_deleteValue = (k) => { localStorage.removeItem(store + k); };
+  window.GM_listValues = () => Object.keys(localStorage).filter(k => k.startsWith(store)).map(k => k.slice(store.length));
+  window.GM_xmlhttpRequest = (d) => {
+    const ctl = new AbortController();
+    const method = (d.method || 'GET').toUpperCase();
+    const url = new URL(d.url, location.href).href;
+    const body = d.data == null ? undefined : d.data;
+    requests.push({ method, url, bytes: typeof body === 'string' ? new Blob([body]).size : (body && (body.size || body.byteLength)) || 0 });
+    let timer;
+    if (d.timeout) timer = setTimeout(() => { ctl.abort(); d.ontimeout && d.ontimeout({}); }, d.timeout);
+    fetch(url, { method, headers: d.headers, body, signal: ctl.signal, credentials: d.anonymous ? 'omit' : 'include' })
+      .then(async (r) => {
+        const text = await r.text();
+        let response = text;
+        if (d.responseType === 'json') { try { response = JSON.parse(text); } catch (e) { response = null; } }
+        const res = {
+          status: r.status, statusText: r.statusText, readyState: 4, finalUrl: r.url,
+          responseText: text, response,
+          responseHeaders: Array.from(r.headers.entries()).map(([k, v]) => k + ': ' + v).join('\r\n'),
+        };
+        clearTimeout(timer);
+        d.onload && d.onload(res);
+        d.onloadend && d.onloadend(res);
+      })
+      .catch((err) => {
+        clearTimeout(timer);
+        if (err.name === 'AbortError') return;
+        d.onerror && d.onerror({ error: String(err) });
+        d.onloadend && d.onloadend({});
+      });
+    return { abort() { clearTimeout(timer); ctl.abort(); d.onabort && d.onabort({}); } };
+  };
+  window.GM_info = { scriptHandler: 'lab-shim', version: '0' };
+  window.GM = {
+    registerMenuCommand: async (...a) => GM_registerMenuCommand(...a),
+    unregisterMenuCommand: async (id) => GM_unregisterMenuCommand(id),
+    addStyle: async (css) => GM_addStyle(css),
+    getValue: async (k, d) => GM_getValue(k, d),
+    setValue: async (k, v) => GM_setValue(k, v),
+    deleteValue: async (k) => GM_deleteValue(k),
+    listValues: async () => GM_listValues(),
+    xmlHttpRequest: (d) => new Promise((resolve, reject) => GM_xmlhttpRequest(Object.assign({}, d, {
+      onload: (r) => { d.onload && d.onload(r); resolve(r); },
+      onerror: (e) => { d.onerror && d.onerror(e); reject(e); },
+      ontimeout: (e) => { d.ontimeout && d.ontimeout(e); reject(e); },
+    }))),
+    info: window.GM_info,
+  };
+})();
+`
+
+// MenuCommand is a GM_registerMenuCommand entry a script registered.
+type MenuCommand struct {
+	Script    string `json:"script"`
+	Caption   string `json:"caption"`
+	AccessKey string `json:"access_key,omitempty"`
+}
+
+// listMenuCommands reads the shim registry from the page.
+func listMenuCommands(page playwright.Page, script string) ([]MenuCommand, error) {
+	raw, err := page.Evaluate(`() => window.__labGM ? window.__labGM.listMenu() : []`)
+	if err != nil {
+		return nil, err
+	}
+	items, _ := raw.([]any)
+	var out []MenuCommand
+	for _, it := range items {
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		caption, _ := m["caption"].(string)
+		key, _ := m["access_key"].(string)
+		out = append(out, MenuCommand{Script: script, Caption: caption, AccessKey: key})
+	}
+	return out, nil
+}
+
+// Userscript engines the runner can install as a browser extension.
+const (
+	engineTampermonkey  = "tampermonkey"
+	engineViolentmonkey = "violentmonkey"
+)
+
+// enginePopupURL returns the extension page listing menu commands for the active tab.
+func enginePopupURL(engine, extensionID string) string {
+	if engine == engineViolentmonkey {
+		return fmt.Sprintf("chrome-extension://%s/popup/index.html", extensionID)
+	}
+	return fmt.Sprintf("chrome-extension://%s/action.html", extensionID)
+}
+
+// invokeMenuCommand runs a registered command by caption, through the shim in
+// init-script mode or the extension popup when a real engine is installed.
+func (sr *stepRunner) invokeMenuCommand(caption string) error {
+	if caption == "" {
+		return errors.New("menu-command needs a caption in value")
+	}
+	if sr.engine == "" {
+		ok, err := sr.page.Evaluate(`c => !!(window.__labGM && window.__labGM.invokeMenu(c))`, caption)
+		if err != nil {
+			return err
+		}
+		if invoked, _ := ok.(bool); !invoked {
+			return fmt.Errorf("no menu command %q registered", caption)
+		}
+		return nil
+	}
+	return sr.withEnginePopup(caption, func(item playwright.Locator) error {
+		return item.Click(playwright.LocatorClickOptions{Timeout: playwright.Float(5000)})
+	})
+}
+
+// assertMenuCommand checks caption is registered, reading the shim registry in
+// init-script mode or the extension popup when a real engine is installed.
+func (sr *stepRunner) assertMenuCommand(caption string) error {
+	if sr.engine != "" {
+		return sr.withEnginePopup(caption, func(playwright.Locator) error { return nil })
+	}
+	cmds, err := listMenuCommands(sr.page, sr.scriptName)
+	if err != nil {
+		return err
+	}
+	if !hasMenuCommand(cmds, caption) {
+		return fmt.Errorf("menu command %q not registered (have %d)", caption, len(cmds))
+	}
+	return nil
+}
+
+// withEnginePopup opens the engine popup, waits for the caption and hands the
+// matching item to fn. The popup lists commands for the active tab, so the
+// target page is kept in front while it opens. Selectors are version-pinned.
+// Captions found this way are remembered for the run manifest.
+func (sr *stepRunner) withEnginePopup(caption string, fn func(playwright.Locator) error) error {
+	popup, err := sr.page.Context().NewPage()
+	if err != nil {
+		return err
+	}
+	defer popup.Close()
+	if err := sr.page.BringToFront(); err != nil {
+		return err
+	}
+	if _, err := popup.Goto(enginePopupURL(sr.engine, sr.extensionID), playwright.PageGotoOptions{WaitUntil: playwright.WaitUntilStateDomcontentloaded}); err != nil {
+		return fmt.Errorf("open %s popup: %w", sr.engine, err)
+	}
+	item := popup.GetByText(menuCaptionPattern(caption)).First()
+	if err := item.WaitFor(playwright.LocatorWaitForOptions{Timeout: playwright.Float(5000)}); err != nil {
+		return fmt.Errorf("menu command %q not in %s popup: %w", caption, sr.engine, err)
+	}
+	if err := fn(item); err != nil {
+		return err
+	}
+	if !hasMenuCommand(sr.engineCommands, caption) {
+		sr.engineCommands = append(sr.engineCommands, MenuCommand{Script: sr.scriptName, Caption: caption})
+	}
+	return sr.page.BringToFront()
+}
+
+// menuCaptionPattern matches an engine popup item whose whole text is caption,
+// ignoring case and surrounding space, the way hasMenuCommand compares
+// captions. A plain text selector would also match longer captions.
+func menuCaptionPattern(caption string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)^\s*` + regexp.QuoteMeta(strings.TrimSpace(caption)) + `\s*$`)
+}
+
+// hasMenuCommand reports whether caption is among the registered commands.
+func hasMenuCommand(cmds []MenuCommand, caption string) bool {
+	for _, c := range cmds {
+		if strings.EqualFold(c.Caption, caption) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// runShim evaluates the GM shim in node with a minimal window stub, then runs
+// body and returns what it passes to done().
+func runShim(t *testing.T, body string) map[string]any {
+	t.Helper()
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node not installed")
+	}
+	src := `globalThis.window = globalThis;
+globalThis.MouseEvent = class { constructor(type) { this.type = type; } };
+const done = (v) => process.stdout.write(JSON.stringify(v));
+` + gmShim + "\n" + body
+	path := filepath.Join(t.TempDir(), "shim.js")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(node, path).Output()
+	if err != nil {
+		t.Fatalf("node: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("decode %q: %v", out, err)
+	}
+	return got
+}
+
+func TestGMShimMenuCommands(t *testing.T) {
+	got := runShim(t, `
+const calls = [];
+GM_registerMenuCommand('Toggle theme', (e) => calls.push('theme:' + e.type), 't');
+const id = GM_registerMenuCommand('Reset', () => calls.push('reset'));
+GM_registerMenuCommand('reset', () => calls.push('reset-lower'));
+const exact = __labGM.invokeMenu('reset');
+const folded = __labGM.invokeMenu('TOGGLE THEME');
+GM_unregisterMenuCommand(id);
+const missing = __labGM.invokeMenu('Export');
+done({ list: __labGM.listMenu(), calls, exact, folded, missing });
+`)
+	if got["exact"] != true || got["folded"] != true || got["missing"] != false {
+		t.Fatalf("invoke results = %v", got)
+	}
+	calls, _ := json.Marshal(got["calls"])
+	if string(calls) != `["reset-lower","theme:click"]` {
+		t.Fatalf("calls = %s", calls)
+	}
+	list, _ := json.Marshal(got["list"])
+	if string(list) != `[{"access_key":"t","caption":"Toggle theme","id":1},{"access_key":"","caption":"reset","id":3}]` {
+		t.Fatalf("listMenu = %s", list)
+	}
+}
+
+func TestHasMenuCommand(t *testing.T) {
+	cmds := []MenuCommand{{Caption: "Toggle theme"}}
+	if !hasMenuCommand(cmds, "toggle THEME") || hasMenuCommand(cmds, "Toggle") || hasMenuCommand(nil, "Toggle theme") {
+		t.Fatal("hasMenuCommand should match whole captions case-insensitively")
+	}
+}
+
+func TestMenuCaptionPattern(t *testing.T) {
+	re := menuCaptionPattern("Settings")
+	for text, want := range map[string]bool{
+		"Settings":            true,
+		"  settings\n":        true,
+		"Settings (advanced)": false,
+		"Open Settings":       false,
+	} {
+		if got := re.MatchString(text); got != want {
+			t.Errorf("%q matches = %v, want %v", text, got, want)
+		}
+	}
+	if !menuCaptionPattern("Dark (1+1)?").MatchString("dark (1+1)?") {
+		t.Error("caption metacharacters not quoted")
+	}
+}
+
+func TestEnginePopupURL(t *testing.T) {
+	if got := enginePopupURL(engineTampermonkey, "tm"); got != "chrome-extension://tm/action.html" {
+		t.Errorf("tampermonkey popup = %q", got)
+	}
+	if got := enginePopupURL(engineViolentmonkey, "vm"); got != "chrome-extension://vm/popup/index.html" {
+		t.Errorf("violentmonkey popup = %q", got)
+	}
+}
@@ -0,0 +1,177 @@
+package runner
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/playwright-community/playwright-go"
+
+	"philadelphia/internal/har"
+)
+
+// maxHARMisses caps the unmatched requests listed in the manifest.
+const maxHARMisses = 200
+
+// HARMiss is a request the replayed HAR had no recording for.
+type HARMiss struct {
+	Method       string `json:"method"`
+	URL          string `json:"url"`
+	ResourceType string `json:"resource_type"`
+}
+
+// HARReplayReport summarises HAR replay for the manifest.
+type HARReplayReport struct {
+	Path           string    `json:"path"`
+	Strict         bool      `json:"strict"` // unmatched requests failed instead of going to the network
+	Match          string    `json:"match"`
+	Served         int       `json:"served"`
+	UnmatchedCount int       `json:"unmatched_count"`
+	Unmatched      []HARMiss `json:"unmatched,omitempty"`
+}
+
+// harReplayer answers requests from a recorded HAR. Replaces RouteFromHAR so
+// match rules and misses are under our control.
+type harReplayer struct {
+	path   string
+	dir    string
+	index  *har.Index
+	mode   har.MatchMode
+	strict bool
+	redact *redactor
+	logger *ndjsonLogger
+
+	mu     sync.Mutex
+	served int
+	count  int
+	misses []HARMiss
+}
+
+func newHARReplayer(path string, mode har.MatchMode, strict bool, redact *redactor, logger *ndjsonLogger) (*harReplayer, error) {
+	f, err := har.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &harReplayer{
+		path:   path,
+		dir:    filepath.Dir(path),
+		index:  har.NewIndex(f, mode),
+		mode:   mode,
+		strict: strict,
+		redact: redact,
+		logger: logger,
+	}, nil
+}
+
+// install routes context traffic through the HAR. In strict mode WebSockets
+// are refused too, since a HAR cannot replay them.
+func (h *harReplayer) install(ctx playwright.BrowserContext) error {
+	if err := ctx.Route("**/*", h.handle); err != nil {
+		return err
+	}
+	if !h.strict {
+		return nil
+	}
+	return ctx.RouteWebSocket("**/*", func(ws playwright.WebSocketRoute) {
+		h.miss(HARMiss{Method: "GET", URL: h.redact.url(ws.URL()), ResourceType: "websocket"})
+		ws.Close()
+	})
+}
+
+func (h *harReplayer) handle(route playwright.Route) {
+	req := route.Request()
+	entry, ok := h.lookup(req)
+	if !ok {
+		h.miss(HARMiss{Method: req.Method(), URL: h.redact.url(req.URL()), ResourceType: req.ResourceType()})
+		if h.strict {
+			_ = route.Abort("internetdisconnected")
+		} else {
+			_ = route.Fallback()
+		}
+		return
+	}
+	if err := h.fulfill(route, entry); err != nil {
+		h.logger.warn("har", "replay failed", map[string]any{"url": h.redact.url(req.URL()), "error": err.Error()})
+		_ = route.Abort("failed")
+		return
+	}
+	h.markServed()
+}
+
+func (h *harReplayer) lookup(req playwright.Request) (*har.Entry, bool) {
+	body, _ := req.PostDataBuffer()
+	return h.index.Lookup(req.Method(), req.URL(), body)
+}
+
+func (h *harReplayer) markServed() {
+	h.mu.Lock()
+	h.served++
+	h.mu.Unlock()
+}
+
+// fulfill answers with the recorded response. Recorded entries without a
+// status were network failures and are replayed as such.
+func (h *harReplayer) fulfill(route playwright.Route, e *har.Entry) error {
+	if e.Response.Status == 0 {
+		return route.Abort("failed")
+	}
+	headers, body, err := h.response(e)
+	if err != nil {
+		return err
+	}
+	return route.Fulfill(playwright.RouteFulfillOptions{
+		Status:  playwright.Int(e.Response.Status),
+		Headers: headers,
+		Body:    body,
+	})
+}
+
+// response returns the recorded headers and decoded body of e.
+func (h *harReplayer) response(e *har.Entry) (map[string]string, []byte, error) {
+	body, err := e.Response.Content.Body(h.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	headers := map[string]string{}
+	for _, hd := range e.Response.Headers {
+		name := strings.ToLower(hd.Name)
+		switch name {
+		case "content-length", "content-encoding", "transfer-encoding":
+			// The body is stored decoded; let the browser recompute framing.
+			continue
+		}
+		if v, ok := headers[name]; ok && name == "set-cookie" {
+			headers[name] = v + "\n" + hd.Value
+			continue
+		}
+		headers[name] = hd.Value
+	}
+	return headers, body, nil
+}
+
+func (h *harReplayer) miss(m HARMiss) {
+	level := h.logger.info
+	if h.strict {
+		level = h.logger.warn
+	}
+	level("har", "no recording for request", map[string]any{"method": m.Method, "url": m.URL})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.count++
+	if len(h.misses) < maxHARMisses {
+		h.misses = append(h.misses, m)
+	}
+}
+
+func (h *harReplayer) report() *HARReplayReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return &HARReplayReport{
+		Path:           h.path,
+		Strict:         h.strict,
+		Match:          string(h.mode),
+		Served:         h.served,
+		UnmatchedCount: h.count,
+		Unmatched:      append([]HARMiss(nil), h.misses...),
+	}
+}
@@ -0,0 +1,399 @@
+package runner
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// Impact roles of the two child runs.
+const (
+	impactWithout = "without-script"
+	impactWith    = "with-script"
+)
+
+// maxImpactItems bounds the request and console lists of an impact report.
+const maxImpactItems = 200
+
+// StyleSheetInfo describes one stylesheet on the page.
+type StyleSheetInfo struct {
+	Owner  string `json:"owner"` // style, link, adopted or import
+	Href   string `json:"href,omitempty"`
+	Rules  int    `json:"rules"` // -1 when cross-origin rules are unreadable
+	Size   int    `json:"size"`  // characters of CSS
+	Hash   string `json:"hash"`
+	Sample string `json:"sample,omitempty"`
+}
+
+// PageTimings are the page's navigation timings, in milliseconds.
+type PageTimings struct {
+	DOMContentLoadedMS     float64 `json:"dom_content_loaded_ms"`
+	LoadMS                 float64 `json:"load_ms"`
+	FirstContentfulPaintMS float64 `json:"first_contentful_paint_ms,omitempty"`
+	RunMS                  float64 `json:"run_ms"` // navigation start to the final screenshot
+}
+
+// impactSnapshot is what each child of an impact run records in
+// artifacts/impact.json once its steps are done.
+type impactSnapshot struct {
+	DOM           *DOMNode         `json:"dom"`
+	Styles        []StyleSheetInfo `json:"styles"`
+	ConsoleErrors []string         `json:"console_errors"`
+	Timings       PageTimings      `json:"timings"`
+	Error         string           `json:"error,omitempty"` // why the snapshot is incomplete
+}
+
+// ImpactNetwork compares the traffic of the two runs.
+type ImpactNetwork struct {
+	Without         NetworkSummary `json:"without"`
+	With            NetworkSummary `json:"with"`
+	RequestsDelta   int            `json:"requests_delta"`
+	BytesInDelta    int64          `json:"bytes_in_delta"`
+	ExtraRequests   []string       `json:"extra_requests,omitempty"`   // only made with the script
+	MissingRequests []string       `json:"missing_requests,omitempty"` // only made without it
+}
+
+// ImpactTimings compares page timings; Delta is With minus Without.
+type ImpactTimings struct {
+	Without PageTimings `json:"without"`
+	With    PageTimings `json:"with"`
+	Delta   PageTimings `json:"delta"`
+}
+
+// ImpactReport is persisted to impact.json in the parent run directory.
+type ImpactReport struct {
+	RunID            string            `json:"run_id"`
+	StartedAt        time.Time         `json:"started_at"`
+	FinishedAt       time.Time         `json:"finished_at"`
+	TargetURL        string            `json:"target_url"`
+	WithoutRun       string            `json:"without_run"`
+	WithRun          string            `json:"with_run"`
+	WithoutStatus    string            `json:"without_status"`
+	WithStatus       string            `json:"with_status"`
+	DOM              DOMDiff           `json:"dom"`
+	NewStyles        []StyleSheetInfo  `json:"new_styles,omitempty"`
+	RemovedStyles    []StyleSheetInfo  `json:"removed_styles,omitempty"`
+	Network          ImpactNetwork     `json:"network"`
+	NewConsoleErrors []string          `json:"new_console_errors,omitempty"`
+	Timings          ImpactTimings     `json:"timings"`
+	Visual           *VisualComparison `json:"visual,omitempty"` // without-script screenshot as the baseline
+}
+
+// consoleCollector keeps console errors and uncaught exceptions.
+type consoleCollector struct {
+	mu     sync.Mutex
+	errors []string
+}
+
+func newConsoleCollector(page playwright.Page) *consoleCollector {
+	c := &consoleCollector{}
+	page.OnConsole(func(m playwright.ConsoleMessage) {
+		if m.Type() == "error" {
+			c.add(m.Text())
+		}
+	})
+	page.OnPageError(func(err error) { c.add("uncaught: " + err.Error()) })
+	return c
+}
+
+func (c *consoleCollector) add(msg string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errors = append(c.errors, msg)
+}
+
+func (c *consoleCollector) snapshot() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.errors...)
+}
+
+// Every stylesheet with its rules as text, when readable.
+const styleSheetsJS = `() => {
+  const out = [];
+  const describe = (sheet, owner) => {
+    let text = '', rules = -1;
+    try { rules = sheet.cssRules.length; for (const r of sheet.cssRules) text += r.cssText + '\n'; } catch (e) {}
+    out.push({owner, href: sheet.href || '', rules, text});
+  };
+  for (const s of document.styleSheets) describe(s, s.ownerNode ? s.ownerNode.tagName.toLowerCase() : 'import');
+  for (const s of document.adoptedStyleSheets || []) describe(s, 'adopted');
+  return out;
+}`
+
+const pageTimingsJS = `() => {
+  const n = performance.getEntriesByType('navigation')[0];
+  const fcp = performance.getEntriesByName('first-contentful-paint')[0];
+  return {dcl: n ? n.domContentLoadedEventEnd : 0, load: n ? n.loadEventEnd : 0, fcp: fcp ? fcp.startTime : 0};
+}`
+
+// writeImpactSnapshot records the page state an impact report compares. If
+// the DOM cannot be read it still writes what it has, with Error set, so the
+// report can say which run failed and why.
+func writeImpactSnapshot(page playwright.Page, console *consoleCollector, started time.Time, path string) error {
+	snap := impactSnapshot{ConsoleErrors: console.snapshot()}
+	dom, domErr := snapshotDOM(page, "", nil)
+	if domErr != nil {
+		domErr = fmt.Errorf("dom snapshot: %w", domErr)
+		snap.Error = domErr.Error()
+	}
+	snap.DOM = dom
+	var sheets []struct {
+		Owner, Href, Text string
+		Rules             int
+	}
+	if raw, err := page.Evaluate(styleSheetsJS); err == nil {
+		if b, err := json.Marshal(raw); err == nil {
+			_ = json.Unmarshal(b, &sheets)
+		}
+	}
+	for _, s := range sheets {
+		key := s.Text
+		if key == "" {
+			key = s.Href
+		}
+		info := StyleSheetInfo{Owner: s.Owner, Href: s.Href, Rules: s.Rules, Size: len(s.Text), Hash: fmt.Sprintf("%x", sha256.Sum256([]byte(key)))[:16]}
+		if len(s.Text) > 200 {
+			info.Sample = s.Text[:200]
+		} else {
+			info.Sample = s.Text
+		}
+		snap.Styles = append(snap.Styles, info)
+	}
+	var t struct{ DCL, Load, FCP float64 }
+	if raw, err := page.Evaluate(pageTimingsJS); err == nil {
+		if b, err := json.Marshal(raw); err == nil {
+			_ = json.Unmarshal(b, &t)
+		}
+	}
+	snap.Timings = PageTimings{DOMContentLoadedMS: t.DCL, LoadMS: t.Load, FirstContentfulPaintMS: t.FCP, RunMS: float64(time.Since(started).Milliseconds())}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+	return domErr
+}
+
+// RunImpact runs opts twice in identical conditions, once without injecting
+// the userscript and once with it, and reports what the script changed. The
+// children are ordinary runs; the report goes to impact.json in a parent run
+// directory. Deterministic mode is switched on if opts leave it off, and
+// baselines are not touched.
+func RunImpact(opts Options) (ImpactReport, error) {
+	return runImpact(opts, Run)
+}
+
+func runImpact(opts Options, run func(Options) (Result, error)) (ImpactReport, error) {
+	if opts.Workspace == "" {
+		opts.Workspace = GuessWorkspace()
+	}
+	if opts.Deterministic == nil {
+		opts.Deterministic = &Determinism{}
+	}
+	opts.BaselineDir = ""
+	parentID := fmt.Sprintf("%x", time.Now().UnixNano())
+	parentDir := filepath.Join(opts.Workspace, "runs", parentID)
+	artifactsDir := filepath.Join(parentDir, "artifacts")
+	if err := os.MkdirAll(artifactsDir, 0o755); err != nil {
+		return ImpactReport{}, err
+	}
+	if err := os.MkdirAll(filepath.Join(parentDir, "logs"), 0o755); err != nil {
+		return ImpactReport{}, err
+	}
+	logFile, err := os.Create(filepath.Join(parentDir, "logs", "runner.ndjson"))
+	if err != nil {
+		return ImpactReport{}, err
+	}
+	defer logFile.Close()
+	logger := newNDJSONLogger(logFile)
+
+	rep := ImpactReport{RunID: parentID, StartedAt: time.Now(), TargetURL: opts.TargetURL}
+	var results [2]Result
+	for i, role := range []string{impactWithout, impactWith} {
+		child := opts
+		child.parentRun, child.impact = parentID, role
+		res, err := run(child)
+		if err != nil {
+			return rep, fmt.Errorf("%s run: %w", role, err)
+		}
+		results[i] = res
+		logger.info("impact", "child run finished", map[string]any{"role": role, "run_id": res.RunID, "status": res.Manifest.Status})
+	}
+	without, with := results[0], results[1]
+	rep.WithoutRun, rep.WithRun = without.RunID, with.RunID
+	rep.WithoutStatus, rep.WithStatus = without.Manifest.Status, with.Manifest.Status
+
+	var snaps [2]impactSnapshot
+	for i, r := range results {
+		role := []string{impactWithout, impactWith}[i]
+		data, err := os.ReadFile(filepath.Join(r.RunDir, "artifacts", "impact.json"))
+		if err != nil {
+			return rep, fmt.Errorf("%s run %s (%s) left no impact snapshot: %w", role, r.RunID, r.Manifest.Status, err)
+		}
+		if err := json.Unmarshal(data, &snaps[i]); err != nil {
+			return rep, fmt.Errorf("%s run %s: impact snapshot: %w", role, r.RunID, err)
+		}
+		if snaps[i].Error != "" {
+			return rep, fmt.Errorf("%s run %s: impact snapshot incomplete: %s", role, r.RunID, snaps[i].Error)
+		}
+	}
+	rep.DOM = diffDOM(snaps[0].DOM, snaps[1].DOM)
+	rep.NewStyles, rep.RemovedStyles = diffStyles(snaps[0].Styles, snaps[1].Styles)
+	rep.NewConsoleErrors = extraItems(snaps[0].ConsoleErrors, snaps[1].ConsoleErrors)
+	rep.Timings = ImpactTimings{Without: snaps[0].Timings, With: snaps[1].Timings, Delta: snaps[1].Timings.minus(snaps[0].Timings)}
+
+	withoutEntries := readNetworkLog(filepath.Join(without.RunDir, "logs", "network.ndjson"), logger)
+	withEntries := readNetworkLog(filepath.Join(with.RunDir, "logs", "network.ndjson"), logger)
+	rep.Network = ImpactNetwork{
+		Without:         summarizeEntries(withoutEntries, ""),
+		With:            summarizeEntries(withEntries, ""),
+		ExtraRequests:   extraItems(requestKeys(withoutEntries), requestKeys(withEntries)),
+		MissingRequests: extraItems(requestKeys(withEntries), requestKeys(withoutEntries)),
+	}
+	rep.Network.RequestsDelta = rep.Network.With.Requests - rep.Network.Without.Requests
+	rep.Network.BytesInDelta = rep.Network.With.BytesIn - rep.Network.Without.BytesIn
+
+	basePNG, err1 := os.ReadFile(filepath.Join(without.RunDir, "artifacts", "screenshot.png"))
+	currPNG, err2 := os.ReadFile(filepath.Join(with.RunDir, "artifacts", "screenshot.png"))
+	if err1 == nil && err2 == nil {
+		rep.Visual = computeDiffImage(basePNG, currPNG, artifactsDir, "impact", nil, logger, opts)
+		if rep.Visual != nil {
+			rep.Visual.Baseline = filepath.Join("runs", without.RunID, "artifacts", "screenshot.png")
+		}
+	} else {
+		logger.warn("impact", "screenshots missing; no visual diff", nil)
+	}
+
+	rep.FinishedAt = time.Now()
+	logger.info("impact", "report ready", map[string]any{"dom": rep.DOM.summary(), "new_styles": len(rep.NewStyles), "extra_requests": len(rep.Network.ExtraRequests)})
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return rep, err
+	}
+	return rep, os.WriteFile(filepath.Join(parentDir, "impact.json"), append(data, '\n'), 0o644)
+}
+
+// LoadImpactReport reads a parent run's impact.json.
+func LoadImpactReport(path string) (ImpactReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ImpactReport{}, err
+	}
+	var r ImpactReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		return ImpactReport{}, err
+	}
+	return r, nil
+}
+
+func (t PageTimings) minus(o PageTimings) PageTimings {
+	return PageTimings{
+		DOMContentLoadedMS:     math.Round(t.DOMContentLoadedMS - o.DOMContentLoadedMS),
+		LoadMS:                 math.Round(t.LoadMS - o.LoadMS),
+		FirstContentfulPaintMS: math.Round(t.FirstContentfulPaintMS - o.FirstContentfulPaintMS),
+		RunMS:                  t.RunMS - o.RunMS,
+	}
+}
+
+// diffStyles returns stylesheets only in after, and only in before.
+func diffStyles(before, after []StyleSheetInfo) (added, removed []StyleSheetInfo) {
+	count := map[string]int{}
+	for _, s := range before {
+		count[s.Hash]++
+	}
+	for _, s := range after {
+		if count[s.Hash] > 0 {
+			count[s.Hash]--
+			continue
+		}
+		added = append(added, s)
+	}
+	for _, s := range before {
+		if count[s.Hash] > 0 {
+			count[s.Hash]--
+			removed = append(removed, s)
+		}
+	}
+	return added, removed
+}
+
+// extraItems lists the items of after that before lacks, as a multiset, with
+// repeats collapsed to "item (xN)".
+func extraItems(before, after []string) []string {
+	count := map[string]int{}
+	for _, s := range before {
+		count[s]++
+	}
+	extra := map[string]int{}
+	var order []string
+	for _, s := range after {
+		if count[s] > 0 {
+			count[s]--
+			continue
+		}
+		if extra[s] == 0 {
+			order = append(order, s)
+		}
+		extra[s]++
+	}
+	sort.Strings(order)
+	var out []string
+	for _, s := range order {
+		if len(out) == maxImpactItems {
+			out = append(out, fmt.Sprintf("… %d more", len(order)-maxImpactItems))
+			break
+		}
+		if n := extra[s]; n > 1 {
+			s = fmt.Sprintf("%s (x%d)", s, n)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// requestKeys identifies requests by method and URL without the fragment.
+func requestKeys(entries []NetworkEntry) []string {
+	keys := make([]string, 0, len(entries))
+	for _, e := range entries {
+		u := e.URL
+		if p, err := url.Parse(u); err == nil {
+			p.Fragment = ""
+			u = p.String()
+		}
+		keys = append(keys, e.Method+" "+u)
+	}
+	return keys
+}
+
+// readNetworkLog loads a run's network.ndjson.
+func readNetworkLog(path string, logger *ndjsonLogger) []NetworkEntry {
+	f, err := os.Open(path)
+	if err != nil {
+		logger.warn("impact", "network log unreadable", map[string]any{"path": path, "error": err.Error()})
+		return nil
+	}
+	defer f.Close()
+	var entries []NetworkEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var e NetworkEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,175 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+func TestRunImpact(t *testing.T) {
+	ws := t.TempDir()
+	white := solid(20, 20, color.NRGBA{255, 255, 255, 255})
+	dark := solid(20, 20, color.NRGBA{20, 20, 20, 255})
+	fake := func(o Options) (Result, error) {
+		if o.Deterministic == nil || o.BaselineDir != "" {
+			t.Errorf("child options not pinned: %+v", o)
+		}
+		runDir := filepath.Join(ws, "runs", o.impact)
+		for _, d := range []string{"artifacts", "logs"} {
+			if err := os.MkdirAll(filepath.Join(runDir, d), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		snap := impactSnapshot{
+			DOM:     el("html", nil, "", el("body", nil, "")),
+			Styles:  []StyleSheetInfo{{Owner: "link", Href: "https://example.com/site.css", Hash: "site"}},
+			Timings: PageTimings{DOMContentLoadedMS: 100, LoadMS: 200, RunMS: 1000},
+		}
+		entries := []NetworkEntry{{Method: "GET", URL: "https://example.com/", Sizes: NetworkSizes{}}}
+		img := white
+		if o.impact == impactWith {
+			snap.DOM.Children[0].Attrs = map[string]string{"class": "dark"}
+			snap.Styles = append(snap.Styles, StyleSheetInfo{Owner: "style", Hash: "gm", Sample: "body{background:#111}"})
+			snap.ConsoleErrors = []string{"uncaught: boom"}
+			snap.Timings = PageTimings{DOMContentLoadedMS: 130, LoadMS: 260, RunMS: 1100}
+			entries = append(entries, NetworkEntry{Method: "GET", URL: "https://cdn.example.net/theme.css#x"}, NetworkEntry{Method: "GET", URL: "https://cdn.example.net/theme.css"})
+			img = dark
+		}
+		data, _ := json.Marshal(snap)
+		os.WriteFile(filepath.Join(runDir, "artifacts", "impact.json"), data, 0o644)
+		os.WriteFile(filepath.Join(runDir, "artifacts", "screenshot.png"), encodePNG(t, img), 0o644)
+		var lines []byte
+		for _, e := range entries {
+			b, _ := json.Marshal(e)
+			lines = append(append(lines, b...), '\n')
+		}
+		os.WriteFile(filepath.Join(runDir, "logs", "network.ndjson"), lines, 0o644)
+		return Result{RunID: o.impact, RunDir: runDir, Manifest: Manifest{Status: "passed"}}, nil
+	}
+
+	rep, err := runImpact(Options{Workspace: ws, BaselineDir: "baselines"}, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.WithoutRun != impactWithout || rep.WithRun != impactWith {
+		t.Fatalf("runs = %s, %s", rep.WithoutRun, rep.WithRun)
+	}
+	if rep.DOM.Changed != 1 || len(rep.NewStyles) != 1 || rep.NewStyles[0].Hash != "gm" || len(rep.RemovedStyles) != 0 {
+		t.Fatalf("dom %+v, styles %+v", rep.DOM, rep.NewStyles)
+	}
+	if !reflect.DeepEqual(rep.Network.ExtraRequests, []string{"GET https://cdn.example.net/theme.css (x2)"}) || rep.Network.RequestsDelta != 2 {
+		t.Fatalf("network = %+v", rep.Network)
+	}
+	if !reflect.DeepEqual(rep.NewConsoleErrors, []string{"uncaught: boom"}) {
+		t.Fatalf("console = %v", rep.NewConsoleErrors)
+	}
+	if rep.Timings.Delta.DOMContentLoadedMS != 30 || rep.Timings.Delta.RunMS != 100 {
+		t.Fatalf("timings = %+v", rep.Timings.Delta)
+	}
+	if rep.Visual == nil || rep.Visual.DiffPixels != 400 || rep.Visual.DiffImg != "impact-diff.png" {
+		t.Fatalf("visual = %+v", rep.Visual)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "runs", rep.RunID, "artifacts", "impact-diff.png")); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadImpactReport(filepath.Join(ws, "runs", rep.RunID, "impact.json"))
+	if err != nil || loaded.DOM.Changed != 1 {
+		t.Fatalf("impact.json = %+v, %v", loaded, err)
+	}
+}
+
+func TestRunImpactSnapshotErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		snap *impactSnapshot // nil writes no impact.json
+		want string
+	}{
+		{"missing", nil, "with-script run with-script (failed) left no impact snapshot"},
+		{"incomplete", &impactSnapshot{Error: "dom snapshot: page crashed"}, "with-script run with-script: impact snapshot incomplete: dom snapshot: page crashed"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ws := t.TempDir()
+			fake := func(o Options) (Result, error) {
+				runDir := filepath.Join(ws, "runs", o.impact)
+				if err := os.MkdirAll(filepath.Join(runDir, "artifacts"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				snap, status := &impactSnapshot{DOM: el("html", nil, "")}, "passed"
+				if o.impact == impactWith {
+					snap, status = tc.snap, "failed"
+				}
+				if snap != nil {
+					data, _ := json.Marshal(snap)
+					os.WriteFile(filepath.Join(runDir, "artifacts", "impact.json"), data, 0o644)
+				}
+				return Result{RunID: o.impact, RunDir: runDir, Manifest: Manifest{Status: status}}, nil
+			}
+			_, err := runImpact(Options{Workspace: ws}, fake)
+			if err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+				t.Fatalf("err = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+// brokenPage fails every evaluation and records what the run asked of it.
+type brokenPage struct {
+	playwright.Page
+	calls []string
+}
+
+func (p *brokenPage) Evaluate(string, ...any) (any, error) {
+	return nil, errors.New("page crashed")
+}
+
+func (p *brokenPage) WaitForSelector(selector string, _ ...playwright.PageWaitForSelectorOptions) (playwright.ElementHandle, error) {
+	p.calls = append(p.calls, "wait "+selector)
+	return nil, nil
+}
+
+func (p *brokenPage) Click(selector string, _ ...playwright.PageClickOptions) error {
+	p.calls = append(p.calls, "click "+selector)
+	return nil
+}
+
+func TestWriteImpactSnapshotKeepsPartialResult(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "impact.json")
+	err := writeImpactSnapshot(&brokenPage{}, &consoleCollector{errors: []string{"boom"}}, time.Now(), path)
+	if err == nil || !strings.Contains(err.Error(), "page crashed") {
+		t.Fatalf("err = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap impactSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil || snap.Error != "dom snapshot: page crashed" || len(snap.ConsoleErrors) != 1 {
+		t.Fatalf("snapshot = %+v, %v", snap, err)
+	}
+}
+
+func TestDefaultToggleWithoutSteps(t *testing.T) {
+	logger := &ndjsonLogger{w: bufio.NewWriter(io.Discard)}
+	for _, role := range []string{impactWithout, impactWith} {
+		page := &brokenPage{}
+		defaultToggle(page, logger, role)
+		if len(page.calls) != 0 {
+			t.Errorf("%s: default toggle ran: %v", role, page.calls)
+		}
+	}
+	page := &brokenPage{}
+	defaultToggle(page, logger, "")
+	if want := []string{"wait text=Toggle Dark Mode", "click text=Toggle Dark Mode"}; !reflect.DeepEqual(page.calls, want) {
+		t.Fatalf("calls = %v, want %v", page.calls, want)
+	}
+}
@@ -0,0 +1,139 @@
+package runner
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// userscriptSourceURL names the injected script in stack traces so requests
+// it starts can be told apart from the page's own.
+const userscriptSourceURL = "lab-userscript.user.js"
+
+// Initiator kinds.
+const (
+	initiatorPage       = "page"
+	initiatorUserscript = "userscript"
+	initiatorUnknown    = "unknown"
+)
+
+// initiatorInfo is what CDP reported about who started a request.
+type initiatorInfo struct {
+	Kind  string   // page, userscript or unknown
+	Type  string   // CDP initiator type: parser, script, preload, other, ...
+	Stack []string // "url:line" frames, innermost first, capped
+}
+
+// initiatorTracker follows Network.requestWillBeSent on the page's CDP session
+// and attributes each request to the page or the userscript by its stack.
+type initiatorTracker struct {
+	mu    sync.Mutex
+	byKey map[string][]initiatorInfo
+}
+
+func newInitiatorTracker() *initiatorTracker {
+	return &initiatorTracker{byKey: map[string][]initiatorInfo{}}
+}
+
+// attach opens a CDP session for page; Chromium only.
+func (t *initiatorTracker) attach(ctx playwright.BrowserContext, page playwright.Page) error {
+	session, err := ctx.NewCDPSession(page)
+	if err != nil {
+		return err
+	}
+	session.On("Network.requestWillBeSent", func(params map[string]any) {
+		req, _ := params["request"].(map[string]any)
+		if req == nil {
+			return
+		}
+		method, _ := req["method"].(string)
+		url, _ := req["url"].(string)
+		info := classifyInitiator(params["initiator"])
+		t.mu.Lock()
+		key := method + " " + url
+		t.byKey[key] = append(t.byKey[key], info)
+		t.mu.Unlock()
+	})
+	_, err = session.Send("Network.enable", map[string]any{})
+	return err
+}
+
+// lookup returns the most recent initiator for method+url, waiting briefly
+// because CDP and routing events are not ordered relative to each other.
+func (t *initiatorTracker) lookup(method, url string, wait time.Duration) initiatorInfo {
+	var info initiatorInfo
+	found := pollUntil(wait, func() bool {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		list := t.byKey[method+" "+url]
+		if len(list) == 0 {
+			return false
+		}
+		info = list[len(list)-1]
+		return true
+	})
+	if !found {
+		return initiatorInfo{Kind: initiatorUnknown}
+	}
+	return info
+}
+
+// take removes and returns the oldest unclaimed initiator for method+url, so
+// repeated requests to one URL are attributed in order.
+func (t *initiatorTracker) take(method, url string, wait time.Duration) initiatorInfo {
+	info := initiatorInfo{Kind: initiatorUnknown}
+	pollUntil(wait, func() bool {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		key := method + " " + url
+		list := t.byKey[key]
+		if len(list) == 0 {
+			return false
+		}
+		info = list[0]
+		if len(list) == 1 {
+			delete(t.byKey, key)
+		} else {
+			t.byKey[key] = list[1:]
+		}
+		return true
+	})
+	return info
+}
+
+// classifyInitiator walks the initiator stack, including async parents.
+func classifyInitiator(raw any) initiatorInfo {
+	in, _ := raw.(map[string]any)
+	info := initiatorInfo{Kind: initiatorPage}
+	if in == nil {
+		info.Kind = initiatorUnknown
+		return info
+	}
+	info.Type, _ = in["type"].(string)
+	if u, _ := in["url"].(string); isUserscriptURL(u) {
+		info.Kind = initiatorUserscript
+	}
+	for stack, _ := in["stack"].(map[string]any); stack != nil; stack, _ = stack["parent"].(map[string]any) {
+		frames, _ := stack["callFrames"].([]any)
+		for _, f := range frames {
+			cf, _ := f.(map[string]any)
+			u, _ := cf["url"].(string)
+			line, _ := cf["lineNumber"].(float64)
+			if len(info.Stack) < 8 {
+				info.Stack = append(info.Stack, u+":"+strconv.Itoa(int(line)+1))
+			}
+			if isUserscriptURL(u) {
+				info.Kind = initiatorUserscript
+			}
+		}
+	}
+	return info
+}
+
+// isUserscriptURL recognises the init-script sourceURL and engine-hosted scripts.
+func isUserscriptURL(u string) bool {
+	return strings.HasSuffix(u, userscriptSourceURL) || strings.HasPrefix(u, "chrome-extension://")
+}
@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// IgnoreRegion excludes part of the page from visual comparison: every element
+// matching Selector, or a rectangle in CSS pixels from the top of the document.
+type IgnoreRegion struct {
+	Selector string  `json:"selector,omitempty"`
+	X        float64 `json:"x,omitempty"`
+	Y        float64 `json:"y,omitempty"`
+	Width    float64 `json:"width,omitempty"`
+	Height   float64 `json:"height,omitempty"`
+}
+
+// MaskRect is a resolved ignore region in screenshot pixels.
+type MaskRect struct {
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Source string `json:"source"` // selector, or "rect"
+}
+
+var (
+	maskFill    = color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+	maskOverlay = color.NRGBA{R: 40, G: 110, B: 255, A: 255}
+)
+
+// Element boxes in document coordinates, scaled to screenshot pixels.
+const maskBoxesJS = `els => els.map(e => {
+  const r = e.getBoundingClientRect(), d = window.devicePixelRatio || 1;
+  return {x: (r.x + scrollX) * d, y: (r.y + scrollY) * d, w: r.width * d, h: r.height * d};
+})`
+
+// resolveIgnoreRegions turns regions into pixel rectangles for a screenshot
+// whose top-left corner is at origin in the document. Selectors are measured
+// on the current page only, so the same rectangles are painted out of the
+// baseline.
+func resolveIgnoreRegions(page playwright.Page, regions []IgnoreRegion, origin image.Point, logger *ndjsonLogger) []MaskRect {
+	if len(regions) == 0 {
+		return nil
+	}
+	dpr := 1.0
+	if v, err := page.Evaluate(`() => window.devicePixelRatio || 1`); err == nil {
+		if f, ok := v.(float64); ok && f > 0 {
+			dpr = f
+		} else if n, ok := v.(int); ok && n > 0 {
+			dpr = float64(n)
+		}
+	}
+	var rects []MaskRect
+	for _, r := range regions {
+		if r.Selector == "" {
+			if m, ok := pixelRect(r.X*dpr-float64(origin.X), r.Y*dpr-float64(origin.Y), r.Width*dpr, r.Height*dpr, "rect"); ok {
+				rects = append(rects, m)
+			}
+			continue
+		}
+		raw, err := page.Locator(r.Selector).EvaluateAll(maskBoxesJS)
+		if err != nil {
+			logger.warn("visual", "ignore region lookup failed", map[string]any{"selector": r.Selector, "error": err.Error()})
+			continue
+		}
+		var boxes []struct{ X, Y, W, H float64 }
+		if b, err := json.Marshal(raw); err == nil {
+			_ = json.Unmarshal(b, &boxes)
+		}
+		if len(boxes) == 0 {
+			logger.info("visual", "ignore region matched nothing", map[string]any{"selector": r.Selector})
+		}
+		for _, b := range boxes {
+			if m, ok := pixelRect(b.X-float64(origin.X), b.Y-float64(origin.Y), b.W, b.H, r.Selector); ok {
+				rects = append(rects, m)
+			}
+		}
+	}
+	return rects
+}
+
+// pixelRect rounds outwards so partially covered pixels are masked too.
+func pixelRect(x, y, w, h float64, source string) (MaskRect, bool) {
+	if w <= 0 || h <= 0 {
+		return MaskRect{}, false
+	}
+	x0, y0 := math.Floor(x), math.Floor(y)
+	x1, y1 := math.Ceil(x+w), math.Ceil(y+h)
+	return MaskRect{X: int(x0), Y: int(y0), Width: int(x1 - x0), Height: int(y1 - y0), Source: source}, true
+}
+
+func (m MaskRect) rect() image.Rectangle {
+	return image.Rect(m.X, m.Y, m.X+m.Width, m.Y+m.Height)
+}
+
+// paintMasks fills the masked rectangles of img with a flat colour.
+func paintMasks(img *image.NRGBA, masks []MaskRect, c color.NRGBA) {
+	for _, m := range masks {
+		r := m.rect().Intersect(img.Rect)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				img.SetNRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// outlineMasks tints the masked areas of the diff overlay and draws their
+// borders so reviewers see what was excluded.
+func outlineMasks(img *image.NRGBA, masks []MaskRect) {
+	for _, m := range masks {
+		r := m.rect().Intersect(img.Rect)
+		if r.Empty() {
+			continue
+		}
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				border := x < r.Min.X+2 || x >= r.Max.X-2 || y < r.Min.Y+2 || y >= r.Max.Y-2
+				if border {
+					img.SetNRGBA(x, y, maskOverlay)
+					continue
+				}
+				p := img.NRGBAAt(x, y)
+				img.SetNRGBA(x, y, color.NRGBA{R: p.R / 2, G: p.G/2 + 55, B: p.B/2 + 127, A: 255})
+			}
+		}
+	}
+}
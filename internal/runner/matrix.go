@@ -0,0 +1,244 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Matrix lists the environments a matrix run fans out over. Viewports and
+// devices together form the screen axis; each is crossed with every colour
+// scheme and locale. Empty axes leave the setting at the run's default.
+type Matrix struct {
+	Viewports    []string `json:"viewports,omitempty"`
+	Devices      []string `json:"devices,omitempty"`
+	ColorSchemes []string `json:"color_schemes,omitempty"`
+	Locales      []string `json:"locales,omitempty"`
+}
+
+// MatrixCell is one combination of a matrix.
+type MatrixCell struct {
+	Viewport    string `json:"viewport,omitempty"`
+	Device      string `json:"device,omitempty"`
+	ColorScheme string `json:"color_scheme,omitempty"`
+	Locale      string `json:"locale,omitempty"`
+}
+
+// MatrixCellResult is the outcome of one child run.
+type MatrixCellResult struct {
+	MatrixCell
+	RunID           string  `json:"run_id,omitempty"`
+	Status          string  `json:"status"` // the child's status, or "error" if it could not run
+	Error           string  `json:"error,omitempty"`
+	VisualDiffRatio float64 `json:"visual_diff_ratio,omitempty"`
+}
+
+// MatrixGrid lays cell statuses out with one row per screen and one column
+// per colour scheme and locale pair.
+type MatrixGrid struct {
+	Columns []string    `json:"columns"`
+	Rows    []MatrixRow `json:"rows"`
+}
+
+// MatrixRow is one screen of the grid; Statuses and RunIDs follow Columns.
+type MatrixRow struct {
+	Screen   string   `json:"screen"`
+	Statuses []string `json:"statuses"`
+	RunIDs   []string `json:"run_ids"`
+}
+
+// MatrixManifest is persisted to matrix.json in the parent run directory.
+type MatrixManifest struct {
+	RunID      string             `json:"run_id"`
+	StartedAt  time.Time          `json:"started_at"`
+	FinishedAt time.Time          `json:"finished_at"`
+	TargetURL  string             `json:"target_url"`
+	Matrix     Matrix             `json:"matrix"`
+	Cells      []MatrixCellResult `json:"cells"`
+	Grid       MatrixGrid         `json:"grid"`
+	Passed     int                `json:"passed"`
+	Failed     int                `json:"failed"` // failed, aborted or errored cells
+	Status     string             `json:"status"` // passed if every cell passed
+}
+
+// Empty reports whether m has no axes, i.e. describes a plain run.
+func (m Matrix) Empty() bool {
+	return len(m.Viewports) == 0 && len(m.Devices) == 0 && len(m.ColorSchemes) == 0 && len(m.Locales) == 0
+}
+
+// Cells expands m into its combinations, screens first.
+func (m Matrix) Cells() ([]MatrixCell, error) {
+	var screens []MatrixCell
+	for _, v := range m.Viewports {
+		if _, err := parseViewport(v); err != nil {
+			return nil, err
+		}
+		screens = append(screens, MatrixCell{Viewport: v})
+	}
+	for _, d := range m.Devices {
+		if strings.TrimSpace(d) == "" {
+			return nil, fmt.Errorf("empty device name")
+		}
+		screens = append(screens, MatrixCell{Device: d})
+	}
+	for _, cs := range m.ColorSchemes {
+		if _, err := parseColorScheme(cs); err != nil {
+			return nil, err
+		}
+	}
+	if len(screens) == 0 {
+		screens = []MatrixCell{{}}
+	}
+	schemes, locales := orDefault(m.ColorSchemes), orDefault(m.Locales)
+	var cells []MatrixCell
+	for _, s := range screens {
+		for _, cs := range schemes {
+			for _, l := range locales {
+				c := s
+				c.ColorScheme, c.Locale = strings.ToLower(cs), l
+				cells = append(cells, c)
+			}
+		}
+	}
+	return cells, nil
+}
+
+func orDefault(values []string) []string {
+	if len(values) == 0 {
+		return []string{""}
+	}
+	return values
+}
+
+func (c MatrixCell) screen() string {
+	switch {
+	case c.Device != "":
+		return c.Device
+	case c.Viewport != "":
+		return c.Viewport
+	}
+	return "default"
+}
+
+func (c MatrixCell) column() string {
+	var parts []string
+	for _, p := range []string{c.ColorScheme, c.Locale} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return "default"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Label names the cell for logs and summaries.
+func (c MatrixCell) Label() string {
+	return c.screen() + " / " + c.column()
+}
+
+// RunMatrix runs opts once per cell of m, one child run each, and writes a
+// parent matrix.json linking them. Cells run one after another.
+func RunMatrix(opts Options, m Matrix) (MatrixManifest, error) {
+	return runMatrix(opts, m, Run)
+}
+
+func runMatrix(opts Options, m Matrix, run func(Options) (Result, error)) (MatrixManifest, error) {
+	cells, err := m.Cells()
+	if err != nil {
+		return MatrixManifest{}, err
+	}
+	if opts.Workspace == "" {
+		opts.Workspace = GuessWorkspace()
+	}
+	parentID := fmt.Sprintf("%x", time.Now().UnixNano())
+	parentDir := filepath.Join(opts.Workspace, "runs", parentID)
+	if err := os.MkdirAll(parentDir, 0o755); err != nil {
+		return MatrixManifest{}, err
+	}
+	mm := MatrixManifest{RunID: parentID, StartedAt: time.Now(), TargetURL: opts.TargetURL, Matrix: m}
+	for _, cell := range cells {
+		child := opts
+		child.parentRun, child.cell = parentID, &cell
+		if cell.Viewport != "" {
+			child.Viewport = cell.Viewport
+		}
+		if cell.Device != "" {
+			child.Device = cell.Device
+		}
+		if cell.ColorScheme != "" {
+			child.ColorScheme = cell.ColorScheme
+		}
+		if cell.Locale != "" {
+			child.Locale = cell.Locale
+		}
+		r := MatrixCellResult{MatrixCell: cell}
+		res, err := run(child)
+		if err != nil {
+			r.Status, r.Error = "error", err.Error()
+		} else {
+			r.RunID, r.Status = res.RunID, res.Manifest.Status
+			r.VisualDiffRatio = res.Manifest.VisualDiffRatio
+		}
+		if r.Status == "passed" {
+			mm.Passed++
+		} else {
+			mm.Failed++
+		}
+		mm.Cells = append(mm.Cells, r)
+	}
+	mm.Grid = matrixGrid(mm.Cells)
+	mm.Status = "passed"
+	if mm.Failed > 0 {
+		mm.Status = "failed"
+	}
+	mm.FinishedAt = time.Now()
+	data, err := json.MarshalIndent(mm, "", "  ")
+	if err != nil {
+		return mm, err
+	}
+	return mm, os.WriteFile(filepath.Join(parentDir, "matrix.json"), append(data, '\n'), 0o644)
+}
+
+func matrixGrid(cells []MatrixCellResult) MatrixGrid {
+	var g MatrixGrid
+	cols, rows := map[string]int{}, map[string]int{}
+	for _, c := range cells {
+		if _, ok := cols[c.column()]; !ok {
+			cols[c.column()] = len(g.Columns)
+			g.Columns = append(g.Columns, c.column())
+		}
+	}
+	for _, c := range cells {
+		i, ok := rows[c.screen()]
+		if !ok {
+			i = len(g.Rows)
+			rows[c.screen()] = i
+			g.Rows = append(g.Rows, MatrixRow{
+				Screen:   c.screen(),
+				Statuses: make([]string, len(g.Columns)),
+				RunIDs:   make([]string, len(g.Columns)),
+			})
+		}
+		g.Rows[i].Statuses[cols[c.column()]] = c.Status
+		g.Rows[i].RunIDs[cols[c.column()]] = c.RunID
+	}
+	return g
+}
+
+// LoadMatrixManifest reads a parent run's matrix.json.
+func LoadMatrixManifest(path string) (MatrixManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return MatrixManifest{}, err
+	}
+	var m MatrixManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return MatrixManifest{}, err
+	}
+	return m, nil
+}
@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatrixCells(t *testing.T) {
+	m := Matrix{Viewports: []string{"1280x720"}, Devices: []string{"iPhone 13"}, ColorSchemes: []string{"light", "Dark"}}
+	cells, err := m.Cells()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MatrixCell{
+		{Viewport: "1280x720", ColorScheme: "light"},
+		{Viewport: "1280x720", ColorScheme: "dark"},
+		{Device: "iPhone 13", ColorScheme: "light"},
+		{Device: "iPhone 13", ColorScheme: "dark"},
+	}
+	if !reflect.DeepEqual(cells, want) {
+		t.Fatalf("cells = %+v", cells)
+	}
+	if cells, _ := (Matrix{Locales: []string{"en-US", "de-DE"}}).Cells(); len(cells) != 2 || cells[1].Label() != "default / de-DE" {
+		t.Fatalf("locale-only cells = %+v", cells)
+	}
+	for _, bad := range []Matrix{{Viewports: []string{"wide"}}, {ColorSchemes: []string{"sepia"}}, {Devices: []string{" "}}} {
+		if _, err := bad.Cells(); err == nil {
+			t.Errorf("%+v accepted", bad)
+		}
+	}
+}
+
+func TestRunMatrix(t *testing.T) {
+	ws := t.TempDir()
+	m := Matrix{Viewports: []string{"1280x720", "390x844"}, ColorSchemes: []string{"light", "dark"}}
+	var parents []string
+	fake := func(o Options) (Result, error) {
+		parents = append(parents, o.parentRun)
+		if o.Viewport == "390x844" && o.ColorScheme == "dark" {
+			return Result{}, errors.New("browser crashed")
+		}
+		status := "passed"
+		if o.ColorScheme == "dark" {
+			status = "failed"
+		}
+		if o.cell == nil || o.cell.Viewport != o.Viewport {
+			t.Errorf("cell not passed to child: %+v", o.cell)
+		}
+		return Result{RunID: o.Viewport + "-" + o.ColorScheme, Manifest: Manifest{Status: status}}, nil
+	}
+	mm, err := runMatrix(Options{Workspace: ws, ColorScheme: "light"}, m, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mm.Passed != 2 || mm.Failed != 2 || mm.Status != "failed" {
+		t.Fatalf("passed=%d failed=%d status=%s", mm.Passed, mm.Failed, mm.Status)
+	}
+	for _, p := range parents {
+		if p != mm.RunID {
+			t.Fatalf("child parent = %q, want %q", p, mm.RunID)
+		}
+	}
+	wantGrid := MatrixGrid{
+		Columns: []string{"light", "dark"},
+		Rows: []MatrixRow{
+			{Screen: "1280x720", Statuses: []string{"passed", "failed"}, RunIDs: []string{"1280x720-light", "1280x720-dark"}},
+			{Screen: "390x844", Statuses: []string{"passed", "error"}, RunIDs: []string{"390x844-light", ""}},
+		},
+	}
+	if !reflect.DeepEqual(mm.Grid, wantGrid) {
+		t.Fatalf("grid = %+v", mm.Grid)
+	}
+	loaded, err := LoadMatrixManifest(filepath.Join(ws, "runs", mm.RunID, "matrix.json"))
+	if err != nil || loaded.Cells[3].Error != "browser crashed" {
+		t.Fatalf("matrix.json = %+v, %v", loaded, err)
+	}
+}
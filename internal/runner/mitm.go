@@ -0,0 +1,139 @@
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+
+	"philadelphia/internal/proxy"
+)
+
+// ProxyReport summarises the record/replay proxy for the manifest.
+type ProxyReport struct {
+	Mode           string   `json:"mode"`
+	Cassette       string   `json:"cassette"`
+	CACert         string   `json:"ca_cert"`
+	Interactions   int      `json:"interactions"` // entries in the cassette after the run
+	Recorded       int      `json:"recorded,omitempty"`
+	Replayed       int      `json:"replayed,omitempty"`
+	Tunneled       int      `json:"tunneled,omitempty"`
+	Errors         int      `json:"errors,omitempty"`
+	UnmatchedCount int      `json:"unmatched_count"`
+	Unmatched      []string `json:"unmatched,omitempty"`
+}
+
+// mitmProxy is the proxy the browser profile is pointed at. Unlike context
+// routes it also sees service-worker and extension traffic, e.g. Tampermonkey's
+// GM_xmlhttpRequest, which runs in the extension's background worker.
+type mitmProxy struct {
+	mode     proxy.Mode
+	path     string
+	cassette *proxy.Cassette
+	ca       *proxy.CA
+	server   *proxy.Server
+	redact   *redactor
+	logger   *ndjsonLogger
+}
+
+// startMITM starts the proxy and points ctxOpts at it. The CA lives in
+// <workspace>/.lab/ca and is trusted by SPKI pin, so nothing is installed
+// system-wide.
+func startMITM(ctxOpts *playwright.BrowserTypeLaunchPersistentContextOptions, workspace, mode, cassettePath string, redact *redactor, logger *ndjsonLogger) (*mitmProxy, error) {
+	m, err := proxy.ParseMode(mode)
+	if err != nil {
+		return nil, err
+	}
+	if cassettePath == "" {
+		return nil, fmt.Errorf("proxy %s needs a cassette path", m)
+	}
+	cassette := proxy.NewCassette(cassettePath)
+	cassette.SetRedactor(cassetteRedactor{redact}, redactedValue)
+	if m == proxy.ModeReplay {
+		if cassette, err = proxy.LoadCassette(cassettePath); err != nil {
+			return nil, err
+		}
+	}
+	ca, err := proxy.LoadOrCreateCA(filepath.Join(workspace, ".lab", "ca"))
+	if err != nil {
+		return nil, err
+	}
+	pins, err := ca.SPKIPins()
+	if err != nil {
+		return nil, err
+	}
+	server, err := proxy.New(proxy.Options{
+		Mode:     m,
+		Cassette: cassette,
+		CA:       ca,
+		Logf: func(format string, args ...any) {
+			logger.info("proxy", redactURLs(redact, fmt.Sprintf(format, args...)), nil)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	addr, err := server.Start()
+	if err != nil {
+		return nil, err
+	}
+	ctxOpts.Proxy = &playwright.Proxy{
+		Server: "http://" + addr,
+		// Chromium skips the proxy for localhost unless told otherwise.
+		Bypass: playwright.String("<-loopback>"),
+	}
+	ctxOpts.Args = append(ctxOpts.Args, "--ignore-certificate-errors-spki-list="+strings.Join(pins, ","))
+	logger.info("proxy", "MITM proxy listening", map[string]any{"addr": addr, "mode": m, "cassette": cassettePath, "ca": ca.CertPath})
+	return &mitmProxy{mode: m, path: cassettePath, cassette: cassette, ca: ca, server: server, redact: redact, logger: logger}, nil
+}
+
+// finish stops the proxy, saves a recorded cassette and reports.
+func (p *mitmProxy) finish() *ProxyReport {
+	if err := p.server.Close(); err != nil {
+		p.logger.warn("proxy", "close failed", map[string]any{"error": err.Error()})
+	}
+	if p.mode == proxy.ModeRecord {
+		if err := p.cassette.Save(); err != nil {
+			p.logger.warn("proxy", "save cassette failed", map[string]any{"error": err.Error()})
+		}
+	}
+	st := p.server.Stats()
+	report := &ProxyReport{
+		Mode:           string(p.mode),
+		Cassette:       p.path,
+		CACert:         p.ca.CertPath,
+		Interactions:   p.cassette.Len(),
+		Recorded:       st.Recorded,
+		Replayed:       st.Replayed,
+		Tunneled:       st.Tunneled,
+		Errors:         st.Errors,
+		UnmatchedCount: st.UnmatchedCount,
+	}
+	for _, u := range st.Unmatched {
+		report.Unmatched = append(report.Unmatched, redactURLs(p.redact, u))
+	}
+	if st.UnmatchedCount > 0 {
+		p.logger.warn("proxy", "requests missing from cassette", map[string]any{"count": st.UnmatchedCount})
+	}
+	return report
+}
+
+// cassetteRedactor applies the run's redaction rules to recorded exchanges,
+// so cassettes hold no more credentials than the network log.
+type cassetteRedactor struct{ r *redactor }
+
+func (c cassetteRedactor) URL(raw string) string                         { return c.r.url(raw) }
+func (c cassetteRedactor) Headers(h map[string]string) map[string]string { return c.r.headerMap(h) }
+func (c cassetteRedactor) Body(text string) string                       { return c.r.bodyText(text) }
+
+// redactURLs redacts every URL-looking word in s, e.g. "GET https://...".
+func redactURLs(r *redactor, s string) string {
+	words := strings.Split(s, " ")
+	for i, w := range words {
+		if strings.Contains(w, "://") {
+			words[i] = r.url(w)
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,296 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// MockSpec configures a mock or fault step. The step's Target is the URL glob
+// or /regex/ to intercept; for fault steps Value names the fault.
+type MockSpec struct {
+	Method      string            `json:"method,omitempty"`       // only intercept this method
+	Status      int               `json:"status,omitempty"`       // mock default 200; fault "status" default 500
+	Body        string            `json:"body,omitempty"`         // inline response body
+	JSON        json.RawMessage   `json:"json,omitempty"`         // inline JSON body; sets the content type
+	Fixture     string            `json:"fixture,omitempty"`      // file whose contents become the body
+	ContentType string            `json:"content_type,omitempty"` // overrides the inferred content type
+	Headers     map[string]string `json:"headers,omitempty"`
+	LatencyMS   int               `json:"latency_ms,omitempty"` // delay before responding (or continuing, for faults)
+	Times       int               `json:"times,omitempty"`      // stop intercepting after this many hits; 0 means unlimited
+	Error       string            `json:"error,omitempty"`      // network error for fault "abort"; default "failed"
+}
+
+// MockRecord is a mock or fault installed during the run, with its hit count.
+type MockRecord struct {
+	Step    int    `json:"step"`
+	Action  string `json:"action"` // mock or fault
+	URL     string `json:"url"`
+	Fault   string `json:"fault,omitempty"`
+	Hits    int    `json:"hits"`
+	Removed bool   `json:"removed,omitempty"`
+}
+
+// Fault kinds accepted by the fault step.
+const (
+	faultAbort       = "abort"        // fail the request with a network error
+	faultStatus      = "status"       // answer with an error status and no body
+	faultCorruptJSON = "corrupt-json" // fetch the real response and truncate its body
+	faultLatency     = "latency"      // delay, then let the request through
+)
+
+// abortErrors are the error codes Route.Abort accepts.
+var abortErrors = map[string]bool{
+	"aborted": true, "accessdenied": true, "addressunreachable": true, "blockedbyclient": true,
+	"blockedbyresponse": true, "connectionaborted": true, "connectionclosed": true, "connectionfailed": true,
+	"connectionrefused": true, "connectionreset": true, "internetdisconnected": true, "namenotresolved": true,
+	"timedout": true, "failed": true,
+}
+
+// mockRegistry installs step-defined routes on the context. Routes added
+// later take precedence, and requests a mock declines fall back to earlier
+// handlers such as the sandbox or HAR replay.
+type mockRegistry struct {
+	ctx    playwright.BrowserContext
+	logger *ndjsonLogger
+	mu     sync.Mutex
+	routes []*mockRoute
+
+	// Earlier handlers in the route chain. corrupt-json fetches the real
+	// response itself, so it must apply their policy first.
+	sandbox  *networkSandbox
+	replayer *harReplayer
+}
+
+type mockRoute struct {
+	record  MockRecord
+	pattern *regexp.Regexp
+}
+
+func newMockRegistry(ctx playwright.BrowserContext, logger *ndjsonLogger) *mockRegistry {
+	return &mockRegistry{ctx: ctx, logger: logger}
+}
+
+// add validates spec and routes matching requests to a mock or fault handler.
+func (m *mockRegistry) add(step int, action, target, fault string, spec MockSpec) error {
+	if target == "" {
+		return fmt.Errorf("%s needs a URL pattern in target", action)
+	}
+	matcher, err := newURLMatcher(target)
+	if err != nil {
+		return err
+	}
+	var handle func(playwright.Route) error
+	switch action {
+	case "mock":
+		body, contentType, err := mockBody(spec)
+		if err != nil {
+			return err
+		}
+		status := spec.Status
+		if status == 0 {
+			status = 200
+		}
+		handle = func(r playwright.Route) error {
+			return r.Fulfill(playwright.RouteFulfillOptions{Status: playwright.Int(status), Body: body, ContentType: playwright.String(contentType), Headers: spec.Headers})
+		}
+	case "fault":
+		if handle, err = m.faultHandler(fault, spec); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown mock action %q", action)
+	}
+
+	mr := &mockRoute{record: MockRecord{Step: step, Action: action, URL: target, Fault: fault}, pattern: matcher.re}
+	handler := func(r playwright.Route) {
+		m.mu.Lock()
+		active := !mr.record.Removed && (spec.Method == "" || strings.EqualFold(spec.Method, r.Request().Method()))
+		if active {
+			mr.record.Hits++
+		}
+		m.mu.Unlock()
+		if !active {
+			_ = r.Fallback()
+			return
+		}
+		if spec.LatencyMS > 0 {
+			time.Sleep(time.Duration(spec.LatencyMS) * time.Millisecond)
+		}
+		if err := handle(r); err != nil {
+			m.logger.warn("mock", action+" handler failed", map[string]any{"url": r.Request().URL(), "error": err.Error()})
+			_ = r.Fallback()
+		}
+	}
+	var times []int
+	if spec.Times > 0 {
+		times = append(times, spec.Times)
+	}
+	if err := m.ctx.Route(matcher.re, handler, times...); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.routes = append(m.routes, mr)
+	m.mu.Unlock()
+	return nil
+}
+
+// remove drops every mock and fault on target, or all of them when target is
+// empty. Removed handlers stay routed but fall through: Unroute would also
+// drop the sandbox, HAR replay or other mocks sharing the pattern, and
+// playwright-go cannot single out one closure among several.
+func (m *mockRegistry) remove(target string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	removed := 0
+	for _, mr := range m.routes {
+		if mr.record.Removed || (target != "" && mr.record.URL != target) {
+			continue
+		}
+		mr.record.Removed = true
+		removed++
+	}
+	if removed == 0 {
+		if target == "" {
+			return errors.New("no mocks installed")
+		}
+		return fmt.Errorf("no mock installed for %s", target)
+	}
+	return nil
+}
+
+func (m *mockRegistry) snapshot() []MockRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []MockRecord
+	for _, mr := range m.routes {
+		out = append(out, mr.record)
+	}
+	return out
+}
+
+// mockBody picks the body from JSON, Body or Fixture and infers a content type.
+func mockBody(spec MockSpec) ([]byte, string, error) {
+	set := 0
+	for _, ok := range []bool{len(spec.JSON) > 0, spec.Body != "", spec.Fixture != ""} {
+		if ok {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, "", errors.New("mock takes only one of json, body or fixture")
+	}
+	contentType := spec.ContentType
+	var body []byte
+	switch {
+	case len(spec.JSON) > 0:
+		body = spec.JSON
+		if contentType == "" {
+			contentType = "application/json"
+		}
+	case spec.Fixture != "":
+		b, err := os.ReadFile(spec.Fixture)
+		if err != nil {
+			return nil, "", fmt.Errorf("mock fixture: %w", err)
+		}
+		body = b
+		if contentType == "" && strings.HasSuffix(strings.ToLower(spec.Fixture), ".json") {
+			contentType = "application/json"
+		}
+	default:
+		body = []byte(spec.Body)
+	}
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	return body, contentType, nil
+}
+
+func (m *mockRegistry) faultHandler(fault string, spec MockSpec) (func(playwright.Route) error, error) {
+	switch fault {
+	case faultAbort:
+		code := spec.Error
+		if code == "" {
+			code = "failed"
+		}
+		if !abortErrors[code] {
+			return nil, fmt.Errorf("unknown network error %q", code)
+		}
+		return func(r playwright.Route) error { return r.Abort(code) }, nil
+	case faultStatus:
+		status := spec.Status
+		if status == 0 {
+			status = 500
+		}
+		if status < 400 {
+			return nil, fmt.Errorf("fault status %d is not an error status", status)
+		}
+		return func(r playwright.Route) error {
+			return r.Fulfill(playwright.RouteFulfillOptions{Status: playwright.Int(status), Body: spec.Body, Headers: spec.Headers})
+		}, nil
+	case faultCorruptJSON:
+		return m.corruptUpstream, nil
+	case faultLatency:
+		if spec.LatencyMS <= 0 {
+			return nil, errors.New("fault latency needs latency_ms")
+		}
+		return func(r playwright.Route) error { return r.Fallback() }, nil
+	case "":
+		return nil, errors.New("fault needs a kind in value: abort, status, corrupt-json or latency")
+	default:
+		return nil, fmt.Errorf("unknown fault %q", fault)
+	}
+}
+
+// corruptUpstream answers r with a truncated copy of the response it would
+// otherwise get. Route.Fetch goes straight to the network, so requests the
+// sandbox blocks or strict HAR replay refuses fall back to those handlers,
+// and recorded responses are corrupted instead of live ones.
+func (m *mockRegistry) corruptUpstream(r playwright.Route) error {
+	req := r.Request()
+	if m.sandbox != nil {
+		if host := entryHost(req.URL()); host != "" && !m.sandbox.allowed(host) {
+			return r.Fallback()
+		}
+	}
+	if m.replayer != nil {
+		e, ok := m.replayer.lookup(req)
+		switch {
+		case ok && e.Response.Status == 0:
+			m.replayer.markServed()
+			return r.Abort("failed") // a recorded network failure has no body to corrupt
+		case ok:
+			headers, body, err := m.replayer.response(e)
+			if err != nil {
+				return err
+			}
+			m.replayer.markServed()
+			return r.Fulfill(playwright.RouteFulfillOptions{Status: playwright.Int(e.Response.Status), Headers: headers, Body: corruptJSON(body)})
+		case m.replayer.strict:
+			return r.Fallback()
+		}
+	}
+	resp, err := r.Fetch()
+	if err != nil {
+		return err
+	}
+	body, err := resp.Body()
+	if err != nil {
+		return err
+	}
+	return r.Fulfill(playwright.RouteFulfillOptions{Response: resp, Body: corruptJSON(body)})
+}
+
+// corruptJSON cuts a body in half and leaves an unterminated string, so any
+// JSON parser fails while the response still looks plausible on the wire.
+func corruptJSON(body []byte) []byte {
+	out := append([]byte(nil), body[:len(body)/2]...)
+	return append(out, []byte(`,"\u0000`)...)
+}
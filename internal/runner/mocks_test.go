@@ -0,0 +1,187 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"philadelphia/internal/har"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+func TestMockBody(t *testing.T) {
+	body, ct, err := mockBody(MockSpec{JSON: json.RawMessage(`{"id":1}`)})
+	if err != nil || string(body) != `{"id":1}` || ct != "application/json" {
+		t.Fatalf("json body = %q %q %v", body, ct, err)
+	}
+
+	fixture := filepath.Join(t.TempDir(), "user.json")
+	if err := os.WriteFile(fixture, []byte(`{"name":"x"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	body, ct, err = mockBody(MockSpec{Fixture: fixture})
+	if err != nil || string(body) != `{"name":"x"}` || ct != "application/json" {
+		t.Fatalf("fixture body = %q %q %v", body, ct, err)
+	}
+
+	if _, ct, _ = mockBody(MockSpec{Body: "<p>hi</p>", ContentType: "text/html"}); ct != "text/html" {
+		t.Fatalf("explicit content type = %q", ct)
+	}
+	if _, _, err = mockBody(MockSpec{Body: "a", JSON: json.RawMessage(`1`)}); err == nil {
+		t.Fatal("expected error for body and json together")
+	}
+}
+
+func TestFaultHandlerValidation(t *testing.T) {
+	for _, tc := range []struct {
+		fault string
+		spec  MockSpec
+		ok    bool
+	}{
+		{faultAbort, MockSpec{}, true},
+		{faultAbort, MockSpec{Error: "connectionreset"}, true},
+		{faultAbort, MockSpec{Error: "boom"}, false},
+		{faultStatus, MockSpec{}, true},
+		{faultStatus, MockSpec{Status: 302}, false},
+		{faultCorruptJSON, MockSpec{}, true},
+		{faultLatency, MockSpec{}, false},
+		{faultLatency, MockSpec{LatencyMS: 500}, true},
+		{"", MockSpec{}, false},
+		{"explode", MockSpec{}, false},
+	} {
+		_, err := (&mockRegistry{}).faultHandler(tc.fault, tc.spec)
+		if (err == nil) != tc.ok {
+			t.Errorf("faultHandler(%q, %+v) err = %v", tc.fault, tc.spec, err)
+		}
+	}
+}
+
+func TestCorruptJSON(t *testing.T) {
+	var v any
+	if err := json.Unmarshal(corruptJSON([]byte(`{"items":[1,2,3],"ok":true}`)), &v); err == nil {
+		t.Fatal("corrupted body still parses")
+	}
+	if err := json.Unmarshal(corruptJSON(nil), &v); err == nil {
+		t.Fatal("corrupted empty body still parses")
+	}
+}
+
+type requestStub struct {
+	playwright.Request
+	method, url string
+}
+
+func (r *requestStub) Method() string                  { return r.method }
+func (r *requestStub) URL() string                     { return r.url }
+func (r *requestStub) PostDataBuffer() ([]byte, error) { return nil, nil }
+
+// routeStub records what a handler did with the route.
+type routeStub struct {
+	playwright.Route
+	req    *requestStub
+	calls  []string
+	status int
+	body   []byte
+}
+
+func (r *routeStub) Request() playwright.Request { return r.req }
+func (r *routeStub) Fallback(...playwright.RouteFallbackOptions) error {
+	r.calls = append(r.calls, "fallback")
+	return nil
+}
+func (r *routeStub) Abort(code ...string) error {
+	r.calls = append(r.calls, "abort")
+	return nil
+}
+func (r *routeStub) Fetch(...playwright.RouteFetchOptions) (playwright.APIResponse, error) {
+	r.calls = append(r.calls, "fetch")
+	return nil, errors.New("offline")
+}
+func (r *routeStub) Fulfill(opts ...playwright.RouteFulfillOptions) error {
+	r.calls = append(r.calls, "fulfill")
+	r.status = *opts[0].Status
+	r.body, _ = opts[0].Body.([]byte)
+	return nil
+}
+
+func TestCorruptJSONRespectsUpstreamPolicy(t *testing.T) {
+	logger := &ndjsonLogger{w: bufio.NewWriter(io.Discard)}
+	red, _ := newRedactor(RedactionRules{})
+	recorded := &har.File{Log: har.Log{Entries: []har.Entry{{
+		Request:  har.Request{Method: "GET", URL: "https://a.test/api"},
+		Response: har.Response{Status: 200, Content: har.Content{Text: `{"items":[1,2,3]}`}},
+	}}}}
+	replayer := func(strict bool) *harReplayer {
+		return &harReplayer{index: har.NewIndex(recorded, har.MatchURL), strict: strict, redact: red, logger: logger}
+	}
+	for _, tc := range []struct {
+		name string
+		m    *mockRegistry
+		url  string
+		want string
+	}{
+		{"sandbox blocks", &mockRegistry{sandbox: newNetworkSandbox([]string{"a.test"}, nil, red, logger)}, "https://evil.test/api", "fallback"},
+		{"sandbox allows", &mockRegistry{sandbox: newNetworkSandbox([]string{"a.test"}, nil, red, logger)}, "https://a.test/api", "fetch"},
+		{"replay hit", &mockRegistry{replayer: replayer(true)}, "https://a.test/api", "fulfill"},
+		{"strict replay miss", &mockRegistry{replayer: replayer(true)}, "https://a.test/other", "fallback"},
+		{"lenient replay miss", &mockRegistry{replayer: replayer(false)}, "https://a.test/other", "fetch"},
+	} {
+		r := &routeStub{req: &requestStub{method: "GET", url: tc.url}}
+		_ = tc.m.corruptUpstream(r)
+		if got := strings.Join(r.calls, ","); got != tc.want {
+			t.Errorf("%s: calls = %s, want %s", tc.name, got, tc.want)
+		}
+		if tc.want == "fulfill" {
+			var v any
+			if r.status != 200 || json.Unmarshal(r.body, &v) == nil {
+				t.Errorf("%s: fulfilled %d %q, want a corrupted recorded body", tc.name, r.status, r.body)
+			}
+		}
+	}
+}
+
+// routeContext captures routes registered on a context.
+type routeContext struct {
+	playwright.BrowserContext
+	handlers []func(playwright.Route)
+}
+
+func (c *routeContext) Route(url interface{}, handler func(playwright.Route), times ...int) error {
+	c.handlers = append(c.handlers, handler)
+	return nil
+}
+
+func TestMockRemoveOnlyDisablesMatchingHandlers(t *testing.T) {
+	ctx := &routeContext{}
+	m := newMockRegistry(ctx, &ndjsonLogger{w: bufio.NewWriter(io.Discard)})
+	for _, target := range []string{"**/api", "**/api", "**/img"} {
+		if err := m.add(1, "mock", target, "", MockSpec{Body: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.remove("**/api"); err != nil {
+		t.Fatal(err)
+	}
+	urls := []string{"https://a.test/api", "https://a.test/api", "https://a.test/img"}
+	want := []string{"fallback", "fallback", "fulfill"}
+	for i, h := range ctx.handlers {
+		r := &routeStub{req: &requestStub{method: "GET", url: urls[i]}}
+		h(r)
+		if got := strings.Join(r.calls, ","); got != want[i] {
+			t.Errorf("handler %d: calls = %s, want %s", i, got, want[i])
+		}
+	}
+	recs := m.snapshot()
+	if !recs[0].Removed || !recs[1].Removed || recs[2].Removed || recs[2].Hits != 1 || recs[0].Hits != 0 {
+		t.Fatalf("records = %+v", recs)
+	}
+	if err := m.remove("**/api"); err == nil {
+		t.Fatal("removing twice should fail")
+	}
+}
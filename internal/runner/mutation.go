@@ -0,0 +1,477 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// mutationBinding receives batches of mutation records from the page.
+const mutationBinding = "__labMutations"
+
+// mutationSourceURL names the observer in stack traces so its own frames are
+// skipped when attributing a mutation.
+const mutationSourceURL = "lab-mutations.js"
+
+// maxMutationJournal bounds the lines written to mutations.ndjson; counts,
+// hot spots and assertions still see every mutation.
+const maxMutationJournal = 100_000
+
+// maxMutationHotSpots is how many of the most-mutated elements the manifest lists.
+const maxMutationHotSpots = 10
+
+// mutationParser is the source of unattributed mutations made while the
+// document is still loading. Besides the initiator kinds, mutations made
+// outside a patched DOM API after that are unknown.
+const mutationParser = "parser"
+
+// MutationRecord is one line of mutations.ndjson.
+type MutationRecord struct {
+	Seq    int     `json:"seq"`
+	TimeMS float64 `json:"t"`               // since the journal started; batched, so accurate to ~100ms
+	Type   string  `json:"type"`            // added, removed, attribute, style or text
+	Target string  `json:"target"`          // the element changed; the parent for added and removed
+	Node   string  `json:"node,omitempty"`  // the node added or removed
+	Attr   string  `json:"attr,omitempty"`  // attribute name for attribute changes
+	Old    string  `json:"old,omitempty"`   // previous attribute value or text
+	Value  string  `json:"value,omitempty"` // new attribute value or text
+	Source string  `json:"source"`          // userscript, page, parser or unknown
+	Frame  string  `json:"frame,omitempty"` // URL of the frame, when not the top one
+}
+
+// MutationHotSpot is an element that was mutated often.
+type MutationHotSpot struct {
+	Target     string `json:"target"`
+	Count      int    `json:"count"`
+	Userscript int    `json:"userscript,omitempty"` // how many of Count the userscript made
+}
+
+// MutationSummary is the manifest's view of the journal.
+type MutationSummary struct {
+	Journal       string            `json:"journal"` // artifact-relative path of mutations.ndjson
+	Total         int               `json:"total"`
+	ByType        map[string]int    `json:"by_type"`
+	BySource      map[string]int    `json:"by_source"`
+	PeakPerSecond int               `json:"peak_per_second"`
+	HotSpots      []MutationHotSpot `json:"hot_spots,omitempty"`
+	Truncated     bool              `json:"truncated,omitempty"` // journal stopped at maxMutationJournal lines
+}
+
+// mutationObserverJS runs before the userscript in every frame. A
+// MutationObserver records each change; the DOM APIs scripts mutate through
+// are wrapped so the records they produce can be taken synchronously and
+// attributed by the caller's stack. Changes made some other way arrive in the
+// observer callback without a stack.
+const mutationObserverJS = `(() => {
+  const sink = window[%[1]q];
+  if (!sink || window.__labMutationFlush) return;
+  const SCRIPT = %[2]q, SELF = %[3]q;
+  const setT = window.setTimeout.bind(window);
+  const frame = window === window.top ? '' : location.href;
+  let queue = [], timer = 0, pending = Promise.resolve(), depth = 0;
+
+  const label = n => {
+    if (!n) return '';
+    if (n.nodeType === 3) return '#text';
+    if (n.nodeType !== 1) return n.nodeName.toLowerCase();
+    let s = n.localName;
+    if (n.id) return s + '#' + n.id;
+    const cls = typeof n.className === 'string' ? n.className.trim().split(/\s+/).filter(Boolean).slice(0, 2) : [];
+    if (cls.length) s += '.' + cls.join('.');
+    return s;
+  };
+  const selector = n => {
+    const parts = [];
+    for (let e = n; e && e.nodeType === 1 && parts.length < 5; e = e.parentElement) {
+      parts.unshift(label(e));
+      if (e.id) break;
+    }
+    return parts.join(' > ') || label(n);
+  };
+  const clip = v => v == null ? '' : String(v).slice(0, 200);
+  const stackSource = () => {
+    const limit = Error.stackTraceLimit;
+    Error.stackTraceLimit = 50;
+    const stack = new Error().stack || '';
+    Error.stackTraceLimit = limit;
+    let page = false;
+    for (const line of stack.split('\n')) {
+      if (line.includes(SELF)) continue;
+      if (line.includes(SCRIPT) || line.includes('chrome-extension://') || line.includes('moz-extension://')) return 'userscript';
+      if (/(https?|file|blob):/.test(line)) page = true;
+    }
+    return page ? 'page' : 'unknown';
+  };
+
+  const send = () => {
+    timer = 0;
+    if (!queue.length) return pending;
+    const batch = queue;
+    queue = [];
+    pending = Promise.resolve(sink(batch)).catch(() => {});
+    return pending;
+  };
+  const push = r => {
+    if (frame) r.frame = frame;
+    queue.push(r);
+    if (queue.length >= 2000) send();
+    else if (!timer) timer = setT(send, 100);
+  };
+  const record = (recs, source) => {
+    for (const m of recs) {
+      const target = selector(m.target);
+      if (m.type === 'childList') {
+        for (const n of m.addedNodes) push({type: 'added', target, node: label(n), source});
+        for (const n of m.removedNodes) push({type: 'removed', target, node: label(n), source});
+      } else if (m.type === 'attributes') {
+        const value = m.target.getAttribute(m.attributeName);
+        push({type: m.attributeName === 'style' ? 'style' : 'attribute', target, attr: m.attributeName, old: clip(m.oldValue), value: clip(value), source});
+      } else {
+        push({type: 'text', target: selector(m.target.parentNode), old: clip(m.oldValue), value: clip(m.target.data), source});
+      }
+    }
+  };
+  const unattributed = () => document.readyState === 'loading' ? %[4]q : 'unknown';
+  const observer = new MutationObserver(recs => record(recs, unattributed()));
+  observer.observe(document, {subtree: true, childList: true, attributes: true, attributeOldValue: true, characterData: true, characterDataOldValue: true});
+
+  const run = (fn, self, args) => {
+    if (depth > 0) return fn.apply(self, args);
+    const before = observer.takeRecords();
+    if (before.length) record(before, unattributed());
+    depth++;
+    try {
+      return fn.apply(self, args);
+    } finally {
+      depth--;
+      const recs = observer.takeRecords();
+      if (recs.length) record(recs, stackSource());
+    }
+  };
+  const wrapMethods = (proto, names) => {
+    for (const name of names) {
+      const d = proto && Object.getOwnPropertyDescriptor(proto, name);
+      if (!d || typeof d.value !== 'function' || !d.configurable) continue;
+      const orig = d.value;
+      Object.defineProperty(proto, name, {...d, value: {[name](...args) { return run(orig, this, args); }}[name]});
+    }
+  };
+  const wrapSetters = (proto, names) => {
+    for (const name of names || Object.getOwnPropertyNames(proto)) {
+      const d = proto && Object.getOwnPropertyDescriptor(proto, name);
+      if (!d || !d.set || !d.configurable) continue;
+      const set = d.set;
+      Object.defineProperty(proto, name, {...d, set(v) { run(set, this, [v]); }});
+    }
+  };
+  wrapMethods(Node.prototype, ['appendChild', 'insertBefore', 'removeChild', 'replaceChild']);
+  wrapSetters(Node.prototype, ['textContent', 'nodeValue']);
+  wrapMethods(Element.prototype, ['setAttribute', 'setAttributeNS', 'removeAttribute', 'removeAttributeNS', 'toggleAttribute',
+    'append', 'prepend', 'before', 'after', 'replaceWith', 'replaceChildren', 'remove', 'insertAdjacentHTML', 'insertAdjacentElement', 'insertAdjacentText']);
+  wrapSetters(Element.prototype, ['innerHTML', 'outerHTML', 'className', 'id']);
+  wrapMethods(CharacterData.prototype, ['appendData', 'replaceData', 'insertData', 'deleteData', 'before', 'after', 'replaceWith', 'remove']);
+  wrapSetters(CharacterData.prototype, ['data']);
+  wrapSetters(HTMLElement.prototype, ['innerText', 'outerText', 'hidden', 'title', 'dir', 'lang']);
+  wrapMethods(DOMTokenList.prototype, ['add', 'remove', 'toggle', 'replace']);
+  wrapSetters(DOMTokenList.prototype, ['value']);
+  for (let p = Object.getPrototypeOf(document.createElement('div').style); p && p !== Object.prototype; p = Object.getPrototypeOf(p)) {
+    wrapMethods(p, ['setProperty', 'removeProperty']);
+    wrapSetters(p);
+  }
+
+  Object.defineProperty(window, '__labMutationFlush', {value: () => {
+    const recs = observer.takeRecords();
+    if (recs.length) record(recs, unattributed());
+    return send();
+  }});
+})();
+//# sourceURL=` + mutationSourceURL
+
+// wantsMutationJournal reports whether a run records mutations: when asked
+// to, or when a step asserts on them.
+func wantsMutationJournal(opts Options) bool {
+	if opts.Mutations {
+		return true
+	}
+	for _, step := range opts.Steps {
+		if strings.EqualFold(step.Action, "assert-mutations") {
+			return true
+		}
+	}
+	return false
+}
+
+// mutationJournal receives record batches from every frame, streams them to
+// mutations.ndjson and keeps the counts the manifest and assertions use.
+type mutationJournal struct {
+	mu        sync.Mutex
+	start     time.Time
+	file      *os.File
+	out       *bufio.Writer
+	path      string
+	written   int
+	total     int
+	byType    map[string]int
+	bySource  map[string]int
+	targets   map[string]*MutationHotSpot
+	perSource map[string]map[string]int // source -> target -> count
+	perSecond map[string]map[int64]int  // source ("" for all) -> second -> count
+	closed    sync.Once
+	stopped   bool // set by close; later batches are dropped
+	summary   *MutationSummary
+}
+
+func newMutationJournal(path string) (*mutationJournal, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &mutationJournal{
+		start:     time.Now(),
+		file:      f,
+		out:       bufio.NewWriter(f),
+		path:      path,
+		byType:    map[string]int{},
+		bySource:  map[string]int{},
+		targets:   map[string]*MutationHotSpot{},
+		perSource: map[string]map[string]int{},
+		perSecond: map[string]map[int64]int{},
+	}, nil
+}
+
+// install exposes the binding and adds the observer. It must run before the
+// userscript is added so the observer's wrappers are in place first.
+func (j *mutationJournal) install(ctx playwright.BrowserContext) error {
+	if err := ctx.ExposeBinding(mutationBinding, func(_ *playwright.BindingSource, args ...any) any {
+		if len(args) == 0 {
+			return nil
+		}
+		b, err := json.Marshal(args[0])
+		if err != nil {
+			return nil
+		}
+		var batch []MutationRecord
+		if json.Unmarshal(b, &batch) == nil {
+			j.add(batch)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("expose mutation binding: %w", err)
+	}
+	script := fmt.Sprintf(mutationObserverJS, mutationBinding, userscriptSourceURL, mutationSourceURL, mutationParser)
+	if err := ctx.AddInitScript(playwright.Script{Content: playwright.String(script)}); err != nil {
+		return fmt.Errorf("inject mutation observer: %w", err)
+	}
+	return nil
+}
+
+// add records a batch as it arrives from the page.
+func (j *mutationJournal) add(batch []MutationRecord) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stopped {
+		return
+	}
+	elapsed := time.Since(j.start)
+	sec := int64(elapsed / time.Second)
+	for _, r := range batch {
+		j.total++
+		r.Seq, r.TimeMS = j.total, float64(elapsed.Microseconds())/1000
+		if r.Source == "" {
+			r.Source = initiatorUnknown
+		}
+		j.byType[r.Type]++
+		j.bySource[r.Source]++
+		for _, s := range []string{"", r.Source} {
+			if j.perSecond[s] == nil {
+				j.perSecond[s] = map[int64]int{}
+			}
+			j.perSecond[s][sec]++
+		}
+		h := j.targets[r.Target]
+		if h == nil {
+			h = &MutationHotSpot{Target: r.Target}
+			j.targets[r.Target] = h
+		}
+		h.Count++
+		if j.perSource[r.Source] == nil {
+			j.perSource[r.Source] = map[string]int{}
+		}
+		j.perSource[r.Source][r.Target]++
+		if r.Source == initiatorUserscript {
+			h.Userscript++
+		}
+		if j.written < maxMutationJournal {
+			b, _ := json.Marshal(r)
+			j.out.Write(b)
+			j.out.WriteByte('\n')
+			j.written++
+		}
+	}
+}
+
+// flush asks the page to hand over records it has not sent yet and waits
+// until they have been received.
+func (j *mutationJournal) flush(page playwright.Page) error {
+	_, err := page.Evaluate(`() => window.__labMutationFlush ? window.__labMutationFlush() : null`)
+	return err
+}
+
+// count returns the mutations from source ("" for all) and the most in any
+// one second.
+func (j *mutationJournal) count(source string) (total, peak int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if source == "" {
+		total = j.total
+	} else {
+		total = j.bySource[source]
+	}
+	for _, n := range j.perSecond[source] {
+		peak = max(peak, n)
+	}
+	return total, peak
+}
+
+// hotSpots lists the most-mutated elements, most first.
+func (j *mutationJournal) hotSpots(n int) []MutationHotSpot {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := make([]MutationHotSpot, 0, len(j.targets))
+	for _, h := range j.targets {
+		out = append(out, *h)
+	}
+	sort.Slice(out, func(a, b int) bool {
+		if out[a].Count != out[b].Count {
+			return out[a].Count > out[b].Count
+		}
+		return out[a].Target < out[b].Target
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// hottest returns the element source ("" for all) mutated most, ties going to
+// the first target in order.
+func (j *mutationJournal) hottest(source string) (string, int) {
+	if source == "" {
+		if h := j.hotSpots(1); len(h) > 0 {
+			return h[0].Target, h[0].Count
+		}
+		return "", 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var target string
+	var n int
+	for t, c := range j.perSource[source] {
+		if c > n || (c == n && t < target) {
+			target, n = t, c
+		}
+	}
+	return target, n
+}
+
+// close writes out the journal and returns the summary. It is safe to call
+// more than once.
+func (j *mutationJournal) close() *MutationSummary {
+	j.closed.Do(func() {
+		hot := j.hotSpots(maxMutationHotSpots)
+		_, peak := j.count("")
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.stopped = true
+		j.out.Flush()
+		j.file.Close()
+		j.summary = &MutationSummary{
+			Journal:       "mutations.ndjson",
+			Total:         j.total,
+			ByType:        j.byType,
+			BySource:      j.bySource,
+			PeakPerSecond: peak,
+			HotSpots:      hot,
+			Truncated:     j.written < j.total,
+		}
+	})
+	return j.summary
+}
+
+// mutationLimit is the parsed value of an assert-mutations step, e.g.
+// "max=500 per-second=100 source=userscript".
+type mutationLimit struct {
+	Max       int    // total mutations; noMutationLimit when unset
+	PerSecond int    // mutations in any one second; noMutationLimit when unset
+	Source    string // userscript, page, parser or unknown; empty counts all
+}
+
+// noMutationLimit leaves a bound of mutationLimit unchecked, so that max=0
+// ("must not touch the DOM") is a limit like any other.
+const noMutationLimit = -1
+
+func parseMutationLimit(v string) (mutationLimit, error) {
+	l := mutationLimit{Max: noMutationLimit, PerSecond: noMutationLimit}
+	for _, field := range strings.FieldsFunc(v, func(r rune) bool { return r == ' ' || r == ',' }) {
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return l, fmt.Errorf("assert-mutations: %q is not key=value", field)
+		}
+		switch strings.ToLower(key) {
+		case "max", "per-second":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				return l, fmt.Errorf("assert-mutations: %s must be a non-negative integer, got %q", key, val)
+			}
+			if strings.EqualFold(key, "max") {
+				l.Max = n
+			} else {
+				l.PerSecond = n
+			}
+		case "source":
+			switch val = strings.ToLower(val); val {
+			case "all", "any":
+				l.Source = ""
+			case initiatorUserscript, initiatorPage, mutationParser, initiatorUnknown:
+				l.Source = val
+			default:
+				return l, fmt.Errorf("assert-mutations: unknown source %q (want userscript, page, parser, unknown or all)", val)
+			}
+		default:
+			return l, fmt.Errorf("assert-mutations: unknown key %q (want max, per-second or source)", key)
+		}
+	}
+	if l.Max == noMutationLimit && l.PerSecond == noMutationLimit {
+		return l, errors.New("assert-mutations needs max=N or per-second=N")
+	}
+	return l, nil
+}
+
+// check compares the journal so far against l.
+func (j *mutationJournal) check(l mutationLimit) error {
+	total, peak := j.count(l.Source)
+	who := "mutations"
+	if l.Source != "" {
+		who = l.Source + " mutations"
+	}
+	var hot string
+	if target, n := j.hottest(l.Source); n > 0 {
+		hot = fmt.Sprintf("; most mutated: %s (%d)", target, n)
+	}
+	if l.Max != noMutationLimit && total > l.Max {
+		return fmt.Errorf("%d %s, max %d%s", total, who, l.Max, hot)
+	}
+	if l.PerSecond != noMutationLimit && peak > l.PerSecond {
+		return fmt.Errorf("%d %s in one second, max %d%s", peak, who, l.PerSecond, hot)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseMutationLimit(t *testing.T) {
+	l, err := parseMutationLimit("max=500, per-second=50 source=userscript")
+	if err != nil || l != (mutationLimit{Max: 500, PerSecond: 50, Source: initiatorUserscript}) {
+		t.Fatalf("limit = %+v, %v", l, err)
+	}
+	if l, err := parseMutationLimit("max=10 source=all"); err != nil || l.Source != "" || l.PerSecond != noMutationLimit {
+		t.Fatalf("limit = %+v, %v", l, err)
+	}
+	if l, err := parseMutationLimit("max=0 source=userscript"); err != nil || l != (mutationLimit{Max: 0, PerSecond: noMutationLimit, Source: initiatorUserscript}) {
+		t.Fatalf("max=0 limit = %+v, %v", l, err)
+	}
+	if l, err := parseMutationLimit("per-second=0"); err != nil || l.PerSecond != 0 || l.Max != noMutationLimit {
+		t.Fatalf("per-second=0 limit = %+v, %v", l, err)
+	}
+	for _, bad := range []string{"", "source=page", "max=-1", "max=lots", "min=3", "max", "max=5 source=css"} {
+		if _, err := parseMutationLimit(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestMutationJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mutations.ndjson")
+	j, err := newMutationJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batch []MutationRecord
+	for i := 0; i < 30; i++ {
+		batch = append(batch, MutationRecord{Type: "style", Target: "div#feed", Attr: "style", Source: initiatorUserscript})
+	}
+	batch = append(batch,
+		MutationRecord{Type: "added", Target: "body", Node: "div.banner", Source: initiatorPage},
+		MutationRecord{Type: "added", Target: "body", Node: "p", Source: mutationParser},
+		MutationRecord{Type: "text", Target: "h1"},
+	)
+	j.add(batch)
+
+	if total, peak := j.count(""); total != 33 || peak != 33 {
+		t.Fatalf("count = %d, peak %d", total, peak)
+	}
+	if total, _ := j.count(initiatorUserscript); total != 30 {
+		t.Fatalf("userscript count = %d", total)
+	}
+	if err := j.check(mutationLimit{Max: 40, PerSecond: noMutationLimit}); err != nil {
+		t.Fatal(err)
+	}
+	err = j.check(mutationLimit{Max: 20, PerSecond: noMutationLimit, Source: initiatorUserscript})
+	if err == nil || !strings.Contains(err.Error(), "30 userscript mutations, max 20") || !strings.Contains(err.Error(), "div#feed (30)") {
+		t.Fatalf("check = %v", err)
+	}
+	if err := j.check(mutationLimit{Max: noMutationLimit, PerSecond: 10}); err == nil || !strings.Contains(err.Error(), "in one second") {
+		t.Fatalf("check = %v", err)
+	}
+	// max=0 is enforced, and the hottest element is the filtered source's.
+	err = j.check(mutationLimit{Max: 0, PerSecond: noMutationLimit, Source: initiatorPage})
+	if err == nil || !strings.Contains(err.Error(), "1 page mutations, max 0; most mutated: body (1)") {
+		t.Fatalf("check = %v", err)
+	}
+	if err := j.check(mutationLimit{Max: 0, PerSecond: noMutationLimit, Source: "none"}); err != nil {
+		t.Fatalf("check with no mutations = %v", err)
+	}
+
+	s := j.close()
+	if s != j.close() {
+		t.Fatal("close is not idempotent")
+	}
+	j.add([]MutationRecord{{Type: "text", Target: "h1"}}) // a late batch from a closing page
+	if total, _ := j.count(""); total != 33 {
+		t.Fatalf("batch after close was counted: total %d", total)
+	}
+	if s.Total != 33 || s.ByType["style"] != 30 || s.BySource[initiatorUnknown] != 1 || s.Truncated {
+		t.Fatalf("summary = %+v", s)
+	}
+	if len(s.HotSpots) != 3 || s.HotSpots[0] != (MutationHotSpot{Target: "div#feed", Count: 30, Userscript: 30}) || s.HotSpots[1].Target != "body" {
+		t.Fatalf("hot spots = %+v", s.HotSpots)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []MutationRecord
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var r MutationRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, r)
+	}
+	if len(lines) != 33 || lines[0].Seq != 1 || lines[32].Seq != 33 || lines[32].Source != initiatorUnknown {
+		t.Fatalf("journal has %d lines: first %+v", len(lines), lines[0])
+	}
+}
+
+func TestWantsMutationJournal(t *testing.T) {
+	for _, tc := range []struct {
+		opts Options
+		want bool
+	}{
+		{Options{}, false},
+		{Options{Steps: []Step{{Action: "click", Target: "#go"}}}, false},
+		{Options{Mutations: true}, true},
+		{Options{Steps: []Step{{Action: "Assert-Mutations", Value: "max=10"}}}, true},
+	} {
+		if got := wantsMutationJournal(tc.opts); got != tc.want {
+			t.Errorf("wantsMutationJournal(%+v) = %v, want %v", tc.opts, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxEvidence caps the offending requests attached to an assertion result.
+const maxEvidence = 5
+
+// NetworkAssertion checks captured traffic. URL selects requests (a glob such
+// as "**/api/**" or a /regex/); the remaining fields are the expectations.
+type NetworkAssertion struct {
+	URL         string   `json:"url,omitempty"`
+	Method      string   `json:"method,omitempty"`
+	Made        *bool    `json:"made,omitempty"`         // true: at least one match; false: none
+	Count       *int     `json:"count,omitempty"`        // exact number of matches
+	Status      int      `json:"status,omitempty"`       // every match returned this status
+	ContentType string   `json:"content_type,omitempty"` // every match's content type contains this
+	JSONField   string   `json:"json_field,omitempty"`   // dotted path present in some match's JSON body
+	JSONValue   string   `json:"json_value,omitempty"`   // optional expected value at JSONField
+	AllowHosts  []string `json:"allow_hosts,omitempty"`  // no hosts outside these (plus the target's) were contacted
+}
+
+// NetworkEvidence identifies a request that caused an assertion to fail.
+type NetworkEvidence struct {
+	ID     int    `json:"id"`
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Status int    `json:"status,omitempty"`
+	Frame  string `json:"frame,omitempty"`
+}
+
+// NetworkAssertionResult is the outcome of one NetworkAssertion.
+type NetworkAssertionResult struct {
+	Assertion NetworkAssertion  `json:"assertion"`
+	Passed    bool              `json:"passed"`
+	Message   string            `json:"message,omitempty"`
+	Evidence  []NetworkEvidence `json:"evidence,omitempty"`
+}
+
+// urlMatcher matches request URLs against a glob or /regex/.
+type urlMatcher struct {
+	re *regexp.Regexp
+}
+
+func newURLMatcher(pattern string) (*urlMatcher, error) {
+	if pattern == "" {
+		return &urlMatcher{}, nil
+	}
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("url regex %s: %w", pattern, err)
+		}
+		return &urlMatcher{re: re}, nil
+	}
+	return &urlMatcher{re: globToRegexp(pattern)}, nil
+}
+
+func (m *urlMatcher) match(u string) bool {
+	return m.re == nil || m.re.MatchString(u)
+}
+
+// URLFilter compiles a URL glob or /regex/ with the same rules as network
+// assertions and mocks, for tools outside the runner.
+func URLFilter(pattern string) (func(string) bool, error) {
+	m, err := newURLMatcher(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return m.match, nil
+}
+
+// globToRegexp converts a URL glob: "**" spans path segments, "*" stays
+// within one, "?" is a single character. Patterns without a scheme match any.
+func globToRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	if !strings.Contains(glob, "://") && !strings.HasPrefix(glob, "*") {
+		b.WriteString(".*")
+	}
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// hostMatches reports whether host equals pattern or is a subdomain of it.
+// A leading "*." restricts the match to subdomains only.
+func hostMatches(host, pattern string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "" || host == "" {
+		return false
+	}
+	if sub, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+sub)
+	}
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// entryHost returns the hostname of a network entry, or "" for non-network schemes.
+func entryHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ws" && u.Scheme != "wss") {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// needsBodies reports whether evaluating any assertion requires response bodies.
+func needsBodies(assertions []NetworkAssertion, steps []Step) bool {
+	for _, a := range assertions {
+		if a.JSONField != "" {
+			return true
+		}
+	}
+	for _, s := range steps {
+		if s.Network != nil && s.Network.JSONField != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateNetworkAssertion checks a against entries; targetHost is always allowed.
+func evaluateNetworkAssertion(a NetworkAssertion, entries []NetworkEntry, targetHost string) NetworkAssertionResult {
+	res := NetworkAssertionResult{Assertion: a, Passed: true}
+	fail := func(msg string, offending []NetworkEntry) NetworkAssertionResult {
+		res.Passed = false
+		res.Message = msg
+		for i, e := range offending {
+			if i == maxEvidence {
+				break
+			}
+			res.Evidence = append(res.Evidence, NetworkEvidence{ID: e.ID, Method: e.Method, URL: e.URL, Status: e.Status, Frame: e.Frame})
+		}
+		return res
+	}
+
+	if len(a.AllowHosts) > 0 {
+		var outside []NetworkEntry
+		for _, e := range entries {
+			host := entryHost(e.URL)
+			if host == "" || (targetHost != "" && hostMatches(host, targetHost)) {
+				continue
+			}
+			allowed := false
+			for _, p := range a.AllowHosts {
+				if hostMatches(host, p) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				outside = append(outside, e)
+			}
+		}
+		if len(outside) > 0 {
+			return fail(fmt.Sprintf("%d request(s) to hosts outside the allowlist", len(outside)), outside)
+		}
+	}
+
+	m, err := newURLMatcher(a.URL)
+	if err != nil {
+		return fail(err.Error(), nil)
+	}
+	var matches []NetworkEntry
+	for _, e := range entries {
+		if m.match(e.matchURL()) && (a.Method == "" || strings.EqualFold(a.Method, e.Method)) {
+			matches = append(matches, e)
+		}
+	}
+	desc := a.URL
+	if desc == "" {
+		desc = "any request"
+	}
+
+	if a.Made != nil {
+		if *a.Made && len(matches) == 0 {
+			return fail(fmt.Sprintf("expected a request matching %s; none was made", desc), nil)
+		}
+		if !*a.Made && len(matches) > 0 {
+			return fail(fmt.Sprintf("expected no request matching %s; saw %d", desc, len(matches)), matches)
+		}
+	}
+	if a.Count != nil && len(matches) != *a.Count {
+		return fail(fmt.Sprintf("expected %d request(s) matching %s; saw %d", *a.Count, desc, len(matches)), matches)
+	}
+	if (a.Status != 0 || a.ContentType != "" || a.JSONField != "") && len(matches) == 0 {
+		return fail(fmt.Sprintf("no request matching %s to check", desc), nil)
+	}
+	if a.Status != 0 {
+		var bad []NetworkEntry
+		for _, e := range matches {
+			if e.Status != a.Status {
+				bad = append(bad, e)
+			}
+		}
+		if len(bad) > 0 {
+			return fail(fmt.Sprintf("expected status %d for %s; %d response(s) differed", a.Status, desc, len(bad)), bad)
+		}
+	}
+	if a.ContentType != "" {
+		var bad []NetworkEntry
+		for _, e := range matches {
+			if !strings.Contains(strings.ToLower(e.ContentType), strings.ToLower(a.ContentType)) {
+				bad = append(bad, e)
+			}
+		}
+		if len(bad) > 0 {
+			return fail(fmt.Sprintf("expected content type %q for %s; %d response(s) differed", a.ContentType, desc, len(bad)), bad)
+		}
+	}
+	if a.JSONField != "" {
+		for _, e := range matches {
+			v, err := jsonPath(e.ResponseBody, a.JSONField)
+			if err != nil {
+				continue
+			}
+			if a.JSONValue == "" || jsonString(v) == a.JSONValue {
+				return res
+			}
+		}
+		want := a.JSONField
+		if a.JSONValue != "" {
+			want += "=" + a.JSONValue
+		}
+		return fail(fmt.Sprintf("no JSON response matching %s contained %s", desc, want), matches)
+	}
+	return res
+}
+
+// jsonPath walks a dotted path ("data.items.0.id") through a JSON document.
+func jsonPath(body, path string) (any, error) {
+	if body == "" {
+		return nil, errors.New("empty body")
+	}
+	var doc any
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		return nil, err
+	}
+	cur := doc
+	for _, key := range strings.Split(path, ".") {
+		switch node := cur.(type) {
+		case map[string]any:
+			v, ok := node[key]
+			if !ok {
+				return nil, fmt.Errorf("missing %q", key)
+			}
+			cur = v
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("bad index %q", key)
+			}
+			cur = node[i]
+		default:
+			return nil, fmt.Errorf("cannot descend into %q", key)
+		}
+	}
+	return cur, nil
+}
+
+func jsonString(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
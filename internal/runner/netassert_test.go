@@ -0,0 +1,92 @@
+package runner
+
+import "testing"
+
+func TestHostMatches(t *testing.T) {
+	cases := []struct {
+		host, pattern string
+		want          bool
+	}{
+		{"example.com", "example.com", true},
+		{"cdn.example.com", "example.com", true},
+		{"notexample.com", "example.com", false},
+		{"example.com.evil.net", "example.com", false},
+		{"example.com", "*.example.com", false},
+		{"a.example.com", "*.example.com", true},
+		{"EXAMPLE.com.", "example.com", true},
+	}
+	for _, c := range cases {
+		if got := hostMatches(c.host, c.pattern); got != c.want {
+			t.Errorf("hostMatches(%q, %q) = %v, want %v", c.host, c.pattern, got, c.want)
+		}
+	}
+}
+
+func TestURLMatcherGlobAndRegex(t *testing.T) {
+	glob, _ := newURLMatcher("**/api/*")
+	if !glob.match("https://example.com/api/save") || glob.match("https://example.com/api/v1/save") {
+		t.Fatal("glob * should stay within one path segment")
+	}
+	re, err := newURLMatcher(`/\/v\d+\/user$/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !re.match("https://example.com/v2/user") {
+		t.Fatal("regex should match")
+	}
+}
+
+func TestEvaluateNetworkAssertion(t *testing.T) {
+	entries := []NetworkEntry{
+		{ID: 1, Method: "GET", URL: "https://en.wikipedia.org/wiki/X", Status: 200, ContentType: "text/html"},
+		{ID: 2, Method: "POST", URL: "https://en.wikipedia.org/api/save", Status: 500, ContentType: "application/json", ResponseBody: `{"error":{"code":"E1"}}`},
+		{ID: 3, Method: "GET", URL: "https://tracker.notwikipedia.org/px", Status: 204},
+		{ID: 4, Method: "GET", URL: "data:image/png;base64,xx"},
+	}
+	yes, no, one := true, false, 1
+
+	tests := []struct {
+		name     string
+		a        NetworkAssertion
+		pass     bool
+		evidence []int
+	}{
+		{"made", NetworkAssertion{URL: "**/api/save", Made: &yes}, true, nil},
+		{"not made", NetworkAssertion{URL: "**/px", Made: &no}, false, []int{3}},
+		{"count", NetworkAssertion{URL: "**/api/**", Method: "POST", Count: &one}, true, nil},
+		{"status", NetworkAssertion{URL: "**/api/save", Status: 200}, false, []int{2}},
+		{"content type", NetworkAssertion{URL: "**/api/save", ContentType: "json"}, true, nil},
+		{"json field", NetworkAssertion{URL: "**/api/save", JSONField: "error.code", JSONValue: "E1"}, true, nil},
+		{"json field missing", NetworkAssertion{URL: "**/api/save", JSONField: "data"}, false, []int{2}},
+		{"allowlist", NetworkAssertion{AllowHosts: []string{"wikimedia.org"}}, false, []int{3}},
+		{"allowlist ok", NetworkAssertion{AllowHosts: []string{"notwikipedia.org"}}, true, nil},
+	}
+	for _, tt := range tests {
+		res := evaluateNetworkAssertion(tt.a, entries, "en.wikipedia.org")
+		if res.Passed != tt.pass {
+			t.Errorf("%s: passed = %v (%s), want %v", tt.name, res.Passed, res.Message, tt.pass)
+			continue
+		}
+		var ids []int
+		for _, e := range res.Evidence {
+			ids = append(ids, e.ID)
+		}
+		if len(ids) != len(tt.evidence) || (len(ids) > 0 && ids[0] != tt.evidence[0]) {
+			t.Errorf("%s: evidence = %v, want %v", tt.name, ids, tt.evidence)
+		}
+	}
+}
+
+func TestNetworkAssertionMatchesUnredactedURL(t *testing.T) {
+	raw := "https://example.com/api?b=1&token=abc&a=2"
+	entries := []NetworkEntry{{ID: 1, Method: "GET", URL: "https://example.com/api?a=2&b=1&token=%5BREDACTED%5D", Status: 200, rawURL: raw}}
+	for _, pattern := range []string{"**/api?b=1&token=*", "/token=abc/"} {
+		res := evaluateNetworkAssertion(NetworkAssertion{URL: pattern, Status: 500}, entries, "example.com")
+		if res.Passed || len(res.Evidence) != 1 {
+			t.Fatalf("%s: %+v, want a status failure with evidence", pattern, res)
+		}
+		if res.Evidence[0].URL != entries[0].URL {
+			t.Fatalf("%s: evidence URL = %q, want the redacted one", pattern, res.Evidence[0].URL)
+		}
+	}
+}
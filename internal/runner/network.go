@@ -0,0 +1,538 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// maxCapturedBody bounds request/response bodies written to network.ndjson.
+const maxCapturedBody = 64 << 10
+
+// redactedValue replaces scrubbed values in captured network data.
+const redactedValue = "[REDACTED]"
+
+// RedactionRules say what to scrub from captured network data before it is written.
+type RedactionRules struct {
+	Headers      []string `json:"headers,omitempty"`       // header names, case-insensitive
+	Cookies      []string `json:"cookies,omitempty"`       // cookie names masked in cookie/set-cookie; "*" masks all
+	QueryParams  []string `json:"query_params,omitempty"`  // query parameter names masked in URLs
+	BodyPatterns []string `json:"body_patterns,omitempty"` // regular expressions replaced in bodies
+}
+
+// DefaultRedaction covers credentials that commonly appear in page traffic.
+func DefaultRedaction() RedactionRules {
+	return RedactionRules{
+		Headers:     []string{"authorization", "proxy-authorization", "x-api-key", "x-csrf-token"},
+		Cookies:     []string{"*"},
+		QueryParams: []string{"access_token", "token", "api_key", "apikey", "key", "password", "sig", "signature"},
+	}
+}
+
+// NetworkTiming holds per-request phase durations in milliseconds; -1 means unavailable.
+type NetworkTiming struct {
+	DNS      float64 `json:"dns_ms"`
+	Connect  float64 `json:"connect_ms"`
+	TLS      float64 `json:"tls_ms"`
+	TTFB     float64 `json:"ttfb_ms"`
+	Duration float64 `json:"duration_ms"`
+}
+
+// NetworkSizes are wire sizes reported by the browser.
+type NetworkSizes struct {
+	RequestHeaders  int `json:"request_headers"`
+	RequestBody     int `json:"request_body"`
+	ResponseHeaders int `json:"response_headers"`
+	ResponseBody    int `json:"response_body"`
+}
+
+// NetworkEntry is one request/response exchange, written as a line of network.ndjson.
+type NetworkEntry struct {
+	ID              int               `json:"id"`
+	StartedAt       time.Time         `json:"started_at"`
+	Method          string            `json:"method"`
+	URL             string            `json:"url"`
+	ResourceType    string            `json:"resource_type"`
+	Frame           string            `json:"frame,omitempty"`     // initiator frame URL
+	Initiator       string            `json:"initiator,omitempty"` // page, userscript or unknown (Chromium CDP stacks)
+	MainFrame       bool              `json:"main_frame"`
+	Navigation      bool              `json:"navigation,omitempty"`
+	ServiceWorker   bool              `json:"service_worker,omitempty"`
+	Status          int               `json:"status,omitempty"`
+	StatusText      string            `json:"status_text,omitempty"`
+	ContentType     string            `json:"content_type,omitempty"`
+	Failure         string            `json:"failure,omitempty"`
+	Incomplete      bool              `json:"incomplete,omitempty"`
+	RequestHeaders  map[string]string `json:"request_headers,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+	RequestBody     string            `json:"request_body,omitempty"`
+	ResponseBody    string            `json:"response_body,omitempty"`
+	Sizes           NetworkSizes      `json:"sizes"`
+	Timing          NetworkTiming     `json:"timing"`
+
+	// Unredacted outbound data, kept in memory only for egress checks.
+	rawURL  string
+	rawBody string
+}
+
+// matchURL is the URL assertions match against: the request's own URL, not
+// the redacted one, whose query is re-encoded and masked. Entries read back
+// from network.ndjson only have the redacted form.
+func (e NetworkEntry) matchURL() string {
+	if e.rawURL != "" {
+		return e.rawURL
+	}
+	return e.URL
+}
+
+// NetworkSummary is the manifest's digest of network.ndjson.
+type NetworkSummary struct {
+	Requests      int            `json:"requests"`
+	Failed        int            `json:"failed"`
+	ErrorStatuses int            `json:"error_statuses"`
+	BytesIn       int64          `json:"bytes_in"`
+	BytesOut      int64          `json:"bytes_out"`
+	ByType        map[string]int `json:"by_type,omitempty"`
+	TopHosts      []HostCount    `json:"top_hosts,omitempty"`
+	Log           string         `json:"log,omitempty"`
+}
+
+// HostCount pairs a host with its request count.
+type HostCount struct {
+	Host     string `json:"host"`
+	Requests int    `json:"requests"`
+}
+
+// redactor applies RedactionRules.
+type redactor struct {
+	headers    map[string]bool
+	cookies    map[string]bool
+	allCookies bool
+	query      map[string]bool
+	body       []*regexp.Regexp
+}
+
+func newRedactor(rules RedactionRules) (*redactor, error) {
+	r := &redactor{headers: map[string]bool{}, cookies: map[string]bool{}, query: map[string]bool{}}
+	for _, h := range rules.Headers {
+		r.headers[strings.ToLower(strings.TrimSpace(h))] = true
+	}
+	for _, c := range rules.Cookies {
+		if c = strings.TrimSpace(c); c == "*" {
+			r.allCookies = true
+		} else if c != "" {
+			r.cookies[c] = true
+		}
+	}
+	for _, q := range rules.QueryParams {
+		r.query[strings.ToLower(strings.TrimSpace(q))] = true
+	}
+	for _, p := range rules.BodyPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("redaction body pattern %q: %w", p, err)
+		}
+		r.body = append(r.body, re)
+	}
+	return r, nil
+}
+
+func (r *redactor) url(raw string) string {
+	if len(r.query) == 0 || !strings.Contains(raw, "?") {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	q := u.Query()
+	changed := false
+	for k := range q {
+		if r.query[strings.ToLower(k)] {
+			q.Set(k, redactedValue)
+			changed = true
+		}
+	}
+	if !changed {
+		return raw
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (r *redactor) headerMap(in map[string]string) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		lk := strings.ToLower(k)
+		switch {
+		case r.headers[lk]:
+			v = redactedValue
+		case lk == "cookie":
+			v = r.cookieHeader(v, "; ")
+		case lk == "set-cookie":
+			// Multiple Set-Cookie values arrive newline-joined; mask each name=value pair.
+			lines := strings.Split(v, "\n")
+			for i, line := range lines {
+				name, rest, _ := strings.Cut(line, ";")
+				lines[i] = r.cookieHeader(name, "") + prefixIfSet(";", rest)
+			}
+			v = strings.Join(lines, "\n")
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// cookieHeader masks values in a "a=1; b=2" list.
+func (r *redactor) cookieHeader(v, sep string) string {
+	if !r.allCookies && len(r.cookies) == 0 {
+		return v
+	}
+	parts := strings.Split(v, ";")
+	for i, p := range parts {
+		name, _, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if ok && (r.allCookies || r.cookies[name]) {
+			parts[i] = name + "=" + redactedValue
+		} else {
+			parts[i] = strings.TrimSpace(p)
+		}
+	}
+	if sep == "" {
+		sep = "; "
+	}
+	return strings.Join(parts, sep)
+}
+
+func (r *redactor) bodyText(s string) string {
+	for _, re := range r.body {
+		s = re.ReplaceAllString(s, redactedValue)
+	}
+	return s
+}
+
+func prefixIfSet(prefix, s string) string {
+	if s == "" {
+		return ""
+	}
+	return prefix + s
+}
+
+// networkRecorder collects request/response events from any goroutine and
+// streams finished exchanges to network.ndjson.
+type networkRecorder struct {
+	mu       sync.Mutex
+	idle     *sync.Cond // signalled on mu when inflight drops to zero
+	inflight int        // lookups started by take and not yet finished
+	nextID   int
+	pending  map[playwright.Request]*NetworkEntry
+	done     []NetworkEntry
+	out      *bufio.Writer
+	file     *os.File
+	redact   *redactor
+	bodies   bool
+	logger   *ndjsonLogger
+	path     string
+	closed   sync.Once
+	stopped  bool // set under mu once close starts; later events are dropped
+	entries  []NetworkEntry
+
+	initiators *initiatorTracker // optional; attributes entries to page or userscript
+}
+
+func newNetworkRecorder(path string, rules RedactionRules, bodies bool, logger *ndjsonLogger) (*networkRecorder, error) {
+	red, err := newRedactor(rules)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	n := &networkRecorder{
+		pending: map[playwright.Request]*NetworkEntry{},
+		out:     bufio.NewWriter(f),
+		file:    f,
+		redact:  red,
+		bodies:  bodies,
+		logger:  logger,
+		path:    path,
+	}
+	n.idle = sync.NewCond(&n.mu)
+	return n, nil
+}
+
+// attach subscribes to context-wide network events.
+func (n *networkRecorder) attach(ctx playwright.BrowserContext) {
+	ctx.OnRequest(n.onRequest)
+	ctx.OnResponse(n.onResponse)
+	ctx.OnRequestFinished(n.onFinished)
+	ctx.OnRequestFailed(n.onFailed)
+}
+
+func (n *networkRecorder) onRequest(req playwright.Request) {
+	e := &NetworkEntry{
+		StartedAt:      time.Now(),
+		Method:         req.Method(),
+		URL:            n.redact.url(req.URL()),
+		ResourceType:   req.ResourceType(),
+		Navigation:     req.IsNavigationRequest(),
+		RequestHeaders: n.redact.headerMap(req.Headers()),
+		Timing:         NetworkTiming{DNS: -1, Connect: -1, TLS: -1, TTFB: -1, Duration: -1},
+		rawURL:         req.URL(),
+	}
+	if f := req.Frame(); f != nil {
+		e.Frame = n.redact.url(f.URL())
+		e.MainFrame = f.ParentFrame() == nil
+	} else if !e.Navigation {
+		e.ServiceWorker = true
+	}
+	if body, err := req.PostData(); err == nil && body != "" {
+		e.RequestBody = n.redact.bodyText(truncate(body, maxCapturedBody))
+		e.rawBody = truncate(body, maxCapturedBody)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return
+	}
+	n.nextID++
+	e.ID = n.nextID
+	n.pending[req] = e
+}
+
+func (n *networkRecorder) onResponse(resp playwright.Response) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	e, ok := n.pending[resp.Request()]
+	if !ok {
+		return
+	}
+	e.Status = resp.Status()
+	e.StatusText = resp.StatusText()
+	headers := resp.Headers()
+	e.ContentType = headers["content-type"]
+	e.ResponseHeaders = n.redact.headerMap(headers)
+	if resp.FromServiceWorker() {
+		e.ServiceWorker = true
+	}
+}
+
+// onFinished runs on the dispatch goroutine; sizes and bodies need round trips
+// to the driver, so they are fetched separately.
+func (n *networkRecorder) onFinished(req playwright.Request) {
+	e, ok := n.take(req)
+	if !ok {
+		return
+	}
+	applyTiming(e, req.Timing())
+	go func() {
+		defer n.lookupDone()
+		n.attribute(e, 250*time.Millisecond)
+		if sizes, err := req.Sizes(); err == nil && sizes != nil {
+			e.Sizes = NetworkSizes{
+				RequestHeaders:  sizes.RequestHeadersSize,
+				RequestBody:     sizes.RequestBodySize,
+				ResponseHeaders: sizes.ResponseHeadersSize,
+				ResponseBody:    sizes.ResponseBodySize,
+			}
+		}
+		if n.bodies && isTextual(e.ContentType) {
+			if resp, err := req.Response(); err == nil && resp != nil {
+				if body, err := resp.Body(); err == nil {
+					e.ResponseBody = n.redact.bodyText(truncate(string(body), maxCapturedBody))
+				}
+			}
+		}
+		n.finish(*e)
+	}()
+}
+
+func (n *networkRecorder) onFailed(req playwright.Request) {
+	e, ok := n.take(req)
+	if !ok {
+		return
+	}
+	if err := req.Failure(); err != nil {
+		e.Failure = err.Error()
+	}
+	applyTiming(e, req.Timing())
+	go func() {
+		defer n.lookupDone()
+		n.attribute(e, 250*time.Millisecond)
+		n.finish(*e)
+	}()
+}
+
+// take removes req from the pending set and, unless the recorder is closing,
+// counts the follow-up lookup as in flight; lookupDone ends it. Both happen
+// under mu, so lookups may start while settle is waiting.
+func (n *networkRecorder) take(req playwright.Request) (*NetworkEntry, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	e, ok := n.pending[req]
+	if !ok || n.stopped {
+		return nil, false
+	}
+	delete(n.pending, req)
+	n.inflight++
+	return e, true
+}
+
+func (n *networkRecorder) lookupDone() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.inflight--; n.inflight == 0 {
+		n.idle.Broadcast()
+	}
+}
+
+// settle waits until no lookup is in flight, while the context that serves
+// them is still open. Requests finishing meanwhile are waited for too.
+func (n *networkRecorder) settle() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.waitIdle()
+}
+
+// waitIdle blocks until inflight is zero; mu must be held.
+func (n *networkRecorder) waitIdle() {
+	for n.inflight > 0 {
+		n.idle.Wait()
+	}
+}
+
+// attribute records who started e; CDP events may trail Playwright's, hence wait.
+func (n *networkRecorder) attribute(e *NetworkEntry, wait time.Duration) {
+	if n.initiators != nil {
+		e.Initiator = n.initiators.take(e.Method, e.rawURL, wait).Kind
+	}
+}
+
+// snapshot returns the exchanges finished so far.
+func (n *networkRecorder) snapshot() []NetworkEntry {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]NetworkEntry(nil), n.done...)
+}
+
+func (n *networkRecorder) finish(e NetworkEntry) {
+	b, _ := json.Marshal(e)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.done = append(n.done, e)
+	n.out.Write(b)
+	n.out.WriteByte('\n')
+}
+
+// close waits for in-flight lookups, writes still-pending requests as
+// incomplete and returns every entry in request order. It is safe to call
+// more than once.
+func (n *networkRecorder) close() []NetworkEntry {
+	n.closed.Do(func() { n.entries = n.flush() })
+	return n.entries
+}
+
+func (n *networkRecorder) flush() []NetworkEntry {
+	n.mu.Lock()
+	n.stopped = true
+	n.waitIdle()
+	var rest []NetworkEntry
+	for _, e := range n.pending {
+		e.Incomplete = true
+		n.attribute(e, 0)
+		rest = append(rest, *e)
+	}
+	n.pending = map[playwright.Request]*NetworkEntry{}
+	n.mu.Unlock()
+	sort.Slice(rest, func(i, j int) bool { return rest[i].ID < rest[j].ID })
+	for _, e := range rest {
+		n.finish(e)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.out.Flush(); err != nil {
+		n.logger.warn("network", "flush network log failed", map[string]any{"error": err.Error()})
+	}
+	n.file.Close()
+	entries := append([]NetworkEntry(nil), n.done...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	return entries
+}
+
+func applyTiming(e *NetworkEntry, t *playwright.RequestTiming) {
+	if t == nil {
+		return
+	}
+	span := func(a, b float64) float64 {
+		if a < 0 || b < 0 {
+			return -1
+		}
+		return b - a
+	}
+	e.Timing = NetworkTiming{
+		DNS:      span(t.DomainLookupStart, t.DomainLookupEnd),
+		Connect:  span(t.ConnectStart, t.ConnectEnd),
+		TLS:      span(t.SecureConnectionStart, t.ConnectEnd),
+		TTFB:     span(t.RequestStart, t.ResponseStart),
+		Duration: t.ResponseEnd,
+	}
+}
+
+func isTextual(contentType string) bool {
+	ct := strings.ToLower(contentType)
+	return strings.Contains(ct, "json") || strings.HasPrefix(ct, "text/") ||
+		strings.Contains(ct, "javascript") || strings.Contains(ct, "xml") || strings.Contains(ct, "x-www-form-urlencoded")
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// summarizeEntries builds the manifest digest.
+func summarizeEntries(entries []NetworkEntry, logPath string) NetworkSummary {
+	sum := NetworkSummary{ByType: map[string]int{}, Log: logPath}
+	hosts := map[string]int{}
+	for _, e := range entries {
+		sum.Requests++
+		if e.Failure != "" {
+			sum.Failed++
+		}
+		if e.Status >= 400 {
+			sum.ErrorStatuses++
+		}
+		sum.BytesIn += int64(e.Sizes.ResponseHeaders + e.Sizes.ResponseBody)
+		sum.BytesOut += int64(e.Sizes.RequestHeaders + e.Sizes.RequestBody)
+		sum.ByType[e.ResourceType]++
+		if u, err := url.Parse(e.URL); err == nil && u.Hostname() != "" {
+			hosts[u.Hostname()]++
+		}
+	}
+	for h, c := range hosts {
+		sum.TopHosts = append(sum.TopHosts, HostCount{Host: h, Requests: c})
+	}
+	sort.Slice(sum.TopHosts, func(i, j int) bool {
+		if sum.TopHosts[i].Requests != sum.TopHosts[j].Requests {
+			return sum.TopHosts[i].Requests > sum.TopHosts[j].Requests
+		}
+		return sum.TopHosts[i].Host < sum.TopHosts[j].Host
+	})
+	if len(sum.TopHosts) > 10 {
+		sum.TopHosts = sum.TopHosts[:10]
+	}
+	return sum
+}
@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+func TestRedactorScrubsHeadersCookiesQueryAndBodies(t *testing.T) {
+	rules := DefaultRedaction()
+	rules.BodyPatterns = []string{`"password":"[^"]*"`}
+	r, err := newRedactor(rules)
+	if err != nil {
+		t.Fatalf("newRedactor() error = %v", err)
+	}
+
+	got := r.url("https://api.example.com/v1?q=cats&access_token=abc123")
+	if strings.Contains(got, "abc123") || !strings.Contains(got, "q=cats") {
+		t.Fatalf("url not redacted as expected: %s", got)
+	}
+
+	h := r.headerMap(map[string]string{
+		"Authorization": "Bearer secret",
+		"cookie":        "sid=abc; theme=dark",
+		"set-cookie":    "sid=abc; Path=/; HttpOnly\nlang=en",
+		"accept":        "text/html",
+	})
+	if h["Authorization"] != redactedValue {
+		t.Fatalf("authorization = %q", h["Authorization"])
+	}
+	if h["cookie"] != "sid=[REDACTED]; theme=[REDACTED]" {
+		t.Fatalf("cookie = %q", h["cookie"])
+	}
+	if h["set-cookie"] != "sid=[REDACTED]; Path=/; HttpOnly\nlang=[REDACTED]" {
+		t.Fatalf("set-cookie = %q", h["set-cookie"])
+	}
+	if h["accept"] != "text/html" {
+		t.Fatalf("accept = %q", h["accept"])
+	}
+
+	body := r.bodyText(`{"user":"a","password":"hunter2"}`)
+	if strings.Contains(body, "hunter2") {
+		t.Fatalf("body not redacted: %s", body)
+	}
+}
+
+func TestRedactorNamedCookiesOnly(t *testing.T) {
+	r, err := newRedactor(RedactionRules{Cookies: []string{"sid"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.cookieHeader("sid=abc; theme=dark", "; "); got != "sid=[REDACTED]; theme=dark" {
+		t.Fatalf("cookieHeader = %q", got)
+	}
+}
+
+// stubRequest is only used as a pending-map key; events for it must not reach the driver.
+type stubRequest struct{ playwright.Request }
+
+func TestNetworkRecorderDropsEventsAfterClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "network.ndjson")
+	n, err := newNetworkRecorder(path, RedactionRules{}, false, &ndjsonLogger{w: bufio.NewWriter(io.Discard)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &stubRequest{}
+	n.pending[req] = &NetworkEntry{ID: 1, Method: "GET", URL: "https://example.com/late"}
+	entries := n.close()
+	if len(entries) != 1 || !entries[0].Incomplete {
+		t.Fatalf("close() = %+v, want one incomplete entry", entries)
+	}
+	// A finish/fail that arrives after close must neither start a lookup nor
+	// write to the closed log.
+	n.onFinished(req)
+	n.onFailed(req)
+	if _, ok := n.take(req); ok {
+		t.Fatal("take() succeeded after close")
+	}
+	if got := n.close(); len(got) != 1 {
+		t.Fatalf("second close() = %+v", got)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Fatalf("network log has %d lines, want 1", lines)
+	}
+}
+
+func TestNetworkRecorderSettleWaitsForLateLookups(t *testing.T) {
+	n, err := newNetworkRecorder(filepath.Join(t.TempDir(), "network.ndjson"), RedactionRules{}, false, &ndjsonLogger{w: bufio.NewWriter(io.Discard)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, late := &stubRequest{}, &stubRequest{}
+	n.pending[first] = &NetworkEntry{ID: 1}
+	n.pending[late] = &NetworkEntry{ID: 2}
+	if _, ok := n.take(first); !ok {
+		t.Fatal("take(first) failed")
+	}
+	settled := make(chan struct{})
+	go func() {
+		n.settle()
+		close(settled)
+	}()
+	// A request finishing while settle waits starts another lookup.
+	if _, ok := n.take(late); !ok {
+		t.Fatal("take(late) failed during settle")
+	}
+	n.lookupDone()
+	select {
+	case <-settled:
+		t.Fatal("settle returned with a lookup in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	n.lookupDone()
+	select {
+	case <-settled:
+	case <-time.After(time.Second):
+		t.Fatal("settle did not return")
+	}
+	n.close()
+}
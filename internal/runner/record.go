@@ -0,0 +1,262 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// RecordOptions configure an interactive recording session.
+type RecordOptions struct {
+	TargetURL  string
+	ScriptPath string
+	ProfileDir string // optional persistent profile location
+}
+
+// recorderJS captures user input in the top frame and reports it through the
+// __labRecord binding. Selectors prefer test ids, then ARIA role + name, then
+// visible text, then stable ids/names, and only fall back to a CSS path.
+const recorderJS = `(() => {
+  if (window.top !== window || window.__labRecorder) return;
+  window.__labRecorder = true;
+  const q = (s) => JSON.stringify(s);
+  const clean = (s) => (s || '').replace(/\s+/g, ' ').trim();
+  const testAttrs = ['data-testid', 'data-test-id', 'data-test', 'data-qa'];
+  const implicitRole = (el) => {
+    const tag = el.tagName.toLowerCase();
+    const type = (el.getAttribute('type') || '').toLowerCase();
+    if (tag === 'button' || (tag === 'input' && ['button', 'submit', 'reset'].includes(type))) return 'button';
+    if (tag === 'a' && el.hasAttribute('href')) return 'link';
+    if (tag === 'input' && type === 'checkbox') return 'checkbox';
+    if (tag === 'input' && type === 'radio') return 'radio';
+    if (tag === 'input' && ['', 'text', 'email', 'search', 'tel', 'url', 'password'].includes(type)) return type === 'search' ? 'searchbox' : 'textbox';
+    if (tag === 'textarea') return 'textbox';
+    if (tag === 'select') return 'combobox';
+    if (/^h[1-6]$/.test(tag)) return 'heading';
+    if (tag === 'summary') return 'button';
+    return '';
+  };
+  const accessibleName = (el) => {
+    const label = el.getAttribute('aria-label');
+    if (label) return clean(label);
+    const by = el.getAttribute('aria-labelledby');
+    if (by) {
+      const t = by.split(/\s+/).map(id => document.getElementById(id)).filter(Boolean).map(n => n.textContent).join(' ');
+      if (clean(t)) return clean(t);
+    }
+    if (el.labels && el.labels.length) return clean(el.labels[0].textContent);
+    const tag = el.tagName.toLowerCase();
+    if (tag === 'input' && ['button', 'submit', 'reset'].includes((el.type || '').toLowerCase())) return clean(el.value);
+    if (['button', 'a', 'summary'].includes(tag) || /^h[1-6]$/.test(tag) || el.getAttribute('role')) return clean(el.innerText);
+    return clean(el.getAttribute('title') || el.getAttribute('alt') || el.getAttribute('placeholder'));
+  };
+  const unique = (css) => { try { return document.querySelectorAll(css).length === 1; } catch (e) { return false; } };
+  const looksGenerated = (id) => /\d{3,}|[a-f0-9]{8,}|^:r/i.test(id);
+  const cssPath = (el) => {
+    const parts = [];
+    for (let n = el; n && n.nodeType === 1 && n !== document.documentElement; n = n.parentElement) {
+      if (n.id && !looksGenerated(n.id) && unique('#' + CSS.escape(n.id))) { parts.unshift('#' + CSS.escape(n.id)); break; }
+      const tag = n.tagName.toLowerCase();
+      const sibs = n.parentElement ? Array.from(n.parentElement.children).filter(c => c.tagName === n.tagName) : [];
+      parts.unshift(sibs.length > 1 ? tag + ':nth-of-type(' + (sibs.indexOf(n) + 1) + ')' : tag);
+    }
+    return parts.join(' > ');
+  };
+  const selectorFor = (el) => {
+    for (const a of testAttrs) {
+      const v = el.getAttribute(a);
+      if (v) return '[' + a + '=' + q(v) + ']';
+    }
+    const role = el.getAttribute('role') || implicitRole(el);
+    const name = accessibleName(el);
+    if (role && name && name.length <= 60) return 'role=' + role + '[name=' + q(name) + ']';
+    const text = clean(el.innerText);
+    if (text && text.length <= 40 && !['input', 'textarea', 'select'].includes(el.tagName.toLowerCase())) return 'text=' + q(text);
+    if (el.id && !looksGenerated(el.id) && unique('#' + CSS.escape(el.id))) return '#' + CSS.escape(el.id);
+    const tag = el.tagName.toLowerCase();
+    for (const a of ['name', 'placeholder', 'aria-label', 'title']) {
+      const v = el.getAttribute(a);
+      if (v && unique(tag + '[' + a + '=' + q(v) + ']')) return tag + '[' + a + '=' + q(v) + ']';
+    }
+    return cssPath(el);
+  };
+  const interactive = 'button, a[href], input, select, textarea, label, summary, [role], [onclick], [data-testid]';
+  const isTextEntry = (el) => el.matches('textarea, [contenteditable=""], [contenteditable="true"]') ||
+    (el.tagName === 'INPUT' && !['button', 'submit', 'reset', 'checkbox', 'radio', 'file', 'image'].includes((el.type || '').toLowerCase()));
+  const send = (ev) => { try { window.__labRecord(ev); } catch (e) {} };
+  document.addEventListener('click', (e) => {
+    if (!e.isTrusted) return;
+    const el = (e.target.closest && e.target.closest(interactive)) || e.target;
+    if (!el || el.nodeType !== 1 || isTextEntry(el)) return;
+    send({ type: 'click', selector: selectorFor(el) });
+  }, true);
+  document.addEventListener('input', (e) => {
+    const el = e.target;
+    if (!e.isTrusted || !el || !isTextEntry(el)) return;
+    send({ type: 'fill', selector: selectorFor(el), value: el.isContentEditable ? el.innerText : el.value });
+  }, true);
+  document.addEventListener('change', (e) => {
+    const el = e.target;
+    if (!e.isTrusted || !el || el.tagName !== 'SELECT') return;
+    send({ type: 'select', selector: selectorFor(el), value: el.value });
+  }, true);
+  const namedKeys = ['Enter', 'Escape', 'Tab', 'ArrowUp', 'ArrowDown', 'ArrowLeft', 'ArrowRight', 'PageUp', 'PageDown', 'Home', 'End', 'Delete'];
+  document.addEventListener('keydown', (e) => {
+    if (!e.isTrusted || ['Control', 'Shift', 'Alt', 'Meta'].includes(e.key)) return;
+    const chord = e.ctrlKey || e.metaKey || e.altKey;
+    if (!chord && !namedKeys.includes(e.key)) return;
+    const mods = [e.ctrlKey && 'Control', e.metaKey && 'Meta', e.altKey && 'Alt', e.shiftKey && 'Shift'].filter(Boolean);
+    const el = document.activeElement && document.activeElement !== document.body ? document.activeElement : document.body;
+    send({ type: 'press', selector: el === document.body ? 'body' : selectorFor(el), key: mods.concat([e.key]).join('+') });
+  }, true);
+})();
+`
+
+// navGrace is how long after an input event a navigation is attributed to it
+// rather than recorded as its own goto step.
+const navGrace = 1500 * time.Millisecond
+
+// stepRecorder turns recorder events into a step list, merging consecutive
+// fills of the same field.
+type stepRecorder struct {
+	mu        sync.Mutex
+	steps     []Step
+	lastInput time.Time
+	started   bool // ignore the initial navigation
+}
+
+func (r *stepRecorder) add(ev map[string]any) {
+	kind, _ := ev["type"].(string)
+	selector, _ := ev["selector"].(string)
+	value, _ := ev["value"].(string)
+	key, _ := ev["key"].(string)
+	if selector == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastInput = time.Now()
+	switch kind {
+	case "click":
+		r.steps = append(r.steps, Step{Action: "click", Target: selector})
+	case "fill", "select":
+		if n := len(r.steps); n > 0 && r.steps[n-1].Action == kind && r.steps[n-1].Target == selector {
+			r.steps[n-1].Value = value
+			return
+		}
+		r.steps = append(r.steps, Step{Action: kind, Target: selector, Value: value})
+	case "press":
+		r.steps = append(r.steps, Step{Action: "press", Target: selector, Value: key})
+	}
+}
+
+// navigated records a goto step for navigations not caused by recorded input.
+func (r *stepRecorder) navigated(url string, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started || url == "" || url == "about:blank" {
+		return
+	}
+	if !r.lastInput.IsZero() && at.Sub(r.lastInput) < navGrace {
+		return
+	}
+	r.steps = append(r.steps, Step{Action: "goto", Value: url})
+}
+
+func (r *stepRecorder) start() {
+	r.mu.Lock()
+	r.started = true
+	r.mu.Unlock()
+}
+
+func (r *stepRecorder) result() []Step {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Step(nil), r.steps...)
+}
+
+// Record opens a headed browser with the userscript injected and captures the
+// user's clicks, fills, key presses and navigations until the page is closed.
+func Record(opts RecordOptions) ([]Step, error) {
+	if opts.TargetURL == "" {
+		return nil, errors.New("TargetURL is required")
+	}
+	if opts.ScriptPath == "" {
+		return nil, errors.New("ScriptPath is required")
+	}
+	scriptContent, err := os.ReadFile(opts.ScriptPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := playwright.Install(&playwright.RunOptions{Browsers: []string{"chromium"}}); err != nil {
+		return nil, err
+	}
+	pw, err := playwright.Run()
+	if err != nil {
+		return nil, err
+	}
+	defer pw.Stop()
+
+	profileDir := opts.ProfileDir
+	if profileDir == "" {
+		profileDir = filepath.Join(os.TempDir(), fmt.Sprintf("philadelphia-record-%x", time.Now().UnixNano()))
+		defer os.RemoveAll(profileDir)
+	}
+	ctx, err := pw.Chromium.LaunchPersistentContext(profileDir, playwright.BrowserTypeLaunchPersistentContextOptions{
+		Headless: playwright.Bool(false),
+		Args:     []string{"--disable-dev-shm-usage"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("launch context: %w", err)
+	}
+	defer ctx.Close()
+
+	rec := &stepRecorder{}
+	if err := ctx.ExposeBinding("__labRecord", func(_ *playwright.BindingSource, args ...any) any {
+		if len(args) > 0 {
+			if ev, ok := args[0].(map[string]any); ok {
+				rec.add(ev)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("expose recorder binding: %w", err)
+	}
+	if err := ctx.AddInitScript(playwright.Script{Content: playwright.String(recorderJS)}); err != nil {
+		return nil, fmt.Errorf("inject recorder: %w", err)
+	}
+
+	page, err := ctx.NewPage()
+	if err != nil {
+		return nil, err
+	}
+	if err := page.AddInitScript(playwright.Script{Content: playwright.String(gmShim + "\n" + string(scriptContent))}); err != nil {
+		return nil, fmt.Errorf("inject userscript: %w", err)
+	}
+	page.OnFrameNavigated(func(f playwright.Frame) {
+		if f.ParentFrame() == nil {
+			rec.navigated(f.URL(), time.Now())
+		}
+	})
+	closed := make(chan struct{})
+	var once sync.Once
+	page.OnClose(func(playwright.Page) { once.Do(func() { close(closed) }) })
+	ctx.OnClose(func(playwright.BrowserContext) { once.Do(func() { close(closed) }) })
+
+	if _, err := page.Goto(opts.TargetURL, playwright.PageGotoOptions{
+		WaitUntil: playwright.WaitUntilStateDomcontentloaded,
+		Timeout:   playwright.Float(40_000),
+	}); err != nil {
+		return nil, fmt.Errorf("navigate: %w", err)
+	}
+	rec.start()
+
+	<-closed
+	return rec.result(), nil
+}
@@ -0,0 +1,35 @@
+package runner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStepRecorderMergesFillsAndSkipsInducedNavigation(t *testing.T) {
+	rec := &stepRecorder{}
+	rec.navigated("https://example.com/", time.Now())
+	rec.start()
+
+	rec.add(map[string]any{"type": "fill", "selector": "role=textbox[name=\"Search\"]", "value": "w"})
+	rec.add(map[string]any{"type": "fill", "selector": "role=textbox[name=\"Search\"]", "value": "wiki"})
+	rec.add(map[string]any{"type": "press", "selector": "role=textbox[name=\"Search\"]", "key": "Enter"})
+	rec.navigated("https://example.com/search?q=wiki", time.Now())
+	rec.navigated("https://example.com/later", time.Now().Add(2*navGrace))
+	rec.add(map[string]any{"type": "click", "selector": "text=\"Next\""})
+
+	got := rec.result()
+	want := []Step{
+		{Action: "fill", Target: "role=textbox[name=\"Search\"]", Value: "wiki"},
+		{Action: "press", Target: "role=textbox[name=\"Search\"]", Value: "Enter"},
+		{Action: "goto", Value: "https://example.com/later"},
+		{Action: "click", Target: "text=\"Next\""},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d steps, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("step %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -2,25 +2,23 @@ package runner
 
 import (
 	"bufio"
-	"bytes"
 	"context"
-	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"image"
-	"image/color"
-	"image/png"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"philadelphia/internal/har"
 	"philadelphia/internal/userscript"
 
 	"github.com/playwright-community/playwright-go"
@@ -38,23 +36,63 @@ type Options struct {
 	Engine              string // display only for now
 	Headless            bool
 	ProfileDir          string // optional persistent profile location
-	CaptureTrace        bool
+	CaptureTrace        bool   // trace the whole run, one chunk per step
 	CaptureHAR          bool
-	ReplayHAR           string   // optional path to HAR for replay
-	BaselineDir         string   // for visual regression hashes
-	VisualDiffThreshold float64  // per-channel threshold 0-255
-	BlockedHosts        []string // basic network assertion
-	Steps               []Step   // flow actions/assertions
-	Workspace           string   // base path; defaults to cwd
+	HARContent          string             // embed (default), attach or omit
+	HARMode             string             // full (default) or minimal
+	HARURLFilter        string             // glob or /regex/ limiting recorded requests
+	ReplayHAR           string             // optional path to HAR for replay
+	ReplayHARStrict     bool               // fail requests the HAR has no recording for instead of going live
+	ReplayHARMatch      string             // url (default) or url+body
+	BaselineDir         string             // keyed baseline store; new checkpoints stay pending until approved
+	VisualDiffThreshold float64            // colour tolerance 0-255, compared in YIQ space
+	VisualMaxDiffRatio  float64            // fail the run when more than this fraction of pixels differ; 0 only reports
+	VisualSSIM          bool               // also compute an SSIM score (slower)
+	IgnoreRegions       []IgnoreRegion     // painted out of every baseline and screenshot before comparing
+	NoStabilize         bool               // capture after a fixed wait instead of freezing motion and waiting for identical frames
+	HideScrollbars      bool               // hide scrollbars while stabilizing screenshots
+	StableBudget        time.Duration      // how long a screenshot may take to settle; default 5s
+	BlockedHosts        []string           // basic network assertion
+	Redaction           *RedactionRules    // scrubbing for logs/network.ndjson; nil uses DefaultRedaction
+	NetworkBodies       bool               // capture text/JSON bodies (truncated) in the network log
+	NetworkAssertions   []NetworkAssertion // evaluated against all traffic once the run ends
+	Sandbox             bool               // abort requests to hosts outside the target, @connect and SandboxAllowHosts
+	SandboxAllowHosts   []string           // extra hosts the sandbox lets through (subdomains included)
+	ProxyMode           string             // record or replay through the MITM proxy; empty disables it
+	ProxyCassette       string             // cassette the proxy writes (record) or serves from (replay)
+	Emulation           string             // comma-separated profiles: slow-3g, fast-3g, offline-after-load, 2x-cpu, 4x-cpu, 6x-cpu
+	Deterministic       *Determinism       // fixed clock, seeded randomness, pinned locale/timezone/motion/DPR; nil leaves them live
+	Viewport            string             // WxH; default 1280x720, or the device's
+	Device              string             // Playwright device name, e.g. "iPhone 13" (UA, viewport, DPR, touch)
+	ColorScheme         string             // prefers-color-scheme: light, dark or no-preference
+	Locale              string             // BCP 47 tag, e.g. de-DE
+	Mutations           bool               // journal DOM mutations to mutations.ndjson; assert-mutations steps turn it on
+	Snapshot            string             // dom or a11y: also snapshot each checkpoint and diff it against its baseline
+	SnapshotRules       *SnapshotRules     // volatile attributes and text to normalise away; nil uses the defaults
+	Steps               []Step             // flow actions/assertions
+	DialogPolicy        string             // accept (default), dismiss, or respond:<text>
+	Debug               bool               // headed + slow-mo; pause with a REPL on the first failure
+	BreakAt             []int              // 1-based step indexes to pause before (debug mode)
+	DebugIn             io.Reader          // REPL input; defaults to stdin
+	DebugOut            io.Writer          // REPL output; defaults to stdout
+	Workspace           string             // base path; defaults to cwd
+
+	parentRun string      // set by RunMatrix and RunImpact
+	cell      *MatrixCell // set by RunMatrix
+	impact    string      // impact role set by RunImpact; without-script skips injection
 }
 
 // Step represents a simple flow action or assertion.
 type Step struct {
-	Action string `json:"action"`
-	Target string `json:"target,omitempty"`
-	Value  string `json:"value,omitempty"`
-	Assert string `json:"assert,omitempty"` // e.g., "text-equals", "contains", "exists", "not-exists", "attr"
-	Attr   string `json:"attr,omitempty"`   // used with assert attr
+	Action  string            `json:"action"`
+	Target  string            `json:"target,omitempty"`
+	Value   string            `json:"value,omitempty"`
+	Assert  string            `json:"assert,omitempty"`     // e.g., "text-equals", "contains", "exists", "not-exists", "attr"
+	Attr    string            `json:"attr,omitempty"`       // used with assert attr
+	Dialog  string            `json:"dialog,omitempty"`     // dialog policy while this step runs; overrides Options.DialogPolicy
+	Network *NetworkAssertion `json:"network,omitempty"`    // used with assert-network
+	Mock    *MockSpec         `json:"mock,omitempty"`       // used with mock and fault
+	Shot    *ScreenshotSpec   `json:"screenshot,omitempty"` // used with screenshot
 }
 
 // Result contains artifact paths and manifest.
@@ -67,34 +105,58 @@ type Result struct {
 		Screenshot string
 		VideoWebM  string
 		VideoWebP  string
-		TraceZip   string
 		HAR        string
 	}
 }
 
 // Manifest is persisted to run.json.
 type Manifest struct {
-	RunID            string          `json:"run_id"`
-	StartedAt        time.Time       `json:"started_at"`
-	FinishedAt       time.Time       `json:"finished_at"`
-	TargetURL        string          `json:"target_url"`
-	Screenshot       string          `json:"screenshot"`
-	VideoWebM        string          `json:"video_webm,omitempty"`
-	VideoWebP        string          `json:"video_webp,omitempty"`
-	TraceZip         string          `json:"trace_zip,omitempty"`
-	HAR              string          `json:"har,omitempty"`
-	ReplayHAR        string          `json:"replay_har,omitempty"`
-	ScriptMeta       userscript.Meta `json:"script_meta"`
-	ProfileFolder    string          `json:"profile_folder"`
-	Engine           string          `json:"engine"`
-	ExtensionDir     string          `json:"extension_dir,omitempty"`
-	LogPath          string          `json:"log_path"`
-	VisualHash       string          `json:"visual_hash,omitempty"`
-	VisualDiff       bool            `json:"visual_diff,omitempty"`
-	VisualDiffImg    string          `json:"visual_diff_img,omitempty"`
-	VisualDiffPixels int             `json:"visual_diff_pixels,omitempty"`
-	VisualDiffRatio  float64         `json:"visual_diff_ratio,omitempty"`
-	NetworkIssues    []string        `json:"network_issues,omitempty"`
+	RunID             string                   `json:"run_id"`
+	StartedAt         time.Time                `json:"started_at"`
+	FinishedAt        time.Time                `json:"finished_at"`
+	TargetURL         string                   `json:"target_url"`
+	Screenshot        string                   `json:"screenshot"`
+	VideoWebM         string                   `json:"video_webm,omitempty"`
+	VideoWebP         string                   `json:"video_webp,omitempty"`
+	TraceChunks       []TraceChunk             `json:"trace_chunks,omitempty"`
+	HAR               string                   `json:"har,omitempty"`
+	HARCapture        *HARCapture              `json:"har_capture,omitempty"`
+	ReplayHAR         string                   `json:"replay_har,omitempty"`
+	HARReplay         *HARReplayReport         `json:"har_replay,omitempty"`
+	Proxy             *ProxyReport             `json:"proxy,omitempty"`
+	Emulation         *EmulationReport         `json:"emulation,omitempty"`
+	Deterministic     *Determinism             `json:"deterministic,omitempty"` // values applied, with defaults filled in
+	Environment       *PageEnvironment         `json:"environment,omitempty"`
+	Parent            string                   `json:"parent,omitempty"` // matrix run this run is a cell of
+	Cell              *MatrixCell              `json:"cell,omitempty"`
+	Impact            string                   `json:"impact,omitempty"` // without-script or with-script in an impact report
+	ScriptMeta        userscript.Meta          `json:"script_meta"`
+	ProfileFolder     string                   `json:"profile_folder"`
+	Engine            string                   `json:"engine"`
+	ExtensionDir      string                   `json:"extension_dir,omitempty"`
+	LogPath           string                   `json:"log_path"`
+	VisualHash        string                   `json:"visual_hash,omitempty"`
+	VisualDiff        bool                     `json:"visual_diff,omitempty"`
+	VisualDiffImg     string                   `json:"visual_diff_img,omitempty"`
+	VisualDiffPixels  int                      `json:"visual_diff_pixels,omitempty"`
+	VisualDiffRatio   float64                  `json:"visual_diff_ratio,omitempty"`
+	VisualCompareImg  string                   `json:"visual_compare_img,omitempty"`
+	Visual            *VisualComparison        `json:"visual,omitempty"`
+	Screenshots       []ScreenshotResult       `json:"screenshots,omitempty"`
+	Baseline          *BaselineRef             `json:"baseline,omitempty"`
+	NetworkIssues     []string                 `json:"network_issues,omitempty"`
+	Network           *NetworkSummary          `json:"network,omitempty"`
+	Mutations         *MutationSummary         `json:"mutations,omitempty"`
+	NetworkAssertions []NetworkAssertionResult `json:"network_assertions,omitempty"`
+	Sandbox           *SandboxReport           `json:"sandbox,omitempty"`
+	ScriptEgress      *ScriptEgressReport      `json:"script_egress,omitempty"`
+	Mocks             []MockRecord             `json:"mocks,omitempty"`
+	Status            string                   `json:"status"` // passed, failed, or aborted
+	ProposedSteps     string                   `json:"proposed_steps,omitempty"`
+	Steps             []StepResult             `json:"steps,omitempty"`
+	Dialogs           []DialogRecord           `json:"dialogs,omitempty"`
+	MenuCommands      []MenuCommand            `json:"menu_commands,omitempty"`
+	Downloads         []DownloadRecord         `json:"downloads,omitempty"`
 }
 
 // Run executes a single userscript against a URL and produces artifacts.
@@ -109,6 +171,50 @@ func Run(opts Options) (Result, error) {
 		cwd, _ := os.Getwd()
 		opts.Workspace = cwd
 	}
+	if _, _, err := parseDialogPolicy(opts.DialogPolicy); err != nil {
+		return Result{}, err
+	}
+	harMatch, err := har.ParseMatchMode(opts.ReplayHARMatch)
+	if err != nil {
+		return Result{}, err
+	}
+	if opts.Snapshot != "" {
+		if _, err := newSnapshotter(opts.Snapshot, opts.SnapshotRules); err != nil {
+			return Result{}, err
+		}
+	}
+	emulationNames, emulationProfile, err := parseEmulation(opts.Emulation)
+	if err != nil {
+		return Result{}, err
+	}
+	if _, err := parseColorScheme(opts.ColorScheme); err != nil {
+		return Result{}, err
+	}
+	if opts.Viewport != "" {
+		if _, err := parseViewport(opts.Viewport); err != nil {
+			return Result{}, err
+		}
+	}
+	var determinism *Determinism
+	if opts.Deterministic != nil {
+		d := *opts.Deterministic
+		if d.Locale == "" {
+			d.Locale = opts.Locale
+		}
+		d, err := d.withDefaults()
+		if err != nil {
+			return Result{}, err
+		}
+		determinism = &d
+	}
+	for i, step := range opts.Steps {
+		if step.Dialog == "" {
+			continue
+		}
+		if _, _, err := parseDialogPolicy(step.Dialog); err != nil {
+			return Result{}, fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
 	if opts.ScriptPath == "" && opts.ScriptContent == "" && opts.ScriptURL == "" && opts.ScriptGitRepo == "" {
 		return Result{}, errors.New("provide ScriptPath, ScriptContent, ScriptURL, or ScriptGitRepo")
 	}
@@ -183,14 +289,23 @@ func Run(opts Options) (Result, error) {
 	}
 	_ = os.RemoveAll(profileDir)
 
+	if len(opts.BreakAt) > 0 {
+		opts.Debug = true
+	}
+	if opts.Debug {
+		opts.Headless = false
+	}
+
 	ctxOpts := playwright.BrowserTypeLaunchPersistentContextOptions{
 		Headless: playwright.Bool(opts.Headless),
 		Args:     []string{"--disable-dev-shm-usage"},
 		RecordVideo: &playwright.RecordVideo{
-			Dir:  filepath.Join(artifactsDir, "video"),
-			Size: &playwright.Size{Width: 1280, Height: 720},
+			Dir: filepath.Join(artifactsDir, "video"), // sized to the viewport by applyEnvironment
 		},
 	}
+	if opts.Debug {
+		ctxOpts.SlowMo = playwright.Float(debugSlowMo)
+	}
 	if opts.ExtensionDir != "" {
 		ctxOpts.Args = append(ctxOpts.Args,
 			"--disable-extensions-except="+opts.ExtensionDir,
@@ -198,10 +313,42 @@ func Run(opts Options) (Result, error) {
 		)
 		logger.info("runner", "attempting MV3 extension load", map[string]any{"extension_dir": opts.ExtensionDir})
 	}
+	pageEnv, device, err := applyEnvironment(&ctxOpts, opts, pw.Devices)
+	if err != nil {
+		return Result{}, err
+	}
+	if determinism != nil {
+		if device != nil && opts.Deterministic.DeviceScaleFactor == 0 {
+			determinism.DeviceScaleFactor = device.DeviceScaleFactor
+		}
+		determinism.contextOptions(&ctxOpts)
+		pageEnv.Locale = determinism.variantLocale(opts)
+	}
+	if opts.Sandbox || (opts.ReplayHAR != "" && opts.ReplayHARStrict) {
+		// Service workers would fetch outside context routing.
+		ctxOpts.ServiceWorkers = playwright.ServiceWorkerPolicyBlock
+	}
+	redaction := DefaultRedaction()
+	if opts.Redaction != nil {
+		redaction = *opts.Redaction
+	}
+	var mitm *mitmProxy
+	if opts.ProxyMode != "" {
+		proxyRedact, err := newRedactor(redaction)
+		if err != nil {
+			return Result{}, err
+		}
+		if mitm, err = startMITM(&ctxOpts, opts.Workspace, opts.ProxyMode, opts.ProxyCassette, proxyRedact, logger); err != nil {
+			return Result{}, fmt.Errorf("proxy: %w", err)
+		}
+		defer mitm.server.Close()
+	}
+	harPath := filepath.Join(artifactsDir, "network.har")
+	var harCapture HARCapture
 	if opts.CaptureHAR {
-		harPath := filepath.Join(artifactsDir, "network.har")
-		ctxOpts.RecordHarPath = playwright.String(harPath)
-		ctxOpts.RecordHarURLFilter = "*"
+		if harCapture, err = harRecordOptions(&ctxOpts, harPath, opts.HARContent, opts.HARMode, opts.HARURLFilter); err != nil {
+			return Result{}, err
+		}
 	}
 
 	ctx, err := pw.Chromium.LaunchPersistentContext(profileDir, ctxOpts)
@@ -218,35 +365,121 @@ func Run(opts Options) (Result, error) {
 		}
 	}
 
+	if determinism != nil {
+		if err := determinism.install(ctx); err != nil {
+			return Result{}, fmt.Errorf("deterministic: %w", err)
+		}
+		logger.info("runner", "deterministic environment", map[string]any{
+			"time": determinism.Time, "seed": *determinism.Seed, "timezone": determinism.Timezone,
+			"locale": determinism.Locale, "reduced_motion": determinism.ReducedMotion, "device_scale_factor": determinism.DeviceScaleFactor,
+		})
+	}
+
+	networkLogPath := filepath.Join(logsDir, "network.ndjson")
+	captureBodies := opts.NetworkBodies || needsBodies(opts.NetworkAssertions, opts.Steps)
+	netrec, err := newNetworkRecorder(networkLogPath, redaction, captureBodies, logger)
+	if err != nil {
+		return Result{}, fmt.Errorf("network recorder: %w", err)
+	}
+	defer netrec.close()
+	var tracer *traceRecorder
+	if opts.CaptureTrace {
+		if tracer, err = startTrace(ctx, artifactsDir, runID, logger); err != nil {
+			logger.warn("trace", "start failed", map[string]any{"error": err.Error()})
+		}
+		defer tracer.stop()
+	}
+
+	initiators := newInitiatorTracker()
+	netrec.initiators = initiators
+	netrec.attach(ctx)
+
+	var sandbox *networkSandbox
+	if opts.Sandbox {
+		allow := sandboxAllowlist(entryHost(opts.TargetURL), scriptMeta.Connect, opts.SandboxAllowHosts, logger)
+		sandbox = newNetworkSandbox(allow, initiators, netrec.redact, logger)
+		if err := sandbox.install(ctx); err != nil {
+			return Result{}, fmt.Errorf("network sandbox: %w", err)
+		}
+		logger.info("sandbox", "enforcing network allowlist", map[string]any{"allow": allow})
+	}
+
+	// Installed after the sandbox so recorded responses are served without
+	// consulting it; misses fall back to it unless replay is strict.
+	var replayer *harReplayer
 	if opts.ReplayHAR != "" {
-		if err := ctx.RouteFromHAR(opts.ReplayHAR); err != nil {
-			logger.warn("har", "route from HAR failed", map[string]any{"error": err.Error()})
-		} else {
-			logger.info("har", "replaying from HAR", map[string]any{"path": opts.ReplayHAR})
+		if replayer, err = newHARReplayer(opts.ReplayHAR, harMatch, opts.ReplayHARStrict, netrec.redact, logger); err != nil {
+			return Result{}, fmt.Errorf("replay HAR: %w", err)
 		}
+		if err := replayer.install(ctx); err != nil {
+			return Result{}, fmt.Errorf("replay HAR: %w", err)
+		}
+		logger.info("har", "replaying from HAR", map[string]any{"path": opts.ReplayHAR, "strict": opts.ReplayHARStrict, "match": harMatch})
 	}
 
-	var responses []playwright.Response
-	ctx.OnResponse(func(resp playwright.Response) {
-		responses = append(responses, resp)
-	})
+	mocks := newMockRegistry(ctx, logger)
+	mocks.sandbox, mocks.replayer = sandbox, replayer
+
+	var mutations *mutationJournal
+	if wantsMutationJournal(opts) {
+		if mutations, err = newMutationJournal(filepath.Join(artifactsDir, "mutations.ndjson")); err != nil {
+			return Result{}, fmt.Errorf("mutation journal: %w", err)
+		}
+		if err := mutations.install(ctx); err != nil {
+			logger.warn("mutations", "journal unavailable", map[string]any{"error": err.Error()})
+			mutations.close()
+			mutations = nil
+		}
+	}
 
 	page, err := ctx.NewPage()
 	if err != nil {
 		return Result{}, err
 	}
 
-	// Inject script pre-navigation to approximate engine execution.
-	engineLower := strings.ToLower(opts.Engine)
-	installed := false
-	if strings.Contains(engineLower, "tampermonkey") {
-		installed = installTampermonkey(ctx, opts.ScriptPath, logger)
+	dialogs := newDialogHandler(opts.DialogPolicy, logger)
+	downloads := newDownloadCollector(artifactsDir, logger)
+	// Dialogs are handled on the context so popups opened by the page are covered too.
+	ctx.OnDialog(dialogs.handle)
+	var console *consoleCollector
+	if opts.impact != "" {
+		console = newConsoleCollector(page)
 	}
-	if strings.Contains(engineLower, "violentmonkey") {
-		installed = installViolentmonkey(ctx, logger)
+	page.OnDownload(downloads.handle)
+	ctx.OnPage(func(p playwright.Page) {
+		if p != page {
+			p.OnDownload(downloads.handle)
+		}
+	})
+	if err := initiators.attach(ctx, page); err != nil {
+		logger.warn("sandbox", "initiator tracking unavailable", map[string]any{"error": err.Error()})
 	}
-	if !installed {
-		if err := page.AddInitScript(playwright.Script{Content: playwright.String(string(scriptContent))}); err != nil {
+	var emu *emulator
+	if len(emulationNames) > 0 {
+		if emu, err = newEmulator(ctx, page, emulationNames, emulationProfile, logger); err != nil {
+			return Result{}, fmt.Errorf("emulation: %w", err)
+		}
+		emu.apply()
+	}
+
+	// Inject script pre-navigation to approximate engine execution.
+	engineLower := strings.ToLower(opts.Engine)
+	var engine string // the engine actually installed; empty in init-script mode
+	inject := opts.impact != impactWithout
+	if inject && strings.Contains(engineLower, engineTampermonkey) && installTampermonkey(ctx, opts.ScriptPath, logger) {
+		engine = engineTampermonkey
+	}
+	if inject && strings.Contains(engineLower, engineViolentmonkey) && installViolentmonkey(ctx, logger) {
+		engine = engineViolentmonkey
+	}
+	installed := engine != ""
+	var engineExtID string
+	if !inject {
+		logger.info("runner", "impact reference run; userscript not injected", nil)
+	} else if installed {
+		engineExtID = engineExtensionID(ctx, engine)
+	} else {
+		if err := page.AddInitScript(playwright.Script{Content: playwright.String(gmShim + "\n" + string(scriptContent) + "\n//# sourceURL=" + userscriptSourceURL)}); err != nil {
 			logger.warn("runner", "init script injection failed; continuing", map[string]any{"error": err.Error()})
 		}
 	}
@@ -255,62 +488,84 @@ func Run(opts Options) (Result, error) {
 	logger.info("browser", "navigating", map[string]any{"url": opts.TargetURL})
 	if _, err := page.Goto(opts.TargetURL, playwright.PageGotoOptions{
 		WaitUntil: playwright.WaitUntilStateNetworkidle,
-		Timeout:   playwright.Float(40_000),
+		Timeout:   playwright.Float(navigationTimeout(emulationProfile)),
 	}); err != nil {
 		return Result{}, fmt.Errorf("navigate: %w", err)
 	}
+	var emulationReport *EmulationReport
+	if emu != nil {
+		emu.afterLoad()
+		emulationReport = emu.report
+	}
 
 	// Execute flow steps or default toggle.
+	var (
+		stepResults    []StepResult
+		aborted        bool
+		proposedSteps  string
+		engineCommands []MenuCommand // captions seen in the engine popup
+	)
+	shots := newScreenshotCollector(opts, artifactsDir, runID, baselineKey(scriptMeta, opts.ScriptPath, opts.TargetURL, opts.Engine, pageEnv, page), logger)
 	if len(opts.Steps) > 0 {
-		executeSteps(page, opts.Steps, logger)
-	} else {
-		if _, err := page.WaitForSelector("text=Toggle Dark Mode", playwright.PageWaitForSelectorOptions{
-			Timeout: playwright.Float(8_000),
-		}); err != nil {
-			logger.warn("assert", "toggle button not found", map[string]any{"error": err.Error()})
-		} else {
-			logger.info("assert", "toggle button present", nil)
+		sr := &stepRunner{page: page, logger: logger, dialogs: dialogs, downloads: downloads, engine: engine, extensionID: engineExtID, scriptName: scriptMeta.Name, network: netrec, targetHost: entryHost(opts.TargetURL), mocks: mocks, trace: tracer, shots: shots, mutations: mutations}
+		if opts.Debug {
+			sr.debug = newDebugger(opts.DebugIn, opts.DebugOut, opts.BreakAt)
 		}
-		if err := page.Click("text=Toggle Dark Mode"); err != nil {
-			logger.warn("action", "click toggle failed", map[string]any{"error": err.Error()})
-		} else {
-			logger.info("action", "toggled dark mode", nil)
+		stepResults = executeSteps(sr, opts.Steps)
+		aborted = sr.aborted
+		engineCommands = sr.engineCommands
+		if sr.debug != nil {
+			proposedSteps = writeProposedSteps(artifactsDir, sr.debug.proposed, logger)
 		}
+	} else {
+		defaultToggle(page, logger, opts.impact)
 	}
 
-	page.WaitForTimeout(1200)
+	tracer.begin(0, "finish")
+	if opts.NoStabilize {
+		page.WaitForTimeout(unstabilizedWait)
+	}
 
 	screenshotPath := filepath.Join(artifactsDir, "screenshot.png")
-	if _, err := page.Screenshot(playwright.PageScreenshotOptions{
-		Path:     playwright.String(screenshotPath),
-		FullPage: playwright.Bool(true),
-	}); err != nil {
+	final, err := shots.capture(page, 0, finalCheckpoint, "", shotFullPage, nil)
+	if err != nil && final.Error != "" {
 		logger.warn("artifact", "screenshot failed", map[string]any{"error": err.Error()})
 	}
+	visualHash, visual := final.Hash, final.Visual
+	if mutations != nil {
+		if err := mutations.flush(page); err != nil {
+			logger.warn("mutations", "flush failed", map[string]any{"error": err.Error()})
+		}
+	}
+	if opts.impact != "" {
+		if err := writeImpactSnapshot(page, console, start, filepath.Join(artifactsDir, "impact.json")); err != nil {
+			logger.warn("impact", "snapshot failed", map[string]any{"error": err.Error()})
+		}
+	}
 
-	visualHash, visualDiff, visualDiffImg, visualDiffPixels, visualDiffRatio := computeVisualHash(screenshotPath, opts, artifactsDir, logger)
-
-	video := page.Video()
-	if err := page.Close(); err != nil {
-		logger.warn("runner", "close page", map[string]any{"error": err.Error()})
+	// An installed engine keeps its registry in the extension, so only the
+	// commands steps found in its popup can be reported.
+	menuCommands := engineCommands
+	if !installed {
+		if menuCommands, err = listMenuCommands(page, scriptMeta.Name); err != nil {
+			logger.warn("gm", "list menu commands failed", map[string]any{"error": err.Error()})
+		}
 	}
 
-	if opts.CaptureTrace {
-		tracePath := filepath.Join(artifactsDir, "trace.zip")
-		if err := ctx.Tracing().Start(playwright.TracingStartOptions{Screenshots: playwright.Bool(true), Snapshots: playwright.Bool(true), Sources: playwright.Bool(true)}); err != nil {
-			logger.warn("trace", "start failed", map[string]any{"error": err.Error()})
+	var gmRequests []gmRequest
+	if !installed {
+		if gmRequests, err = listGMRequests(page); err != nil {
+			logger.warn("gm", "list GM_xmlhttpRequest calls failed", map[string]any{"error": err.Error()})
+		}
+		for i := range gmRequests {
+			gmRequests[i].URL = netrec.redact.url(gmRequests[i].URL)
 		}
-		defer func() {
-			if err := ctx.Tracing().StopChunk(tracePath); err != nil {
-				logger.warn("trace", "stop failed", map[string]any{"error": err.Error()})
-			} else {
-				logger.info("trace", "trace captured", map[string]any{"path": tracePath})
-			}
-		}()
 	}
+	secrets := collectPageSecrets(ctx, page, opts.TargetURL)
 
-	if opts.CaptureHAR {
-		// TODO: integrate HAR recording
+	video := page.Video()
+	if err := page.Close(); err != nil {
+		logger.warn("runner", "close page", map[string]any{"error": err.Error()})
 	}
 
 	var videoPath, webpPath string
@@ -330,32 +585,145 @@ func Run(opts Options) (Result, error) {
 		}
 	}
 
+	downloadRecords := downloads.wait()
+	var harReplay *HARReplayReport
+	if replayer != nil {
+		harReplay = replayer.report()
+	}
+	var sandboxReport *SandboxReport
+	if sandbox != nil {
+		sandboxReport = sandbox.report()
+	}
+
+	traceChunks := tracer.stop()
+
+	// Sizes and bodies are fetched from the driver, so let in-flight lookups
+	// finish first; the recorder itself is closed after the context so late
+	// request events are still recorded.
+	netrec.settle()
 	if err := ctx.Close(); err != nil {
 		logger.warn("runner", "close context", map[string]any{"error": err.Error()})
 	}
+	networkEntries := netrec.close()
+	// Like the network recorder, the journal is closed once no page can
+	// deliver another batch.
+	var mutationSummary *MutationSummary
+	if mutations != nil {
+		mutationSummary = mutations.close()
+		if len(mutationSummary.HotSpots) > 0 {
+			logger.info("mutations", "journal written", map[string]any{"total": mutationSummary.Total, "by_source": mutationSummary.BySource, "hottest": mutationSummary.HotSpots[0].Target})
+		}
+	}
+	networkSummary := summarizeEntries(networkEntries, networkLogPath)
+	scriptEgress := analyzeScriptEgress(networkEntries, gmRequests, scriptMeta.Connect, entryHost(opts.TargetURL), secrets)
+	if len(scriptEgress.Undeclared) > 0 {
+		logger.warn("egress", "userscript contacted hosts missing from @connect", map[string]any{"hosts": scriptEgress.Undeclared})
+	}
+	for _, fl := range scriptEgress.Flags {
+		logger.warn("egress", "possible data exfiltration", map[string]any{"url": fl.URL, "kind": fl.Kind, "reason": fl.Reason})
+	}
+	var networkResults []NetworkAssertionResult
+	for _, a := range opts.NetworkAssertions {
+		r := evaluateNetworkAssertion(a, networkEntries, entryHost(opts.TargetURL))
+		if !r.Passed {
+			logger.warn("network", "assertion failed", map[string]any{"message": r.Message, "evidence": r.Evidence})
+		}
+		networkResults = append(networkResults, r)
+	}
+
+	// Closed after the context so in-flight worker traffic is recorded.
+	var proxyReport *ProxyReport
+	if mitm != nil {
+		proxyReport = mitm.finish()
+	}
+
+	// The HAR is only written when the context closes.
+	var harName string
+	var harInfo *HARCapture
+	if opts.CaptureHAR {
+		if err := validateHAR(harPath, &harCapture); err != nil {
+			logger.warn("har", "recorded HAR is invalid", map[string]any{"error": err.Error()})
+		} else {
+			harName, harInfo = filepath.Base(harPath), &harCapture
+			logger.info("har", "HAR captured", map[string]any{"path": harPath, "entries": harCapture.Entries})
+		}
+	}
+
+	status := "passed"
+	for _, r := range stepResults {
+		if !r.Passed {
+			status = "failed"
+			break
+		}
+	}
+	for _, r := range networkResults {
+		if !r.Passed {
+			status = "failed"
+		}
+	}
+	if visual != nil && !visual.Passed {
+		logger.warn("visual", "diff ratio above the pass threshold", map[string]any{"ratio": visual.DiffRatio, "max": visual.MaxRatio})
+		status = "failed"
+	}
+	if aborted {
+		status = "aborted"
+	}
 
 	manifest := Manifest{
-		RunID:            runID,
-		StartedAt:        start,
-		FinishedAt:       time.Now(),
-		TargetURL:        opts.TargetURL,
-		Screenshot:       filepath.Base(screenshotPath),
-		VisualHash:       visualHash,
-		VisualDiff:       visualDiff,
-		VisualDiffImg:    visualDiffImg,
-		VisualDiffPixels: visualDiffPixels,
-		VisualDiffRatio:  visualDiffRatio,
-		VideoWebM:        filepath.Base(videoPath),
-		VideoWebP:        filepath.Base(webpPath),
-		TraceZip:         traceNameIfExists(artifactsDir),
-		HAR:              harNameIfExists(artifactsDir),
-		ReplayHAR:        opts.ReplayHAR,
-		ScriptMeta:       scriptMeta,
-		ProfileFolder:    profileDir,
-		Engine:           opts.Engine,
-		ExtensionDir:     opts.ExtensionDir,
-		LogPath:          logPath,
-		NetworkIssues:    summarizeNetwork(responses, opts.BlockedHosts, logger),
+		RunID:             runID,
+		StartedAt:         start,
+		FinishedAt:        time.Now(),
+		TargetURL:         opts.TargetURL,
+		Screenshot:        filepath.Base(screenshotPath),
+		VisualHash:        visualHash,
+		Visual:            visual,
+		VideoWebM:         filepath.Base(videoPath),
+		VideoWebP:         filepath.Base(webpPath),
+		TraceChunks:       traceChunks,
+		HAR:               harName,
+		HARCapture:        harInfo,
+		ReplayHAR:         opts.ReplayHAR,
+		HARReplay:         harReplay,
+		Proxy:             proxyReport,
+		Emulation:         emulationReport,
+		Deterministic:     determinism,
+		Environment:       pageEnv,
+		Parent:            opts.parentRun,
+		Cell:              opts.cell,
+		Impact:            opts.impact,
+		ScriptMeta:        scriptMeta,
+		ProfileFolder:     profileDir,
+		Engine:            opts.Engine,
+		ExtensionDir:      opts.ExtensionDir,
+		LogPath:           logPath,
+		NetworkIssues:     summarizeNetwork(networkEntries, opts.BlockedHosts, logger),
+		Network:           &networkSummary,
+		Mutations:         mutationSummary,
+		NetworkAssertions: networkResults,
+		Sandbox:           sandboxReport,
+		ScriptEgress:      scriptEgress,
+		Mocks:             mocks.snapshot(),
+		Status:            status,
+		ProposedSteps:     proposedSteps,
+		Steps:             stepResults,
+		Dialogs:           dialogs.snapshot(),
+		MenuCommands:      menuCommands,
+		Downloads:         downloadRecords,
+		Screenshots:       shots.shots,
+	}
+	if shots.store != nil {
+		dir, _ := filepath.Abs(opts.BaselineDir)
+		manifest.Baseline = &BaselineRef{Dir: dir, Set: shots.key.ID(), Key: shots.key, Pending: shots.pending()}
+		if len(manifest.Baseline.Pending) > 0 {
+			logger.info("visual", "baselines pending approval", map[string]any{"checkpoints": manifest.Baseline.Pending, "approve": "lab baseline approve " + runID})
+		}
+	}
+	if visual != nil {
+		manifest.VisualDiff = visual.DiffPixels > 0
+		manifest.VisualDiffImg = visual.DiffImg
+		manifest.VisualCompareImg = visual.CompareImg
+		manifest.VisualDiffPixels = visual.DiffPixels
+		manifest.VisualDiffRatio = visual.DiffRatio
 	}
 
 	manifestPath := filepath.Join(runDir, "run.json")
@@ -384,7 +752,8 @@ func Run(opts Options) (Result, error) {
 // --- helpers ---
 
 type ndjsonLogger struct {
-	w *bufio.Writer
+	mu sync.Mutex // route handlers and the proxy log from their own goroutines
+	w  *bufio.Writer
 }
 
 type logLine struct {
@@ -402,6 +771,8 @@ func newNDJSONLogger(file *os.File) *ndjsonLogger {
 func (l *ndjsonLogger) write(level, scope, msg string, meta map[string]any) {
 	line := logLine{TS: time.Now(), Level: level, Scope: scope, Msg: msg, Meta: meta}
 	b, _ := json.Marshal(line)
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	l.w.Write(b)
 	l.w.WriteByte('\n')
 	l.w.Flush()
@@ -458,6 +829,21 @@ func tryFfmpegWebp(input, output string) error {
 	return cmd.Run()
 }
 
+// writeProposedSteps saves the debug session's step list and returns its artifact name.
+func writeProposedSteps(artifactsDir string, steps []Step, logger *ndjsonLogger) string {
+	if len(steps) == 0 {
+		return ""
+	}
+	out := filepath.Join(artifactsDir, "proposed-steps.json")
+	b, _ := json.MarshalIndent(steps, "", "  ")
+	if err := os.WriteFile(out, b, 0o644); err != nil {
+		logger.warn("debug", "write proposed steps failed", map[string]any{"error": err.Error()})
+		return ""
+	}
+	logger.info("debug", "proposed steps written", map[string]any{"path": out, "count": len(steps)})
+	return filepath.Base(out)
+}
+
 func writeManifest(path string, manifest Manifest) error {
 	file, err := os.Create(path)
 	if err != nil {
@@ -510,132 +896,18 @@ func DiscoverExtensionDir() string {
 	return strings.TrimSpace(ext)
 }
 
-// computeVisualHash creates a SHA256 of the screenshot and compares to any baseline.
-func computeVisualHash(screenshotPath string, opts Options, artifactsDir string, logger *ndjsonLogger) (hash string, diff bool, diffImg string, diffPixels int, diffRatio float64) {
-	data, err := os.ReadFile(screenshotPath)
-	if err != nil || len(data) == 0 {
-		return "", false, "", 0, 0
-	}
-	sum := fmt.Sprintf("%x", sha256.Sum256(data))
-	hash = sum
-	if opts.BaselineDir == "" {
-		return hash, false, "", 0, 0
-	}
-	if err := os.MkdirAll(opts.BaselineDir, 0o755); err != nil {
-		logger.warn("visual", "baseline dir create failed", map[string]any{"error": err.Error()})
-		return hash, false, "", 0, 0
-	}
-	basePath := filepath.Join(opts.BaselineDir, "screenshot.png")
-	if _, err := os.Stat(basePath); err != nil {
-		_ = os.WriteFile(basePath, data, 0o644)
-		logger.info("visual", "baseline created", map[string]any{"path": basePath})
-		return hash, false, "", 0, 0
-	}
-	baseData, err := os.ReadFile(basePath)
-	if err != nil {
-		logger.warn("visual", "baseline read failed", map[string]any{"error": err.Error()})
-		return hash, false, "", 0, 0
-	}
-	baseHash := fmt.Sprintf("%x", sha256.Sum256(baseData))
-	if baseHash != hash {
-		logger.warn("visual", "screenshot hash mismatch vs baseline", map[string]any{"baseline": baseHash, "current": hash})
-		diffImg, diffPixels, diffRatio = computeDiffImage(baseData, data, artifactsDir, logger, opts.VisualDiffThreshold)
-		return hash, true, diffImg, diffPixels, diffRatio
-	}
-	logger.info("visual", "screenshot matches baseline", nil)
-	return hash, false, "", 0, 0
-}
-
-// computeDiffImage generates a simple diff heatmap (red overlay) when sizes match.
-func computeDiffImage(basePNG, currentPNG []byte, artifactsDir string, logger *ndjsonLogger, threshold float64) (string, int, float64) {
-	baseImg, err := png.Decode(bytes.NewReader(basePNG))
-	if err != nil {
-		logger.warn("visual", "decode baseline failed", map[string]any{"error": err.Error()})
-		return "", 0, 0
-	}
-	currImg, err := png.Decode(bytes.NewReader(currentPNG))
-	if err != nil {
-		logger.warn("visual", "decode current failed", map[string]any{"error": err.Error()})
-		return "", 0, 0
-	}
-	if baseImg.Bounds() != currImg.Bounds() {
-		logger.warn("visual", "baseline/current size mismatch", nil)
-		return "", 0, 0
-	}
-	bounds := baseImg.Bounds()
-	diff := image.NewRGBA(bounds)
-	var changed int
-	total := (bounds.Max.X - bounds.Min.X) * (bounds.Max.Y - bounds.Min.Y)
-	thr := threshold
-	if thr < 0 {
-		thr = 0
-	}
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			br, bg, bb, _ := baseImg.At(x, y).RGBA()
-			cr, cg, cb, _ := currImg.At(x, y).RGBA()
-			dr := absDiff(br, cr)
-			dg := absDiff(bg, cg)
-			db := absDiff(bb, cb)
-			if float64(dr) > thr || float64(dg) > thr || float64(db) > thr {
-				changed++
-				diff.Set(x, y, color.RGBA{R: 255, A: 180})
-			} else {
-				diff.Set(x, y, color.RGBA{A: 0})
-			}
-		}
-	}
-	if changed == 0 {
-		return "", 0, 0
-	}
-	out := filepath.Join(artifactsDir, "visual-diff.png")
-	f, err := os.Create(out)
-	if err != nil {
-		logger.warn("visual", "write diff failed", map[string]any{"error": err.Error()})
-		return "", changed, float64(changed) / float64(total)
-	}
-	defer f.Close()
-	if err := png.Encode(f, diff); err != nil {
-		logger.warn("visual", "encode diff failed", map[string]any{"error": err.Error()})
-		return "", changed, float64(changed) / float64(total)
-	}
-	logger.warn("visual", "diff image generated", map[string]any{"path": out, "pixels_changed": changed})
-	return filepath.Base(out), changed, float64(changed) / float64(total)
-}
-
-func absDiff(a, b uint32) uint32 {
-	if a > b {
-		return a - b
-	}
-	return b - a
-}
-func harNameIfExists(artifactsDir string) string {
-	har := filepath.Join(artifactsDir, "network.har")
-	if _, err := os.Stat(har); err == nil {
-		return filepath.Base(har)
-	}
-	return ""
-}
-
-func traceNameIfExists(artifactsDir string) string {
-	trace := filepath.Join(artifactsDir, "trace.zip")
-	if _, err := os.Stat(trace); err == nil {
-		return filepath.Base(trace)
-	}
-	return ""
-}
-
-func summarizeNetwork(responses []playwright.Response, blocked []string, logger *ndjsonLogger) []string {
+func summarizeNetwork(entries []NetworkEntry, blocked []string, logger *ndjsonLogger) []string {
 	var issues []string
-	for _, r := range responses {
-		status := r.Status()
-		url := r.URL()
+	for _, e := range entries {
+		status := e.Status
+		url := e.URL
 		if status >= 400 {
 			msg := fmt.Sprintf("status %d for %s", status, url)
 			issues = append(issues, msg)
 		}
-		for _, host := range blocked {
-			if strings.Contains(url, host) {
+		host := entryHost(url)
+		for _, pattern := range blocked {
+			if hostMatches(host, pattern) {
 				msg := fmt.Sprintf("blocked host seen: %s", url)
 				issues = append(issues, msg)
 			}
@@ -666,6 +938,23 @@ func detectExtensionID(ctx playwright.BrowserContext) string {
 	return ""
 }
 
+// engineExtensionID returns the extension id of the installed engine. Only
+// Tampermonkey has a known fallback id.
+func engineExtensionID(ctx playwright.BrowserContext, engine string) string {
+	if engine == engineTampermonkey {
+		return tampermonkeyID(ctx)
+	}
+	return detectExtensionID(ctx)
+}
+
+// tampermonkeyID returns the detected extension id, falling back to the known TM MV3 id.
+func tampermonkeyID(ctx playwright.BrowserContext) string {
+	if detected := detectExtensionID(ctx); detected != "" {
+		return detected
+	}
+	return "dhdgffkkebhmkfjojejmpbldmpobfkfo"
+}
+
 // installTampermonkey attempts deterministic install of the provided userscript into TM MV3.
 // For now it opens the internal userscript.html import page and drops the file via file chooser.
 func installTampermonkey(ctx playwright.BrowserContext, scriptPath string, logger *ndjsonLogger) bool {
@@ -674,11 +963,8 @@ func installTampermonkey(ctx playwright.BrowserContext, scriptPath string, logge
 		logger.warn("tm", "new page failed", map[string]any{"error": err.Error()})
 		return false
 	}
-	extID := "dhdgffkkebhmkfjojejmpbldmpobfkfo" // known TM MV3 id
-	if detected := detectExtensionID(ctx); detected != "" {
-		extID = detected
-		logger.info("tm", "using detected extension id", map[string]any{"id": extID})
-	}
+	extID := tampermonkeyID(ctx)
+	logger.info("tm", "using extension id", map[string]any{"id": extID})
 	localURL := fmt.Sprintf("chrome-extension://%s/userscript.html", extID)
 	if _, err := page.Goto(localURL, playwright.PageGotoOptions{WaitUntil: playwright.WaitUntilStateNetworkidle}); err != nil {
 		logger.warn("tm", "open userscript.html failed", map[string]any{"error": err.Error()})
@@ -706,88 +992,365 @@ func installViolentmonkey(ctx playwright.BrowserContext, logger *ndjsonLogger) b
 	return false
 }
 
+// stepRunner carries the page and run-scoped collectors that steps act on.
+type stepRunner struct {
+	page           playwright.Page
+	logger         *ndjsonLogger
+	dialogs        *dialogHandler
+	downloads      *downloadCollector
+	engine         string // installed engine; empty in init-script mode
+	extensionID    string // extension id of engine
+	scriptName     string
+	debug          *debugger // nil unless Options.Debug
+	network        *networkRecorder
+	targetHost     string
+	mocks          *mockRegistry
+	trace          *traceRecorder // nil unless Options.CaptureTrace
+	shots          *screenshotCollector
+	mutations      *mutationJournal // nil unless Options.Mutations
+	engineCommands []MenuCommand    // menu commands found in the engine popup
+	step           int              // 1-based index of the step being executed; 0 for ad-hoc debug steps
+	aborted        bool
+}
+
+// StepResult records the outcome of a single flow step.
+type StepResult struct {
+	Index      int    `json:"index"`
+	Action     string `json:"action"`
+	Target     string `json:"target,omitempty"`
+	Passed     bool   `json:"passed"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	Trace      string `json:"trace,omitempty"` // trace chunk covering this attempt
+}
+
+// defaultToggle is what a run without steps does: wait for the demo
+// script's toggle button and click it. Impact runs skip it in both children,
+// so that without steps they compare the page as loaded and the reference
+// run does not sit out the wait for a button only the script adds.
+func defaultToggle(page playwright.Page, logger *ndjsonLogger, impact string) {
+	if impact != "" {
+		logger.info("runner", "impact run without steps; default toggle skipped", nil)
+		return
+	}
+	if _, err := page.WaitForSelector("text=Toggle Dark Mode", playwright.PageWaitForSelectorOptions{
+		Timeout: playwright.Float(8_000),
+	}); err != nil {
+		logger.warn("assert", "toggle button not found", map[string]any{"error": err.Error()})
+	} else {
+		logger.info("assert", "toggle button present", nil)
+	}
+	if err := page.Click("text=Toggle Dark Mode"); err != nil {
+		logger.warn("action", "click toggle failed", map[string]any{"error": err.Error()})
+	} else {
+		logger.info("action", "toggled dark mode", nil)
+	}
+}
+
 // executeSteps runs a minimal action/assertion DSL against the page.
-func executeSteps(page playwright.Page, steps []Step, logger *ndjsonLogger) {
-	for i, step := range steps {
-		scope := fmt.Sprintf("step-%d", i+1)
-		switch strings.ToLower(step.Action) {
-		case "click":
-			if err := page.Click(step.Target); err != nil {
-				logger.warn(scope, "click failed", map[string]any{"error": err.Error(), "target": step.Target})
-			} else {
-				logger.info(scope, "click ok", map[string]any{"target": step.Target})
-			}
-		case "fill":
-			if err := page.Fill(step.Target, step.Value); err != nil {
-				logger.warn(scope, "fill failed", map[string]any{"error": err.Error(), "target": step.Target})
-			} else {
-				logger.info(scope, "fill ok", map[string]any{"target": step.Target})
-			}
-		case "waitforselector":
-			if _, err := page.WaitForSelector(step.Target, playwright.PageWaitForSelectorOptions{Timeout: playwright.Float(8000)}); err != nil {
-				logger.warn(scope, "waitForSelector failed", map[string]any{"error": err.Error(), "target": step.Target})
-			} else {
-				logger.info(scope, "selector present", map[string]any{"target": step.Target})
+func executeSteps(sr *stepRunner, steps []Step) []StepResult {
+	results := make([]StepResult, 0, len(steps))
+	d := sr.debug
+	for i := 0; i < len(steps); i++ {
+		step := steps[i]
+		if d != nil && d.shouldBreak(i+1) {
+			if d.pause(sr, "breakpoint", i+1, step, nil) == pauseAbort {
+				sr.aborted = true
+				break
 			}
-		case "wait":
-			d := 500.0
-			if v, err := strconv.ParseFloat(step.Value, 64); err == nil && v > 0 {
-				d = v
-			}
-			page.WaitForTimeout(d)
-			logger.info(scope, "waited", map[string]any{"ms": d})
-		case "assert-text", "assert-equals":
-			text, err := page.TextContent(step.Target)
-			if err != nil {
-				logger.warn(scope, "assert-text failed", map[string]any{"error": err.Error(), "target": step.Target})
-				continue
-			}
-			got := strings.TrimSpace(text)
-			if got != step.Value {
-				logger.warn(scope, "assert-text mismatch", map[string]any{"target": step.Target, "expected": step.Value, "got": got})
-			} else {
-				logger.info(scope, "assert-text ok", map[string]any{"target": step.Target, "value": got})
-			}
-		case "assert-contains":
-			text, err := page.TextContent(step.Target)
-			if err != nil {
-				logger.warn(scope, "assert-contains failed", map[string]any{"error": err.Error(), "target": step.Target})
-				continue
-			}
-			got := strings.TrimSpace(text)
-			if !strings.Contains(got, step.Value) {
-				logger.warn(scope, "assert-contains mismatch", map[string]any{"target": step.Target, "expected_substring": step.Value, "got": got})
-			} else {
-				logger.info(scope, "assert-contains ok", map[string]any{"target": step.Target, "value": got})
-			}
-		case "assert-exists":
-			if _, err := page.WaitForSelector(step.Target, playwright.PageWaitForSelectorOptions{Timeout: playwright.Float(5000)}); err != nil {
-				logger.warn(scope, "assert-exists failed", map[string]any{"error": err.Error(), "target": step.Target})
-			} else {
-				logger.info(scope, "assert-exists ok", map[string]any{"target": step.Target})
-			}
-		case "assert-not-exists":
-			_, err := page.WaitForSelector(step.Target, playwright.PageWaitForSelectorOptions{Timeout: playwright.Float(3000), State: playwright.WaitForSelectorStateDetached})
-			if err != nil {
-				logger.warn(scope, "assert-not-exists failed", map[string]any{"error": err.Error(), "target": step.Target})
-			} else {
-				logger.info(scope, "assert-not-exists ok", map[string]any{"target": step.Target})
+		}
+		res := sr.execute(i, step)
+		keep := res.Passed
+		if !res.Passed && d != nil && d.shouldPauseOnFailure() {
+		repl:
+			for {
+				switch d.pause(sr, "failure", i+1, step, errors.New(res.Error)) {
+				case pauseRetry:
+					if res = sr.execute(i, step); res.Passed {
+						keep = true
+						break repl
+					}
+				case pauseSkip:
+					break repl
+				case pauseAbort:
+					sr.aborted = true
+					break repl
+				default:
+					// continue/next leave the failed step out of the proposed list.
+					break repl
+				}
 			}
-		case "assert-attr":
-			val, err := page.GetAttribute(step.Target, step.Attr)
-			if err != nil {
-				logger.warn(scope, "assert-attr failed", map[string]any{"error": err.Error(), "target": step.Target, "attr": step.Attr})
-				continue
+		}
+		results = append(results, res)
+		if d != nil && keep {
+			d.proposed = append(d.proposed, step)
+		}
+		if sr.aborted {
+			break
+		}
+	}
+	sr.dialogs.setStep(0, "")
+	sr.downloads.setStep(0)
+	return results
+}
+
+// execute runs step i and wraps the outcome in a StepResult.
+func (sr *stepRunner) execute(i int, step Step) StepResult {
+	scope := fmt.Sprintf("step-%d", i+1)
+	sr.dialogs.setStep(i+1, step.Dialog)
+	sr.downloads.setStep(i + 1)
+	sr.step = i + 1
+	defer func() { sr.step = 0 }()
+	sr.trace.begin(i+1, step.Action)
+	started := time.Now()
+	err := sr.runStep(scope, step)
+	res := StepResult{
+		Index:      i + 1,
+		Action:     step.Action,
+		Target:     step.Target,
+		Passed:     err == nil,
+		DurationMS: time.Since(started).Milliseconds(),
+		Trace:      sr.trace.current(),
+	}
+	if err != nil {
+		res.Error = err.Error()
+		sr.logger.warn(scope, strings.ToLower(step.Action)+" failed", map[string]any{"error": err.Error(), "target": step.Target})
+	}
+	return res
+}
+
+// runStep executes one step, logging success and returning an error on failure.
+func (sr *stepRunner) runStep(scope string, step Step) error {
+	page, logger := sr.page, sr.logger
+	switch strings.ToLower(step.Action) {
+	case "click":
+		if err := page.Click(step.Target); err != nil {
+			return err
+		}
+		logger.info(scope, "click ok", map[string]any{"target": step.Target})
+	case "fill":
+		if err := page.Fill(step.Target, step.Value); err != nil {
+			return err
+		}
+		logger.info(scope, "fill ok", map[string]any{"target": step.Target})
+	case "select":
+		if _, err := page.SelectOption(step.Target, playwright.SelectOptionValues{Values: &[]string{step.Value}}); err != nil {
+			return err
+		}
+		logger.info(scope, "select ok", map[string]any{"target": step.Target, "value": step.Value})
+	case "press":
+		// Value is a key or chord such as "Enter" or "Control+K"; without a Target
+		// the key goes to whatever element has focus.
+		if step.Target == "" {
+			if err := page.Keyboard().Press(step.Value); err != nil {
+				return err
 			}
-			if val != step.Value {
-				logger.warn(scope, "assert-attr mismatch", map[string]any{"target": step.Target, "attr": step.Attr, "expected": step.Value, "got": val})
-			} else {
-				logger.info(scope, "assert-attr ok", map[string]any{"target": step.Target, "attr": step.Attr, "value": val})
+		} else if err := page.Press(step.Target, step.Value); err != nil {
+			return err
+		}
+		logger.info(scope, "press ok", map[string]any{"target": step.Target, "key": step.Value})
+	case "goto":
+		if _, err := page.Goto(step.Value, playwright.PageGotoOptions{WaitUntil: playwright.WaitUntilStateLoad}); err != nil {
+			return err
+		}
+		logger.info(scope, "goto ok", map[string]any{"url": step.Value})
+	case "waitforselector":
+		if _, err := page.WaitForSelector(step.Target, playwright.PageWaitForSelectorOptions{Timeout: playwright.Float(8000)}); err != nil {
+			return err
+		}
+		logger.info(scope, "selector present", map[string]any{"target": step.Target})
+	case "wait":
+		d := 500.0
+		if v, err := strconv.ParseFloat(step.Value, 64); err == nil && v > 0 {
+			d = v
+		}
+		page.WaitForTimeout(d)
+		logger.info(scope, "waited", map[string]any{"ms": d})
+	case "choose-files":
+		// Target opens the chooser; Value lists the files to answer it with.
+		files := splitList(step.Value)
+		chooser, err := page.ExpectFileChooser(func() error {
+			return page.Click(step.Target)
+		}, playwright.PageExpectFileChooserOptions{Timeout: playwright.Float(8000)})
+		if err != nil {
+			return err
+		}
+		if err := chooser.SetFiles(files); err != nil {
+			return err
+		}
+		logger.info(scope, "file chooser answered", map[string]any{"target": step.Target, "files": files})
+	case "assert-text", "assert-equals":
+		text, err := page.TextContent(step.Target)
+		if err != nil {
+			return err
+		}
+		got := strings.TrimSpace(text)
+		if got != step.Value {
+			return fmt.Errorf("assert-text mismatch: expected %q, got %q", step.Value, got)
+		}
+		logger.info(scope, "assert-text ok", map[string]any{"target": step.Target, "value": got})
+	case "assert-contains":
+		text, err := page.TextContent(step.Target)
+		if err != nil {
+			return err
+		}
+		got := strings.TrimSpace(text)
+		if !strings.Contains(got, step.Value) {
+			return fmt.Errorf("assert-contains mismatch: expected substring %q, got %q", step.Value, got)
+		}
+		logger.info(scope, "assert-contains ok", map[string]any{"target": step.Target, "value": got})
+	case "assert-exists":
+		if _, err := page.WaitForSelector(step.Target, playwright.PageWaitForSelectorOptions{Timeout: playwright.Float(5000)}); err != nil {
+			return err
+		}
+		logger.info(scope, "assert-exists ok", map[string]any{"target": step.Target})
+	case "assert-not-exists":
+		if _, err := page.WaitForSelector(step.Target, playwright.PageWaitForSelectorOptions{Timeout: playwright.Float(3000), State: playwright.WaitForSelectorStateDetached}); err != nil {
+			return err
+		}
+		logger.info(scope, "assert-not-exists ok", map[string]any{"target": step.Target})
+	case "assert-attr":
+		val, err := page.GetAttribute(step.Target, step.Attr)
+		if err != nil {
+			return err
+		}
+		if val != step.Value {
+			return fmt.Errorf("assert-attr mismatch on %s: expected %q, got %q", step.Attr, step.Value, val)
+		}
+		logger.info(scope, "assert-attr ok", map[string]any{"target": step.Target, "attr": step.Attr, "value": val})
+	case "assert-dialog":
+		// Value is a substring of the dialog message; Target optionally narrows the dialog type.
+		found := sr.dialogs.await(step.Target, step.Value, 5*time.Second)
+		if found == nil {
+			return fmt.Errorf("no %sdialog with message containing %q", typePrefix(step.Target), step.Value)
+		}
+		logger.info(scope, "assert-dialog ok", map[string]any{"type": found.Type, "message": found.Message})
+	case "assert-download":
+		// Value is an exact suggested filename or a path.Match glob such as "*.csv".
+		found := sr.downloads.await(step.Value, 10*time.Second)
+		if found == nil {
+			return fmt.Errorf("no download named %q", step.Value)
+		}
+		if found.Error != "" {
+			return fmt.Errorf("download %q failed: %s", found.Name, found.Error)
+		}
+		logger.info(scope, "assert-download ok", map[string]any{"name": found.Name, "size": found.Size})
+	case "eval":
+		out, err := page.Evaluate(step.Value)
+		if err != nil {
+			return err
+		}
+		logger.info(scope, "eval ok", map[string]any{"result": out})
+	case "assert-network":
+		if step.Network == nil {
+			return errors.New("assert-network needs a network assertion")
+		}
+		a := *step.Network
+		// Expectations about requests still to come get a grace period; negative
+		// checks and the host allowlist are judged on what has happened so far.
+		var res NetworkAssertionResult
+		eval := func() bool {
+			res = evaluateNetworkAssertion(a, sr.network.snapshot(), sr.targetHost)
+			return res.Passed
+		}
+		if len(a.AllowHosts) == 0 && (a.Made == nil || *a.Made) {
+			pollUntil(5*time.Second, eval)
+		} else {
+			eval()
+		}
+		if !res.Passed {
+			logger.warn(scope, "assert-network evidence", map[string]any{"evidence": res.Evidence})
+			if len(res.Evidence) > 0 {
+				return fmt.Errorf("%s (e.g. %s %s)", res.Message, res.Evidence[0].Method, res.Evidence[0].URL)
 			}
-		default:
-			logger.warn(scope, "unknown action", map[string]any{"action": step.Action})
+			return errors.New(res.Message)
+		}
+		logger.info(scope, "assert-network ok", map[string]any{"url": a.URL})
+	case "assert-mutations":
+		// Value is "max=N", "per-second=N" and optionally "source=userscript";
+		// counts cover the whole run so far.
+		if sr.mutations == nil {
+			return errors.New("assert-mutations needs the mutation journal, which could not be started")
+		}
+		limit, err := parseMutationLimit(step.Value)
+		if err != nil {
+			return err
+		}
+		if err := sr.mutations.flush(page); err != nil {
+			return err
+		}
+		if err := sr.mutations.check(limit); err != nil {
+			return fmt.Errorf("assert-mutations: %w", err)
+		}
+		total, peak := sr.mutations.count(limit.Source)
+		logger.info(scope, "assert-mutations ok", map[string]any{"total": total, "peak_per_second": peak, "source": limit.Source})
+	case "mock", "fault":
+		var spec MockSpec
+		if step.Mock != nil {
+			spec = *step.Mock
+		}
+		action := strings.ToLower(step.Action)
+		if err := sr.mocks.add(sr.step, action, step.Target, step.Value, spec); err != nil {
+			return err
+		}
+		logger.info(scope, action+" installed", map[string]any{"url": step.Target, "fault": step.Value})
+	case "unmock":
+		if err := sr.mocks.remove(step.Target); err != nil {
+			return err
+		}
+		logger.info(scope, "mocks removed", map[string]any{"url": step.Target})
+	case "screenshot":
+		// Value names the checkpoint; Target, if set, screenshots one element.
+		var spec ScreenshotSpec
+		if step.Shot != nil {
+			spec = *step.Shot
+		}
+		shot, err := sr.shots.capture(page, sr.step, step.Value, step.Target, spec.Mode, spec.Ignore)
+		if err != nil {
+			return err
+		}
+		logger.info(scope, "screenshot ok", map[string]any{"name": step.Value, "path": shot.Path})
+	case "menu-command":
+		if err := sr.invokeMenuCommand(step.Value); err != nil {
+			return err
+		}
+		logger.info(scope, "menu command invoked", map[string]any{"caption": step.Value})
+	case "assert-menu-command":
+		if err := sr.assertMenuCommand(step.Value); err != nil {
+			return err
+		}
+		logger.info(scope, "assert-menu-command ok", map[string]any{"caption": step.Value})
+	default:
+		logger.warn(scope, "unknown action", map[string]any{"action": step.Action})
+	}
+	return nil
+}
+
+func typePrefix(t string) string {
+	if t == "" {
+		return ""
+	}
+	return t + " "
+}
+
+// matchName reports whether name equals pattern or matches it as a glob.
+func matchName(pattern, name string) bool {
+	if pattern == name {
+		return true
+	}
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
+
+// splitList splits a comma-separated value, dropping blanks.
+func splitList(v string) []string {
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		if trimmed := strings.TrimSpace(part); trimmed != "" {
+			out = append(out, trimmed)
 		}
 	}
+	return out
 }
 
 func fetchScript(url string) (string, error) {
@@ -0,0 +1,154 @@
+package runner
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// maxSandboxBlocks caps the blocked requests listed in the manifest.
+const maxSandboxBlocks = 200
+
+// SandboxBlock is a request the enforcing sandbox aborted.
+type SandboxBlock struct {
+	At           time.Time `json:"at"`
+	Method       string    `json:"method"`
+	URL          string    `json:"url"`
+	Host         string    `json:"host"`
+	ResourceType string    `json:"resource_type"`
+	Frame        string    `json:"frame,omitempty"`
+	Initiator    string    `json:"initiator"` // page, userscript or unknown
+	Stack        []string  `json:"stack,omitempty"`
+}
+
+// SandboxReport summarises the network sandbox for the manifest.
+type SandboxReport struct {
+	Enforced     bool           `json:"enforced"`
+	Allowlist    []string       `json:"allowlist"`
+	BlockedCount int            `json:"blocked_count"`
+	Blocked      []SandboxBlock `json:"blocked,omitempty"`
+}
+
+// networkSandbox aborts every request whose host is outside the allowlist.
+type networkSandbox struct {
+	mu        sync.Mutex
+	allow     []string
+	count     int
+	blocked   []SandboxBlock
+	initiator *initiatorTracker
+	redact    *redactor
+	logger    *ndjsonLogger
+}
+
+// sandboxAllowlist derives allowed hosts from the target, @connect and config.
+// "self" in @connect means the target host; "*" is ignored so a script cannot
+// opt itself out of containment.
+func sandboxAllowlist(targetHost string, connect, extra []string, logger *ndjsonLogger) []string {
+	seen := map[string]bool{}
+	var out []string
+	add := func(h string) {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h != "" && !seen[h] {
+			seen[h] = true
+			out = append(out, h)
+		}
+	}
+	add(targetHost)
+	for _, c := range connect {
+		switch c = strings.TrimSpace(c); c {
+		case "self":
+			add(targetHost)
+		case "*":
+			logger.warn("sandbox", "@connect * ignored by enforcing sandbox", nil)
+		default:
+			add(c)
+		}
+	}
+	for _, h := range extra {
+		add(h)
+	}
+	return out
+}
+
+func newNetworkSandbox(allow []string, initiator *initiatorTracker, redact *redactor, logger *ndjsonLogger) *networkSandbox {
+	return &networkSandbox{allow: allow, initiator: initiator, redact: redact, logger: logger}
+}
+
+func (s *networkSandbox) allowed(host string) bool {
+	for _, p := range s.allow {
+		if hostMatches(host, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// install routes all context traffic, including WebSockets, through the sandbox.
+// Allowed requests fall back to any later-registered handlers (HAR replay, mocks).
+func (s *networkSandbox) install(ctx playwright.BrowserContext) error {
+	if err := ctx.Route("**/*", s.handle); err != nil {
+		return err
+	}
+	return ctx.RouteWebSocket("**/*", func(ws playwright.WebSocketRoute) {
+		host := entryHost(ws.URL())
+		if host == "" || s.allowed(host) {
+			if _, err := ws.ConnectToServer(); err != nil {
+				s.logger.warn("sandbox", "websocket connect failed", map[string]any{"url": s.redact.url(ws.URL()), "error": err.Error()})
+			}
+			return
+		}
+		s.record(SandboxBlock{At: time.Now(), Method: "GET", URL: s.redact.url(ws.URL()), Host: host, ResourceType: "websocket", Initiator: initiatorUnknown})
+		ws.Close()
+	})
+}
+
+func (s *networkSandbox) handle(route playwright.Route) {
+	req := route.Request()
+	host := entryHost(req.URL())
+	if host == "" || s.allowed(host) {
+		_ = route.Fallback()
+		return
+	}
+	if err := route.Abort("blockedbyclient"); err != nil {
+		s.logger.warn("sandbox", "abort failed", map[string]any{"url": s.redact.url(req.URL()), "error": err.Error()})
+	}
+	b := SandboxBlock{
+		At:           time.Now(),
+		Method:       req.Method(),
+		URL:          s.redact.url(req.URL()),
+		Host:         host,
+		ResourceType: req.ResourceType(),
+		Initiator:    initiatorUnknown,
+	}
+	if f := req.Frame(); f != nil {
+		b.Frame = s.redact.url(f.URL())
+	}
+	if s.initiator != nil {
+		info := s.initiator.lookup(req.Method(), req.URL(), 250*time.Millisecond)
+		b.Initiator, b.Stack = info.Kind, info.Stack
+	}
+	s.record(b)
+}
+
+func (s *networkSandbox) record(b SandboxBlock) {
+	s.logger.warn("sandbox", "request blocked", map[string]any{"url": b.URL, "host": b.Host, "initiator": b.Initiator, "type": b.ResourceType})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count++
+	if len(s.blocked) < maxSandboxBlocks {
+		s.blocked = append(s.blocked, b)
+	}
+}
+
+func (s *networkSandbox) report() *SandboxReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &SandboxReport{
+		Enforced:     true,
+		Allowlist:    append([]string(nil), s.allow...),
+		BlockedCount: s.count,
+		Blocked:      append([]SandboxBlock(nil), s.blocked...),
+	}
+}
@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestSandboxAllowlist(t *testing.T) {
+	logger := &ndjsonLogger{w: bufio.NewWriter(io.Discard)}
+	got := sandboxAllowlist("en.wikipedia.org", []string{"self", "*", "api.example.com", "API.example.com"}, []string{"cdn.example.net"}, logger)
+	want := []string{"en.wikipedia.org", "api.example.com", "cdn.example.net"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("allowlist = %v, want %v", got, want)
+	}
+
+	s := newNetworkSandbox(got, nil, nil, logger)
+	for host, ok := range map[string]bool{
+		"en.wikipedia.org":        true,
+		"upload.en.wikipedia.org": true,
+		"wikipedia.org":           false,
+		"evil-example.com":        false,
+		"api.example.com":         true,
+	} {
+		if s.allowed(host) != ok {
+			t.Errorf("allowed(%q) = %v, want %v", host, !ok, ok)
+		}
+	}
+}
+
+func TestClassifyInitiator(t *testing.T) {
+	page := classifyInitiator(map[string]any{"type": "parser", "url": "https://example.com/"})
+	if page.Kind != initiatorPage {
+		t.Fatalf("parser initiator kind = %q", page.Kind)
+	}
+	script := classifyInitiator(map[string]any{
+		"type": "script",
+		"stack": map[string]any{
+			"callFrames": []any{map[string]any{"url": "https://example.com/app.js", "lineNumber": float64(3)}},
+			"parent": map[string]any{
+				"callFrames": []any{map[string]any{"url": userscriptSourceURL, "lineNumber": float64(9)}},
+			},
+		},
+	})
+	if script.Kind != initiatorUserscript {
+		t.Fatalf("async parent frame not attributed to userscript: %+v", script)
+	}
+	if len(script.Stack) != 2 || script.Stack[1] != userscriptSourceURL+":10" {
+		t.Fatalf("stack = %v", script.Stack)
+	}
+	if classifyInitiator(nil).Kind != initiatorUnknown {
+		t.Fatal("nil initiator should be unknown")
+	}
+}
@@ -0,0 +1,255 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+
+	"philadelphia/internal/baseline"
+)
+
+// finalCheckpoint names the screenshot taken after all steps. Its artifact
+// keeps the historical screenshot.png name.
+const finalCheckpoint = "final"
+
+// Screenshot modes.
+const (
+	shotFullPage = "fullpage"
+	shotViewport = "viewport"
+	shotElement  = "element"
+)
+
+// ScreenshotSpec holds the optional settings of a screenshot step.
+type ScreenshotSpec struct {
+	Mode   string         `json:"mode,omitempty"`   // fullpage (default) or viewport; ignored for element shots
+	Ignore []IgnoreRegion `json:"ignore,omitempty"` // added to Options.IgnoreRegions for this checkpoint
+}
+
+// ScreenshotResult is one checkpoint screenshot and its baseline comparison.
+type ScreenshotResult struct {
+	Name     string              `json:"name"`
+	Step     int                 `json:"step,omitempty"` // 1-based step index; 0 for the final screenshot
+	Mode     string              `json:"mode"`           // fullpage, viewport or element
+	Selector string              `json:"selector,omitempty"`
+	Path     string              `json:"path,omitempty"` // relative to artifacts
+	Hash     string              `json:"hash,omitempty"`
+	Visual   *VisualComparison   `json:"visual,omitempty"`
+	Snapshot *SnapshotComparison `json:"snapshot,omitempty"`
+	Error    string              `json:"error,omitempty"`
+
+	Attempts      int  `json:"attempts,omitempty"`       // frames taken until two matched
+	Unstable      bool `json:"unstable,omitempty"`       // the budget ran out before two frames matched
+	PendingImages int  `json:"pending_images,omitempty"` // images still loading when capture started
+}
+
+// screenshotCollector takes checkpoint screenshots and compares each with its
+// own baseline in the keyed store.
+type screenshotCollector struct {
+	opts         Options
+	artifactsDir string
+	runID        string
+	store        *baseline.Store // nil without Options.BaselineDir
+	snap         *snapshotter    // nil without Options.Snapshot
+	key          baseline.Key
+	logger       *ndjsonLogger
+	shots        []ScreenshotResult
+	names        map[string]bool
+}
+
+func newScreenshotCollector(opts Options, artifactsDir, runID string, key baseline.Key, logger *ndjsonLogger) *screenshotCollector {
+	c := &screenshotCollector{opts: opts, artifactsDir: artifactsDir, runID: runID, key: key, logger: logger, names: map[string]bool{}}
+	if opts.BaselineDir != "" {
+		c.store = baseline.Open(opts.BaselineDir)
+		if c.store.Legacy() {
+			logger.warn("visual", "ignoring flat screenshot.png baseline; approve a new run to re-create it", map[string]any{"dir": opts.BaselineDir})
+		}
+	}
+	if opts.Snapshot != "" {
+		// Run has already validated the kind and rules.
+		c.snap, _ = newSnapshotter(opts.Snapshot, opts.SnapshotRules)
+	}
+	return c
+}
+
+// checkpointSlug makes a checkpoint name safe for file names.
+func checkpointSlug(name string) string {
+	return baseline.Slug(name)
+}
+
+// pending lists checkpoints whose screenshot or snapshot baseline awaits
+// approval.
+func (c *screenshotCollector) pending() []string {
+	var out []string
+	for _, sh := range c.shots {
+		if (sh.Visual != nil && sh.Visual.Pending) || (sh.Snapshot != nil && sh.Snapshot.Pending) {
+			out = append(out, sh.Name)
+		}
+	}
+	return out
+}
+
+// capture screenshots page (or the first element matching selector) and
+// compares it with the checkpoint's baseline.
+func (c *screenshotCollector) capture(page playwright.Page, step int, name, selector, mode string, ignore []IgnoreRegion) (ScreenshotResult, error) {
+	res := ScreenshotResult{Name: name, Step: step, Selector: selector, Mode: strings.ToLower(mode)}
+	if res.Mode == "" {
+		res.Mode = shotFullPage
+	}
+	if selector != "" {
+		res.Mode = shotElement
+	}
+	if res.Mode != shotFullPage && res.Mode != shotViewport && res.Mode != shotElement {
+		return res, fmt.Errorf("unknown screenshot mode %q (want fullpage or viewport)", mode)
+	}
+	slug := checkpointSlug(name)
+	if slug == "" {
+		return res, errors.New("screenshot needs a name")
+	}
+	if c.names[slug] {
+		return res, fmt.Errorf("screenshot name %q used twice", name)
+	}
+	if slug == finalCheckpoint && step != 0 {
+		return res, fmt.Errorf("screenshot name %q is reserved for the end-of-run screenshot", finalCheckpoint)
+	}
+
+	rel, diffPrefix := filepath.Join("screenshots", slug+".png"), filepath.Join("screenshots", slug)
+	if step == 0 && slug == finalCheckpoint {
+		rel, diffPrefix = "screenshot.png", "visual"
+	}
+	abs := filepath.Join(c.artifactsDir, rel)
+	if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+		return res, err
+	}
+
+	data, st, err := c.shoot(page, res.Mode, selector)
+	res.Attempts, res.PendingImages = st.attempts, st.pendingImages
+	res.Unstable = !st.stable && !c.opts.NoStabilize
+	if err == nil {
+		err = os.WriteFile(abs, data, 0o644)
+	}
+	if err != nil {
+		res.Error = err.Error()
+		c.shots = append(c.shots, res)
+		return res, err
+	}
+	// Reserve the name only once the image is on disk, so a retried step
+	// can reuse it after a failed capture.
+	c.names[slug] = true
+	res.Path = filepath.ToSlash(rel)
+	if res.Unstable {
+		c.logger.warn("visual", "screenshot did not settle", map[string]any{"checkpoint": name, "attempts": res.Attempts})
+	}
+
+	regions := append(append([]IgnoreRegion(nil), c.opts.IgnoreRegions...), ignore...)
+	var masks []MaskRect
+	if len(regions) > 0 {
+		origin, err := screenshotOrigin(page, res.Mode, selector)
+		if err != nil {
+			c.logger.warn("visual", "could not place ignore regions", map[string]any{"checkpoint": name, "error": err.Error()})
+		} else {
+			masks = resolveIgnoreRegions(page, regions, origin, c.logger)
+		}
+	}
+	res.Hash, res.Visual = c.compare(abs, slug, diffPrefix, masks)
+	if c.snap != nil {
+		res.Snapshot = c.snapshot(page, slug, selector)
+	}
+	c.shots = append(c.shots, res)
+	c.logger.info("visual", "checkpoint captured", map[string]any{"checkpoint": name, "path": res.Path, "mode": res.Mode})
+	if res.Visual != nil && !res.Visual.Passed {
+		return res, fmt.Errorf("screenshot %q differs from its baseline by %.2f%% (max %.2f%%)", name, res.Visual.DiffRatio*100, res.Visual.MaxRatio*100)
+	}
+	return res, nil
+}
+
+// shoot returns the checkpoint screenshot. Unless Options.NoStabilize is
+// set, motion is frozen first and frames are taken until two are identical.
+func (c *screenshotCollector) shoot(page playwright.Page, mode, selector string) ([]byte, stabilization, error) {
+	stabilize := !c.opts.NoStabilize
+	var animations *playwright.ScreenshotAnimations
+	var caret *playwright.ScreenshotCaret
+	if stabilize {
+		animations, caret = playwright.ScreenshotAnimationsDisabled, playwright.ScreenshotCaretHide
+	}
+	frame := func() ([]byte, error) {
+		if mode == shotElement {
+			return page.Locator(selector).First().Screenshot(playwright.LocatorScreenshotOptions{Animations: animations, Caret: caret})
+		}
+		return page.Screenshot(playwright.PageScreenshotOptions{
+			FullPage:   playwright.Bool(mode == shotFullPage),
+			Animations: animations,
+			Caret:      caret,
+		})
+	}
+	if !stabilize {
+		data, err := frame()
+		return data, stabilization{attempts: 1}, err
+	}
+	budget := c.opts.StableBudget
+	if budget <= 0 {
+		budget = defaultStableBudget
+	}
+	deadline := time.Now().Add(budget)
+	pending, cleanup := prepareStable(page, c.opts, budget, c.logger)
+	defer cleanup()
+	data, st, err := stableFrames(frame, deadline, stableInterval)
+	st.pendingImages = pending
+	return data, st, err
+}
+
+// compare hashes the screenshot and diffs it against the approved baseline.
+// Without one, the screenshot becomes the pending candidate.
+func (c *screenshotCollector) compare(path, slug, diffPrefix string, masks []MaskRect) (string, *VisualComparison) {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return "", nil
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+	if c.store == nil {
+		return hash, nil
+	}
+	if basePath, ok := c.store.ApprovedPath(c.key, slug); ok {
+		return hash, compareBaseline(data, hash, basePath, diffPrefix, c.opts, c.artifactsDir, masks, c.logger)
+	}
+	candidate, err := c.store.Propose(c.key, slug, c.runID, data)
+	if err != nil {
+		c.logger.warn("visual", "store pending baseline failed", map[string]any{"checkpoint": slug, "error": err.Error()})
+		return hash, nil
+	}
+	c.logger.info("visual", "baseline pending approval", map[string]any{"checkpoint": slug, "path": candidate})
+	return hash, &VisualComparison{Baseline: candidate, Pending: true, Threshold: c.opts.VisualDiffThreshold, Passed: true}
+}
+
+// screenshotOrigin is the document position, in screenshot pixels, of the
+// top-left corner of a screenshot taken in mode.
+func screenshotOrigin(page playwright.Page, mode, selector string) (image.Point, error) {
+	var raw any
+	var err error
+	switch mode {
+	case shotFullPage:
+		return image.Point{}, nil
+	case shotViewport:
+		raw, err = page.Evaluate(`() => { const d = window.devicePixelRatio || 1; return [{x: scrollX * d, y: scrollY * d}]; }`)
+	default:
+		raw, err = page.Locator(selector).First().EvaluateAll(maskBoxesJS)
+	}
+	if err != nil {
+		return image.Point{}, err
+	}
+	var boxes []struct{ X, Y float64 }
+	if b, err := json.Marshal(raw); err == nil {
+		_ = json.Unmarshal(b, &boxes)
+	}
+	if len(boxes) == 0 {
+		return image.Point{}, errors.New("element not found")
+	}
+	return image.Pt(int(boxes[0].X), int(boxes[0].Y)), nil
+}
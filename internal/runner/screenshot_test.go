@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"philadelphia/internal/baseline"
+)
+
+func TestScreenshotCheckpointNames(t *testing.T) {
+	c := newScreenshotCollector(Options{}, t.TempDir(), "run1", baseline.Key{}, &ndjsonLogger{w: bufio.NewWriter(io.Discard)})
+	c.names["after-toggle"] = true
+	for _, tc := range []struct {
+		name, mode, want string
+	}{
+		{"", "", "needs a name"},
+		{"  !! ", "", "needs a name"},
+		{"After Toggle", "", "used twice"},
+		{"final", "", "reserved"},
+		{"dark", "sideways", "unknown screenshot mode"},
+	} {
+		_, err := c.capture(nil, 2, tc.name, "", tc.mode, nil)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("capture(%q, %q) = %v, want %q", tc.name, tc.mode, err, tc.want)
+		}
+	}
+	if got := checkpointSlug("Before: Dark Mode!"); got != "before-dark-mode" {
+		t.Fatalf("slug = %q", got)
+	}
+}
+
+func TestScreenshotFailedCaptureKeepsName(t *testing.T) {
+	// A file where the screenshots directory should be makes every capture
+	// fail before the page is touched.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "screenshots"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := newScreenshotCollector(Options{}, dir, "run1", baseline.Key{}, &ndjsonLogger{w: bufio.NewWriter(io.Discard)})
+	for i := 0; i < 2; i++ {
+		_, err := c.capture(nil, 1, "menu", "", "", nil)
+		if err == nil || strings.Contains(err.Error(), "used twice") {
+			t.Fatalf("attempt %d: err = %v, want the write error", i+1, err)
+		}
+	}
+	if c.names["menu"] {
+		t.Fatal("failed capture reserved its name")
+	}
+}
+
+func TestApproveRun(t *testing.T) {
+	runDir, store := t.TempDir(), baseline.Open(t.TempDir())
+	key := baseline.Key{Script: "demo", URL: "https://example.com/", Viewport: "800x600", Engine: "chromium"}
+	for _, dir := range []string{"screenshots", "snapshots"} {
+		if err := os.MkdirAll(filepath.Join(runDir, "artifacts", dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range map[string]string{"screenshot.png": "final", "screenshots/menu.png": "menu", "snapshots/final.dom.json": `{"t":"html"}`} {
+		if err := os.WriteFile(filepath.Join(runDir, "artifacts", name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := Manifest{
+		RunID:    "run1",
+		Baseline: &BaselineRef{Dir: store.Dir, Set: key.ID(), Key: key, Pending: []string{"final", "menu"}},
+		Screenshots: []ScreenshotResult{
+			{Name: "menu", Step: 2, Path: "screenshots/menu.png"},
+			{Name: "final", Path: "screenshot.png", Snapshot: &SnapshotComparison{Kind: "dom", Path: "snapshots/final.dom.json", Pending: true}},
+		},
+	}
+	b, _ := json.Marshal(m)
+	if err := os.WriteFile(filepath.Join(runDir, "run.json"), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ApproveRun(runDir, "missing"); err == nil {
+		t.Fatal("approving an unknown checkpoint succeeded")
+	}
+	got, err := ApproveRun(runDir, "menu")
+	if err != nil || len(got) != 1 || got[0].Checkpoint != "menu" {
+		t.Fatalf("ApproveRun(menu) = %+v, %v", got, err)
+	}
+	if _, ok := store.ApprovedPath(key, "final"); ok {
+		t.Fatal("final approved without being asked for")
+	}
+	if got, err := ApproveRun(runDir, ""); err != nil || len(got) != 2 {
+		t.Fatalf("ApproveRun() = %+v, %v", got, err)
+	}
+	p, ok := store.ApprovedPath(key, "final")
+	if data, _ := os.ReadFile(p); !ok || string(data) != "final" {
+		t.Fatalf("final baseline = %q, %v", data, ok)
+	}
+	p, ok = store.ApprovedSnapshotPath(key, "final", "dom")
+	if data, _ := os.ReadFile(p); !ok || string(data) != `{"t":"html"}` {
+		t.Fatalf("final snapshot = %q, %v", data, ok)
+	}
+}
@@ -0,0 +1,361 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// Snapshot kinds.
+const (
+	snapshotKindDOM  = "dom"
+	snapshotKindA11y = "a11y"
+)
+
+// maxSnapshotChanges bounds the changes a manifest lists per checkpoint; the
+// diff file next to the snapshot has the rest.
+const maxSnapshotChanges = 20
+
+// SnapshotRules normalise DOM and accessibility snapshots so that values
+// which change on every page load do not show up as drift.
+type SnapshotRules struct {
+	StripAttrs []string `json:"strip_attrs,omitempty"` // attribute names to drop; globs allowed, e.g. data-test-*
+	MaskValues []string `json:"mask_values,omitempty"` // regexes; matches in attribute values become *
+	MaskText   []string `json:"mask_text,omitempty"`   // regexes; matches in text become *
+	Ignore     []string `json:"ignore,omitempty"`      // selectors whose subtrees are left out (DOM snapshots only)
+}
+
+// defaultSnapshotRules strip nonces and CSRF tokens and mask the generated
+// ids and class names of common frameworks.
+var defaultSnapshotRules = SnapshotRules{
+	StripAttrs: []string{"nonce", "data-reactid", "data-react-checksum", "*csrf*"},
+	MaskValues: []string{
+		`:[rR][0-9a-zA-Z]+:`,         // React useId
+		`\bember\d+\b`,               // Ember view ids
+		`\bmui-\d+\b`,                // Material UI
+		`\bheadlessui-[a-z-]+-\d+\b`, // Headless UI
+		`\bsc-[a-zA-Z]{5,}\b`,        // styled-components
+		`\bcss-[a-z0-9]{5,}\b`,       // Emotion
+	},
+}
+
+// SnapshotComparison is a checkpoint's snapshot and how it compares with the
+// approved one.
+type SnapshotComparison struct {
+	Kind     string   `json:"kind"`               // dom or a11y
+	Path     string   `json:"path,omitempty"`     // relative to artifacts
+	Hash     string   `json:"hash,omitempty"`     // sha256 of the normalised snapshot
+	Baseline string   `json:"baseline,omitempty"` // approved snapshot, or the candidate when Pending
+	Pending  bool     `json:"pending,omitempty"`
+	Diff     *DOMDiff `json:"diff,omitempty"`      // set when the snapshot drifted; lists the first changes only
+	DiffPath string   `json:"diff_path,omitempty"` // full structural diff, relative to artifacts
+	Error    string   `json:"error,omitempty"`
+}
+
+// snapshotter captures and normalises checkpoint snapshots.
+type snapshotter struct {
+	kind   string
+	ignore []string
+	strip  []string
+	values []*regexp.Regexp
+	text   []*regexp.Regexp
+}
+
+// newSnapshotter validates kind and compiles rules; nil rules use the defaults.
+func newSnapshotter(kind string, rules *SnapshotRules) (*snapshotter, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if kind != snapshotKindDOM && kind != snapshotKindA11y {
+		return nil, fmt.Errorf("invalid snapshot kind %q (want dom or a11y)", kind)
+	}
+	r := defaultSnapshotRules
+	if rules != nil {
+		r = *rules
+	}
+	s := &snapshotter{kind: kind, ignore: r.Ignore}
+	for _, a := range r.StripAttrs {
+		if _, err := path.Match(strings.ToLower(a), ""); err != nil {
+			return nil, fmt.Errorf("snapshot rules: bad strip_attrs pattern %q", a)
+		}
+		s.strip = append(s.strip, strings.ToLower(a))
+	}
+	for _, set := range []struct {
+		patterns []string
+		out      *[]*regexp.Regexp
+		field    string
+	}{{r.MaskValues, &s.values, "mask_values"}, {r.MaskText, &s.text, "mask_text"}} {
+		for _, p := range set.patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("snapshot rules: bad %s pattern %q: %w", set.field, p, err)
+			}
+			*set.out = append(*set.out, re)
+		}
+	}
+	return s, nil
+}
+
+// capture takes a normalised snapshot of the page, or of the first element
+// matching selector.
+func (s *snapshotter) capture(page playwright.Page, selector string) (*DOMNode, error) {
+	var root *DOMNode
+	if s.kind == snapshotKindDOM {
+		n, err := snapshotDOM(page, selector, s.ignore)
+		if err != nil {
+			return nil, err
+		}
+		root = n
+	} else {
+		if selector == "" {
+			selector = "body"
+		}
+		yaml, err := page.Locator(selector).First().AriaSnapshot(playwright.LocatorAriaSnapshotOptions{Timeout: playwright.Float(10_000)})
+		if err != nil {
+			return nil, err
+		}
+		root = parseAriaSnapshot(yaml)
+	}
+	s.normalize(root)
+	return root, nil
+}
+
+// normalize applies the rules to n and its subtree in place.
+func (s *snapshotter) normalize(n *DOMNode) {
+	if n == nil {
+		return
+	}
+	for name, v := range n.Attrs {
+		if s.stripped(name) {
+			delete(n.Attrs, name)
+			continue
+		}
+		for _, re := range s.values {
+			v = re.ReplaceAllString(v, "*")
+		}
+		n.Attrs[name] = v
+	}
+	if len(n.Attrs) == 0 {
+		n.Attrs = nil
+	}
+	for _, re := range s.text {
+		n.Text = re.ReplaceAllString(n.Text, "*")
+	}
+	for _, c := range n.Children {
+		s.normalize(c)
+	}
+}
+
+func (s *snapshotter) stripped(name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range s.strip {
+		if matchName(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot captures the checkpoint's snapshot and compares it with the
+// approved one, proposing it as the candidate when there is none.
+func (c *screenshotCollector) snapshot(page playwright.Page, slug, selector string) *SnapshotComparison {
+	kind := c.snap.kind
+	sc := &SnapshotComparison{Kind: kind}
+	root, err := c.snap.capture(page, selector)
+	if err == nil {
+		var data []byte
+		data, err = json.MarshalIndent(root, "", " ")
+		if err == nil {
+			sc.Path = filepath.ToSlash(filepath.Join("snapshots", slug+"."+kind+".json"))
+			err = writeArtifact(filepath.Join(c.artifactsDir, sc.Path), data)
+			sc.Hash = fmt.Sprintf("%x", sha256.Sum256(data))
+		}
+		if err == nil && c.store != nil {
+			err = c.compareSnapshot(sc, slug, root, data)
+		}
+	}
+	if err != nil {
+		sc.Error = err.Error()
+		c.logger.warn("snapshot", "checkpoint snapshot failed", map[string]any{"checkpoint": slug, "kind": kind, "error": err.Error()})
+	}
+	return sc
+}
+
+func (c *screenshotCollector) compareSnapshot(sc *SnapshotComparison, slug string, root *DOMNode, data []byte) error {
+	basePath, ok := c.store.ApprovedSnapshotPath(c.key, slug, sc.Kind)
+	if !ok {
+		candidate, err := c.store.ProposeSnapshot(c.key, slug, sc.Kind, c.runID, data)
+		if err != nil {
+			return err
+		}
+		sc.Baseline, sc.Pending = candidate, true
+		c.logger.info("snapshot", "baseline pending approval", map[string]any{"checkpoint": slug, "path": candidate})
+		return nil
+	}
+	sc.Baseline = basePath
+	baseData, err := os.ReadFile(basePath)
+	if err != nil {
+		return err
+	}
+	var base DOMNode
+	if err := json.Unmarshal(baseData, &base); err != nil {
+		return fmt.Errorf("baseline snapshot %s: %w", basePath, err)
+	}
+	// The baseline may predate the current rules.
+	c.snap.normalize(&base)
+	d := diffDOM(&base, root)
+	if d.Identical() {
+		return nil
+	}
+	full, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	sc.DiffPath = filepath.ToSlash(filepath.Join("snapshots", slug+"."+sc.Kind+".diff.json"))
+	if err := writeArtifact(filepath.Join(c.artifactsDir, sc.DiffPath), full); err != nil {
+		return err
+	}
+	if len(d.Changes) > maxSnapshotChanges {
+		d.Changes, d.Truncated = d.Changes[:maxSnapshotChanges], true
+	}
+	sc.Diff = &d
+	c.logger.warn("snapshot", "snapshot drifted from its baseline", map[string]any{"checkpoint": slug, "kind": sc.Kind, "diff": d.summary()})
+	return nil
+}
+
+func writeArtifact(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// parseAriaSnapshot turns Playwright's YAML accessibility snapshot into a
+// tree diffDOM can compare. A node's tag is its role; its accessible name is
+// the "name" attribute, bracketed states such as [level=2] or [checked] are
+// attributes too, and inline text is the node's text. Property lines such as
+// "- /url: ..." become attributes of the node they belong to.
+func parseAriaSnapshot(yaml string) *DOMNode {
+	root := &DOMNode{Tag: "aria"}
+	stack := []*DOMNode{root} // stack[d] is the parent of items at depth d
+	for _, line := range strings.Split(yaml, "\n") {
+		item := strings.TrimLeft(line, " ")
+		if !strings.HasPrefix(item, "- ") {
+			continue
+		}
+		depth := (len(line) - len(item)) / 2
+		if depth >= len(stack) {
+			depth = len(stack) - 1
+		}
+		parent := stack[depth]
+		key, value := splitAriaItem(item[2:])
+		if key == "" {
+			continue
+		}
+		if strings.HasPrefix(key, "/") {
+			if parent.Attrs == nil {
+				parent.Attrs = map[string]string{}
+			}
+			parent.Attrs[key[1:]] = value
+			continue
+		}
+		n := parseAriaKey(key)
+		n.Text = value
+		parent.Children = append(parent.Children, n)
+		stack = append(stack[:depth+1], n)
+	}
+	return root
+}
+
+// splitAriaItem splits `role "name" [attr]: text` at the colon that ends the
+// key, unquoting YAML-quoted keys and values.
+func splitAriaItem(item string) (key, value string) {
+	if item == "" {
+		return "", ""
+	}
+	if q := item[0]; q == '\'' || q == '"' {
+		if k, rest, ok := cutQuoted(item); ok {
+			_, value, _ = strings.Cut(rest, ":")
+			return k, unquoteYAML(strings.TrimSpace(value))
+		}
+	}
+	inQuote, inBracket := false, false
+	for i := 0; i < len(item); i++ {
+		switch ch := item[i]; {
+		case inQuote && ch == '\\':
+			i++
+		case ch == '"' && !inBracket:
+			inQuote = !inQuote
+		case ch == '[' && !inQuote:
+			inBracket = true
+		case ch == ']' && !inQuote:
+			inBracket = false
+		case ch == ':' && !inQuote && !inBracket && (i == len(item)-1 || item[i+1] == ' '):
+			return item[:i], unquoteYAML(strings.TrimSpace(item[i+1:]))
+		}
+	}
+	return item, ""
+}
+
+// cutQuoted reads a YAML single- or double-quoted scalar at the start of s.
+func cutQuoted(s string) (string, string, bool) {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case q == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == q:
+			return unquoteYAML(s[:i+1]), s[i+1:], true
+		}
+	}
+	return "", s, false
+}
+
+func unquoteYAML(s string) string {
+	if len(s) < 2 {
+		return s
+	}
+	switch {
+	case s[0] == '"' && s[len(s)-1] == '"':
+		var out string
+		if json.Unmarshal([]byte(s), &out) == nil {
+			return out
+		}
+	case s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	return s
+}
+
+var ariaAttr = regexp.MustCompile(`\[([^\]=]+)(?:=([^\]]*))?\]`)
+
+// parseAriaKey reads `role "name" [attr=value] [flag]`.
+func parseAriaKey(key string) *DOMNode {
+	role, rest, _ := strings.Cut(strings.TrimSpace(key), " ")
+	n := &DOMNode{Tag: role}
+	rest = strings.TrimSpace(rest)
+	attrs := map[string]string{}
+	if strings.HasPrefix(rest, `"`) {
+		if name, tail, ok := cutQuoted(rest); ok {
+			attrs["name"], rest = name, tail
+		}
+	}
+	for _, m := range ariaAttr.FindAllStringSubmatch(rest, -1) {
+		v := m[2]
+		if v == "" {
+			v = "true"
+		}
+		attrs[strings.TrimSpace(m[1])] = v
+	}
+	if len(attrs) > 0 {
+		n.Attrs = attrs
+	}
+	return n
+}
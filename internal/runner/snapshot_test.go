@@ -0,0 +1,141 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"philadelphia/internal/baseline"
+)
+
+func TestParseAriaSnapshot(t *testing.T) {
+	yaml := `- banner:
+  - heading "Dark: Mode" [level=1]
+  - link "Home":
+    - /url: /wiki/Home
+- main:
+  - paragraph: Some "quoted" text
+  - 'button "It''s on" [pressed]'
+  - checkbox "Sync" [checked=mixed]
+  - text: "12: noon"
+  - 
+  - list:
+    - listitem: one
+- `
+	got := parseAriaSnapshot(yaml)
+	want := el("aria", nil, "",
+		el("banner", nil, "",
+			el("heading", map[string]string{"name": "Dark: Mode", "level": "1"}, ""),
+			el("link", map[string]string{"name": "Home", "url": "/wiki/Home"}, ""),
+		),
+		el("main", nil, "",
+			el("paragraph", nil, `Some "quoted" text`),
+			el("button", map[string]string{"name": "It's on", "pressed": "true"}, ""),
+			el("checkbox", map[string]string{"name": "Sync", "checked": "mixed"}, ""),
+			el("text", nil, "12: noon"),
+			el("list", nil, "", el("listitem", nil, "one")),
+		),
+	)
+	if !reflect.DeepEqual(got, want) {
+		a, _ := json.Marshal(got)
+		t.Fatalf("tree = %s", a)
+	}
+}
+
+func TestSplitAriaItem(t *testing.T) {
+	for _, tc := range []struct{ item, key, value string }{
+		{"", "", ""},
+		{`heading "Dark: Mode" [level=1]`, `heading "Dark: Mode" [level=1]`, ""},
+		{"text: 12: noon", "text", "12: noon"},
+		{`'button "x"': y`, `button "x"`, "y"},
+		{`"`, `"`, ""},
+	} {
+		if k, v := splitAriaItem(tc.item); k != tc.key || v != tc.value {
+			t.Errorf("splitAriaItem(%q) = %q, %q; want %q, %q", tc.item, k, v, tc.key, tc.value)
+		}
+	}
+}
+
+func TestSnapshotterNormalize(t *testing.T) {
+	s, err := newSnapshotter("DOM", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := el("div", map[string]string{"id": ":r1a:", "aria-labelledby": ":r1b: title", "nonce": "abc", "data-csrf-token": "x", "class": "card css-1q2w3e"}, "Updated 5 minutes ago")
+	s.normalize(n)
+	if want := map[string]string{"id": "*", "aria-labelledby": "* title", "class": "card *"}; !reflect.DeepEqual(n.Attrs, want) {
+		t.Fatalf("attrs = %v", n.Attrs)
+	}
+
+	s, err = newSnapshotter("a11y", &SnapshotRules{StripAttrs: []string{"data-*"}, MaskText: []string{`\d+ minutes ago`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n = el("p", map[string]string{"data-x": "1", "id": ":r1:"}, "Updated 5 minutes ago")
+	s.normalize(n)
+	if n.Text != "Updated *" || !reflect.DeepEqual(n.Attrs, map[string]string{"id": ":r1:"}) {
+		t.Fatalf("node = %+v", n)
+	}
+
+	for _, bad := range []struct {
+		kind  string
+		rules *SnapshotRules
+	}{{"html", nil}, {"dom", &SnapshotRules{MaskValues: []string{"("}}}, {"dom", &SnapshotRules{StripAttrs: []string{"["}}}} {
+		if _, err := newSnapshotter(bad.kind, bad.rules); err == nil {
+			t.Errorf("newSnapshotter(%q, %+v) succeeded", bad.kind, bad.rules)
+		}
+	}
+}
+
+func TestCompareSnapshot(t *testing.T) {
+	artifacts := t.TempDir()
+	snap, _ := newSnapshotter("dom", nil)
+	c := &screenshotCollector{
+		artifactsDir: artifacts,
+		runID:        "run1",
+		store:        baseline.Open(t.TempDir()),
+		snap:         snap,
+		key:          baseline.Key{Script: "demo", URL: "https://example.com/", Viewport: "800x600", Engine: "chromium"},
+		logger:       &ndjsonLogger{w: bufio.NewWriter(io.Discard)},
+	}
+	compare := func(root *DOMNode) *SnapshotComparison {
+		t.Helper()
+		data, _ := json.MarshalIndent(root, "", " ")
+		sc := &SnapshotComparison{Kind: "dom"}
+		if err := c.compareSnapshot(sc, "final", root, data); err != nil {
+			t.Fatal(err)
+		}
+		return sc
+	}
+
+	page := el("html", nil, "", el("body", nil, "", el("h1", map[string]string{"id": ":r1:"}, "Title")))
+	sc := compare(page)
+	if !sc.Pending || filepath.Base(sc.Baseline) != "final.pending.dom.json" {
+		t.Fatalf("first run = %+v", sc)
+	}
+	data, _ := os.ReadFile(sc.Baseline)
+	if _, err := c.store.ApproveSnapshot(c.key, "final", "dom", "run1", data); err != nil {
+		t.Fatal(err)
+	}
+
+	// The generated id is masked, so a new one is not drift.
+	again := el("html", nil, "", el("body", nil, "", el("h1", map[string]string{"id": ":r9:"}, "Title")))
+	snap.normalize(again)
+	if sc := compare(again); sc.Pending || sc.Diff != nil || sc.DiffPath != "" {
+		t.Fatalf("unchanged run = %+v", sc)
+	}
+
+	drifted := el("html", nil, "", el("body", map[string]string{"class": "dark"}, "", el("h1", map[string]string{"id": "*"}, "Title"), el("button", nil, "Toggle")))
+	sc = compare(drifted)
+	if sc.Diff == nil || sc.Diff.Added != 1 || sc.Diff.Changed != 1 || sc.DiffPath != "snapshots/final.dom.diff.json" {
+		t.Fatalf("drifted run = %+v", sc)
+	}
+	var full DOMDiff
+	if b, err := os.ReadFile(filepath.Join(artifacts, sc.DiffPath)); err != nil || json.Unmarshal(b, &full) != nil || len(full.Changes) != 2 {
+		t.Fatalf("diff file = %+v, %v", full, err)
+	}
+}
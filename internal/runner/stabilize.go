@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/playwright-community/playwright-go"
+)
+
+// Stabilization defaults.
+const (
+	defaultStableBudget = 5 * time.Second
+	stableInterval      = 150 * time.Millisecond
+	// unstabilizedWait is the fixed settle time used before stabilization
+	// existed; it still applies with Options.NoStabilize.
+	unstabilizedWait = 1200
+)
+
+// stabilizeCSS freezes motion that makes consecutive frames differ. It is
+// removed again after the capture so later steps see the page unchanged.
+const stabilizeCSS = `*, *::before, *::after {
+  animation-delay: -1ms !important;
+  animation-duration: 1ms !important;
+  animation-iteration-count: 1 !important;
+  transition: none !important;
+  caret-color: transparent !important;
+  scroll-behavior: auto !important;
+}`
+
+const hideScrollbarsCSS = `::-webkit-scrollbar { display: none !important; }
+html, body { scrollbar-width: none !important; }`
+
+const stabilizeStyleID = "__lab_stabilize"
+
+// Adds the freeze stylesheet, then waits for web fonts and for images still
+// loading, up to timeout ms. Returns how many images were still incomplete.
+const stabilizePrepareJS = `async ([id, css, timeout]) => {
+  if (!document.getElementById(id)) {
+    const s = document.createElement('style');
+    s.id = id;
+    s.textContent = css;
+    (document.head || document.documentElement).appendChild(s);
+  }
+  const loading = () => Array.from(document.images).filter(i => !i.complete && i.loading !== 'lazy');
+  const waits = loading().map(i => new Promise(r => {
+    i.addEventListener('load', r, {once: true});
+    i.addEventListener('error', r, {once: true});
+  }));
+  if (document.fonts) waits.push(document.fonts.ready);
+  await Promise.race([Promise.all(waits), new Promise(r => setTimeout(r, timeout))]);
+  await new Promise(r => requestAnimationFrame(() => requestAnimationFrame(r)));
+  return loading().length;
+}`
+
+const stabilizeCleanupJS = `id => { const s = document.getElementById(id); if (s) s.remove(); }`
+
+// stabilization is how a checkpoint screenshot settled.
+type stabilization struct {
+	attempts      int
+	stable        bool
+	pendingImages int
+}
+
+// prepareStable freezes animations and waits for fonts and images, spending
+// at most half of budget. The returned func removes the injected styles.
+func prepareStable(page playwright.Page, opts Options, budget time.Duration, logger *ndjsonLogger) (int, func()) {
+	css := stabilizeCSS
+	if opts.HideScrollbars {
+		css += "\n" + hideScrollbarsCSS
+	}
+	pending := 0
+	raw, err := page.Evaluate(stabilizePrepareJS, []any{stabilizeStyleID, css, budget.Milliseconds() / 2})
+	if err != nil {
+		logger.warn("visual", "stabilize page failed", map[string]any{"error": err.Error()})
+	} else if n, ok := raw.(int); ok {
+		pending = n
+	} else if f, ok := raw.(float64); ok {
+		pending = int(f)
+	}
+	return pending, func() {
+		if _, err := page.Evaluate(stabilizeCleanupJS, stabilizeStyleID); err != nil {
+			logger.warn("visual", "remove stabilize styles failed", map[string]any{"error": err.Error()})
+		}
+	}
+}
+
+// stableFrames calls shoot until two consecutive frames are byte-identical
+// or the deadline passes, and returns the last frame. At least two frames
+// are always taken.
+func stableFrames(shoot func() ([]byte, error), deadline time.Time, interval time.Duration) ([]byte, stabilization, error) {
+	var st stabilization
+	var prev []byte
+	for {
+		frame, err := shoot()
+		if err != nil {
+			return prev, st, err
+		}
+		st.attempts++
+		if prev != nil && bytes.Equal(prev, frame) {
+			st.stable = true
+			return frame, st, nil
+		}
+		prev = frame
+		if st.attempts >= 2 && time.Now().Add(interval).After(deadline) {
+			return frame, st, nil
+		}
+		time.Sleep(interval)
+	}
+}
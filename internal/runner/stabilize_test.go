@@ -0,0 +1,40 @@
+package runner
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func frames(seq ...string) func() ([]byte, error) {
+	i := 0
+	return func() ([]byte, error) {
+		f := seq[min(i, len(seq)-1)]
+		i++
+		if f == "err" {
+			return nil, errors.New("screenshot failed")
+		}
+		return []byte(f), nil
+	}
+}
+
+func TestStableFrames(t *testing.T) {
+	far := time.Now().Add(time.Minute)
+	data, st, err := stableFrames(frames("a", "b", "c", "c"), far, 0)
+	if err != nil || string(data) != "c" || st.attempts != 4 || !st.stable {
+		t.Fatalf("settling page: %q %+v %v", data, st, err)
+	}
+
+	// A page that never settles stops at the deadline, but only after two
+	// frames so there was something to compare.
+	var n byte
+	changing := func() ([]byte, error) { n++; return []byte{n}, nil }
+	data, st, err = stableFrames(changing, time.Now(), 0)
+	if err != nil || st.stable || st.attempts != 2 || data[0] != 2 {
+		t.Fatalf("animated page: %v %+v %v", data, st, err)
+	}
+
+	if _, st, err = stableFrames(frames("a", "err"), far, 0); err == nil || st.attempts != 1 {
+		t.Fatalf("failing shot: %+v %v", st, err)
+	}
+}
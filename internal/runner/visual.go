@@ -0,0 +1,483 @@
+package runner
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// ImageSize is a width/height pair.
+type ImageSize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// VisualComparison is the outcome of comparing a screenshot with its baseline.
+type VisualComparison struct {
+	Baseline     string     `json:"baseline"`
+	Pending      bool       `json:"pending,omitempty"` // no approved baseline; Baseline is the candidate awaiting approval
+	Threshold    float64    `json:"threshold"`         // colour tolerance 0-255, compared in YIQ space
+	DiffPixels   int        `json:"diff_pixels"`
+	AAPixels     int        `json:"aa_pixels,omitempty"`     // differing pixels classed as anti-aliasing and ignored
+	PaddedPixels int        `json:"padded_pixels,omitempty"` // pixels present in only one image; included in DiffPixels
+	DiffRatio    float64    `json:"diff_ratio"`
+	SSIM         *float64   `json:"ssim,omitempty"`
+	BaselineSize ImageSize  `json:"baseline_size"`
+	CurrentSize  ImageSize  `json:"current_size"`
+	SizeDelta    ImageSize  `json:"size_delta"` // current minus baseline
+	DiffImg      string     `json:"diff_img,omitempty"`
+	CompareImg   string     `json:"compare_img,omitempty"` // baseline | diff | current
+	Masks        []MaskRect `json:"masks,omitempty"`       // ignore regions painted out of both images
+	MaxRatio     float64    `json:"max_ratio,omitempty"`
+	Passed       bool       `json:"passed"`
+}
+
+// Diff overlay colours, as in pixelmatch.
+var (
+	diffColor   = color.NRGBA{R: 255, A: 255}
+	aaColor     = color.NRGBA{R: 255, G: 255, A: 255}
+	paddedColor = color.NRGBA{R: 255, B: 255, A: 255}
+)
+
+// compareBaseline compares a screenshot with the approved baseline at
+// basePath, writing any diff images as diffPrefix-*.png.
+func compareBaseline(data []byte, hash, basePath, diffPrefix string, opts Options, artifactsDir string, masks []MaskRect, logger *ndjsonLogger) *VisualComparison {
+	baseData, err := os.ReadFile(basePath)
+	if err != nil {
+		logger.warn("visual", "baseline read failed", map[string]any{"error": err.Error()})
+		return nil
+	}
+	baseHash := fmt.Sprintf("%x", sha256.Sum256(baseData))
+	if baseHash == hash {
+		logger.info("visual", "screenshot matches baseline", map[string]any{"baseline": basePath})
+		return &VisualComparison{Baseline: basePath, Threshold: opts.VisualDiffThreshold, MaxRatio: opts.VisualMaxDiffRatio, Masks: masks, Passed: true}
+	}
+	logger.warn("visual", "screenshot hash mismatch vs baseline", map[string]any{"baseline": baseHash, "current": hash})
+	cmp := computeDiffImage(baseData, data, artifactsDir, diffPrefix, masks, logger, opts)
+	if cmp != nil {
+		cmp.Baseline = basePath
+	}
+	return cmp
+}
+
+// computeDiffImage compares two PNGs perceptually and writes <name>-diff.png
+// (changes over a faded copy of the current image) and <name>-compare.png
+// (baseline, diff and current side by side). Images of different sizes are
+// padded to the larger one; the padding counts as changed. Masked areas are
+// painted out of both images first and outlined on the diff.
+func computeDiffImage(basePNG, currentPNG []byte, artifactsDir, name string, masks []MaskRect, logger *ndjsonLogger, opts Options) *VisualComparison {
+	baseImg, err := png.Decode(bytes.NewReader(basePNG))
+	if err != nil {
+		logger.warn("visual", "decode baseline failed", map[string]any{"error": err.Error()})
+		return nil
+	}
+	currImg, err := png.Decode(bytes.NewReader(currentPNG))
+	if err != nil {
+		logger.warn("visual", "decode current failed", map[string]any{"error": err.Error()})
+		return nil
+	}
+	base, curr := toNRGBA(baseImg), toNRGBA(currImg)
+	paintMasks(base, masks, maskFill)
+	paintMasks(curr, masks, maskFill)
+	res := diffImages(base, curr, opts.VisualDiffThreshold)
+	cmp := &res.VisualComparison
+	cmp.Masks = masks
+	cmp.MaxRatio = opts.VisualMaxDiffRatio
+	cmp.Passed = opts.VisualMaxDiffRatio <= 0 || cmp.DiffRatio <= opts.VisualMaxDiffRatio
+	if opts.VisualSSIM {
+		s := ssim(base, curr)
+		cmp.SSIM = &s
+	}
+	if cmp.SizeDelta != (ImageSize{}) {
+		logger.warn("visual", "baseline/current size mismatch", map[string]any{"baseline": cmp.BaselineSize, "current": cmp.CurrentSize})
+	}
+	if cmp.DiffPixels == 0 {
+		logger.info("visual", "no perceptible difference", map[string]any{"aa_pixels": cmp.AAPixels})
+		return cmp
+	}
+	outlineMasks(res.overlay, masks)
+	diffName, compareName := filepath.ToSlash(name+"-diff.png"), filepath.ToSlash(name+"-compare.png")
+	if err := writePNG(filepath.Join(artifactsDir, diffName), res.overlay); err != nil {
+		logger.warn("visual", "write diff failed", map[string]any{"error": err.Error()})
+	} else {
+		cmp.DiffImg = diffName
+	}
+	if err := writePNG(filepath.Join(artifactsDir, compareName), sideBySide(base, res.overlay, curr)); err != nil {
+		logger.warn("visual", "write comparison failed", map[string]any{"error": err.Error()})
+	} else {
+		cmp.CompareImg = compareName
+	}
+	logger.warn("visual", "diff image generated", map[string]any{"path": diffName, "pixels_changed": cmp.DiffPixels, "ratio": cmp.DiffRatio})
+	return cmp
+}
+
+type diffResult struct {
+	VisualComparison
+	overlay *image.NRGBA // nil when the images are pixel-identical
+}
+
+// minBandRows keeps bands large enough that goroutine overhead stays small
+// next to the per-pixel work.
+const minBandRows = 32
+
+// diffImages is a port of pixelmatch: pixels whose YIQ distance exceeds the
+// threshold are changed unless they look like anti-aliasing in either image.
+// Rows are split into bands that are diffed in parallel.
+func diffImages(base, curr *image.NRGBA, threshold float64) diffResult {
+	return diffImagesBands(base, curr, threshold, runtime.GOMAXPROCS(0))
+}
+
+func diffImagesBands(base, curr *image.NRGBA, threshold float64, bands int) diffResult {
+	bb, cb := base.Bounds(), curr.Bounds()
+	w, h := max(bb.Dx(), cb.Dx()), max(bb.Dy(), cb.Dy())
+	var res diffResult
+	res.Threshold = threshold
+	res.BaselineSize = ImageSize{bb.Dx(), bb.Dy()}
+	res.CurrentSize = ImageSize{cb.Dx(), cb.Dy()}
+	res.SizeDelta = ImageSize{cb.Dx() - bb.Dx(), cb.Dy() - bb.Dy()}
+	if res.SizeDelta == (ImageSize{}) && samePixels(base, curr) {
+		return res
+	}
+	res.overlay = image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	t := math.Max(0, math.Min(threshold, 255)) / 255
+	maxDelta := 35215 * t * t
+	rows := max((h+max(bands, 1)-1)/max(bands, 1), minBandRows)
+	counts := make([]diffResult, (h+rows-1)/rows)
+	var wg sync.WaitGroup
+	for i := range counts {
+		wg.Add(1)
+		go func(band *diffResult, y0, y1 int) {
+			defer wg.Done()
+			diffBand(band, res.overlay, base, curr, maxDelta, y0, y1)
+		}(&counts[i], i*rows, min((i+1)*rows, h))
+	}
+	wg.Wait()
+	for _, c := range counts {
+		res.DiffPixels += c.DiffPixels
+		res.AAPixels += c.AAPixels
+		res.PaddedPixels += c.PaddedPixels
+	}
+	if total := w * h; total > 0 {
+		res.DiffRatio = float64(res.DiffPixels) / float64(total)
+	}
+	return res
+}
+
+// diffBand diffs rows [y0, y1), writing only those rows of overlay and
+// counting into band.
+func diffBand(band *diffResult, overlay, base, curr *image.NRGBA, maxDelta float64, y0, y1 int) {
+	bw, bh := base.Rect.Dx(), base.Rect.Dy()
+	cw, ch := curr.Rect.Dx(), curr.Rect.Dy()
+	w := overlay.Rect.Dx()
+	shared := min(bw, cw)
+	for y := y0; y < y1; y++ {
+		out := overlay.Pix[y*overlay.Stride : y*overlay.Stride+w*4]
+		if y >= bh || y >= ch {
+			fillRow(out, paddedColor)
+			band.PaddedPixels += w
+			band.DiffPixels += w
+			continue
+		}
+		rb := base.Pix[y*base.Stride : y*base.Stride+shared*4]
+		rc := curr.Pix[y*curr.Stride : y*curr.Stride+shared*4]
+		if bytes.Equal(rb, rc) {
+			fadeRow(out, rc)
+		} else {
+			for x, i := 0, 0; x < shared; x, i = x+1, i+4 {
+				pb, pc := rb[i:i+4:i+4], rc[i:i+4:i+4]
+				if pb[0] == pc[0] && pb[1] == pc[1] && pb[2] == pc[2] && pb[3] == pc[3] {
+					setPix(out, i, faded(pc))
+					continue
+				}
+				if math.Abs(colorDelta(pb, pc, false)) <= maxDelta {
+					setPix(out, i, faded(pc))
+					continue
+				}
+				if antialiased(base, x, y, curr) || antialiased(curr, x, y, base) {
+					band.AAPixels++
+					setPix(out, i, aaColor)
+				} else {
+					band.DiffPixels++
+					setPix(out, i, diffColor)
+				}
+			}
+		}
+		if pad := w - shared; pad > 0 {
+			fillRow(out[shared*4:], paddedColor)
+			band.PaddedPixels += pad
+			band.DiffPixels += pad
+		}
+	}
+}
+
+// samePixels reports whether two images of equal size hold the same pixels.
+func samePixels(a, b *image.NRGBA) bool {
+	w, h := a.Rect.Dx(), a.Rect.Dy()
+	if a.Stride == b.Stride && a.Stride == w*4 {
+		return bytes.Equal(a.Pix[:h*a.Stride], b.Pix[:h*b.Stride])
+	}
+	for y := 0; y < h; y++ {
+		if !bytes.Equal(a.Pix[y*a.Stride:y*a.Stride+w*4], b.Pix[y*b.Stride:y*b.Stride+w*4]) {
+			return false
+		}
+	}
+	return true
+}
+
+func setPix(row []uint8, i int, c color.NRGBA) {
+	row[i], row[i+1], row[i+2], row[i+3] = c.R, c.G, c.B, c.A
+}
+
+func fillRow(row []uint8, c color.NRGBA) {
+	for i := 0; i+4 <= len(row); i += 4 {
+		setPix(row, i, c)
+	}
+}
+
+// fadeRow writes the faded form of src into dst, reusing the last result
+// across runs of equal pixels, which are the norm on web pages.
+func fadeRow(dst, src []uint8) {
+	var last [4]uint8
+	var f color.NRGBA
+	for i := 0; i+4 <= len(src); i += 4 {
+		p := [4]uint8{src[i], src[i+1], src[i+2], src[i+3]}
+		if i == 0 || p != last {
+			last, f = p, faded(src[i:i+4])
+		}
+		setPix(dst, i, f)
+	}
+}
+
+// toNRGBA returns img as an NRGBA image anchored at the origin. PNG
+// screenshots decode to *image.NRGBA or, when opaque, *image.RGBA; both are
+// converted straight from their pixel slices.
+func toNRGBA(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) {
+		return n
+	}
+	b := img.Bounds()
+	n := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		draw.Draw(n, n.Rect, img, b.Min, draw.Src)
+		return n
+	}
+	w := b.Dx() * 4
+	for y := 0; y < b.Dy(); y++ {
+		s := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):][:w]
+		d := n.Pix[y*n.Stride:][:w]
+		copy(d, s)
+		for i := 3; i < w; i += 4 {
+			// Un-premultiply translucent pixels; opaque ones are already equal.
+			if a := uint32(d[i]); a != 255 && a != 0 {
+				d[i-3] = uint8(uint32(d[i-3]) * 255 / a)
+				d[i-2] = uint8(uint32(d[i-2]) * 255 / a)
+				d[i-1] = uint8(uint32(d[i-1]) * 255 / a)
+			}
+		}
+	}
+	return n
+}
+
+func pixel(img *image.NRGBA, x, y int) []uint8 {
+	i := img.PixOffset(x, y)
+	return img.Pix[i : i+4 : i+4]
+}
+
+// blend composites a channel over white.
+func blend(c, a float64) float64 {
+	return 255 + (c-255)*a
+}
+
+// colorDelta is the squared YIQ distance between two pixels, signed by which
+// one is brighter; yOnly compares brightness alone.
+func colorDelta(p1, p2 []uint8, yOnly bool) float64 {
+	r1, g1, b1, a1 := float64(p1[0]), float64(p1[1]), float64(p1[2]), float64(p1[3])
+	r2, g2, b2, a2 := float64(p2[0]), float64(p2[1]), float64(p2[2]), float64(p2[3])
+	if a1 == a2 && r1 == r2 && g1 == g2 && b1 == b2 {
+		return 0
+	}
+	if a1 < 255 {
+		a1 /= 255
+		r1, g1, b1 = blend(r1, a1), blend(g1, a1), blend(b1, a1)
+	}
+	if a2 < 255 {
+		a2 /= 255
+		r2, g2, b2 = blend(r2, a2), blend(g2, a2), blend(b2, a2)
+	}
+	y1, y2 := rgb2y(r1, g1, b1), rgb2y(r2, g2, b2)
+	y := y1 - y2
+	if yOnly {
+		return y
+	}
+	i := rgb2i(r1, g1, b1) - rgb2i(r2, g2, b2)
+	q := rgb2q(r1, g1, b1) - rgb2q(r2, g2, b2)
+	delta := 0.5053*y*y + 0.299*i*i + 0.1957*q*q
+	if y1 > y2 {
+		return -delta
+	}
+	return delta
+}
+
+func rgb2y(r, g, b float64) float64 { return r*0.29889531 + g*0.58662247 + b*0.11448223 }
+func rgb2i(r, g, b float64) float64 { return r*0.59597799 - g*0.27417610 - b*0.32180189 }
+func rgb2q(r, g, b float64) float64 { return r*0.21147017 - g*0.52261711 + b*0.31114694 }
+
+// antialiased reports whether the pixel at x,y in img sits on an edge between
+// a darkest and a brightest neighbour that are both flat areas in img and other.
+func antialiased(img *image.NRGBA, x1, y1 int, other *image.NRGBA) bool {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	x0, y0 := max(x1-1, 0), max(y1-1, 0)
+	x2, y2 := min(x1+1, w-1), min(y1+1, h-1)
+	zeroes := 0
+	if x1 == x0 || x1 == x2 || y1 == y0 || y1 == y2 {
+		zeroes = 1
+	}
+	var minDelta, maxDelta float64
+	var minX, minY, maxX, maxY int
+	center := pixel(img, x1, y1)
+	for x := x0; x <= x2; x++ {
+		for y := y0; y <= y2; y++ {
+			if x == x1 && y == y1 {
+				continue
+			}
+			delta := colorDelta(center, pixel(img, x, y), true)
+			switch {
+			case delta == 0:
+				zeroes++
+				if zeroes > 2 {
+					return false
+				}
+			case delta < minDelta:
+				minDelta, minX, minY = delta, x, y
+			case delta > maxDelta:
+				maxDelta, maxX, maxY = delta, x, y
+			}
+		}
+	}
+	if minDelta == 0 || maxDelta == 0 {
+		return false
+	}
+	return (hasManySiblings(img, minX, minY) && hasManySiblings(other, minX, minY)) ||
+		(hasManySiblings(img, maxX, maxY) && hasManySiblings(other, maxX, maxY))
+}
+
+// hasManySiblings reports whether at least three neighbours equal the pixel.
+func hasManySiblings(img *image.NRGBA, x1, y1 int) bool {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if x1 >= w || y1 >= h {
+		return false
+	}
+	x0, y0 := max(x1-1, 0), max(y1-1, 0)
+	x2, y2 := min(x1+1, w-1), min(y1+1, h-1)
+	zeroes := 0
+	if x1 == x0 || x1 == x2 || y1 == y0 || y1 == y2 {
+		zeroes = 1
+	}
+	center := pixel(img, x1, y1)
+	for x := x0; x <= x2; x++ {
+		for y := y0; y <= y2; y++ {
+			if x == x1 && y == y1 {
+				continue
+			}
+			if bytes.Equal(center, pixel(img, x, y)) {
+				zeroes++
+			}
+			if zeroes > 2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// faded is an unchanged pixel drawn as light grey so changes stand out.
+func faded(p []uint8) color.NRGBA {
+	y := rgb2y(blend(float64(p[0]), float64(p[3])/255), blend(float64(p[1]), float64(p[3])/255), blend(float64(p[2]), float64(p[3])/255))
+	v := uint8(blend(y, 0.1))
+	return color.NRGBA{R: v, G: v, B: v, A: 255}
+}
+
+// ssim is the mean structural similarity of the images' luma over 8x8
+// windows, computed on the area both images cover.
+func ssim(a, b *image.NRGBA) float64 {
+	const win = 8
+	const c1, c2 = (0.01 * 255) * (0.01 * 255), (0.03 * 255) * (0.03 * 255)
+	w := min(a.Rect.Dx(), b.Rect.Dx())
+	h := min(a.Rect.Dy(), b.Rect.Dy())
+	if w < win || h < win {
+		return 0
+	}
+	var sum float64
+	var n int
+	for wy := 0; wy+win <= h; wy += win {
+		for wx := 0; wx+win <= w; wx += win {
+			var ma, mb, va, vb, cov float64
+			for y := wy; y < wy+win; y++ {
+				for x := wx; x < wx+win; x++ {
+					la, lb := luma(pixel(a, x, y)), luma(pixel(b, x, y))
+					ma += la
+					mb += lb
+					va += la * la
+					vb += lb * lb
+					cov += la * lb
+				}
+			}
+			const count = win * win
+			ma /= count
+			mb /= count
+			va = va/count - ma*ma
+			vb = vb/count - mb*mb
+			cov = cov/count - ma*mb
+			sum += ((2*ma*mb + c1) * (2*cov + c2)) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			n++
+		}
+	}
+	return sum / float64(n)
+}
+
+func luma(p []uint8) float64 {
+	a := float64(p[3]) / 255
+	return rgb2y(blend(float64(p[0]), a), blend(float64(p[1]), a), blend(float64(p[2]), a))
+}
+
+// sideBySide lays the images out left to right, top-aligned, with a gap.
+func sideBySide(imgs ...*image.NRGBA) *image.NRGBA {
+	const gap = 8
+	w, h := 0, 0
+	for _, img := range imgs {
+		w += img.Rect.Dx() + gap
+		h = max(h, img.Rect.Dy())
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, w-gap, h))
+	draw.Draw(out, out.Rect, image.NewUniform(color.NRGBA{R: 40, G: 40, B: 40, A: 255}), image.Point{}, draw.Src)
+	x := 0
+	for _, img := range imgs {
+		r := image.Rect(x, 0, x+img.Rect.Dx(), img.Rect.Dy())
+		draw.Draw(out, r, img, image.Point{}, draw.Over)
+		x += img.Rect.Dx() + gap
+	}
+	return out
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,267 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func solid(w, h int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDiffImagesThreshold(t *testing.T) {
+	white := color.NRGBA{255, 255, 255, 255}
+	base := solid(20, 20, white)
+	curr := solid(20, 20, white)
+	curr.SetNRGBA(10, 10, color.NRGBA{250, 250, 250, 255}) // barely visible
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			curr.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 255})
+		}
+	}
+	if got := diffImages(base, curr, 0).DiffPixels; got != 17 {
+		t.Fatalf("exact diff pixels = %d, want 17", got)
+	}
+	res := diffImages(base, curr, 25)
+	if res.DiffPixels != 16 {
+		t.Fatalf("tolerant diff pixels = %d, want 16", res.DiffPixels)
+	}
+	if res.overlay.NRGBAAt(0, 0) != diffColor {
+		t.Error("changed pixel not painted red")
+	}
+}
+
+func TestDiffImagesAntiAliasing(t *testing.T) {
+	// A vertical black/white edge; the current image softens one edge pixel
+	// to grey, which is what a different rasterizer does.
+	base := solid(10, 10, color.NRGBA{255, 255, 255, 255})
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 5; x++ {
+			base.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 255})
+		}
+	}
+	curr := image.NewNRGBA(base.Rect)
+	copy(curr.Pix, base.Pix)
+	curr.SetNRGBA(5, 5, color.NRGBA{128, 128, 128, 255})
+	res := diffImages(base, curr, 0)
+	if res.DiffPixels != 0 || res.AAPixels != 1 {
+		t.Fatalf("diff=%d aa=%d, want 0/1", res.DiffPixels, res.AAPixels)
+	}
+}
+
+func TestDiffImagesPadsHeight(t *testing.T) {
+	white := color.NRGBA{255, 255, 255, 255}
+	res := diffImages(solid(10, 100, white), solid(10, 103, white), 0)
+	if res.SizeDelta != (ImageSize{0, 3}) || res.PaddedPixels != 30 || res.DiffPixels != 30 {
+		t.Fatalf("delta=%v padded=%d diff=%d", res.SizeDelta, res.PaddedPixels, res.DiffPixels)
+	}
+	if res.overlay.Rect.Dy() != 103 {
+		t.Fatalf("overlay height = %d", res.overlay.Rect.Dy())
+	}
+}
+
+// page fakes a tall screenshot: flat bands of colour with text-like noise.
+func page(w, h int, seed int64) *image.NRGBA {
+	rng := rand.New(rand.NewSource(seed))
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		bg := uint8(255 - (y/200)%3*20)
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{bg, bg, bg, 255}
+			if (y/12)%4 == 1 && x%40 < 30 && rng.Intn(3) == 0 {
+				c = color.NRGBA{30, 30, 30, 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestDiffImagesBandsMatchSingleBand(t *testing.T) {
+	base := page(120, 333, 1)
+	curr := page(117, 340, 1)
+	for y := 40; y < 90; y++ {
+		for x := 10; x < 60; x++ {
+			curr.SetNRGBA(x, y, color.NRGBA{200, 0, 0, 255})
+		}
+	}
+	one := diffImagesBands(base, curr, 10, 1)
+	many := diffImagesBands(base, curr, 10, 7)
+	if one.DiffPixels == 0 || one.VisualComparison.DiffPixels != many.DiffPixels ||
+		one.AAPixels != many.AAPixels || one.PaddedPixels != many.PaddedPixels {
+		t.Fatalf("single band %+v, banded %+v", one.VisualComparison, many.VisualComparison)
+	}
+	if !bytes.Equal(one.overlay.Pix, many.overlay.Pix) {
+		t.Fatal("banded overlay differs from single-band overlay")
+	}
+}
+
+func TestDiffImagesIdenticalSkipsOverlay(t *testing.T) {
+	a := page(64, 64, 2)
+	b := image.NewNRGBA(a.Rect)
+	copy(b.Pix, a.Pix)
+	res := diffImages(a, b, 0)
+	if res.DiffPixels != 0 || res.overlay != nil {
+		t.Fatalf("identical images: diff=%d overlay=%v", res.DiffPixels, res.overlay != nil)
+	}
+}
+
+func TestToNRGBAFromRGBA(t *testing.T) {
+	src := image.NewRGBA(image.Rect(5, 5, 7, 6))
+	src.SetRGBA(5, 5, color.RGBA{10, 20, 30, 255})
+	src.SetRGBA(6, 5, color.RGBA{64, 32, 0, 128}) // premultiplied
+	n := toNRGBA(src)
+	if n.Rect != image.Rect(0, 0, 2, 1) {
+		t.Fatalf("rect = %v", n.Rect)
+	}
+	if got := n.NRGBAAt(0, 0); got != (color.NRGBA{10, 20, 30, 255}) {
+		t.Errorf("opaque pixel = %v", got)
+	}
+	want := color.NRGBAModel.Convert(color.RGBA{64, 32, 0, 128}).(color.NRGBA)
+	if got := n.NRGBAAt(1, 0); got != want {
+		t.Errorf("translucent pixel = %v, want %v", got, want)
+	}
+}
+
+func TestSSIM(t *testing.T) {
+	a := solid(32, 32, color.NRGBA{200, 200, 200, 255})
+	if s := ssim(a, a); s < 0.999 {
+		t.Fatalf("identical ssim = %v", s)
+	}
+	b := solid(32, 32, color.NRGBA{20, 20, 20, 255})
+	if s := ssim(a, b); s > 0.5 {
+		t.Fatalf("different ssim = %v", s)
+	}
+}
+
+func TestComputeDiffImageWritesComposite(t *testing.T) {
+	dir := t.TempDir()
+	logger := &ndjsonLogger{w: bufio.NewWriter(io.Discard)}
+	base := solid(16, 16, color.NRGBA{255, 255, 255, 255})
+	curr := solid(16, 18, color.NRGBA{0, 0, 255, 255})
+	cmp := computeDiffImage(encodePNG(t, base), encodePNG(t, curr), dir, "visual", nil, logger, Options{VisualMaxDiffRatio: 0.5, VisualSSIM: true})
+	if cmp == nil || cmp.Passed || cmp.SSIM == nil {
+		t.Fatalf("cmp = %+v", cmp)
+	}
+	f, err := os.Open(filepath.Join(dir, cmp.CompareImg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cfg, err := png.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 16*3+16 || cfg.Height != 18 {
+		t.Fatalf("composite %dx%d", cfg.Width, cfg.Height)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "visual-diff.png")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIgnoreRegionsMaskBothImages(t *testing.T) {
+	white := color.NRGBA{255, 255, 255, 255}
+	base := solid(20, 20, white)
+	curr := solid(20, 20, white)
+	for y := 2; y < 6; y++ {
+		for x := 2; x < 6; x++ {
+			curr.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 255}) // e.g. a rotating avatar
+		}
+	}
+	curr.SetNRGBA(15, 15, color.NRGBA{0, 0, 0, 255})
+	m, ok := pixelRect(1.5, 1.5, 5, 5, ".avatar")
+	if !ok || m != (MaskRect{X: 1, Y: 1, Width: 6, Height: 6, Source: ".avatar"}) {
+		t.Fatalf("pixelRect = %+v", m)
+	}
+	masks := []MaskRect{m}
+	paintMasks(base, masks, maskFill)
+	paintMasks(curr, masks, maskFill)
+	res := diffImages(base, curr, 0)
+	if res.DiffPixels != 1 {
+		t.Fatalf("diff pixels = %d, want only the unmasked one", res.DiffPixels)
+	}
+	outlineMasks(res.overlay, masks)
+	if res.overlay.NRGBAAt(1, 1) != maskOverlay {
+		t.Error("mask border not drawn on the diff")
+	}
+	if _, ok := pixelRect(0, 0, 0, 10, "rect"); ok {
+		t.Error("empty rect accepted")
+	}
+}
+
+func benchPages(b *testing.B) (*image.NRGBA, *image.NRGBA) {
+	b.Helper()
+	base := page(1280, 6000, 3)
+	curr := image.NewNRGBA(base.Rect)
+	copy(curr.Pix, base.Pix)
+	for y := 2000; y < 2300; y++ {
+		for x := 100; x < 700; x++ {
+			curr.SetNRGBA(x, y, color.NRGBA{20, 20, 20, 255})
+		}
+	}
+	return base, curr
+}
+
+func BenchmarkDiffImages(b *testing.B) {
+	base, curr := benchPages(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		diffImages(base, curr, 0)
+	}
+}
+
+func BenchmarkDiffImagesSingleBand(b *testing.B) {
+	base, curr := benchPages(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		diffImagesBands(base, curr, 0, 1)
+	}
+}
+
+func BenchmarkDiffImagesIdentical(b *testing.B) {
+	base, _ := benchPages(b)
+	same := image.NewNRGBA(base.Rect)
+	copy(same.Pix, base.Pix)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		diffImages(base, same, 0)
+	}
+}
+
+func BenchmarkToNRGBA(b *testing.B) {
+	base, _ := benchPages(b)
+	src := image.NewRGBA(base.Rect)
+	copy(src.Pix, base.Pix) // opaque, so premultiplied and straight alpha agree
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		toNRGBA(src)
+	}
+}
@@ -18,6 +18,7 @@ type Meta struct {
 	Exclude     []string
 	RunAt       string
 	Grants      []string
+	Connect     []string
 	Raw         string
 }
 
@@ -77,6 +78,8 @@ func Parse(path string) (Meta, error) {
 			meta.RunAt = val
 		case "grant":
 			meta.Grants = append(meta.Grants, val)
+		case "connect":
+			meta.Connect = append(meta.Connect, val)
 		}
 	}
 	if err := scanner.Err(); err != nil {
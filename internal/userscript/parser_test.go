@@ -1,6 +1,7 @@
 package userscript
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 )
@@ -27,3 +28,18 @@ func TestParseWikipediaScript(t *testing.T) {
 		t.Fatal("expected raw content to be preserved")
 	}
 }
+
+func TestParseConnect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "connect.user.js")
+	src := "// ==UserScript==\n// @name    Connect\n// @connect api.example.com\n// @connect self\n// ==/UserScript==\n"
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := Parse(path)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(meta.Connect) != 2 || meta.Connect[0] != "api.example.com" || meta.Connect[1] != "self" {
+		t.Fatalf("unexpected connect hosts: %+v", meta.Connect)
+	}
+}
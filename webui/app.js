@@ -19,7 +19,7 @@
   const webp = document.getElementById('webp');
   const backendStatus = document.getElementById('backend-status');
   const harLink = document.getElementById('har-link');
-  const traceLink = document.getElementById('trace-link');
+  const traceLinks = document.getElementById('trace-links');
 
   const metaName = document.getElementById('meta-name');
   const metaMatch = document.getElementById('meta-match');
@@ -83,6 +83,7 @@
       engine: engineInput.value,
       extension_dir: '',
       headless: document.getElementById('headless').value === 'true',
+      trace: captureTrace.checked,
       har: captureHar.checked,
       replay_har: replayHar.value.trim(),
       baseline: baselineDir.value.trim(),
@@ -133,13 +134,29 @@
       harLink.href = manifest.har;
       appendLog('artifact', `HAR: ${manifest.har}`);
     }
-    if (manifest.trace_zip) {
-      traceLink.textContent = manifest.trace_zip;
-      traceLink.href = manifest.trace_zip;
-      appendLog('artifact', `Trace: ${manifest.trace_zip}`);
+    const chunks = (manifest.trace_chunks || []).filter((c) => c.path);
+    if (chunks.length) {
+      traceLinks.textContent = '';
+      chunks.forEach((c) => {
+        const link = document.createElement('a');
+        link.textContent = c.name;
+        link.href = c.path;
+        link.target = '_blank';
+        link.rel = 'noreferrer';
+        link.style.display = 'block';
+        traceLinks.appendChild(link);
+      });
+      appendLog('artifact', `Trace: ${chunks.length} chunk(s) under artifacts/trace/`);
     }
     if (manifest.visual_diff_img) {
       appendLog('artifact', `Visual diff pixels=${manifest.visual_diff_pixels || '?'} ratio=${(manifest.visual_diff_ratio*100 || 0).toFixed(2)}%`);
+      const v = manifest.visual || {};
+      if (v.size_delta && (v.size_delta.width || v.size_delta.height)) {
+        appendLog('artifact', `Visual size delta ${v.size_delta.width}x${v.size_delta.height} px (padded)`);
+      }
+      if (manifest.visual_compare_img) {
+        appendLog('artifact', `Side-by-side: ${manifest.visual_compare_img}`);
+      }
       const diffEl = document.getElementById('visual-diff');
       if (diffEl) {
         diffEl.src = manifest.visual_diff_img;